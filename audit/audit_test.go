@@ -0,0 +1,260 @@
+//go:build unit
+// +build unit
+
+package audit_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bazsup/assessment/audit"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	entryColumns      = []string{"id", "expense_id", "action", "actor", "request_id", "source_ip", "changes", "created_at", "prev_hash", "hash"}
+	checkpointColumns = []string{"seq", "audit_id", "hash", "key_id", "signature"}
+	createdAt         = time.Date(2026, 10, 1, 9, 30, 0, 123456000, time.UTC)
+)
+
+func record(id int, action string) audit.Record {
+	return audit.Record{
+		ExpenseID: id,
+		Action:    action,
+		Actor:     "alice",
+		RequestID: "req-" + action,
+		SourceIP:  "10.0.0.1",
+		Changes:   []byte(`{"title":{"before":null,"after":"coffee"}}`),
+		CreatedAt: createdAt,
+	}
+}
+
+// chain hashes records the way the store appends them
+func chain(t *testing.T, recs ...audit.Record) []string {
+	var hashes []string
+	prev := ""
+	for _, r := range recs {
+		h, err := audit.Hash(prev, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, h)
+		prev = h
+	}
+	return hashes
+}
+
+func entryRows(recs []audit.Record, hashes []string) *sqlmock.Rows {
+	return appendEntries(sqlmock.NewRows(entryColumns), recs, hashes)
+}
+
+func appendEntries(rows *sqlmock.Rows, recs []audit.Record, hashes []string) *sqlmock.Rows {
+	prev := ""
+	for i, r := range recs {
+		rows.AddRow(i+1, r.ExpenseID, r.Action, r.Actor, r.RequestID, r.SourceIP, r.Changes, r.CreatedAt, prev, hashes[i])
+		prev = hashes[i]
+	}
+	return rows
+}
+
+func sign(key ed25519.PrivateKey, id int64, hash string) string {
+	msg := fmt.Sprintf("expense-audit-checkpoint:%d:%s", id, hash)
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, []byte(msg)))
+}
+
+func TestHash(t *testing.T) {
+	t.Run("Hash ignores JSON key order and whitespace", func(t *testing.T) {
+		a := record(1, "create")
+		b := a
+		b.Changes = []byte(`{ "title": { "after": "coffee", "before": null } }`)
+
+		assert.Equal(t, chain(t, a), chain(t, b))
+	})
+
+	t.Run("Hash covers the previous hash and every field", func(t *testing.T) {
+		base := record(1, "create")
+		want, _ := audit.Hash("", base)
+
+		other, _ := audit.Hash("abc", base)
+		assert.NotEqual(t, want, other)
+
+		for _, change := range []func(*audit.Record){
+			func(r *audit.Record) { r.ExpenseID = 2 },
+			func(r *audit.Record) { r.Action = "update" },
+			func(r *audit.Record) { r.Actor = "mallory" },
+			func(r *audit.Record) { r.RequestID = "" },
+			func(r *audit.Record) { r.SourceIP = "10.0.0.2" },
+			func(r *audit.Record) { r.Changes = []byte(`{}`) },
+			func(r *audit.Record) { r.CreatedAt = r.CreatedAt.Add(time.Microsecond) },
+		} {
+			r := base
+			change(&r)
+			got, _ := audit.Hash("", r)
+			assert.NotEqual(t, want, got)
+		}
+	})
+
+	t.Run("Hash is the same for the time read back from Postgres", func(t *testing.T) {
+		r := record(1, "create")
+		r.CreatedAt = time.Date(2026, 10, 1, 16, 30, 0, 123456789, time.FixedZone("ICT", 7*3600))
+		stored := r
+		stored.CreatedAt = audit.Timestamp(r.CreatedAt)
+
+		assert.Equal(t, chain(t, r), chain(t, stored))
+	})
+}
+
+func TestVerify(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	pub := key.Public().(ed25519.PublicKey)
+	recs := []audit.Record{record(1, "create"), record(1, "update"), record(1, "delete")}
+	hashes := chain(t, recs...)
+
+	t.Run("Intact chain with a signed checkpoint is valid", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		rows := sqlmock.NewRows(entryColumns).AddRow(0, 9, "create", "system", "", "", []byte(`{}`), createdAt, "", "")
+		mock.ExpectQuery("FROM expense_audit ORDER BY id").WillReturnRows(appendEntries(rows, recs, hashes))
+		mock.ExpectQuery("FROM audit_checkpoints").WillReturnRows(sqlmock.NewRows(checkpointColumns).
+			AddRow(1, 2, hashes[1], audit.KeyID(pub), sign(key, 2, hashes[1])))
+
+		r, err := audit.Verify(context.Background(), db, pub)
+
+		assert.NoError(t, err)
+		assert.Equal(t, audit.Report{Valid: true, Entries: 3, Legacy: 1, Checkpoints: 1, LastHash: hashes[2]}, r)
+	})
+
+	tamperTests := []struct {
+		name     string
+		rows     func() *sqlmock.Rows
+		wantID   int64
+		checkpts *sqlmock.Rows
+	}{
+		{
+			name: "Altered entry breaks the chain at that entry",
+			rows: func() *sqlmock.Rows {
+				altered := append([]audit.Record{}, recs...)
+				altered[1].Actor = "mallory"
+				return entryRows(altered, hashes)
+			},
+			wantID: 2,
+		},
+		{
+			name: "Removed entry breaks the link of the next one",
+			rows: func() *sqlmock.Rows {
+				return sqlmock.NewRows(entryColumns).
+					AddRow(1, 1, "create", "alice", "req-create", "10.0.0.1", recs[0].Changes, createdAt, "", hashes[0]).
+					AddRow(3, 1, "delete", "alice", "req-delete", "10.0.0.1", recs[2].Changes, createdAt, hashes[1], hashes[2])
+			},
+			wantID: 3,
+		},
+		{
+			name: "Truncated tail breaks the checkpoint signing it",
+			rows: func() *sqlmock.Rows {
+				return entryRows(recs[:2], hashes[:2])
+			},
+			checkpts: sqlmock.NewRows(checkpointColumns).AddRow(1, 3, hashes[2], audit.KeyID(pub), sign(key, 3, hashes[2])),
+			wantID:   3,
+		},
+		{
+			name: "Forged checkpoint signature is rejected",
+			rows: func() *sqlmock.Rows {
+				return entryRows(recs, hashes)
+			},
+			checkpts: sqlmock.NewRows(checkpointColumns).AddRow(1, 3, hashes[2], audit.KeyID(pub), sign(key, 2, hashes[2])),
+			wantID:   3,
+		},
+		{
+			name: "Checkpoint signed by an unknown key is rejected",
+			rows: func() *sqlmock.Rows {
+				return entryRows(recs, hashes)
+			},
+			checkpts: sqlmock.NewRows(checkpointColumns).AddRow(1, 3, hashes[2], "unknown-key", sign(key, 3, hashes[2])),
+			wantID:   3,
+		},
+		{
+			name: "Deleted checkpoint leaves a gap in the sequence",
+			rows: func() *sqlmock.Rows {
+				return entryRows(recs, hashes)
+			},
+			checkpts: sqlmock.NewRows(checkpointColumns).
+				AddRow(1, 2, hashes[1], audit.KeyID(pub), sign(key, 2, hashes[1])).
+				AddRow(3, 3, hashes[2], audit.KeyID(pub), sign(key, 3, hashes[2])),
+			wantID: 3,
+		},
+	}
+
+	for _, tt := range tamperTests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, _ := sqlmock.New()
+			mock.ExpectQuery("FROM expense_audit ORDER BY id").WillReturnRows(tt.rows())
+			if tt.checkpts != nil {
+				mock.ExpectQuery("FROM audit_checkpoints").WillReturnRows(tt.checkpts)
+			}
+
+			r, err := audit.Verify(context.Background(), db, pub)
+
+			assert.NoError(t, err)
+			assert.False(t, r.Valid)
+			if assert.NotNil(t, r.Broken) {
+				assert.Equal(t, tt.wantID, r.Broken.AuditID)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	pub := key.Public().(ed25519.PublicKey)
+
+	t.Run("Checkpoint signs the chain head", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery("SELECT id, hash FROM expense_audit").WillReturnRows(sqlmock.NewRows([]string{"id", "hash"}).AddRow(7, "head"))
+		mock.ExpectQuery("SELECT EXISTS").WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		mock.ExpectQuery("INSERT INTO audit_checkpoints").
+			WithArgs(7, "head", audit.KeyID(pub), sign(key, 7, "head")).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "seq", "created_at"}).AddRow(1, 4, createdAt))
+
+		cp, err := audit.NewCheckpointer(db, key).Checkpoint(context.Background())
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(7), cp.AuditID)
+		assert.Equal(t, int64(4), cp.Seq)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Signed head is not signed again", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery("SELECT id, hash FROM expense_audit").WillReturnRows(sqlmock.NewRows([]string{"id", "hash"}).AddRow(7, "head"))
+		mock.ExpectQuery("SELECT EXISTS").WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+		cp, err := audit.NewCheckpointer(db, key).Checkpoint(context.Background())
+
+		assert.NoError(t, err)
+		assert.Nil(t, cp)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestLoadSigningKey(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	path := filepath.Join(t.TempDir(), "audit.pem")
+	os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+
+	got, err := audit.LoadSigningKey(path)
+
+	assert.NoError(t, err)
+	assert.Equal(t, key, got)
+}
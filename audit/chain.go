@@ -0,0 +1,71 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LockID is the Postgres advisory lock key held by a transaction appending
+// to the chain, so concurrent writers can't both extend the same entry
+const LockID int64 = 2565_0002
+
+// Record is the content of an audit entry covered by its hash
+type Record struct {
+	ExpenseID int
+	Action    string
+	Actor     string
+	RequestID string
+	SourceIP  string
+	// Changes is the JSON diff of the entry, canonicalised before hashing
+	Changes   []byte
+	CreatedAt time.Time
+}
+
+// Canonical re-encodes JSON with sorted keys and no insignificant space, so
+// a document hashes the same before and after a round trip through JSONB
+func Canonical(doc []byte) ([]byte, error) {
+	var v interface{}
+	if err := json.Unmarshal(doc, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// Timestamp truncates t to the microsecond precision Postgres stores, so
+// the time hashed on insert is the time read back
+func Timestamp(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Microsecond)
+}
+
+// Hash chains r to the entry hashed as prev. Fields are length prefixed so
+// no two distinct records encode to the same input.
+func Hash(prev string, r Record) (string, error) {
+	changes, err := Canonical(r.Changes)
+	if err != nil {
+		return "", err
+	}
+
+	fields := []string{
+		prev,
+		strconv.Itoa(r.ExpenseID),
+		r.Action,
+		r.Actor,
+		r.RequestID,
+		r.SourceIP,
+		string(changes),
+		Timestamp(r.CreatedAt).Format(time.RFC3339Nano),
+	}
+
+	var b strings.Builder
+	for _, f := range fields {
+		b.WriteString(strconv.Itoa(len(f)))
+		b.WriteByte(':')
+		b.WriteString(f)
+	}
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:]), nil
+}
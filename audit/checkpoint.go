@@ -0,0 +1,111 @@
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Checkpoint is a signed statement that the chain ended at AuditID with Hash
+type Checkpoint struct {
+	ID int64 `json:"id"`
+	// Seq numbers checkpoints from 1 without gaps
+	Seq       int64     `json:"seq"`
+	AuditID   int64     `json:"audit_id"`
+	Hash      string    `json:"hash"`
+	KeyID     string    `json:"key_id"`
+	Signature string    `json:"signature"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// message is what a checkpoint signs
+func message(auditID int64, hash string) []byte {
+	return []byte(fmt.Sprintf("expense-audit-checkpoint:%d:%s", auditID, hash))
+}
+
+// KeyID identifies a public key by the sha256 of its PKIX encoding
+func KeyID(pub ed25519.PublicKey) string {
+	der, _ := x509.MarshalPKIXPublicKey(pub)
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadSigningKey reads a PKCS #8 "PRIVATE KEY" PEM holding an Ed25519 key,
+// as written by "openssl genpkey -algorithm ed25519"
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("audit signing key: no PRIVATE KEY PEM block")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("audit signing key: %s", err.Error())
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("audit signing key: not an Ed25519 key")
+	}
+	return priv, nil
+}
+
+// Checkpointer signs the head of the chain
+type Checkpointer struct {
+	db  *sql.DB
+	key ed25519.PrivateKey
+}
+
+func NewCheckpointer(db *sql.DB, key ed25519.PrivateKey) *Checkpointer {
+	return &Checkpointer{db: db, key: key}
+}
+
+// Checkpoint signs the latest chained entry unless it is already signed,
+// returning nil when there was nothing new to sign
+func (c *Checkpointer) Checkpoint(ctx context.Context) (*Checkpoint, error) {
+	cp := &Checkpoint{}
+	err := c.db.QueryRowContext(ctx,
+		"SELECT id, hash FROM expense_audit WHERE hash <> '' ORDER BY id DESC LIMIT 1").
+		Scan(&cp.AuditID, &cp.Hash)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't read chain head: %s", err.Error())
+	}
+
+	var signed bool
+	err = c.db.QueryRowContext(ctx,
+		"SELECT EXISTS ( SELECT 1 FROM audit_checkpoints WHERE audit_id = $1 )", cp.AuditID).Scan(&signed)
+	if err != nil {
+		return nil, fmt.Errorf("can't read checkpoints: %s", err.Error())
+	}
+	if signed {
+		return nil, nil
+	}
+
+	cp.KeyID = KeyID(c.key.Public().(ed25519.PublicKey))
+	cp.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(c.key, message(cp.AuditID, cp.Hash)))
+	// a concurrent checkpointer taking the same seq fails on its unique
+	// constraint, the next interval signs again
+	err = c.db.QueryRowContext(ctx, `
+	INSERT INTO audit_checkpoints ( seq, audit_id, hash, key_id, signature )
+	SELECT COALESCE(MAX(seq), 0) + 1, $1, $2, $3, $4 FROM audit_checkpoints
+	RETURNING id, seq, created_at
+	`, cp.AuditID, cp.Hash, cp.KeyID, cp.Signature).Scan(&cp.ID, &cp.Seq, &cp.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("can't store checkpoint: %s", err.Error())
+	}
+	return cp, nil
+}
@@ -0,0 +1,146 @@
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+)
+
+// Break is where and why verification found the chain broken
+type Break struct {
+	AuditID int64  `json:"audit_id"`
+	Reason  string `json:"reason"`
+}
+
+// Report is the outcome of verifying the chain
+type Report struct {
+	Valid bool `json:"valid"`
+	// Entries counts chained entries, Legacy the entries written before
+	// chaining which verification can't vouch for
+	Entries     int    `json:"entries"`
+	Legacy      int    `json:"legacy"`
+	Checkpoints int    `json:"checkpoints"`
+	LastHash    string `json:"last_hash,omitempty"`
+	Broken      *Break `json:"broken,omitempty"`
+}
+
+// Querier is the part of *sql.DB verification reads through
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Verify recomputes every hash of the chain and checks every checkpoint
+// signs a hash the chain still has, with no checkpoint missing. Signatures
+// are checked with pub, only the hashes when pub is nil.
+func Verify(ctx context.Context, db Querier, pub ed25519.PublicKey) (Report, error) {
+	r := Report{Valid: true}
+	hashes, err := verifyEntries(ctx, db, &r)
+	if err != nil || !r.Valid {
+		return r, err
+	}
+	err = verifyCheckpoints(ctx, db, pub, hashes, &r)
+	return r, err
+}
+
+func (r *Report) broken(id int64, format string, args ...interface{}) {
+	r.Valid = false
+	r.Broken = &Break{AuditID: id, Reason: fmt.Sprintf(format, args...)}
+}
+
+func verifyEntries(ctx context.Context, db Querier, r *Report) (map[int64]string, error) {
+	rows, err := db.QueryContext(ctx, `
+	SELECT id, expense_id, action, actor, request_id, source_ip, changes, created_at, prev_hash, hash
+	FROM expense_audit ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hashes := map[int64]string{}
+	prev := ""
+	for rows.Next() {
+		var id int64
+		var rec Record
+		var prevHash, hash string
+		err := rows.Scan(&id, &rec.ExpenseID, &rec.Action, &rec.Actor, &rec.RequestID, &rec.SourceIP,
+			&rec.Changes, &rec.CreatedAt, &prevHash, &hash)
+		if err != nil {
+			return nil, err
+		}
+
+		if hash == "" {
+			// legacy entries only come before the chain starts
+			if r.Entries > 0 {
+				r.broken(id, "unchained entry after the chain started")
+				return hashes, nil
+			}
+			r.Legacy++
+			continue
+		}
+
+		if prevHash != prev {
+			r.broken(id, "previous hash %q does not match %q", prevHash, prev)
+			return hashes, nil
+		}
+		want, err := Hash(prevHash, rec)
+		if err != nil {
+			r.broken(id, "can't hash entry: %s", err.Error())
+			return hashes, nil
+		}
+		if want != hash {
+			r.broken(id, "entry hash does not match its content")
+			return hashes, nil
+		}
+
+		hashes[id] = hash
+		prev = hash
+		r.Entries++
+		r.LastHash = hash
+	}
+	return hashes, rows.Err()
+}
+
+func verifyCheckpoints(ctx context.Context, db Querier, pub ed25519.PublicKey, hashes map[int64]string, r *Report) error {
+	rows, err := db.QueryContext(ctx, "SELECT seq, audit_id, hash, key_id, signature FROM audit_checkpoints ORDER BY seq")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	keyID := ""
+	if pub != nil {
+		keyID = KeyID(pub)
+	}
+	for rows.Next() {
+		var cp Checkpoint
+		if err := rows.Scan(&cp.Seq, &cp.AuditID, &cp.Hash, &cp.KeyID, &cp.Signature); err != nil {
+			return err
+		}
+		r.Checkpoints++
+
+		if want := int64(r.Checkpoints); cp.Seq != want {
+			r.broken(cp.AuditID, "checkpoint %d is missing", want)
+			return nil
+		}
+		if hashes[cp.AuditID] != cp.Hash {
+			r.broken(cp.AuditID, "checkpointed hash is no longer in the chain")
+			return nil
+		}
+		if pub == nil {
+			continue
+		}
+		if cp.KeyID != keyID {
+			r.broken(cp.AuditID, "checkpoint is signed by unknown key %s", cp.KeyID)
+			return nil
+		}
+		sig, err := base64.StdEncoding.DecodeString(cp.Signature)
+		if err != nil || !ed25519.Verify(pub, message(cp.AuditID, cp.Hash), sig) {
+			r.broken(cp.AuditID, "checkpoint signature is invalid")
+			return nil
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,256 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformedToken   = errors.New("malformed token")
+	ErrInvalidSignature = errors.New("invalid token signature")
+	ErrUnknownKey       = errors.New("unknown signing key")
+	ErrTokenExpired     = errors.New("token is expired")
+	ErrTokenNotYetValid = errors.New("token is not valid yet")
+	ErrInvalidAudience  = errors.New("invalid token audience")
+	ErrInvalidIssuer    = errors.New("invalid token issuer")
+	ErrMissingIdentity  = errors.New("token has no identity claim")
+)
+
+// Identity is the authenticated caller extracted from a request
+type Identity struct {
+	Subject string                 `json:"subject"`
+	Scopes  []string               `json:"scopes"`
+	Roles   []string               `json:"roles"`
+	Claims  map[string]interface{} `json:"-"`
+}
+
+// HasScope reports whether the identity was granted scope
+func (id *Identity) HasScope(scope string) bool {
+	for _, s := range id.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// KeySource provides the keys used to verify tokens
+type KeySource interface {
+	Keys() KeySet
+}
+
+// VerifierConfig contains the claim checks applied to every token
+type VerifierConfig struct {
+	Audience      string
+	Issuer        string
+	IdentityClaim string
+	ScopesClaim   string
+	RolesClaim    string
+	Leeway        time.Duration
+}
+
+// Verifier validates RS256, ES256 and EdDSA signed JWTs
+type Verifier struct {
+	keys KeySource
+	cfg  VerifierConfig
+	now  func() time.Time
+}
+
+// NewVerifier returns a verifier using keys from ks
+func NewVerifier(ks KeySource, cfg VerifierConfig) *Verifier {
+	if cfg.IdentityClaim == "" {
+		cfg.IdentityClaim = "sub"
+	}
+	if cfg.ScopesClaim == "" {
+		cfg.ScopesClaim = "scope"
+	}
+	if cfg.RolesClaim == "" {
+		cfg.RolesClaim = "roles"
+	}
+	return &Verifier{keys: ks, cfg: cfg, now: time.Now}
+}
+
+// SetClock overrides the time source used for exp and nbf checks
+func (v *Verifier) SetClock(now func() time.Time) {
+	v.now = now
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks the token signature and registered claims and returns the identity it carries
+func (v *Verifier) Verify(token string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, ErrMalformedToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	if err := v.verifySignature(h, signed, sig); err != nil {
+		return nil, err
+	}
+
+	claims := map[string]interface{}{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrMalformedToken
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+
+	subject, _ := claims[v.cfg.IdentityClaim].(string)
+	if subject == "" {
+		return nil, ErrMissingIdentity
+	}
+
+	return &Identity{
+		Subject: subject,
+		Scopes:  stringList(claims[v.cfg.ScopesClaim]),
+		Roles:   stringList(claims[v.cfg.RolesClaim]),
+		Claims:  claims,
+	}, nil
+}
+
+func (v *Verifier) verifySignature(h header, signed, sig []byte) error {
+	keys := v.keys.Keys()
+
+	if h.Kid != "" {
+		key, ok := keys[h.Kid]
+		if !ok {
+			return ErrUnknownKey
+		}
+		return verifyWithKey(h.Alg, key, signed, sig)
+	}
+
+	for _, key := range keys {
+		if verifyWithKey(h.Alg, key, signed, sig) == nil {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+func verifyWithKey(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrInvalidSignature
+		}
+		digest := sha256.Sum256(signed)
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) != nil {
+			return ErrInvalidSignature
+		}
+		return nil
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve.Params().BitSize != 256 || len(sig) != 64 {
+			return ErrInvalidSignature
+		}
+		digest := sha256.Sum256(signed)
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return ErrInvalidSignature
+		}
+		return nil
+	case "EdDSA":
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(pub, signed, sig) {
+			return ErrInvalidSignature
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: unsupported alg %q", ErrInvalidSignature, alg)
+	}
+}
+
+func (v *Verifier) checkClaims(claims map[string]interface{}) error {
+	now := v.now()
+
+	exp, ok := numericDate(claims["exp"])
+	if !ok {
+		return ErrTokenExpired
+	}
+	if now.After(exp.Add(v.cfg.Leeway)) {
+		return ErrTokenExpired
+	}
+	if nbf, ok := numericDate(claims["nbf"]); ok && now.Add(v.cfg.Leeway).Before(nbf) {
+		return ErrTokenNotYetValid
+	}
+
+	if v.cfg.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.cfg.Issuer {
+			return ErrInvalidIssuer
+		}
+	}
+	if v.cfg.Audience != "" {
+		found := false
+		for _, aud := range stringList(claims["aud"]) {
+			if aud == v.cfg.Audience {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ErrInvalidAudience
+		}
+	}
+
+	return nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func numericDate(v interface{}) (time.Time, bool) {
+	f, ok := v.(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+// stringList accepts both a space separated string (OAuth "scope") and a JSON array
+func stringList(v interface{}) []string {
+	switch val := v.(type) {
+	case string:
+		return strings.Fields(val)
+	case []interface{}:
+		list := make([]string, 0, len(val))
+		for _, item := range val {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	default:
+		return nil
+	}
+}
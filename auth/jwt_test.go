@@ -0,0 +1,229 @@
+//go:build unit
+// +build unit
+
+package auth_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/bazsup/assessment/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+type staticKeys auth.KeySet
+
+func (k staticKeys) Keys() auth.KeySet {
+	return auth.KeySet(k)
+}
+
+func sign(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	t.Helper()
+
+	h, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		r, s, signErr := ecdsa.Sign(rand.Reader, k, digest[:])
+		err = signErr
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signed))
+	}
+	if err != nil {
+		t.Fatalf("can't sign token: %s", err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func validClaims(now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"sub":   "user-1",
+		"scope": "expenses:read expenses:write",
+		"aud":   "expenses-api",
+		"iss":   "https://gateway.example.com",
+		"exp":   now.Add(time.Hour).Unix(),
+		"nbf":   now.Add(-time.Minute).Unix(),
+	}
+}
+
+func TestVerify(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	keys := staticKeys{
+		"rsa": &rsaKey.PublicKey,
+		"ec":  &ecKey.PublicKey,
+		"ed":  edPub,
+	}
+	now := time.Now()
+	cfg := auth.VerifierConfig{Audience: "expenses-api", Issuer: "https://gateway.example.com"}
+
+	signers := []struct {
+		alg string
+		kid string
+		key crypto.Signer
+	}{
+		{"RS256", "rsa", rsaKey},
+		{"ES256", "ec", ecKey},
+		{"EdDSA", "ed", edKey},
+	}
+
+	for _, s := range signers {
+		s := s
+		t.Run("Valid "+s.alg+" token", func(t *testing.T) {
+			v := auth.NewVerifier(keys, cfg)
+
+			// Act
+			id, err := v.Verify(sign(t, s.alg, s.kid, s.key, validClaims(now)))
+
+			// Assertions
+			if assert.NoError(t, err) {
+				assert.Equal(t, "user-1", id.Subject)
+				assert.Equal(t, []string{"expenses:read", "expenses:write"}, id.Scopes)
+				assert.True(t, id.HasScope("expenses:write"))
+			}
+		})
+	}
+
+	t.Run("Custom identity and scopes claim", func(t *testing.T) {
+		v := auth.NewVerifier(keys, auth.VerifierConfig{IdentityClaim: "email", ScopesClaim: "permissions"})
+		claims := validClaims(now)
+		claims["email"] = "somchai@example.com"
+		claims["permissions"] = []string{"expenses:read"}
+
+		// Act
+		id, err := v.Verify(sign(t, "RS256", "rsa", rsaKey, claims))
+
+		// Assertions
+		if assert.NoError(t, err) {
+			assert.Equal(t, "somchai@example.com", id.Subject)
+			assert.Equal(t, []string{"expenses:read"}, id.Scopes)
+		}
+	})
+
+	negativeTests := []struct {
+		name    string
+		token   func() string
+		wantErr error
+	}{
+		{
+			name: "Expired token",
+			token: func() string {
+				claims := validClaims(now)
+				claims["exp"] = now.Add(-time.Minute).Unix()
+				return sign(t, "RS256", "rsa", rsaKey, claims)
+			},
+			wantErr: auth.ErrTokenExpired,
+		},
+		{
+			name: "Token without exp",
+			token: func() string {
+				claims := validClaims(now)
+				delete(claims, "exp")
+				return sign(t, "RS256", "rsa", rsaKey, claims)
+			},
+			wantErr: auth.ErrTokenExpired,
+		},
+		{
+			name: "Token not valid yet",
+			token: func() string {
+				claims := validClaims(now)
+				claims["nbf"] = now.Add(time.Hour).Unix()
+				return sign(t, "RS256", "rsa", rsaKey, claims)
+			},
+			wantErr: auth.ErrTokenNotYetValid,
+		},
+		{
+			name: "Wrong audience",
+			token: func() string {
+				claims := validClaims(now)
+				claims["aud"] = []string{"other-api"}
+				return sign(t, "RS256", "rsa", rsaKey, claims)
+			},
+			wantErr: auth.ErrInvalidAudience,
+		},
+		{
+			name: "Wrong issuer",
+			token: func() string {
+				claims := validClaims(now)
+				claims["iss"] = "https://evil.example.com"
+				return sign(t, "RS256", "rsa", rsaKey, claims)
+			},
+			wantErr: auth.ErrInvalidIssuer,
+		},
+		{
+			name: "Unknown kid",
+			token: func() string {
+				return sign(t, "RS256", "missing", rsaKey, validClaims(now))
+			},
+			wantErr: auth.ErrUnknownKey,
+		},
+		{
+			name: "Signed with a different key",
+			token: func() string {
+				other, _ := rsa.GenerateKey(rand.Reader, 2048)
+				return sign(t, "RS256", "rsa", other, validClaims(now))
+			},
+			wantErr: auth.ErrInvalidSignature,
+		},
+		{
+			name: "Algorithm does not match key",
+			token: func() string {
+				return sign(t, "EdDSA", "rsa", edKey, validClaims(now))
+			},
+			wantErr: auth.ErrInvalidSignature,
+		},
+		{
+			name: "Missing identity claim",
+			token: func() string {
+				claims := validClaims(now)
+				delete(claims, "sub")
+				return sign(t, "RS256", "rsa", rsaKey, claims)
+			},
+			wantErr: auth.ErrMissingIdentity,
+		},
+		{
+			name: "Malformed token",
+			token: func() string {
+				return "not-a-jwt"
+			},
+			wantErr: auth.ErrMalformedToken,
+		},
+	}
+
+	for _, tt := range negativeTests {
+		tt := tt // rebind tt into this lexical scope
+		t.Run(tt.name, func(t *testing.T) {
+			v := auth.NewVerifier(keys, cfg)
+
+			// Act
+			id, err := v.Verify(tt.token())
+
+			// Assertions
+			assert.Nil(t, id)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
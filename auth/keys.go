@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeySet maps a key id to a public key used to verify token signatures
+type KeySet map[string]crypto.PublicKey
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseKeys parses either a JWKS document or one or more PEM encoded
+// public keys / certificates. PEM keys have no kid so they are keyed by
+// the hex SHA-256 of their DER bytes and tried in turn.
+func ParseKeys(data []byte) (KeySet, error) {
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "{") {
+		return parseJWKS(data)
+	}
+	return parsePEM(data)
+}
+
+func parseJWKS(data []byte) (KeySet, error) {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("can't decode jwks: %s", err.Error())
+	}
+
+	keys := KeySet{}
+	for i, k := range doc.Keys {
+		pub, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("jwks key %d: %s", i, err.Error())
+		}
+		kid := k.Kid
+		if kid == "" {
+			kid = fmt.Sprintf("jwks-%d", i)
+		}
+		keys[kid] = pub
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("jwks contains no keys")
+	}
+
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func parsePEM(data []byte) (KeySet, error) {
+	keys := KeySet{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		var pub crypto.PublicKey
+		switch block.Type {
+		case "PUBLIC KEY":
+			k, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("can't parse public key: %s", err.Error())
+			}
+			pub = k
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("can't parse certificate: %s", err.Error())
+			}
+			pub = cert.PublicKey
+		default:
+			continue
+		}
+
+		sum := sha256.Sum256(block.Bytes)
+		keys[hex.EncodeToString(sum[:])] = pub
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no PEM public keys found")
+	}
+
+	return keys, nil
+}
+
+// KeyFile holds the keys loaded from a file and reloads them when the
+// file modification time changes, so rotated keys are picked up without
+// a restart.
+type KeyFile struct {
+	path     string
+	interval time.Duration
+
+	mu        sync.RWMutex
+	keys      KeySet
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// NewKeyFile loads the keys in path; changes are looked for at most once per interval
+func NewKeyFile(path string, interval time.Duration) (*KeyFile, error) {
+	kf := &KeyFile{path: path, interval: interval}
+	if err := kf.reload(); err != nil {
+		return nil, err
+	}
+	return kf, nil
+}
+
+func (kf *KeyFile) reload() error {
+	info, err := os.Stat(kf.path)
+	if err != nil {
+		return fmt.Errorf("can't stat key file: %s", err.Error())
+	}
+	data, err := os.ReadFile(kf.path)
+	if err != nil {
+		return fmt.Errorf("can't read key file: %s", err.Error())
+	}
+	keys, err := ParseKeys(data)
+	if err != nil {
+		return err
+	}
+
+	kf.mu.Lock()
+	kf.keys = keys
+	kf.modTime = info.ModTime()
+	kf.checkedAt = time.Now()
+	kf.mu.Unlock()
+	return nil
+}
+
+// Keys returns the current key set, reloading the file first when it changed.
+// A file that fails to parse keeps the previous keys in place.
+func (kf *KeyFile) Keys() KeySet {
+	kf.mu.RLock()
+	due := time.Since(kf.checkedAt) >= kf.interval
+	keys := kf.keys
+	modTime := kf.modTime
+	kf.mu.RUnlock()
+
+	if !due {
+		return keys
+	}
+
+	kf.mu.Lock()
+	kf.checkedAt = time.Now()
+	kf.mu.Unlock()
+
+	info, err := os.Stat(kf.path)
+	if err != nil || info.ModTime().Equal(modTime) {
+		return keys
+	}
+	if err := kf.reload(); err != nil {
+		return keys
+	}
+
+	kf.mu.RLock()
+	defer kf.mu.RUnlock()
+	return kf.keys
+}
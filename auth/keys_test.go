@@ -0,0 +1,133 @@
+//go:build unit
+// +build unit
+
+package auth_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bazsup/assessment/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func TestParseKeys(t *testing.T) {
+	t.Run("Parse JWKS", func(t *testing.T) {
+		rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+		ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		edPub, _, _ := ed25519.GenerateKey(rand.Reader)
+
+		doc, _ := json.Marshal(map[string]interface{}{
+			"keys": []map[string]string{
+				{"kty": "RSA", "kid": "rsa", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+				{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+				{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": b64(edPub)},
+			},
+		})
+
+		// Act
+		keys, err := auth.ParseKeys(doc)
+
+		// Assertions
+		if assert.NoError(t, err) {
+			assert.Len(t, keys, 3)
+			assert.True(t, rsaKey.PublicKey.Equal(keys["rsa"]))
+			assert.True(t, ecKey.PublicKey.Equal(keys["ec"]))
+			assert.True(t, edPub.Equal(keys["ed"]))
+		}
+	})
+
+	t.Run("Parse PEM public keys", func(t *testing.T) {
+		rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+		edPub, _, _ := ed25519.GenerateKey(rand.Reader)
+
+		// Act
+		keys, err := auth.ParseKeys(append(pemPublicKey(t, &rsaKey.PublicKey), pemPublicKey(t, edPub)...))
+
+		// Assertions
+		if assert.NoError(t, err) {
+			assert.Len(t, keys, 2)
+		}
+	})
+
+	t.Run("Unsupported JWK should returns error", func(t *testing.T) {
+		// Act
+		_, err := auth.ParseKeys([]byte(`{"keys":[{"kty":"oct","k":"c2VjcmV0"}]}`))
+
+		// Assertions
+		assert.Error(t, err)
+	})
+
+	t.Run("Empty input should returns error", func(t *testing.T) {
+		// Act
+		_, err := auth.ParseKeys([]byte(""))
+
+		// Assertions
+		assert.Error(t, err)
+	})
+}
+
+func TestKeyFileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.pem")
+
+	first, _ := rsa.GenerateKey(rand.Reader, 2048)
+	second, _ := rsa.GenerateKey(rand.Reader, 2048)
+	if err := os.WriteFile(path, pemPublicKey(t, &first.PublicKey), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	kf, err := auth.NewKeyFile(path, 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, first.PublicKey.Equal(onlyKey(kf.Keys())))
+
+	// Act
+	if err := os.WriteFile(path, pemPublicKey(t, &second.PublicKey), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(path, future, future)
+
+	// Assertions
+	assert.True(t, second.PublicKey.Equal(onlyKey(kf.Keys())))
+
+	// a broken rotation keeps serving the last good keys
+	os.WriteFile(path, []byte("garbage"), 0o600)
+	later := future.Add(time.Minute)
+	os.Chtimes(path, later, later)
+	assert.True(t, second.PublicKey.Equal(onlyKey(kf.Keys())))
+}
+
+func onlyKey(keys auth.KeySet) interface{} {
+	for _, k := range keys {
+		return k
+	}
+	return nil
+}
+
+func pemPublicKey(t *testing.T, pub interface{}) []byte {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
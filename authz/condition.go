@@ -0,0 +1,126 @@
+package authz
+
+import (
+	"fmt"
+)
+
+// Condition compares an expense attribute with a value.
+//
+// Supported operators are eq, ne, lt, lte, gt, gte (numbers and strings),
+// in (attribute is one of value) and contains (tags include value).
+type Condition struct {
+	Attribute string      `json:"attribute"`
+	Operator  string      `json:"operator"`
+	Value     interface{} `json:"value"`
+}
+
+func (c Condition) validate() error {
+	if c.Attribute == "" {
+		return fmt.Errorf("condition missing attribute")
+	}
+	switch c.Operator {
+	case "eq", "ne", "lt", "lte", "gt", "gte", "in", "contains":
+		return nil
+	default:
+		return fmt.Errorf("unknown operator %q", c.Operator)
+	}
+}
+
+func (c Condition) eval(attrs map[string]interface{}) (bool, string) {
+	actual, ok := attrs[c.Attribute]
+	if !ok {
+		return false, fmt.Sprintf("attribute %q is not set", c.Attribute)
+	}
+
+	var matched bool
+	switch c.Operator {
+	case "eq":
+		matched = equal(actual, c.Value)
+	case "ne":
+		matched = !equal(actual, c.Value)
+	case "lt", "lte", "gt", "gte":
+		matched = compare(actual, c.Value, c.Operator)
+	case "in":
+		for _, v := range list(c.Value) {
+			if equal(actual, v) {
+				matched = true
+				break
+			}
+		}
+	case "contains":
+		for _, v := range list(actual) {
+			if equal(v, c.Value) {
+				matched = true
+				break
+			}
+		}
+	}
+
+	if !matched {
+		return false, fmt.Sprintf("%s %v is not %s %v", c.Attribute, actual, c.Operator, c.Value)
+	}
+	return true, fmt.Sprintf("%s %v is %s %v", c.Attribute, actual, c.Operator, c.Value)
+}
+
+func equal(a, b interface{}) bool {
+	if af, ok := number(a); ok {
+		bf, ok := number(b)
+		return ok && af == bf
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+// compare orders two numbers, or two strings byte by byte, values of
+// other or mixed types never compare
+func compare(a, b interface{}, op string) bool {
+	af, aok := number(a)
+	bf, bok := number(b)
+	if aok && bok {
+		return ordered(af, bf, op)
+	}
+	as, aok := a.(string)
+	bs, bok := b.(string)
+	if aok && bok {
+		return ordered(as, bs, op)
+	}
+	return false
+}
+
+func ordered[T float64 | string](a, b T, op string) bool {
+	switch op {
+	case "lt":
+		return a < b
+	case "lte":
+		return a <= b
+	case "gt":
+		return a > b
+	default:
+		return a >= b
+	}
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
+
+func list(v interface{}) []interface{} {
+	switch l := v.(type) {
+	case []interface{}:
+		return l
+	case []string:
+		out := make([]interface{}, len(l))
+		for i, s := range l {
+			out[i] = s
+		}
+		return out
+	default:
+		return nil
+	}
+}
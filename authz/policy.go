@@ -0,0 +1,241 @@
+package authz
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Policy is the declarative role based access control document.
+//
+//	{
+//	  "roles": {
+//	    "approver": {"permissions": [
+//	      {"action": "expenses:read"},
+//	      {"action": "expenses:update", "fields": ["note"]},
+//	      {"action": "expenses:create", "conditions": [{"attribute": "amount", "operator": "lte", "value": 10000}]}
+//	    ]}
+//	  },
+//	  "assignments": {"static-token": ["admin"]}
+//	}
+type Policy struct {
+	Roles       map[string]Role     `json:"roles"`
+	Assignments map[string][]string `json:"assignments"`
+}
+
+// Role groups the permissions granted to its members
+type Role struct {
+	Permissions []Permission `json:"permissions"`
+}
+
+// Permission allows an action when every condition holds. A non empty
+// Fields list restricts updates to those attributes.
+type Permission struct {
+	Action     string      `json:"action"`
+	Conditions []Condition `json:"conditions,omitempty"`
+	Fields     []string    `json:"fields,omitempty"`
+}
+
+// LoadPolicy reads and validates a JSON policy file
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read policy file: %s", err.Error())
+	}
+	return ParsePolicy(data)
+}
+
+// ParsePolicy decodes and validates a JSON policy document
+func ParsePolicy(data []byte) (*Policy, error) {
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("can't decode policy: %s", err.Error())
+	}
+
+	for name, role := range p.Roles {
+		for i, perm := range role.Permissions {
+			if perm.Action == "" {
+				return nil, fmt.Errorf("role %q permission %d: missing action", name, i)
+			}
+			for _, cond := range perm.Conditions {
+				if err := cond.validate(); err != nil {
+					return nil, fmt.Errorf("role %q permission %d: %s", name, i, err.Error())
+				}
+			}
+		}
+	}
+	for subject, roles := range p.Assignments {
+		for _, r := range roles {
+			if _, ok := p.Roles[r]; !ok {
+				return nil, fmt.Errorf("assignment for %q: unknown role %q", subject, r)
+			}
+		}
+	}
+
+	return &p, nil
+}
+
+// Request describes an access attempt
+type Request struct {
+	Subject string
+	Roles   []string
+	Action  string
+	// Resource holds the attributes of the expense being accessed, nil for collections
+	Resource map[string]interface{}
+	// Proposed holds the attributes an update or revert would leave the
+	// expense with, conditions must hold on it as well as on Resource
+	Proposed map[string]interface{}
+	// Changed lists the attributes an update modifies
+	Changed []string
+}
+
+// Step records how a single permission was evaluated
+type Step struct {
+	Role    string `json:"role"`
+	Action  string `json:"action"`
+	Matched bool   `json:"matched"`
+	Reason  string `json:"reason"`
+	denial  *Denial
+}
+
+// Kinds of denial
+const (
+	// DeniedAction when no role holds a permission for the action
+	DeniedAction = "action"
+	// DeniedCondition when the resource fails the condition on Name
+	DeniedCondition = "condition"
+	// DeniedResource when the condition on Name needs a single resource
+	DeniedResource = "resource"
+	// DeniedField when field Name may not be modified
+	DeniedField = "field"
+)
+
+// Denial is why a request was denied, for callers wording it themselves
+// where Reason is an English explanation
+type Denial struct {
+	Kind string
+	// Name is the attribute or field concerned, empty for DeniedAction
+	Name string
+}
+
+// Decision is the outcome of an evaluation along with its explanation
+type Decision struct {
+	Allowed bool     `json:"allowed"`
+	Subject string   `json:"subject"`
+	Roles   []string `json:"roles"`
+	Action  string   `json:"action"`
+	Reason  string   `json:"reason"`
+	Trace   []Step   `json:"trace"`
+	// Denial is set when the request is not allowed
+	Denial *Denial `json:"-"`
+}
+
+// RolesFor merges the roles carried by the caller with those assigned in the policy
+func (p *Policy) RolesFor(subject string, claimed []string) []string {
+	seen := map[string]bool{}
+	roles := []string{}
+	for _, r := range append(append([]string(nil), claimed...), p.Assignments[subject]...) {
+		if !seen[r] {
+			seen[r] = true
+			roles = append(roles, r)
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// Evaluate decides whether req is allowed, denying unless a permission matches
+func (p *Policy) Evaluate(req Request) Decision {
+	roles := p.RolesFor(req.Subject, req.Roles)
+	d := Decision{
+		Subject: req.Subject,
+		Roles:   roles,
+		Action:  req.Action,
+		Trace:   []Step{},
+	}
+
+	for _, name := range roles {
+		role, ok := p.Roles[name]
+		if !ok {
+			d.Trace = append(d.Trace, Step{Role: name, Reason: "role is not defined in policy"})
+			continue
+		}
+		for _, perm := range role.Permissions {
+			if perm.Action != req.Action && perm.Action != "*" {
+				continue
+			}
+			step := Step{Role: name, Action: perm.Action}
+			step.Matched, step.Reason, step.denial = perm.allows(req)
+			d.Trace = append(d.Trace, step)
+
+			if step.Matched && !d.Allowed {
+				d.Allowed = true
+				d.Reason = fmt.Sprintf("granted by role %q: %s", name, step.Reason)
+			}
+		}
+	}
+
+	if !d.Allowed {
+		d.Reason = fmt.Sprintf("no permission of roles %v grants %q", roles, req.Action)
+		d.Denial = &Denial{Kind: DeniedAction}
+		for _, step := range d.Trace {
+			if step.Action != "" {
+				d.Reason += ": " + step.Reason
+				d.Denial = step.denial
+				break
+			}
+		}
+	}
+	return d
+}
+
+// Grants reports whether any role of the caller holds a permission for
+// action, leaving its conditions to be evaluated per resource
+func (p *Policy) Grants(subject string, claimed []string, action string) bool {
+	for _, name := range p.RolesFor(subject, claimed) {
+		for _, perm := range p.Roles[name].Permissions {
+			if perm.Action == action || perm.Action == "*" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (perm Permission) allows(req Request) (bool, string, *Denial) {
+	for _, cond := range perm.Conditions {
+		if req.Resource == nil {
+			return false, fmt.Sprintf("condition on %q needs a single expense", cond.Attribute),
+				&Denial{Kind: DeniedResource, Name: cond.Attribute}
+		}
+		ok, reason := cond.eval(req.Resource)
+		if !ok {
+			return false, reason, &Denial{Kind: DeniedCondition, Name: cond.Attribute}
+		}
+		if req.Proposed == nil {
+			continue
+		}
+		if ok, reason := cond.eval(req.Proposed); !ok {
+			return false, "proposed " + reason, &Denial{Kind: DeniedCondition, Name: cond.Attribute}
+		}
+	}
+
+	if len(perm.Fields) > 0 {
+		allowed := map[string]bool{}
+		for _, f := range perm.Fields {
+			allowed[f] = true
+		}
+		for _, f := range req.Changed {
+			if !allowed[f] {
+				return false, fmt.Sprintf("field %q may not be modified, allowed fields are %v", f, perm.Fields),
+					&Denial{Kind: DeniedField, Name: f}
+			}
+		}
+	}
+
+	if len(perm.Conditions) == 0 && len(perm.Fields) == 0 {
+		return true, "unconditional permission", nil
+	}
+	return true, "all conditions satisfied", nil
+}
@@ -0,0 +1,198 @@
+//go:build unit
+// +build unit
+
+package authz_test
+
+import (
+	"testing"
+
+	"github.com/bazsup/assessment/authz"
+	"github.com/stretchr/testify/assert"
+)
+
+const policyDoc = `{
+	"roles": {
+		"admin": {"permissions": [{"action": "*"}]},
+		"approver": {"permissions": [
+			{"action": "expenses:read"},
+			{"action": "expenses:update", "fields": ["note"]}
+		]},
+		"employee": {"permissions": [
+			{"action": "expenses:create", "conditions": [
+				{"attribute": "amount", "operator": "lte", "value": 10000},
+				{"attribute": "tags", "operator": "contains", "value": "travel"}
+			]}
+		]}
+	},
+	"assignments": {"static-token": ["admin"]}
+}`
+
+func TestEvaluate(t *testing.T) {
+	policy, err := authz.ParsePolicy([]byte(policyDoc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		req     authz.Request
+		allowed bool
+	}{
+		{
+			name:    "Assigned admin may do anything",
+			req:     authz.Request{Subject: "static-token", Action: "expenses:update"},
+			allowed: true,
+		},
+		{
+			name:    "Approver may read the whole list",
+			req:     authz.Request{Subject: "u1", Roles: []string{"approver"}, Action: "expenses:read"},
+			allowed: true,
+		},
+		{
+			name:    "Approver may edit notes",
+			req:     authz.Request{Subject: "u1", Roles: []string{"approver"}, Action: "expenses:update", Changed: []string{"note"}},
+			allowed: true,
+		},
+		{
+			name:    "Approver may not edit amounts",
+			req:     authz.Request{Subject: "u1", Roles: []string{"approver"}, Action: "expenses:update", Changed: []string{"note", "amount"}},
+			allowed: false,
+		},
+		{
+			name: "Employee may create small travel expenses",
+			req: authz.Request{Subject: "u2", Roles: []string{"employee"}, Action: "expenses:create",
+				Resource: map[string]interface{}{"amount": 900.0, "tags": []string{"travel"}}},
+			allowed: true,
+		},
+		{
+			name: "Employee may not create large expenses",
+			req: authz.Request{Subject: "u2", Roles: []string{"employee"}, Action: "expenses:create",
+				Resource: map[string]interface{}{"amount": 39000.0, "tags": []string{"travel"}}},
+			allowed: false,
+		},
+		{
+			name:    "Conditions without a resource deny",
+			req:     authz.Request{Subject: "u2", Roles: []string{"employee"}, Action: "expenses:create"},
+			allowed: false,
+		},
+		{
+			name:    "Unknown role is denied",
+			req:     authz.Request{Subject: "u3", Roles: []string{"guest"}, Action: "expenses:read"},
+			allowed: false,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt // rebind tt into this lexical scope
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			d := policy.Evaluate(tt.req)
+
+			// Assertions
+			assert.Equal(t, tt.allowed, d.Allowed, d.Reason)
+			assert.NotEmpty(t, d.Reason)
+		})
+	}
+
+	t.Run("Denied decision explains each evaluated permission", func(t *testing.T) {
+		// Act
+		d := policy.Evaluate(authz.Request{Subject: "u1", Roles: []string{"approver"}, Action: "expenses:update", Changed: []string{"amount"}})
+
+		// Assertions
+		if assert.Len(t, d.Trace, 1) {
+			assert.Equal(t, "approver", d.Trace[0].Role)
+			assert.False(t, d.Trace[0].Matched)
+			assert.Contains(t, d.Trace[0].Reason, `field "amount" may not be modified`)
+		}
+		assert.Equal(t, &authz.Denial{Kind: authz.DeniedField, Name: "amount"}, d.Denial)
+	})
+
+	t.Run("Conditions hold on the proposed expense too", func(t *testing.T) {
+		// Act
+		d := policy.Evaluate(authz.Request{Subject: "u2", Roles: []string{"employee"}, Action: "expenses:create",
+			Resource: map[string]interface{}{"amount": 900.0, "tags": []string{"travel"}},
+			Proposed: map[string]interface{}{"amount": 39000.0, "tags": []string{"travel"}}})
+
+		// Assertions
+		assert.False(t, d.Allowed)
+		assert.Equal(t, &authz.Denial{Kind: authz.DeniedCondition, Name: "amount"}, d.Denial)
+		assert.Contains(t, d.Reason, "proposed amount")
+	})
+
+	t.Run("Denied decision without a permission for the action", func(t *testing.T) {
+		// Act
+		d := policy.Evaluate(authz.Request{Subject: "u3", Roles: []string{"guest"}, Action: "expenses:read"})
+
+		// Assertions
+		assert.Equal(t, &authz.Denial{Kind: authz.DeniedAction}, d.Denial)
+	})
+}
+
+func TestCompareConditions(t *testing.T) {
+	policy, err := authz.ParsePolicy([]byte(`{"roles": {"early": {"permissions": [
+		{"action": "expenses:read", "conditions": [{"attribute": "title", "operator": "lt", "value": "m"}]}]}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		title   interface{}
+		allowed bool
+	}{
+		{"coffee", true},
+		{"taxi", false},
+		{"m", false},
+		{10.0, false},
+	}
+
+	for _, tt := range tests {
+		// Act
+		d := policy.Evaluate(authz.Request{Roles: []string{"early"}, Action: "expenses:read",
+			Resource: map[string]interface{}{"title": tt.title}})
+
+		// Assertions
+		assert.Equal(t, tt.allowed, d.Allowed, d.Reason)
+	}
+}
+
+func TestGrants(t *testing.T) {
+	policy, err := authz.ParsePolicy([]byte(policyDoc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Conditional permission grants the action", func(t *testing.T) {
+		assert.True(t, policy.Grants("u1", []string{"employee"}, "expenses:create"))
+	})
+
+	t.Run("Wildcard permission grants every action", func(t *testing.T) {
+		assert.True(t, policy.Grants("static-token", nil, "expenses:read"))
+	})
+
+	t.Run("Role without the permission does not grant it", func(t *testing.T) {
+		assert.False(t, policy.Grants("u1", []string{"employee"}, "expenses:read"))
+	})
+}
+
+func TestParsePolicy(t *testing.T) {
+	negativeTests := []struct {
+		name string
+		doc  string
+	}{
+		{"Invalid JSON", `{`},
+		{"Missing action", `{"roles": {"r": {"permissions": [{}]}}}`},
+		{"Unknown operator", `{"roles": {"r": {"permissions": [{"action": "a", "conditions": [{"attribute": "amount", "operator": "like"}]}]}}}`},
+		{"Assignment to unknown role", `{"roles": {}, "assignments": {"u": ["ghost"]}}`},
+	}
+
+	for _, tt := range negativeTests {
+		tt := tt // rebind tt into this lexical scope
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			_, err := authz.ParsePolicy([]byte(tt.doc))
+
+			// Assertions
+			assert.Error(t, err)
+		})
+	}
+}
@@ -1,6 +1,11 @@
 package config
 
-import "os"
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
 
 func getenv(name string) string {
 	v := os.Getenv(name)
@@ -10,18 +15,333 @@ func getenv(name string) string {
 	return v
 }
 
+func getenvDefault(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+func getenvInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		panic("invalid integer environment variable: " + name)
+	}
+	return n
+}
+
+func getenvFloat(name string, def float64) float64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		panic("invalid number environment variable: " + name)
+	}
+	return f
+}
+
+func getenvBool(name string, def bool) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		panic("invalid boolean environment variable: " + name)
+	}
+	return b
+}
+
+func getenvDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		panic("invalid duration environment variable: " + name)
+	}
+	return d
+}
+
+// getenvList splits a comma separated variable, dropping empty items
+func getenvList(name string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 // Config contains app config like running port and database url
 type Config struct {
 	Port        string
+	GRPCPort    string // "off" disables the gRPC API
 	DatabaseUrl string
 	AuthToken   string
+	JWT         JWTConfig
+	// AuthzPolicyFile enables role based access control when set
+	AuthzPolicyFile string
+	// StoreBackend is "state" to write the expenses table directly, or
+	// "events" to append to the event log and project it into the table.
+	// Changes made after switching from events back to state are not in
+	// the log and are lost by the next projection rebuild.
+	StoreBackend string
+	RateLimit    RateLimitConfig
+	Idempotency  IdempotencyConfig
+	// MigrationsMode is "auto" to apply pending migrations on startup,
+	// "check" to refuse starting when the schema is behind, or "off"
+	MigrationsMode string
+	// DBReadTimeout and DBWriteTimeout bound a single expense query
+	DBReadTimeout  time.Duration
+	DBWriteTimeout time.Duration
+	DBPool         PoolConfig
+	// DBStartupMaxWait bounds how long startup waits for the database to accept connections
+	DBStartupMaxWait time.Duration
+	// DBReadAttempts caps attempts of an idempotent read hitting transient errors
+	DBReadAttempts int
+	Tracing        TracingConfig
+	Log            LogConfig
+	Audit          AuditConfig
+	Webhooks       WebhookConfig
+	Stream         StreamConfig
+	GraphQL        GraphQLConfig
+	// DefaultLanguage words API messages for clients without a supported
+	// Accept-Language, either "en" or "th"
+	DefaultLanguage string
+	// MaxBodySize caps request bodies as in "1M", larger ones get 413
+	MaxBodySize string
+	// MetricTags are the expense tags metrics are labelled with, the
+	// others are counted as "other"
+	MetricTags []string
+	// TrustedProxies are the CIDRs or addresses of the proxies whose
+	// X-Forwarded-For is believed, clients are their peer address without
+	TrustedProxies []string
+	// ReadinessGrace is how long readiness fails before the server stops
+	// accepting requests, so load balancers take the instance out first
+	ReadinessGrace time.Duration
+}
+
+// JWTConfig contains bearer token settings, JWT auth is disabled when KeysFile is empty
+type JWTConfig struct {
+	KeysFile      string
+	Audience      string
+	Issuer        string
+	IdentityClaim string
+	ScopesClaim   string
+	RolesClaim    string
+}
+
+// RateLimitConfig contains per client limits, a zero rate disables the class limit
+type RateLimitConfig struct {
+	// Store is either "memory" or "postgres"
+	Store           string
+	ReadRate        float64
+	ReadBurst       int
+	WriteRate       float64
+	WriteBurst      int
+	DailyWriteQuota int
+}
+
+// PoolConfig contains the database/sql connection pool settings, zero keeps the driver default
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// TracingConfig contains the OpenTelemetry tracing settings
+type TracingConfig struct {
+	// Exporter is "none", "stdout", "file" or "otlp"
+	Exporter string
+	// File receives spans as JSON lines when Exporter is "file"
+	File string
+	// Endpoint is the OTLP/HTTP traces endpoint when Exporter is "otlp"
+	Endpoint    string
+	ServiceName string
+	// SampleRatio is the fraction of new traces recorded, incoming traceparent flags win
+	SampleRatio float64
+}
+
+// LogConfig contains the structured logging settings
+type LogConfig struct {
+	// Level is "debug", "info", "warn" or "error"
+	Level string
+	// Format is "json" or "text"
+	Format string
+	// Redact hides Authorization headers and expense notes, on by default
+	Redact bool
+}
+
+// AuditConfig contains the audit chain checkpoint settings, checkpoints are
+// not signed when SigningKeyFile is empty
+type AuditConfig struct {
+	// SigningKeyFile is a PKCS #8 PEM holding an Ed25519 private key
+	SigningKeyFile     string
+	CheckpointInterval time.Duration
+}
+
+// WebhookConfig contains the outbox dispatcher settings. Changes still
+// queue up in the outbox while Enabled is false and are delivered once
+// webhooks are enabled again.
+type WebhookConfig struct {
+	Enabled bool
+	// Interval is how often the outbox is polled for events to deliver
+	Interval time.Duration
+	// MaxAttempts dead letters a delivery after that many failures
+	MaxAttempts int
+	// InitialBackoff doubles after each failure up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Timeout bounds a single POST to a subscriber
+	Timeout time.Duration
+	// OutboxRetention is how long fanned out events are kept in the outbox
+	OutboxRetention time.Duration
+}
+
+// StreamConfig contains the expense change stream settings
+type StreamConfig struct {
+	Enabled bool
+	// Heartbeat is how often idle streams are sent a heartbeat
+	Heartbeat time.Duration
+	// BufferSize is how many events a client may fall behind before it is disconnected
+	BufferSize int
+	// MaxBacklog caps the events replayed to a client resuming with Last-Event-ID
+	MaxBacklog int
+	// PollInterval is how often the outbox is read when no change is notified
+	PollInterval time.Duration
+}
+
+// GraphQLConfig bounds the queries of the GraphQL endpoint, zero is unbounded
+type GraphQLConfig struct {
+	MaxDepth      int
+	MaxComplexity int
+}
+
+// IdempotencyConfig contains the Idempotency-Key settings
+type IdempotencyConfig struct {
+	// Store is either "memory" or "postgres"
+	Store string
+	TTL   time.Duration
+	// LockTimeout is how long a key stays in progress before a retry may take it over
+	LockTimeout time.Duration
 }
 
 // NewConfig returns a new app config
 func NewConfig() *Config {
 	return &Config{
 		Port:        getenv("PORT"),
+		GRPCPort:    getenvDefault("GRPC_PORT", ":2566"),
 		DatabaseUrl: getenv("DATABASE_URL"),
 		AuthToken:   getenv("AUTH_TOKEN"),
+		JWT: JWTConfig{
+			KeysFile:      getenvDefault("JWT_KEYS_FILE", ""),
+			Audience:      getenvDefault("JWT_AUDIENCE", ""),
+			Issuer:        getenvDefault("JWT_ISSUER", ""),
+			IdentityClaim: getenvDefault("JWT_IDENTITY_CLAIM", "sub"),
+			ScopesClaim:   getenvDefault("JWT_SCOPES_CLAIM", "scope"),
+			RolesClaim:    getenvDefault("JWT_ROLES_CLAIM", "roles"),
+		},
+		AuthzPolicyFile: getenvDefault("AUTHZ_POLICY_FILE", ""),
+		StoreBackend:    getenvDefault("STORE_BACKEND", "state"),
+		RateLimit: RateLimitConfig{
+			Store:           getenvDefault("RATE_LIMIT_STORE", "memory"),
+			ReadRate:        getenvFloat("RATE_LIMIT_READ_RATE", 50),
+			ReadBurst:       getenvInt("RATE_LIMIT_READ_BURST", 100),
+			WriteRate:       getenvFloat("RATE_LIMIT_WRITE_RATE", 5),
+			WriteBurst:      getenvInt("RATE_LIMIT_WRITE_BURST", 10),
+			DailyWriteQuota: getenvInt("RATE_LIMIT_DAILY_WRITE_QUOTA", 0),
+		},
+		MigrationsMode:   getenvDefault("MIGRATIONS_MODE", "auto"),
+		DBReadTimeout:    getenvDuration("DB_READ_TIMEOUT", 5*time.Second),
+		DBWriteTimeout:   getenvDuration("DB_WRITE_TIMEOUT", 5*time.Second),
+		DBStartupMaxWait: getenvDuration("DB_STARTUP_MAX_WAIT", time.Minute),
+		DBReadAttempts:   getenvInt("DB_READ_ATTEMPTS", 3),
+		DBPool:           poolConfig(),
+		Idempotency: IdempotencyConfig{
+			Store:       getenvDefault("IDEMPOTENCY_STORE", "memory"),
+			TTL:         getenvDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+			LockTimeout: getenvDuration("IDEMPOTENCY_LOCK_TIMEOUT", time.Minute),
+		},
+		Tracing: TracingConfig{
+			Exporter:    getenvDefault("TRACING_EXPORTER", "none"),
+			File:        getenvDefault("TRACING_FILE", "traces.jsonl"),
+			Endpoint:    getenvDefault("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "http://localhost:4318/v1/traces"),
+			ServiceName: getenvDefault("OTEL_SERVICE_NAME", "expenses"),
+			SampleRatio: getenvFloat("TRACING_SAMPLE_RATIO", 1),
+		},
+		Log:   logConfig(),
+		Audit: auditConfig(),
+		Webhooks: WebhookConfig{
+			Enabled:         getenvBool("WEBHOOKS_ENABLED", true),
+			Interval:        getenvDuration("WEBHOOKS_INTERVAL", 5*time.Second),
+			MaxAttempts:     getenvInt("WEBHOOKS_MAX_ATTEMPTS", 10),
+			InitialBackoff:  getenvDuration("WEBHOOKS_INITIAL_BACKOFF", 30*time.Second),
+			MaxBackoff:      getenvDuration("WEBHOOKS_MAX_BACKOFF", 6*time.Hour),
+			Timeout:         getenvDuration("WEBHOOKS_TIMEOUT", 10*time.Second),
+			OutboxRetention: getenvDuration("WEBHOOKS_OUTBOX_RETENTION", 7*24*time.Hour),
+		},
+		Stream: StreamConfig{
+			Enabled:      getenvBool("STREAM_ENABLED", true),
+			Heartbeat:    getenvDuration("STREAM_HEARTBEAT", 15*time.Second),
+			BufferSize:   getenvInt("STREAM_BUFFER_SIZE", 256),
+			MaxBacklog:   getenvInt("STREAM_MAX_BACKLOG", 1000),
+			PollInterval: getenvDuration("STREAM_POLL_INTERVAL", 30*time.Second),
+		},
+		GraphQL: GraphQLConfig{
+			MaxDepth:      getenvInt("GRAPHQL_MAX_DEPTH", 8),
+			MaxComplexity: getenvInt("GRAPHQL_MAX_COMPLEXITY", 2000),
+		},
+		DefaultLanguage: getenvDefault("DEFAULT_LANGUAGE", "en"),
+		MaxBodySize:     getenvDefault("MAX_BODY_SIZE", "1M"),
+		MetricTags:      getenvList("METRICS_TAGS"),
+		TrustedProxies:  getenvList("TRUSTED_PROXIES"),
+		ReadinessGrace:  getenvDuration("SHUTDOWN_READINESS_GRACE", 5*time.Second),
+	}
+}
+
+// NewToolConfig returns the config of the migrate, verify-audit and
+// rebuild-projection subcommands, which only read the database, logging
+// and audit settings so they run without the server's PORT and AUTH_TOKEN
+func NewToolConfig() *Config {
+	return &Config{
+		DatabaseUrl:      getenv("DATABASE_URL"),
+		DBStartupMaxWait: getenvDuration("DB_STARTUP_MAX_WAIT", time.Minute),
+		DBPool:           poolConfig(),
+		Log:              logConfig(),
+		Audit:            auditConfig(),
+	}
+}
+
+func poolConfig() PoolConfig {
+	return PoolConfig{
+		MaxOpenConns:    getenvInt("DB_MAX_OPEN_CONNS", 25),
+		MaxIdleConns:    getenvInt("DB_MAX_IDLE_CONNS", 25),
+		ConnMaxLifetime: getenvDuration("DB_CONN_MAX_LIFETIME", 30*time.Minute),
+		ConnMaxIdleTime: getenvDuration("DB_CONN_MAX_IDLE_TIME", 5*time.Minute),
+	}
+}
+
+func logConfig() LogConfig {
+	return LogConfig{
+		Level:  getenvDefault("LOG_LEVEL", "info"),
+		Format: getenvDefault("LOG_FORMAT", "json"),
+		Redact: getenvBool("LOG_REDACT", true),
+	}
+}
+
+func auditConfig() AuditConfig {
+	return AuditConfig{
+		SigningKeyFile:     getenvDefault("AUDIT_SIGNING_KEY_FILE", ""),
+		CheckpointInterval: getenvDuration("AUDIT_CHECKPOINT_INTERVAL", time.Hour),
 	}
 }
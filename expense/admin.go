@@ -0,0 +1,63 @@
+package expense
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/bazsup/assessment/problem"
+	"github.com/labstack/echo/v4"
+)
+
+// poolStatser is implemented by stores backed by a database/sql pool
+type poolStatser interface {
+	Stats() sql.DBStats
+}
+
+// PoolStats is the JSON view of sql.DBStats, durations are in milliseconds
+type PoolStats struct {
+	MaxOpenConnections int   `json:"max_open_connections"`
+	OpenConnections    int   `json:"open_connections"`
+	InUse              int   `json:"in_use"`
+	Idle               int   `json:"idle"`
+	WaitCount          int64 `json:"wait_count"`
+	WaitDurationMs     int64 `json:"wait_duration_ms"`
+	MaxIdleClosed      int64 `json:"max_idle_closed"`
+	MaxIdleTimeClosed  int64 `json:"max_idle_time_closed"`
+	MaxLifetimeClosed  int64 `json:"max_lifetime_closed"`
+}
+
+// unwrapper is implemented by storer decorators
+type unwrapper interface {
+	unwrap() storer
+}
+
+// baseStore strips the decorators off store
+func baseStore(store storer) storer {
+	for {
+		u, ok := store.(unwrapper)
+		if !ok {
+			return store
+		}
+		store = u.unwrap()
+	}
+}
+
+func (h *handler) DBStats(c echo.Context) error {
+	s, ok := baseStore(h.store).(poolStatser)
+	if !ok {
+		return problem.Localized(problem.NotConfigured, problem.DetailPoolOff)
+	}
+
+	stats := s.Stats()
+	return c.JSON(http.StatusOK, PoolStats{
+		MaxOpenConnections: stats.MaxOpenConnections,
+		OpenConnections:    stats.OpenConnections,
+		InUse:              stats.InUse,
+		Idle:               stats.Idle,
+		WaitCount:          stats.WaitCount,
+		WaitDurationMs:     stats.WaitDuration.Milliseconds(),
+		MaxIdleClosed:      stats.MaxIdleClosed,
+		MaxIdleTimeClosed:  stats.MaxIdleTimeClosed,
+		MaxLifetimeClosed:  stats.MaxLifetimeClosed,
+	})
+}
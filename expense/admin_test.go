@@ -0,0 +1,48 @@
+//go:build unit
+// +build unit
+
+package expense_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bazsup/assessment/expense"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDBStats(t *testing.T) {
+	t.Run("Pool stats of the expense store", func(t *testing.T) {
+		t.Parallel()
+		db, _, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.SetMaxOpenConns(7)
+
+		e := newEcho()
+		expense.NewApp(e, expense.NewExpenseStore(db), testAuthToken)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/admin/db/stats", testAuthToken)
+
+		var stats expense.PoolStats
+		json.Unmarshal(rec.Body.Bytes(), &stats)
+
+		// Assertions
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, 7, stats.MaxOpenConnections)
+	})
+
+	t.Run("Store without a pool should returns status not found", func(t *testing.T) {
+		e, _ := setupApp(t)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/admin/db/stats", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
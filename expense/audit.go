@@ -0,0 +1,232 @@
+package expense
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bazsup/assessment/audit"
+	"github.com/bazsup/assessment/logging"
+	"github.com/bazsup/assessment/problem"
+	"github.com/labstack/echo/v4"
+)
+
+// auditVerifier is implemented by stores keeping a hash chained audit log
+type auditVerifier interface {
+	VerifyAudit(ctx context.Context) (audit.Report, error)
+}
+
+// Audit actions recorded for expense changes
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+	AuditRevert = "revert"
+)
+
+// systemActor is recorded for changes made outside of a request
+const systemActor = "system"
+
+// Actor is who made a change, recorded with every audit entry
+type Actor struct {
+	Subject   string
+	RequestID string
+	SourceIP  string
+}
+
+type actorKey struct{}
+
+// WithActor returns ctx carrying the actor the store records changes under
+func WithActor(ctx context.Context, a Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, a)
+}
+
+// ActorFrom returns the actor of ctx, the system actor when none was set
+func ActorFrom(ctx context.Context) Actor {
+	if a, ok := ctx.Value(actorKey{}).(Actor); ok {
+		return a
+	}
+	return Actor{Subject: systemActor}
+}
+
+// actorOf describes the caller of an echo request, its source IP is read
+// by the IPExtractor NewApp made sure not to believe client headers
+func actorOf(c echo.Context) Actor {
+	a := Actor{
+		Subject:   "anonymous",
+		RequestID: logging.RequestID(c.Request().Context()),
+		SourceIP:  c.RealIP(),
+	}
+	if id := IdentityFrom(c); id != nil {
+		a.Subject = id.Subject
+	}
+	return a
+}
+
+// FieldChange is the value of a field before and after a change, nil when
+// the expense did not exist on that side
+type FieldChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AuditEntry is an append only record of one expense change
+type AuditEntry struct {
+	ID        int64                  `json:"id"`
+	ExpenseID int                    `json:"expense_id"`
+	Action    string                 `json:"action"`
+	Actor     string                 `json:"actor"`
+	RequestID string                 `json:"request_id"`
+	SourceIP  string                 `json:"source_ip"`
+	Changes   map[string]FieldChange `json:"changes"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// auditedFields are the expense fields diffed by the audit log
+var auditedFields = []string{"title", "amount", "note", "tags"}
+
+// diffExpenses returns the changed fields, every field when before or after is nil
+func diffExpenses(before, after *Expense) map[string]FieldChange {
+	fields := auditedFields
+	if before != nil && after != nil {
+		fields = changedFields(*before, *after)
+	}
+
+	changes := map[string]FieldChange{}
+	for _, f := range fields {
+		var c FieldChange
+		if before != nil {
+			c.Before = before.attributes()[f]
+		}
+		if after != nil {
+			c.After = after.attributes()[f]
+		}
+		changes[f] = c
+	}
+	return changes
+}
+
+// AuditQuery filters the admin audit query, zero values do not filter
+type AuditQuery struct {
+	ExpenseID int
+	Actor     string
+	Action    string
+	Since     time.Time
+	Until     time.Time
+	// After returns entries with a greater id, for paging
+	After int64
+	// Limit is DefaultAuditLimit when zero and capped at MaxAuditLimit
+	Limit int
+}
+
+// Audit query page sizes
+const (
+	DefaultAuditLimit = 100
+	MaxAuditLimit     = 1000
+)
+
+// ExpenseHistory lists the changes of an expense, oldest first
+func (h *handler) ExpenseHistory(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return problem.New(problem.ExpenseNotFound, "")
+	}
+
+	entries, err := h.store.ExpenseHistory(c.Request().Context(), id)
+	if err != nil {
+		return problem.From(err)
+	}
+	if len(entries) == 0 {
+		return problem.New(problem.ExpenseNotFound, "")
+	}
+	return c.JSON(http.StatusOK, entries)
+}
+
+// QueryAudit lists audit entries of every expense filtered by the
+// expense_id, actor, action, since and until query params, paged with
+// after and limit
+func (h *handler) QueryAudit(c echo.Context) error {
+	q, err := parseAuditQuery(c)
+	if err != nil {
+		return badQuery(err)
+	}
+
+	entries, err := h.store.QueryAudit(c.Request().Context(), q)
+	if err != nil {
+		return problem.From(err)
+	}
+	return c.JSON(http.StatusOK, entries)
+}
+
+// VerifyAudit reports whether the audit chain is intact
+func (h *handler) VerifyAudit(c echo.Context) error {
+	v, ok := baseStore(h.store).(auditVerifier)
+	if !ok {
+		return problem.Localized(problem.NotConfigured, problem.DetailAuditChainOff)
+	}
+
+	report, err := v.VerifyAudit(c.Request().Context())
+	if err != nil {
+		return problem.From(err)
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+type queryParamError string
+
+func (e queryParamError) Error() string {
+	return "invalid query param " + string(e)
+}
+
+// badQuery is the bad request answered for err, an invalid query param
+// is worded in the language of the request
+func badQuery(err error) *problem.Error {
+	var qe queryParamError
+	if errors.As(err, &qe) {
+		return problem.Localized(problem.BadRequest, problem.DetailInvalidQueryParam, string(qe))
+	}
+	return problem.New(problem.BadRequest, err.Error())
+}
+
+func parseAuditQuery(c echo.Context) (AuditQuery, error) {
+	q := AuditQuery{
+		Actor:  c.QueryParam("actor"),
+		Action: c.QueryParam("action"),
+		Limit:  DefaultAuditLimit,
+	}
+
+	var err error
+	if v := c.QueryParam("expense_id"); v != "" {
+		if q.ExpenseID, err = strconv.Atoi(v); err != nil {
+			return q, queryParamError("expense_id")
+		}
+	}
+	if v := c.QueryParam("after"); v != "" {
+		if q.After, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return q, queryParamError("after")
+		}
+	}
+	if v := c.QueryParam("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 || q.Limit > MaxAuditLimit {
+			return q, queryParamError("limit")
+		}
+	}
+	if v := c.QueryParam("since"); v != "" {
+		if q.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return q, queryParamError("since")
+		}
+	}
+	if v := c.QueryParam("until"); v != "" {
+		if q.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			return q, queryParamError("until")
+		}
+	}
+	switch q.Action {
+	case "", AuditCreate, AuditUpdate, AuditDelete, AuditRevert:
+	default:
+		return q, queryParamError("action")
+	}
+	return q, nil
+}
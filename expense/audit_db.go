@@ -0,0 +1,198 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bazsup/assessment/audit"
+	"github.com/bazsup/assessment/retry"
+)
+
+const (
+	insertAuditQuery = `
+	INSERT INTO expense_audit ( expense_id, action, actor, request_id, source_ip, changes, created_at, prev_hash, hash )
+	VALUES ( $1, $2, $3, $4, $5, $6, $7, $8, $9 )
+	`
+	lockChainQuery      = "SELECT pg_advisory_xact_lock($1)"
+	chainHeadQuery      = "SELECT hash FROM expense_audit WHERE hash <> '' ORDER BY id DESC LIMIT 1"
+	auditColumns        = "id, expense_id, action, actor, request_id, source_ip, changes, created_at"
+	expenseHistoryQuery = "SELECT " + auditColumns + " FROM expense_audit WHERE expense_id = $1 ORDER BY id"
+)
+
+// auditStmt prepares the audit insert, before the transaction begins so
+// preparing does not take a second connection while the transaction holds one
+func (e *ExpenseStore) auditStmt(ctx context.Context) (*sql.Stmt, error) {
+	stmt, err := e.stmt(ctx, insertAuditQuery)
+	if err != nil {
+		return nil, fmt.Errorf("can't prepare insert audit statement: %s", err.Error())
+	}
+	return stmt, nil
+}
+
+// recordAudit records a change of the expense in tx, the transaction making the
+// change, so the change and its audit entry commit together. The entry is
+// chained to the last one under a lock held until tx ends, so concurrent
+// changes can't both extend the same entry.
+func recordAudit(ctx context.Context, tx *sql.Tx, stmt *sql.Stmt, id int, action string, before, after *Expense) error {
+	changes, err := json.Marshal(diffExpenses(before, after))
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, lockChainQuery, audit.LockID); err != nil {
+		return fmt.Errorf("can't lock audit chain: %s", err.Error())
+	}
+	var prev string
+	err = tx.QueryRowContext(ctx, chainHeadQuery).Scan(&prev)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("can't read audit chain head: %s", err.Error())
+	}
+
+	actor := ActorFrom(ctx)
+	rec := audit.Record{
+		ExpenseID: id,
+		Action:    action,
+		Actor:     actor.Subject,
+		RequestID: actor.RequestID,
+		SourceIP:  actor.SourceIP,
+		Changes:   changes,
+		CreatedAt: audit.Timestamp(time.Now()),
+	}
+	hash, err := audit.Hash(prev, rec)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.StmtContext(ctx, stmt).ExecContext(ctx,
+		rec.ExpenseID, rec.Action, rec.Actor, rec.RequestID, rec.SourceIP, rec.Changes, rec.CreatedAt, prev, hash)
+	return err
+}
+
+// VerifyAudit walks the whole audit chain, it is not bounded by the read timeout
+func (e *ExpenseStore) VerifyAudit(ctx context.Context) (_ audit.Report, err error) {
+	ctx, span := e.span(ctx, "VerifyAudit", "")
+	defer func() { endSpan(span, err) }()
+
+	r, err := audit.Verify(ctx, e.DB, e.auditKey)
+	return r, ctxErr(ctx, err)
+}
+
+func (e *ExpenseStore) ExpenseHistory(ctx context.Context, id int) (_ []AuditEntry, err error) {
+	ctx, span := e.span(ctx, "ExpenseHistory", expenseHistoryQuery)
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, e.readTimeout)
+	defer cancel()
+
+	stmt, err := e.stmt(ctx, expenseHistoryQuery)
+	if err != nil {
+		return nil, fmt.Errorf("can't prepare query history statement: %s", err.Error())
+	}
+
+	var entries []AuditEntry
+	err = retry.Do(ctx, e.readPolicy(ctx), IsTransient, func(int) error {
+		rows, err := stmt.QueryContext(ctx, id)
+		if err != nil {
+			return err
+		}
+		entries, err = scanAuditEntries(rows)
+		return err
+	})
+	if err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	return entries, nil
+}
+
+// QueryAudit builds its query from the filters set, so it is not prepared
+func (e *ExpenseStore) QueryAudit(ctx context.Context, q AuditQuery) (_ []AuditEntry, err error) {
+	query, args := auditQuery(q)
+
+	ctx, span := e.span(ctx, "QueryAudit", query)
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, e.readTimeout)
+	defer cancel()
+
+	var entries []AuditEntry
+	err = retry.Do(ctx, e.readPolicy(ctx), IsTransient, func(int) error {
+		rows, err := e.DB.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		entries, err = scanAuditEntries(rows)
+		return err
+	})
+	if err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	return entries, nil
+}
+
+func auditQuery(q AuditQuery) (string, []interface{}) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, strings.Replace(cond, "?", "$"+strconv.Itoa(len(args)), 1))
+	}
+
+	if q.ExpenseID != 0 {
+		add("expense_id = ?", q.ExpenseID)
+	}
+	if q.Actor != "" {
+		add("actor = ?", q.Actor)
+	}
+	if q.Action != "" {
+		add("action = ?", q.Action)
+	}
+	if !q.Since.IsZero() {
+		add("created_at >= ?", q.Since)
+	}
+	if !q.Until.IsZero() {
+		add("created_at < ?", q.Until)
+	}
+	if q.After != 0 {
+		add("id > ?", q.After)
+	}
+
+	limit := q.Limit
+	switch {
+	case limit <= 0:
+		limit = DefaultAuditLimit
+	case limit > MaxAuditLimit:
+		limit = MaxAuditLimit
+	}
+
+	query := "SELECT " + auditColumns + " FROM expense_audit"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	return query + " ORDER BY id LIMIT " + strconv.Itoa(limit), args
+}
+
+func scanAuditEntries(rows *sql.Rows) ([]AuditEntry, error) {
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var a AuditEntry
+		var changes []byte
+		if err := rows.Scan(&a.ID, &a.ExpenseID, &a.Action, &a.Actor, &a.RequestID, &a.SourceIP, &changes, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(changes, &a.Changes); err != nil {
+			return nil, fmt.Errorf("can't decode changes of audit entry %d: %s", a.ID, err.Error())
+		}
+		entries = append(entries, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
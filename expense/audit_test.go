@@ -0,0 +1,229 @@
+//go:build unit
+// +build unit
+
+package expense_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bazsup/assessment/audit"
+	"github.com/bazsup/assessment/expense"
+	"github.com/bazsup/assessment/webhook"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+var auditRows = []string{"id", "expense_id", "action", "actor", "request_id", "source_ip", "changes", "created_at"}
+
+func TestExpenseHistory(t *testing.T) {
+	t.Run("History lists the changes of an expense", func(t *testing.T) {
+		e, store := setupApp(t)
+
+		// Arrange
+		store.ExpenseHistoryWillReturn([]expense.AuditEntry{
+			{ID: 1, ExpenseID: 1, Action: expense.AuditCreate, Actor: "alice"},
+			{ID: 2, ExpenseID: 1, Action: expense.AuditUpdate, Actor: "bob",
+				Changes: map[string]expense.FieldChange{"amount": {Before: 10.0, After: 20.0}}},
+		}, nil)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/expenses/1/history", testAuthToken)
+
+		var entries []expense.AuditEntry
+		json.Unmarshal(rec.Body.Bytes(), &entries)
+
+		// Assertions
+		assert.Equal(t, http.StatusOK, rec.Code)
+		if assert.Len(t, entries, 2) {
+			assert.Equal(t, "bob", entries[1].Actor)
+			assert.Equal(t, expense.FieldChange{Before: 10.0, After: 20.0}, entries[1].Changes["amount"])
+		}
+	})
+
+	t.Run("Unknown expense should returns status not found", func(t *testing.T) {
+		e, store := setupApp(t)
+
+		// Arrange
+		store.ExpenseHistoryWillReturn([]expense.AuditEntry{}, nil)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/expenses/9/history", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestQueryAudit(t *testing.T) {
+	t.Run("Query params filter the audit log", func(t *testing.T) {
+		e, store := setupApp(t)
+
+		// Arrange
+		store.QueryAuditWillReturn([]expense.AuditEntry{}, nil)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/admin/audit?actor=alice&action=update&expense_id=3&since=2026-01-01T00:00:00Z&after=10&limit=5", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, expense.AuditQuery{
+			ExpenseID: 3,
+			Actor:     "alice",
+			Action:    expense.AuditUpdate,
+			Since:     time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			After:     10,
+			Limit:     5,
+		}, store.auditQuery)
+	})
+
+	invalid := []string{"expense_id=x", "action=drop", "since=yesterday", "limit=0", "limit=5000", "after=-"}
+	for _, q := range invalid {
+		q := q // rebind q into this lexical scope
+		t.Run("Invalid "+q+" should returns status bad request", func(t *testing.T) {
+			e, _ := setupApp(t)
+
+			// Act
+			rec := serve(e, http.MethodGet, "/admin/audit?"+q, testAuthToken)
+
+			// Assertions
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+		})
+	}
+}
+
+func TestDBAudit(t *testing.T) {
+	t.Run("History scans entries with their changes", func(t *testing.T) {
+		expStore, mock := setupDB(t)
+		at := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+
+		// Arrange
+		mock.ExpectPrepare("SELECT .+ FROM expense_audit WHERE expense_id = .+ ORDER BY id").
+			ExpectQuery().WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(auditRows).
+				AddRow(1, 1, "update", "alice", "req-1", "10.0.0.1", []byte(`{"note":{"before":"a","after":"b"}}`), at))
+
+		// Act
+		entries, err := expStore.ExpenseHistory(context.Background(), 1)
+
+		// Assertions
+		if assert.NoError(t, err) && assert.Len(t, entries, 1) {
+			assert.Equal(t, expense.AuditEntry{
+				ID: 1, ExpenseID: 1, Action: "update", Actor: "alice", RequestID: "req-1", SourceIP: "10.0.0.1",
+				Changes:   map[string]expense.FieldChange{"note": {Before: "a", After: "b"}},
+				CreatedAt: at,
+			}, entries[0])
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Query binds only the filters set", func(t *testing.T) {
+		expStore, mock := setupDB(t)
+
+		// Arrange
+		mock.ExpectQuery(`SELECT .+ FROM expense_audit WHERE actor = \$1 AND id > \$2 ORDER BY id LIMIT 100`).
+			WithArgs("alice", int64(7)).
+			WillReturnRows(sqlmock.NewRows(auditRows))
+
+		// Act
+		entries, err := expStore.QueryAudit(context.Background(), expense.AuditQuery{Actor: "alice", After: 7})
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.Empty(t, entries)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Query caps the limit at the maximum", func(t *testing.T) {
+		expStore, mock := setupDB(t)
+
+		// Arrange
+		mock.ExpectQuery(`SELECT .+ FROM expense_audit ORDER BY id LIMIT 1000`).
+			WillReturnRows(sqlmock.NewRows(auditRows))
+
+		// Act
+		_, err := expStore.QueryAudit(context.Background(), expense.AuditQuery{Limit: 5000})
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Authenticated caller is recorded as the actor from its peer address", func(t *testing.T) {
+		t.Parallel()
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := newEcho()
+		expense.NewApp(e, expense.NewExpenseStore(db), testAuthToken)
+
+		// Arrange
+		del := mock.ExpectPrepare("DELETE FROM expenses")
+		audit := mock.ExpectPrepare("INSERT INTO expense_audit")
+		mock.ExpectBegin()
+		del.ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}).AddRow(1, "t", 1, "n", "{}"))
+		expectVersion(mock, 1, false)
+		expectChainHead(mock, "")
+		audit.ExpectExec().
+			WithArgs(1, expense.AuditDelete, "static-token", "", "192.0.2.1", sqlmock.AnyArg(), sqlmock.AnyArg(), "", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		expectOutbox(mock, webhook.EventExpenseDeleted)
+		mock.ExpectCommit()
+
+		// Act
+		req := httptest.NewRequest(http.MethodDelete, "/expenses/1", nil)
+		req.Header.Set(echo.HeaderAuthorization, testAuthToken)
+		req.Header.Set(echo.HeaderXForwardedFor, "203.0.113.9")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		// Assertions
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestVerifyAudit(t *testing.T) {
+	t.Run("Verify reports the audit chain of the expense store", func(t *testing.T) {
+		t.Parallel()
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := newEcho()
+		expense.NewApp(e, expense.NewExpenseStore(db), testAuthToken)
+
+		// Arrange
+		mock.ExpectQuery("FROM expense_audit ORDER BY id").
+			WillReturnRows(sqlmock.NewRows(append(auditRows, "prev_hash", "hash")).
+				AddRow(1, 1, expense.AuditCreate, "alice", "", "", []byte(`{}`), time.Now(), "", "not-the-hash"))
+
+		// Act
+		rec := serve(e, http.MethodGet, "/admin/audit/verify", testAuthToken)
+
+		var report audit.Report
+		json.Unmarshal(rec.Body.Bytes(), &report)
+
+		// Assertions
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.False(t, report.Valid)
+		assert.Equal(t, &audit.Break{AuditID: 1, Reason: "entry hash does not match its content"}, report.Broken)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Store without an audit chain should returns status not found", func(t *testing.T) {
+		e, _ := setupApp(t)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/admin/audit/verify", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
@@ -0,0 +1,300 @@
+package expense
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/bazsup/assessment/auth"
+	"github.com/bazsup/assessment/authz"
+	"github.com/bazsup/assessment/problem"
+	"github.com/labstack/echo/v4"
+)
+
+// attributes exposes an expense to policy conditions
+func (exp Expense) attributes() map[string]interface{} {
+	return map[string]interface{}{
+		"id":     exp.ID,
+		"title":  exp.Title,
+		"amount": exp.Amount,
+		"note":   exp.Note,
+		"tags":   exp.Tags,
+	}
+}
+
+// changedFields lists the attributes that differ between the stored and the requested expense
+func changedFields(before, after Expense) []string {
+	var changed []string
+	if before.Title != after.Title {
+		changed = append(changed, "title")
+	}
+	if before.Amount != after.Amount {
+		changed = append(changed, "amount")
+	}
+	if before.Note != after.Note {
+		changed = append(changed, "note")
+	}
+	if !reflect.DeepEqual(before.Tags, after.Tags) {
+		changed = append(changed, "tags")
+	}
+	return changed
+}
+
+// errResourceNotFound lets the handler answer 404 itself instead of authz answering 403
+var errResourceNotFound = sql.ErrNoRows
+
+// accessRequest describes action on the route path to the policy, query
+// holds the query params of the request
+func (h *handler) accessRequest(c echo.Context, action, path string, params map[string]string, query url.Values, withBody bool) (authz.Request, error) {
+	req := authz.Request{Action: action}
+	if id := IdentityFrom(c); id != nil {
+		req.Subject = id.Subject
+		req.Roles = id.Roles
+	}
+
+	var stored *Expense
+	if raw, ok := params["id"]; ok {
+		id, err := strconv.Atoi(raw)
+		if err != nil {
+			return req, errResourceNotFound
+		}
+		stored, err = h.resource(c.Request().Context(), id, action, path, query)
+		if err != nil {
+			return req, err
+		}
+		req.Resource = stored.attributes()
+	}
+
+	if path == "/expenses/:id/revert" {
+		// a revert changes the fields that differ in the restored version
+		target, err := h.versionOf(c.Request().Context(), stored.ID, query.Get("version"))
+		if err != nil {
+			return req, err
+		}
+		if target != nil {
+			req.Proposed = target.attributes()
+			req.Changed = changedFields(*stored, *target)
+		}
+		return req, nil
+	}
+
+	if !withBody || (action != ActionCreate && action != ActionUpdate) {
+		return req, nil
+	}
+
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return req, problem.BodyError(err)
+	}
+	c.Request().Body = io.NopCloser(bytes.NewReader(body))
+
+	var proposed Expense
+	if err := json.Unmarshal(body, &proposed); err != nil {
+		// malformed bodies are rejected by the handler's own binding
+		return req, nil
+	}
+
+	if stored == nil {
+		req.Resource = proposed.attributes()
+	} else {
+		req.Proposed = proposed.attributes()
+		req.Changed = changedFields(*stored, proposed)
+	}
+	return req, nil
+}
+
+// resource returns the expense id the policy decides action on path upon:
+// its state at as_of for historical reads, its current state, or the latest
+// version of a deleted expense. errResourceNotFound is only returned for
+// ids that never existed, or didn't exist yet at as_of.
+func (h *handler) resource(ctx context.Context, id int, action, path string, query url.Values) (*Expense, error) {
+	if path == "/expenses/:id" && action == ActionRead {
+		// a malformed as_of is rejected by the handler
+		if at, err := time.Parse(time.RFC3339Nano, query.Get("as_of")); err == nil {
+			return h.store.GetExpenseAsOf(ctx, id, at)
+		}
+	}
+
+	exp, err := h.store.GetExpenseByID(ctx, id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return exp, err
+	}
+
+	versions, err := h.store.ExpenseVersions(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, errResourceNotFound
+	}
+	latest := versions[len(versions)-1].Expense
+	return &latest, nil
+}
+
+// versionOf returns the version raw of the expense, nil when there is no
+// such version so the handler answers it
+func (h *handler) versionOf(ctx context.Context, id int, raw string) (*Expense, error) {
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		return nil, nil
+	}
+	versions, err := h.store.ExpenseVersions(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range versions {
+		if v.Version == n {
+			return &v.Expense, nil
+		}
+	}
+	return nil, nil
+}
+
+// accessDecision evaluates p for callers outside the REST authorize
+// middleware, stored is the expense acted upon and proposed the one requested
+func accessDecision(p *authz.Policy, id *auth.Identity, action string, stored, proposed *Expense) authz.Decision {
+	req := authz.Request{Action: action}
+	if id != nil {
+		req.Subject = id.Subject
+		req.Roles = id.Roles
+	}
+	switch {
+	case stored != nil:
+		req.Resource = stored.attributes()
+		if proposed != nil {
+			req.Proposed = proposed.attributes()
+			req.Changed = changedFields(*stored, *proposed)
+		}
+	case proposed != nil:
+		req.Resource = proposed.attributes()
+	}
+	return p.Evaluate(req)
+}
+
+// readFilter returns which expenses of a list the caller of id may read,
+// nil when it may read all of them. A read permission with conditions is
+// evaluated per expense as streams do, a caller without any is forbidden.
+func readFilter(p *authz.Policy, id *auth.Identity) (func(*Expense) bool, error) {
+	if p == nil {
+		return nil, nil
+	}
+	d := accessDecision(p, id, ActionRead, nil, nil)
+	switch {
+	case d.Allowed:
+		return nil, nil
+	case d.Denial != nil && d.Denial.Kind == authz.DeniedResource:
+		return func(exp *Expense) bool {
+			return accessDecision(p, id, ActionRead, exp, nil).Allowed
+		}, nil
+	default:
+		return nil, forbidden(d)
+	}
+}
+
+// readable keeps the expenses keep allows, all of them when keep is nil
+func readable(expenses []*Expense, keep func(*Expense) bool) []*Expense {
+	if keep == nil {
+		return expenses
+	}
+	kept := []*Expense{}
+	for _, exp := range expenses {
+		if keep(exp) {
+			kept = append(kept, exp)
+		}
+	}
+	return kept
+}
+
+// forbidden words a denied decision in the client's language, the English
+// reason of the decision is for the explain endpoint
+func forbidden(d authz.Decision) *problem.Error {
+	if d.Denial == nil {
+		return problem.Localized(problem.Forbidden, problem.DetailForbidden, d.Action)
+	}
+	switch d.Denial.Kind {
+	case authz.DeniedCondition:
+		return problem.Localized(problem.Forbidden, problem.DetailForbiddenCondition, d.Denial.Name)
+	case authz.DeniedResource:
+		return problem.Localized(problem.Forbidden, problem.DetailForbiddenResource, d.Denial.Name)
+	case authz.DeniedField:
+		return problem.Localized(problem.Forbidden, problem.DetailForbiddenField, d.Denial.Name)
+	default:
+		return problem.Localized(problem.Forbidden, problem.DetailForbidden, d.Action)
+	}
+}
+
+func (h *handler) authorize(action string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			params := map[string]string{}
+			for i, name := range c.ParamNames() {
+				params[name] = c.ParamValues()[i]
+			}
+
+			req, err := h.accessRequest(c, action, c.Path(), params, c.QueryParams(), true)
+			switch err {
+			case nil:
+			case errResourceNotFound:
+				// the handler answers 404 for ids that never existed
+				return next(c)
+			default:
+				return problem.From(err)
+			}
+
+			d := h.policy.Evaluate(req)
+			if !d.Allowed {
+				return forbidden(d)
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// ExplainAuthz reports how the policy decides the request described by
+// the method and path query params for the current caller. Request bodies
+// are not available so field restrictions on updates are not evaluated.
+func (h *handler) ExplainAuthz(c echo.Context) error {
+	method := c.QueryParam("method")
+	if method == "" {
+		method = http.MethodGet
+	}
+	path := c.QueryParam("path")
+	target, err := url.Parse(path)
+	if err != nil {
+		return badQuery(queryParamError("path"))
+	}
+
+	r, params, ok := matchRoute(method, target.Path)
+	if !ok {
+		return problem.Localized(problem.BadRequest, problem.DetailNoRoute, method, path)
+	}
+
+	id := IdentityFrom(c)
+	if h.policy == nil || r.Action == "" {
+		d := authz.Decision{Allowed: true, Action: r.Action, Reason: "route is not subject to an authz policy", Trace: []authz.Step{}}
+		if id != nil {
+			d.Subject, d.Roles = id.Subject, id.Roles
+		}
+		return c.JSON(http.StatusOK, d)
+	}
+
+	req, err := h.accessRequest(c, r.Action, r.Path, params, target.Query(), false)
+	switch err {
+	case nil:
+	case errResourceNotFound:
+		return problem.New(problem.ExpenseNotFound, "")
+	default:
+		return problem.From(err)
+	}
+
+	return c.JSON(http.StatusOK, h.policy.Evaluate(req))
+}
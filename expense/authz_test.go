@@ -0,0 +1,320 @@
+//go:build unit
+// +build unit
+
+package expense_test
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bazsup/assessment/authz"
+	"github.com/bazsup/assessment/expense"
+	"github.com/bazsup/assessment/locale"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupAuthzApp(t *testing.T, doc string) (*echo.Echo, *TestStore) {
+	t.Parallel()
+
+	policy, err := authz.ParsePolicy([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := newEcho()
+	store := NewTestStore()
+	expense.NewApp(e, store, testAuthToken, expense.WithPolicy(policy))
+	return e, store
+}
+
+func TestAuthorize(t *testing.T) {
+	stored := expense.Expense{ID: 1, Title: "test-title", Amount: 39000, Note: "test-note", Tags: []string{"tag1"}}
+	notesOnly := `{"roles": {"editor": {"permissions": [{"action": "expenses:update", "fields": ["note"]}]}},
+		"assignments": {"static-token": ["editor"]}}`
+
+	update := func(e *echo.Echo, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/expenses/1", strings.NewReader(body))
+		req.Header.Set(echo.HeaderAuthorization, testAuthToken)
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Allowed field change reaches the handler", func(t *testing.T) {
+		e, store := setupAuthzApp(t, notesOnly)
+		store.GetExpenseByIDWillReturn(&stored, nil)
+		store.UpdateExpenseWillReturn(nil)
+
+		// Act
+		rec := update(e, `{"title": "test-title", "amount": 39000, "note": "approved", "tags": ["tag1"]}`)
+
+		// Assertions
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"note":"approved"`)
+	})
+
+	t.Run("Forbidden field change should returns status forbidden", func(t *testing.T) {
+		e, store := setupAuthzApp(t, notesOnly)
+		store.GetExpenseByIDWillReturn(&stored, nil)
+
+		// Act
+		rec := update(e, `{"title": "test-title", "amount": 1, "note": "approved", "tags": ["tag1"]}`)
+
+		// Assertions
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Contains(t, rec.Body.String(), "amount may not be modified")
+	})
+
+	t.Run("Conditional updater may not push the amount past the limit", func(t *testing.T) {
+		e, store := setupAuthzApp(t, `{"roles": {"small": {"permissions": [
+			{"action": "expenses:update", "conditions": [{"attribute": "amount", "operator": "lte", "value": 10000}]}]}},
+			"assignments": {"static-token": ["small"]}}`)
+		store.GetExpenseByIDWillReturn(&expense.Expense{ID: 1, Title: "test-title", Amount: 5000, Note: "test-note", Tags: []string{"tag1"}}, nil)
+		store.UpdateExpenseWillReturn(nil)
+
+		// Act
+		raised := update(e, `{"title": "test-title", "amount": 10000000, "note": "test-note", "tags": ["tag1"]}`)
+		within := update(e, `{"title": "test-title", "amount": 9000, "note": "test-note", "tags": ["tag1"]}`)
+
+		// Assertions
+		assert.Equal(t, http.StatusForbidden, raised.Code)
+		assert.Contains(t, raised.Body.String(), "condition on amount")
+		assert.Equal(t, http.StatusOK, within.Code)
+	})
+
+	t.Run("Conditional updater may not revert to a version past the limit", func(t *testing.T) {
+		e, store := setupAuthzApp(t, `{"roles": {"small": {"permissions": [
+			{"action": "expenses:update", "conditions": [{"attribute": "amount", "operator": "lte", "value": 10000}]}]}},
+			"assignments": {"static-token": ["small"]}}`)
+		current := expense.Expense{ID: 1, Title: "test-title", Amount: 5000}
+		store.GetExpenseByIDWillReturn(&current, nil)
+		store.ExpenseVersionsWillReturn([]expense.Version{{Version: 1, Expense: stored}, {Version: 2, Expense: current}}, nil)
+		store.RevertExpenseWillReturn(&stored, nil)
+
+		// Act
+		rec := serve(e, http.MethodPost, "/expenses/1/revert?version=1", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("Forbidden field change is explained in Thai", func(t *testing.T) {
+		e, store := setupAuthzApp(t, notesOnly)
+		store.GetExpenseByIDWillReturn(&stored, nil)
+
+		// Arrange
+		req := httptest.NewRequest(http.MethodPut, "/expenses/1", strings.NewReader(`{"title": "test-title", "amount": 1, "note": "approved", "tags": ["tag1"]}`))
+		req.Header.Set(echo.HeaderAuthorization, testAuthToken)
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(locale.HeaderAcceptLanguage, "th")
+		rec := httptest.NewRecorder()
+
+		// Act
+		e.ServeHTTP(rec, req)
+
+		// Assertions
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Contains(t, rec.Body.String(), "ไม่อนุญาตให้แก้ไข amount")
+		assert.NotContains(t, rec.Body.String(), "allowed fields")
+	})
+
+	t.Run("Revert of a deleted expense should returns status forbidden without permission", func(t *testing.T) {
+		e, store := setupAuthzApp(t, `{"roles": {"reader": {"permissions": [{"action": "expenses:read"}]}},
+			"assignments": {"static-token": ["reader"]}}`)
+		store.GetExpenseByIDWillReturn(nil, sql.ErrNoRows)
+		store.ExpenseVersionsWillReturn([]expense.Version{{Version: 1, Expense: stored}}, nil)
+		store.RevertExpenseWillReturn(&stored, nil)
+
+		// Act
+		rec := serve(e, http.MethodPost, "/expenses/1/revert?version=1", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("Versions of a deleted expense are checked against its latest version", func(t *testing.T) {
+		e, store := setupAuthzApp(t, `{"roles": {"small": {"permissions": [
+			{"action": "expenses:read", "conditions": [{"attribute": "amount", "operator": "lt", "value": 1000}]}]}},
+			"assignments": {"static-token": ["small"]}}`)
+		store.GetExpenseByIDWillReturn(nil, sql.ErrNoRows)
+		store.ExpenseVersionsWillReturn([]expense.Version{{Version: 1, Expense: expense.Expense{ID: 1, Amount: 10}}, {Version: 2, Expense: stored}}, nil)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/expenses/1/versions", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("Expense that never existed should returns status not found", func(t *testing.T) {
+		e, store := setupAuthzApp(t, notesOnly)
+		store.GetExpenseByIDWillReturn(nil, sql.ErrNoRows)
+		store.RevertExpenseWillReturn(nil, sql.ErrNoRows)
+
+		// Act
+		rec := serve(e, http.MethodPost, "/expenses/1/revert?version=1", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("Revert restoring a forbidden field should returns status forbidden", func(t *testing.T) {
+		e, store := setupAuthzApp(t, notesOnly)
+		store.GetExpenseByIDWillReturn(&stored, nil)
+		old := stored
+		old.Amount = 10
+		store.ExpenseVersionsWillReturn([]expense.Version{{Version: 1, Expense: old}, {Version: 2, Expense: stored}}, nil)
+
+		// Act
+		rec := serve(e, http.MethodPost, "/expenses/1/revert?version=1", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Contains(t, rec.Body.String(), "amount")
+	})
+
+	t.Run("Revert restoring an allowed field reaches the handler", func(t *testing.T) {
+		e, store := setupAuthzApp(t, notesOnly)
+		store.GetExpenseByIDWillReturn(&stored, nil)
+		old := stored
+		old.Note = "draft"
+		store.ExpenseVersionsWillReturn([]expense.Version{{Version: 1, Expense: old}, {Version: 2, Expense: stored}}, nil)
+		store.RevertExpenseWillReturn(&old, nil)
+
+		// Act
+		rec := serve(e, http.MethodPost, "/expenses/1/revert?version=1", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("as_of read is checked against the historical state", func(t *testing.T) {
+		e, store := setupAuthzApp(t, `{"roles": {"small": {"permissions": [
+			{"action": "expenses:read", "conditions": [{"attribute": "amount", "operator": "lt", "value": 1000}]}]}},
+			"assignments": {"static-token": ["small"]}}`)
+		store.GetExpenseByIDWillReturn(&expense.Expense{ID: 1, Amount: 10}, nil)
+		store.GetExpenseAsOfWillReturn(&stored, nil)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/expenses/1?as_of=2022-01-01T00:00:00Z", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("Action without permission should returns status forbidden", func(t *testing.T) {
+		e, _ := setupAuthzApp(t, notesOnly)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/expenses", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+}
+
+func TestAuthorizeList(t *testing.T) {
+	smallOnly := `{"roles": {"small": {"permissions": [
+		{"action": "expenses:read", "conditions": [{"attribute": "amount", "operator": "lt", "value": 1000}]}]}},
+		"assignments": {"static-token": ["small"]}}`
+
+	t.Run("Conditional reader gets the expenses it may read", func(t *testing.T) {
+		e, store := setupAuthzApp(t, smallOnly)
+		store.GetAllExpensesWillReturn([]*expense.Expense{{ID: 1, Amount: 10}, {ID: 2, Amount: 39000}}, nil)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/expenses", testAuthToken)
+
+		var expenses []expense.Expense
+		json.Unmarshal(rec.Body.Bytes(), &expenses)
+
+		// Assertions
+		assert.Equal(t, http.StatusOK, rec.Code)
+		if assert.Len(t, expenses, 1) {
+			assert.Equal(t, 1, expenses[0].ID)
+		}
+	})
+
+	t.Run("Caller without a read permission should returns status forbidden", func(t *testing.T) {
+		e, store := setupAuthzApp(t, `{"roles": {"writer": {"permissions": [{"action": "expenses:create"}]}},
+			"assignments": {"static-token": ["writer"]}}`)
+		store.GetAllExpensesWillReturn([]*expense.Expense{{ID: 1, Amount: 10}}, nil)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/expenses", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+}
+
+func TestExplainAuthz(t *testing.T) {
+	t.Run("Explain shows the deciding permission", func(t *testing.T) {
+		e, store := setupAuthzApp(t, `{"roles": {"big": {"permissions": [
+			{"action": "expenses:read", "conditions": [{"attribute": "amount", "operator": "gt", "value": 1000}]}]}},
+			"assignments": {"static-token": ["big"]}}`)
+		store.GetExpenseByIDWillReturn(&expense.Expense{ID: 1, Amount: 39000}, nil)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/authz/explain?method=GET&path=/expenses/1", testAuthToken)
+
+		var d authz.Decision
+		json.Unmarshal(rec.Body.Bytes(), &d)
+
+		// Assertions
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.True(t, d.Allowed)
+		assert.Equal(t, expense.ActionRead, d.Action)
+		assert.Equal(t, []string{"big"}, d.Roles)
+		assert.Len(t, d.Trace, 1)
+	})
+
+	t.Run("Static route wins over a path param", func(t *testing.T) {
+		e, _ := setupAuthzApp(t, `{"roles": {"reader": {"permissions": [{"action": "expenses:read"}]}},
+			"assignments": {"static-token": ["reader"]}}`)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/authz/explain?method=GET&path=/expenses/stream", testAuthToken)
+
+		var d authz.Decision
+		json.Unmarshal(rec.Body.Bytes(), &d)
+
+		// Assertions
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.True(t, d.Allowed)
+		assert.Empty(t, d.Action)
+		assert.Equal(t, "route is not subject to an authz policy", d.Reason)
+	})
+
+	t.Run("Trailing wildcard matches the rest of the path", func(t *testing.T) {
+		e, _ := setupAuthzApp(t, `{"roles": {}}`)
+
+		for _, path := range []string{"/docs", "/docs/index.html", "/docs/assets/app.js"} {
+			// Act
+			rec := serve(e, http.MethodGet, "/authz/explain?method=GET&path="+path, testAuthToken)
+
+			var d authz.Decision
+			json.Unmarshal(rec.Body.Bytes(), &d)
+
+			// Assertions
+			assert.Equal(t, http.StatusOK, rec.Code, path)
+			assert.True(t, d.Allowed, path)
+		}
+	})
+
+	t.Run("Unknown route should returns status bad request", func(t *testing.T) {
+		e, _ := setupAuthzApp(t, `{"roles": {}}`)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/authz/explain?method=DELETE&path=/nothing", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
@@ -1,8 +1,10 @@
 package expense
 
 import (
+	"log/slog"
 	"net/http"
 
+	"github.com/bazsup/assessment/problem"
 	"github.com/bazsup/assessment/router"
 )
 
@@ -10,14 +12,15 @@ func CreateExpenseHandler(c router.RouterCtx, store storer) error {
 	var exp Expense
 	err := c.Bind(&exp)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, Err{Message: err.Error()})
+		return bindError(err)
 	}
 
-	insertId, err := store.CreateExpense(exp)
+	insertId, err := store.CreateExpense(c.Request().Context(), exp)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+		return problem.From(err)
 	}
 	exp.ID = insertId
+	slog.DebugContext(c.Request().Context(), "expense created", "expense", exp)
 
 	return c.JSON(http.StatusCreated, exp)
 }
@@ -5,12 +5,16 @@ package expense_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/bazsup/assessment/expense"
+	"github.com/bazsup/assessment/problem"
 	"github.com/stretchr/testify/assert"
 
 	_ "github.com/lib/pq"
@@ -24,6 +28,15 @@ func setupExpense(t *testing.T) (*TestCtx, *TestStore) {
 	return ctx, store
 }
 
+// assertProblem asserts a handler returned the problem of code for the
+// error handler to answer
+func assertProblem(t *testing.T, err error, code problem.Code) bool {
+	t.Helper()
+
+	var pe *problem.Error
+	return assert.ErrorAs(t, err, &pe) && assert.Equal(t, code, pe.Code)
+}
+
 func TestCreateExpense(t *testing.T) {
 	t.Run("Create Expense success", func(t *testing.T) {
 		// Arrange
@@ -67,14 +80,8 @@ func TestCreateExpense(t *testing.T) {
 		ctx.SetReqBody(invalidReqBody)
 		err := expense.CreateExpenseHandler(ctx, store)
 
-		var errRes expense.Err
-		ctx.DecodeResponse(&errRes)
-
 		// Assertions
-		if assert.NoError(t, err) {
-			assert.Equal(t, http.StatusBadRequest, ctx.status)
-			assert.NotEmpty(t, errRes.Message)
-		}
+		assertProblem(t, err, problem.BadRequest)
 	})
 
 	t.Run("Create Expense should return status code internal server error", func(t *testing.T) {
@@ -93,14 +100,8 @@ func TestCreateExpense(t *testing.T) {
 		ctx.SetReqBody(validReqBody)
 		err := expense.CreateExpenseHandler(ctx, store)
 
-		var errRes expense.Err
-		ctx.DecodeResponse(&errRes)
-
 		// Assertions
-		if assert.NoError(t, err) {
-			assert.Equal(t, http.StatusInternalServerError, ctx.status)
-			assert.NotEmpty(t, errRes.Message)
-		}
+		assertProblem(t, err, problem.Internal)
 	})
 }
 
@@ -109,13 +110,25 @@ type TestStore struct {
 	gotr *GetOneExpenseTestResult
 	gatr *GetAllExpensesTestResult
 	utr  *UpdateExpenseTestResult
+	dtr  *DeleteExpenseTestResult
+	htr  *AuditTestResult
+	qtr  *AuditTestResult
+	vtr  *VersionsTestResult
+	rtr  *GetOneExpenseTestResult
+	aotr *GetOneExpenseTestResult
+
+	// asOf is the last time given to an as_of read
+	asOf time.Time
+
+	// auditQuery is the last query given to QueryAudit
+	auditQuery expense.AuditQuery
 }
 
 func NewTestStore() *TestStore {
 	return &TestStore{}
 }
 
-func (s *TestStore) CreateExpense(exp expense.Expense) (int, error) {
+func (s *TestStore) CreateExpense(ctx context.Context, exp expense.Expense) (int, error) {
 	return s.ctr.id, s.ctr.err
 }
 
@@ -123,7 +136,7 @@ func (s *TestStore) CreateExpenseWillReturn(id int, err error) {
 	s.ctr = &CreateExpenseTestResult{id, err}
 }
 
-func (s *TestStore) GetExpenseByID(id int) (*expense.Expense, error) {
+func (s *TestStore) GetExpenseByID(ctx context.Context, id int) (*expense.Expense, error) {
 	return s.gotr.exp, s.gotr.err
 }
 
@@ -131,7 +144,7 @@ func (s *TestStore) GetExpenseByIDWillReturn(exp *expense.Expense, err error) {
 	s.gotr = &GetOneExpenseTestResult{exp, err}
 }
 
-func (s *TestStore) GetAllExpenses() ([]*expense.Expense, error) {
+func (s *TestStore) GetAllExpenses(ctx context.Context) ([]*expense.Expense, error) {
 	return s.gatr.exp, s.gatr.err
 }
 
@@ -139,7 +152,7 @@ func (s *TestStore) GetAllExpensesWillReturn(expenses []*expense.Expense, err er
 	s.gatr = &GetAllExpensesTestResult{expenses, err}
 }
 
-func (s *TestStore) UpdateExpense(exp expense.Expense) error {
+func (s *TestStore) UpdateExpense(ctx context.Context, exp expense.Expense) error {
 	return s.utr.err
 }
 
@@ -147,6 +160,68 @@ func (s *TestStore) UpdateExpenseWillReturn(err error) {
 	s.utr = &UpdateExpenseTestResult{err}
 }
 
+func (s *TestStore) DeleteExpense(ctx context.Context, id int) error {
+	return s.dtr.err
+}
+
+func (s *TestStore) DeleteExpenseWillReturn(err error) {
+	s.dtr = &DeleteExpenseTestResult{err}
+}
+
+func (s *TestStore) GetExpenseAsOf(ctx context.Context, id int, at time.Time) (*expense.Expense, error) {
+	s.asOf = at
+	if s.aotr != nil {
+		return s.aotr.exp, s.aotr.err
+	}
+	return s.gotr.exp, s.gotr.err
+}
+
+// GetExpenseAsOfWillReturn answers as_of reads differently from GetExpenseByID
+func (s *TestStore) GetExpenseAsOfWillReturn(exp *expense.Expense, err error) {
+	s.aotr = &GetOneExpenseTestResult{exp, err}
+}
+
+func (s *TestStore) GetAllExpensesAsOf(ctx context.Context, at time.Time) ([]*expense.Expense, error) {
+	s.asOf = at
+	return s.gatr.exp, s.gatr.err
+}
+
+func (s *TestStore) ExpenseVersions(ctx context.Context, id int) ([]expense.Version, error) {
+	if s.vtr == nil {
+		return []expense.Version{}, nil
+	}
+	return s.vtr.versions, s.vtr.err
+}
+
+func (s *TestStore) ExpenseVersionsWillReturn(versions []expense.Version, err error) {
+	s.vtr = &VersionsTestResult{versions, err}
+}
+
+func (s *TestStore) RevertExpense(ctx context.Context, id, version int) (*expense.Expense, error) {
+	return s.rtr.exp, s.rtr.err
+}
+
+func (s *TestStore) RevertExpenseWillReturn(exp *expense.Expense, err error) {
+	s.rtr = &GetOneExpenseTestResult{exp, err}
+}
+
+func (s *TestStore) ExpenseHistory(ctx context.Context, id int) ([]expense.AuditEntry, error) {
+	return s.htr.entries, s.htr.err
+}
+
+func (s *TestStore) ExpenseHistoryWillReturn(entries []expense.AuditEntry, err error) {
+	s.htr = &AuditTestResult{entries, err}
+}
+
+func (s *TestStore) QueryAudit(ctx context.Context, q expense.AuditQuery) ([]expense.AuditEntry, error) {
+	s.auditQuery = q
+	return s.qtr.entries, s.qtr.err
+}
+
+func (s *TestStore) QueryAuditWillReturn(entries []expense.AuditEntry, err error) {
+	s.qtr = &AuditTestResult{entries, err}
+}
+
 type CreateExpenseTestResult struct {
 	id  int
 	err error
@@ -166,7 +241,22 @@ type UpdateExpenseTestResult struct {
 	err error
 }
 
+type DeleteExpenseTestResult struct {
+	err error
+}
+
+type VersionsTestResult struct {
+	versions []expense.Version
+	err      error
+}
+
+type AuditTestResult struct {
+	entries []expense.AuditEntry
+	err     error
+}
+
 type TestCtx struct {
+	httpReq *http.Request
 	req     *bytes.Buffer
 	status  int
 	v       []byte
@@ -175,7 +265,15 @@ type TestCtx struct {
 }
 
 func NewTestCtx() *TestCtx {
-	return &TestCtx{}
+	return &TestCtx{httpReq: httptest.NewRequest(http.MethodGet, "/", nil)}
+}
+
+func (c *TestCtx) SetRequestContext(ctx context.Context) {
+	c.httpReq = c.httpReq.WithContext(ctx)
+}
+
+func (c *TestCtx) Request() *http.Request {
+	return c.httpReq
 }
 
 func (c *TestCtx) SetReqBody(req *bytes.Buffer) {
@@ -190,6 +288,14 @@ func (c *TestCtx) Param(name string) string {
 	return c.param
 }
 
+func (c *TestCtx) SetQuery(query string) {
+	c.httpReq.URL.RawQuery = query
+}
+
+func (c *TestCtx) QueryParam(name string) string {
+	return c.httpReq.URL.Query().Get(name)
+}
+
 func (c *TestCtx) SetBindErr(err error) {
 	c.bindErr = err
 }
@@ -206,6 +312,12 @@ func (c *TestCtx) JSON(code int, v interface{}) error {
 	return err
 }
 
+func (c *TestCtx) NoContent(code int) error {
+	c.status = code
+	c.v = nil
+	return nil
+}
+
 func (c *TestCtx) DecodeResponse(res interface{}) error {
 	return json.Unmarshal(c.v, res)
 }
@@ -1,82 +1,263 @@
 package expense
 
 import (
+	"context"
+	"crypto/ed25519"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/bazsup/assessment/retry"
 	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
+// InitDB opens the database, the schema is managed by the migration package
 func InitDB(dbUrl string) *sql.DB {
 	var db *sql.DB
 
 	var err error
 	db, err = sql.Open("postgres", dbUrl)
 	if err != nil {
-		log.Fatal("Connect to database error", err)
-	}
-
-	createTb := `
-	CREATE TABLE IF NOT EXISTS expenses (
-		id SERIAL PRIMARY KEY,
-		title TEXT,
-		amount FLOAT,
-		note TEXT,
-		tags TEXT[]
-	);
-	`
-	_, err = db.Exec(createTb)
-
-	if err != nil {
-		log.Fatal("can't create table", err)
+		slog.Error("Connect to database error", "error", err)
+		os.Exit(1)
 	}
 
 	return db
 }
 
+// Default per operation query timeouts, see WithTimeouts
+const (
+	DefaultReadTimeout  = 5 * time.Second
+	DefaultWriteTimeout = 5 * time.Second
+)
+
+const (
+	insertExpenseQuery = "INSERT INTO expenses ( title, amount, note, tags ) VALUES ( $1, $2, $3, $4 ) RETURNING id"
+	getExpenseQuery    = "SELECT id, title, amount, note, tags FROM expenses WHERE id = $1"
+	allExpensesQuery   = "SELECT id, title, amount, note, tags FROM expenses"
+	updateExpenseQuery = `
+	UPDATE expenses
+	SET title = $2, amount = $3, note = $4, tags = $5
+	WHERE id = $1
+	`
+	lockExpenseQuery   = "SELECT id, title, amount, note, tags FROM expenses WHERE id = $1 FOR UPDATE"
+	deleteExpenseQuery = "DELETE FROM expenses WHERE id = $1 RETURNING id, title, amount, note, tags"
+)
+
 type ExpenseStore struct {
 	*sql.DB
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	readRetry    retry.Policy
+	tracer       trace.Tracer
+	auditKey     ed25519.PublicKey
+
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt
 }
 
 func NewExpenseStore(db *sql.DB) *ExpenseStore {
-	return &ExpenseStore{db}
+	return &ExpenseStore{
+		DB:           db,
+		readTimeout:  DefaultReadTimeout,
+		writeTimeout: DefaultWriteTimeout,
+		readRetry:    DefaultReadRetry,
+		stmts:        map[string]*sql.Stmt{},
+		tracer:       noop.NewTracerProvider().Tracer(instrumentation),
+	}
+}
+
+// WithTimeouts bounds how long a single read or write query may run
+func (e *ExpenseStore) WithTimeouts(read, write time.Duration) *ExpenseStore {
+	e.readTimeout = read
+	e.writeTimeout = write
+	return e
+}
+
+// WithReadRetry sets how reads are retried on transient errors, writes are never retried
+func (e *ExpenseStore) WithReadRetry(p retry.Policy) *ExpenseStore {
+	e.readRetry = p
+	return e
+}
+
+// readPolicy returns the read retry policy, logging retries of the request ctx belongs to
+func (e *ExpenseStore) readPolicy(ctx context.Context) retry.Policy {
+	p := e.readRetry
+	if p.OnRetry == nil {
+		p.OnRetry = func(attempt int, err error, delay time.Duration) {
+			slog.WarnContext(ctx, "transient read error, retrying",
+				"attempt", attempt, "error", err.Error(), "delay", delay.Round(time.Millisecond).String())
+		}
+	}
+	return p
+}
+
+// WithTracer records a client span with the SQL statement for every query
+func (e *ExpenseStore) WithTracer(tp trace.TracerProvider) *ExpenseStore {
+	e.tracer = tp.Tracer(instrumentation)
+	return e
+}
+
+// WithAuditKey verifies audit checkpoint signatures with pub
+func (e *ExpenseStore) WithAuditKey(pub ed25519.PublicKey) *ExpenseStore {
+	e.auditKey = pub
+	return e
+}
+
+// span starts the span of a store method running query
+func (e *ExpenseStore) span(ctx context.Context, method, query string) (context.Context, trace.Span) {
+	return e.tracer.Start(ctx, "ExpenseStore."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBOperationName(method),
+			semconv.DBQueryText(strings.TrimSpace(query)),
+		),
+	)
+}
+
+// endSpan ends span, a missing row is an answer rather than a failure
+func endSpan(span trace.Span, err error) {
+	if err != nil && err != sql.ErrNoRows {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// stmt returns the statement for query, preparing it on first use. The
+// statement belongs to the pool so database/sql re-prepares it on other
+// connections as needed.
+func (e *ExpenseStore) stmt(ctx context.Context, query string) (*sql.Stmt, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if stmt, ok := e.stmts[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := e.DB.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	e.stmts[query] = stmt
+	return stmt, nil
 }
 
-func (e *ExpenseStore) CreateExpense(exp Expense) (int, error) {
-	row := e.DB.QueryRow(
-		"INSERT INTO expenses ( title, amount, note, tags ) VALUES ( $1, $2, $3, $4 ) RETURNING id",
-		exp.Title, exp.Amount, exp.Note, pq.Array(&exp.Tags))
-	err := row.Scan(&exp.ID)
-	return exp.ID, err
+// Close releases the prepared statements, the database itself is closed by its owner
+func (e *ExpenseStore) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var firstErr error
+	for query, stmt := range e.stmts {
+		if err := stmt.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(e.stmts, query)
+	}
+	return firstErr
+}
+
+// ctxErr reports the context error instead of the driver's when the query
+// was cut short, so callers can tell timeouts and disconnects from failures
+func ctxErr(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func (e *ExpenseStore) CreateExpense(ctx context.Context, exp Expense) (_ int, err error) {
+	ctx, span := e.span(ctx, "CreateExpense", insertExpenseQuery)
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, e.writeTimeout)
+	defer cancel()
+
+	stmt, err := e.stmt(ctx, insertExpenseQuery)
+	if err != nil {
+		return 0, fmt.Errorf("can't prepare insert expense statement: %s", err.Error())
+	}
+	auditStmt, err := e.auditStmt(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	err = e.inTx(ctx, func(tx *sql.Tx) error {
+		row := tx.StmtContext(ctx, stmt).QueryRowContext(ctx, exp.Title, exp.Amount, exp.Note, pq.Array(&exp.Tags))
+		if err := row.Scan(&exp.ID); err != nil {
+			return err
+		}
+		if err := recordVersion(ctx, tx, exp.ID, &exp, versionTime()); err != nil {
+			return err
+		}
+		return recordChange(ctx, tx, auditStmt, exp.ID, AuditCreate, nil, &exp)
+	})
+	return exp.ID, ctxErr(ctx, err)
 }
 
-func (e *ExpenseStore) GetExpenseByID(id int) (*Expense, error) {
-	stmt, err := e.DB.Prepare("SELECT id, title, amount, note, tags FROM expenses WHERE id = $1")
+func (e *ExpenseStore) GetExpenseByID(ctx context.Context, id int) (_ *Expense, err error) {
+	ctx, span := e.span(ctx, "GetExpenseByID", getExpenseQuery)
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, e.readTimeout)
+	defer cancel()
+
+	stmt, err := e.stmt(ctx, getExpenseQuery)
 	if err != nil {
 		return nil, fmt.Errorf("can't prepare query expense statement: %s", err.Error())
 	}
 
-	row := stmt.QueryRow(id)
 	exp := &Expense{}
-	err = row.Scan(&exp.ID, &exp.Title, &exp.Amount, &exp.Note, pq.Array(&exp.Tags))
+	err = retry.Do(ctx, e.readPolicy(ctx), IsTransient, func(int) error {
+		row := stmt.QueryRowContext(ctx, id)
+		return row.Scan(&exp.ID, &exp.Title, &exp.Amount, &exp.Note, pq.Array(&exp.Tags))
+	})
 	if err != nil {
-		return nil, err
+		return nil, ctxErr(ctx, err)
 	}
 
 	return exp, nil
 }
 
-func (e *ExpenseStore) GetAllExpenses() ([]*Expense, error) {
-	stmt, err := e.DB.Prepare("SELECT id, title, amount, note, tags FROM expenses")
+func (e *ExpenseStore) GetAllExpenses(ctx context.Context) (_ []*Expense, err error) {
+	ctx, span := e.span(ctx, "GetAllExpenses", allExpensesQuery)
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, e.readTimeout)
+	defer cancel()
+
+	stmt, err := e.stmt(ctx, allExpensesQuery)
 	if err != nil {
 		return nil, fmt.Errorf("can't prepare query expense statement: %s", err.Error())
 	}
 
-	rows, err := stmt.Query()
+	var expenses []*Expense
+	err = retry.Do(ctx, e.readPolicy(ctx), IsTransient, func(int) error {
+		expenses, err = queryExpenses(ctx, stmt)
+		return err
+	})
+	if err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+
+	return expenses, nil
+}
+
+func queryExpenses(ctx context.Context, stmt *sql.Stmt, args ...interface{}) ([]*Expense, error) {
+	rows, err := stmt.QueryContext(ctx, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	expenses := []*Expense{}
 	for rows.Next() {
@@ -87,24 +268,96 @@ func (e *ExpenseStore) GetAllExpenses() ([]*Expense, error) {
 
 		expenses = append(expenses, &exp)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
 	return expenses, nil
 }
 
-func (e *ExpenseStore) UpdateExpense(exp Expense) error {
-	stmt, err := e.DB.Prepare(`
-	UPDATE expenses
-	SET title = $2, amount = $3, note = $4, tags = $5
-	WHERE id = $1
-	`)
+func (e *ExpenseStore) UpdateExpense(ctx context.Context, exp Expense) (err error) {
+	ctx, span := e.span(ctx, "UpdateExpense", updateExpenseQuery)
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, e.writeTimeout)
+	defer cancel()
+
+	stmt, err := e.stmt(ctx, updateExpenseQuery)
 	if err != nil {
 		return fmt.Errorf("can't prepare update expense statement:%s", err.Error())
 	}
+	lock, err := e.stmt(ctx, lockExpenseQuery)
+	if err != nil {
+		return fmt.Errorf("can't prepare lock expense statement:%s", err.Error())
+	}
+	auditStmt, err := e.auditStmt(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = e.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := scanExpense(tx.StmtContext(ctx, lock).QueryRowContext(ctx, exp.ID))
+		if err != nil {
+			return err
+		}
+		_, err = tx.StmtContext(ctx, stmt).ExecContext(ctx, exp.ID, exp.Title, exp.Amount, exp.Note, pq.Array(exp.Tags))
+		if err != nil {
+			return err
+		}
+		if err := recordVersion(ctx, tx, exp.ID, &exp, versionTime()); err != nil {
+			return err
+		}
+		return recordChange(ctx, tx, auditStmt, exp.ID, AuditUpdate, before, &exp)
+	})
+	return ctxErr(ctx, err)
+}
+
+func (e *ExpenseStore) DeleteExpense(ctx context.Context, id int) (err error) {
+	ctx, span := e.span(ctx, "DeleteExpense", deleteExpenseQuery)
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, e.writeTimeout)
+	defer cancel()
+
+	stmt, err := e.stmt(ctx, deleteExpenseQuery)
+	if err != nil {
+		return fmt.Errorf("can't prepare delete expense statement:%s", err.Error())
+	}
+	auditStmt, err := e.auditStmt(ctx)
+	if err != nil {
+		return err
+	}
 
-	_, err = stmt.Exec(exp.ID, exp.Title, exp.Amount, exp.Note, pq.Array(exp.Tags))
+	err = e.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := scanExpense(tx.StmtContext(ctx, stmt).QueryRowContext(ctx, id))
+		if err != nil {
+			return err
+		}
+		if err := recordVersion(ctx, tx, id, nil, versionTime()); err != nil {
+			return err
+		}
+		return recordChange(ctx, tx, auditStmt, id, AuditDelete, before, nil)
+	})
+	return ctxErr(ctx, err)
+}
+
+// inTx runs fn in a transaction committed when fn succeeds
+func (e *ExpenseStore) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := e.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
 
-	return nil
+func scanExpense(row *sql.Row) (*Expense, error) {
+	exp := &Expense{}
+	if err := row.Scan(&exp.ID, &exp.Title, &exp.Amount, &exp.Note, pq.Array(&exp.Tags)); err != nil {
+		return nil, err
+	}
+	return exp, nil
 }
@@ -1,12 +1,15 @@
 package expense_test
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/bazsup/assessment/expense"
+	"github.com/bazsup/assessment/webhook"
 	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 )
@@ -38,10 +41,20 @@ func TestDBCreatExpense(t *testing.T) {
 		// Arrange
 		expenseMockRows := sqlmock.NewRows([]string{"id"}).
 			AddRow("1")
-		mock.ExpectQuery("INSERT INTO expenses (.+) VALUES (.+) RETURNING id").WillReturnRows(expenseMockRows)
+		insert := mock.ExpectPrepare("INSERT INTO expenses (.+) VALUES (.+) RETURNING id")
+		audit := mock.ExpectPrepare("INSERT INTO expense_audit")
+		mock.ExpectBegin()
+		insert.ExpectQuery().WillReturnRows(expenseMockRows)
+		expectVersion(mock, 1, true)
+		expectChainHead(mock, "")
+		audit.ExpectExec().
+			WithArgs(1, expense.AuditCreate, "system", "", "", sqlmock.AnyArg(), sqlmock.AnyArg(), "", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		expectOutbox(mock, webhook.EventExpenseCreated)
+		mock.ExpectCommit()
 
 		// Act
-		id, err := expStore.CreateExpense(exp)
+		id, err := expStore.CreateExpense(context.Background(), exp)
 
 		// Assertions
 		assert.NoError(t, err)
@@ -53,13 +66,18 @@ func TestDBCreatExpense(t *testing.T) {
 		expStore, mock := setupDB(t)
 
 		// Arrange
-		mock.ExpectQuery("INSERT INTO expenses").WillReturnError(fmt.Errorf("get expenses fail"))
+		insert := mock.ExpectPrepare("INSERT INTO expenses")
+		mock.ExpectPrepare("INSERT INTO expense_audit")
+		mock.ExpectBegin()
+		insert.ExpectQuery().WillReturnError(fmt.Errorf("get expenses fail"))
+		mock.ExpectRollback()
 
 		// Act
-		_, err := expStore.CreateExpense(exp)
+		_, err := expStore.CreateExpense(context.Background(), exp)
 
 		// Assertions
 		assert.NotNil(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 }
 
@@ -82,7 +100,7 @@ func TestDBGetExpenseByID(t *testing.T) {
 		get.ExpectQuery().WithArgs(1).WillReturnRows(expenseMockRows)
 
 		// Act
-		exp, err := expStore.GetExpenseByID(1)
+		exp, err := expStore.GetExpenseByID(context.Background(), 1)
 
 		// Assertions
 		assert.NoError(t, err)
@@ -102,7 +120,7 @@ func TestDBGetExpenseByID(t *testing.T) {
 		get.WillReturnError(fmt.Errorf("error prepare statement"))
 
 		// Act
-		exp, err := expStore.GetExpenseByID(1)
+		exp, err := expStore.GetExpenseByID(context.Background(), 1)
 
 		// Assertions
 		assert.Nil(t, exp)
@@ -117,12 +135,54 @@ func TestDBGetExpenseByID(t *testing.T) {
 		get.ExpectQuery().WithArgs(1).WillReturnError(sql.ErrNoRows)
 
 		// Act
-		exp, err := expStore.GetExpenseByID(1)
+		exp, err := expStore.GetExpenseByID(context.Background(), 1)
 
 		// Assertions
 		assert.Nil(t, exp)
 		assert.Equal(t, sql.ErrNoRows, err)
 	})
+
+	t.Run("Slow query is cut by the read timeout", func(t *testing.T) {
+		expStore, mock := setupDB(t)
+		expStore.WithTimeouts(10*time.Millisecond, time.Second)
+
+		// Arrange
+		get := mock.ExpectPrepare("SELECT .+ FROM expenses WHERE id = .+")
+		get.ExpectQuery().WithArgs(1).WillDelayFor(time.Second).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}))
+
+		// Act
+		exp, err := expStore.GetExpenseByID(context.Background(), 1)
+
+		// Assertions
+		assert.Nil(t, exp)
+		assert.Equal(t, context.DeadlineExceeded, err)
+	})
+}
+
+func TestDBPreparedStatements(t *testing.T) {
+	t.Run("Statement is prepared once and reused", func(t *testing.T) {
+		expStore, mock := setupDB(t)
+
+		// Arrange
+		get := mock.ExpectPrepare("SELECT .+ FROM expenses WHERE id = .+")
+		get.ExpectQuery().WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}).
+			AddRow(1, "t", 1, "n", "{}"))
+		get.ExpectQuery().WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}).
+			AddRow(2, "t", 1, "n", "{}"))
+		get.WillBeClosed()
+
+		// Act
+		_, err1 := expStore.GetExpenseByID(context.Background(), 1)
+		_, err2 := expStore.GetExpenseByID(context.Background(), 2)
+		closeErr := expStore.Close()
+
+		// Assertions
+		assert.NoError(t, err1)
+		assert.NoError(t, err2)
+		assert.NoError(t, closeErr)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
 }
 
 func TestDBGetAllExpenses(t *testing.T) {
@@ -144,7 +204,7 @@ func TestDBGetAllExpenses(t *testing.T) {
 		get.ExpectQuery().WillReturnRows(expenseMockRows)
 
 		// Act
-		expenses, err := expStore.GetAllExpenses()
+		expenses, err := expStore.GetAllExpenses(context.Background())
 
 		// Assertions
 		if assert.NoError(t, err) {
@@ -175,6 +235,18 @@ func TestDBGetAllExpenses(t *testing.T) {
 			},
 			expectErrContain: "query error",
 		},
+		{
+			name: "Rows iteration error",
+			arrange: func(mock sqlmock.Sqlmock) {
+				get := mock.ExpectPrepare("SELECT .+ FROM expenses")
+
+				expenseMockRows := sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}).
+					AddRow(exp.ID, exp.Title, exp.Amount, exp.Note, pq.Array(&exp.Tags)).
+					RowError(0, fmt.Errorf("connection reset"))
+				get.ExpectQuery().WillReturnRows(expenseMockRows).RowsWillBeClosed()
+			},
+			expectErrContain: "connection reset",
+		},
 		{
 			name: "Scan entity error",
 			arrange: func(mock sqlmock.Sqlmock) {
@@ -197,7 +269,7 @@ func TestDBGetAllExpenses(t *testing.T) {
 			tt.arrange(mock)
 
 			// Act
-			expenses, err := expStore.GetAllExpenses()
+			expenses, err := expStore.GetAllExpenses(context.Background())
 
 			// Assertions
 			assert.Contains(t, err.Error(), tt.expectErrContain)
@@ -221,13 +293,26 @@ func TestDBUpdateExpense(t *testing.T) {
 
 		// Arrange
 		update := mock.ExpectPrepare("UPDATE .+ SET .+ WHERE id = .+")
+		lock := mock.ExpectPrepare("SELECT .+ FROM expenses WHERE id = .+ FOR UPDATE")
+		audit := mock.ExpectPrepare("INSERT INTO expense_audit")
+		mock.ExpectBegin()
+		lock.ExpectQuery().WithArgs(exp.ID).WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}).
+			AddRow(exp.ID, "old-title", exp.Amount, exp.Note, pq.Array(&exp.Tags)))
 		update.
 			ExpectExec().
 			WithArgs(exp.ID, exp.Title, exp.Amount, exp.Note, pq.Array(&exp.Tags)).
 			WillReturnResult(sqlmock.NewResult(1, 1))
+		expectVersion(mock, exp.ID, true)
+		expectChainHead(mock, "prev-hash")
+		audit.ExpectExec().
+			WithArgs(exp.ID, expense.AuditUpdate, "system", "", "", []byte(`{"title":{"before":"old-title","after":"updated-title"}}`),
+				sqlmock.AnyArg(), "prev-hash", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		expectOutbox(mock, webhook.EventExpenseUpdated)
+		mock.ExpectCommit()
 
 		// Act
-		err := expStore.UpdateExpense(exp)
+		err := expStore.UpdateExpense(context.Background(), exp)
 
 		// Assertions
 		assert.NoError(t, err)
@@ -251,10 +336,28 @@ func TestDBUpdateExpense(t *testing.T) {
 			name: "SQL Execute error should returns status internal server error",
 			arrange: func(mock sqlmock.Sqlmock) {
 				update := mock.ExpectPrepare("UPDATE .+ SET .+ WHERE id = .+")
+				lock := mock.ExpectPrepare("SELECT .+ FOR UPDATE")
+				mock.ExpectPrepare("INSERT INTO expense_audit")
+				mock.ExpectBegin()
+				lock.ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}).
+					AddRow(1, "title", 1, "note", "{}"))
 				update.ExpectExec().WillReturnError(fmt.Errorf("execute statement error"))
+				mock.ExpectRollback()
 			},
 			expectErrContain: "execute statement error",
 		},
+		{
+			name: "Missing expense should returns no rows",
+			arrange: func(mock sqlmock.Sqlmock) {
+				mock.ExpectPrepare("UPDATE .+ SET .+ WHERE id = .+")
+				lock := mock.ExpectPrepare("SELECT .+ FOR UPDATE")
+				mock.ExpectPrepare("INSERT INTO expense_audit")
+				mock.ExpectBegin()
+				lock.ExpectQuery().WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+			},
+			expectErrContain: sql.ErrNoRows.Error(),
+		},
 	}
 
 	for _, tt := range negativeTests {
@@ -266,7 +369,7 @@ func TestDBUpdateExpense(t *testing.T) {
 			tt.arrange(mock)
 
 			// Act
-			err := expStore.UpdateExpense(exp)
+			err := expStore.UpdateExpense(context.Background(), exp)
 
 			// Assertions
 			assert.Contains(t, err.Error(), tt.expectErrContain)
@@ -274,3 +377,30 @@ func TestDBUpdateExpense(t *testing.T) {
 		})
 	}
 }
+
+// expectVersion expects a change to close the current version of the
+// expense, and to open the next one unless the expense was deleted
+func expectVersion(mock sqlmock.Sqlmock, id int, open bool) {
+	mock.ExpectExec(`UPDATE expense_versions SET valid_to`).WithArgs(id, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if open {
+		mock.ExpectExec(`INSERT INTO expense_versions`).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+}
+
+// expectOutbox expects a change to enqueue its webhook event
+func expectOutbox(mock sqlmock.Sqlmock, eventType string) {
+	mock.ExpectExec(`INSERT INTO outbox`).WithArgs(eventType, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+// expectChainHead expects an audit entry to lock the audit chain and read
+// its head, prev is empty for an empty chain
+func expectChainHead(mock sqlmock.Sqlmock, prev string) {
+	mock.ExpectExec(`SELECT pg_advisory_xact_lock`).WillReturnResult(sqlmock.NewResult(0, 0))
+	head := sqlmock.NewRows([]string{"hash"})
+	if prev != "" {
+		head.AddRow(prev)
+	}
+	mock.ExpectQuery(`SELECT hash FROM expense_audit`).WillReturnRows(head)
+}
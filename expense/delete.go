@@ -0,0 +1,26 @@
+package expense
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/bazsup/assessment/problem"
+	"github.com/bazsup/assessment/router"
+)
+
+func DeleteExpenseHandler(c router.RouterCtx, store storer) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return problem.New(problem.ExpenseNotFound, "")
+	}
+
+	switch err := store.DeleteExpense(c.Request().Context(), id); err {
+	case nil:
+		return c.NoContent(http.StatusNoContent)
+	case sql.ErrNoRows:
+		return problem.New(problem.ExpenseNotFound, "")
+	default:
+		return problem.From(err)
+	}
+}
@@ -0,0 +1,112 @@
+//go:build unit
+// +build unit
+
+package expense_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bazsup/assessment/expense"
+	"github.com/bazsup/assessment/problem"
+	"github.com/bazsup/assessment/webhook"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeleteExpense(t *testing.T) {
+	t.Run("Delete Expense success", func(t *testing.T) {
+		ctx, store := setupExpense(t)
+
+		// Arrange
+		store.DeleteExpenseWillReturn(nil)
+
+		// Act
+		ctx.SetParam("1")
+		err := expense.DeleteExpenseHandler(ctx, store)
+
+		// Assertions
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusNoContent, ctx.status)
+		}
+	})
+
+	t.Run("Missing expense should returns status not found", func(t *testing.T) {
+		ctx, store := setupExpense(t)
+
+		// Arrange
+		store.DeleteExpenseWillReturn(sql.ErrNoRows)
+
+		// Act
+		ctx.SetParam("1")
+		err := expense.DeleteExpenseHandler(ctx, store)
+
+		// Assertions
+		assertProblem(t, err, problem.ExpenseNotFound)
+	})
+
+	t.Run("Store error should returns status internal server error", func(t *testing.T) {
+		ctx, store := setupExpense(t)
+
+		// Arrange
+		store.DeleteExpenseWillReturn(fmt.Errorf("delete failed"))
+
+		// Act
+		ctx.SetParam("1")
+		err := expense.DeleteExpenseHandler(ctx, store)
+
+		// Assertions
+		assertProblem(t, err, problem.Internal)
+	})
+}
+
+func TestDBDeleteExpense(t *testing.T) {
+	t.Run("Delete records the removed expense in the audit log", func(t *testing.T) {
+		expStore, mock := setupDB(t)
+		ctx := expense.WithActor(context.Background(), expense.Actor{Subject: "alice", RequestID: "req-1", SourceIP: "10.0.0.1"})
+
+		// Arrange
+		del := mock.ExpectPrepare("DELETE FROM expenses WHERE id = .+ RETURNING")
+		audit := mock.ExpectPrepare("INSERT INTO expense_audit")
+		mock.ExpectBegin()
+		del.ExpectQuery().WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}).
+			AddRow(1, "coffee", 60, "", "{}"))
+		expectVersion(mock, 1, false)
+		expectChainHead(mock, "")
+		audit.ExpectExec().
+			WithArgs(1, expense.AuditDelete, "alice", "req-1", "10.0.0.1",
+				[]byte(`{"amount":{"before":60,"after":null},"note":{"before":"","after":null},"tags":{"before":[],"after":null},"title":{"before":"coffee","after":null}}`),
+				sqlmock.AnyArg(), "", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		expectOutbox(mock, webhook.EventExpenseDeleted)
+		mock.ExpectCommit()
+
+		// Act
+		err := expStore.DeleteExpense(ctx, 1)
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Missing expense rolls back", func(t *testing.T) {
+		expStore, mock := setupDB(t)
+
+		// Arrange
+		del := mock.ExpectPrepare("DELETE FROM expenses")
+		mock.ExpectPrepare("INSERT INTO expense_audit")
+		mock.ExpectBegin()
+		del.ExpectQuery().WithArgs(1).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		// Act
+		err := expStore.DeleteExpense(context.Background(), 1)
+
+		// Assertions
+		assert.Equal(t, sql.ErrNoRows, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,162 @@
+package expense
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Event types recorded by the event sourced store
+const (
+	EventCreated = "created"
+	EventUpdated = "updated"
+	EventTagged  = "tagged"
+	EventDeleted = "deleted"
+)
+
+// Event is one change of an expense, the state of an expense is the fold
+// of its events in version order
+type Event struct {
+	Seq       int64           `json:"seq"`
+	ExpenseID int             `json:"expense_id"`
+	Version   int             `json:"version"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+	Actor     string          `json:"actor"`
+	RequestID string          `json:"request_id"`
+	SourceIP  string          `json:"source_ip"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// expensePatch is the data of created, updated and tagged events, fields
+// left nil were not changed by the event
+type expensePatch struct {
+	Title  *string   `json:"title,omitempty"`
+	Amount *float64  `json:"amount,omitempty"`
+	Note   *string   `json:"note,omitempty"`
+	Tags   *[]string `json:"tags,omitempty"`
+}
+
+// eventsFor returns the events turning before into after, nil meaning the
+// expense does not exist on that side. Tag changes are tagged events of
+// their own so tag history can be replayed apart from other edits.
+func eventsFor(before, after *Expense) ([]Event, error) {
+	var events []Event
+	add := func(typ string, data interface{}) error {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		events = append(events, Event{Type: typ, Data: raw})
+		return nil
+	}
+
+	var tags []string
+	if after != nil {
+		// an absent tag list is stored as empty, a null would read as unchanged
+		tags = append([]string{}, after.Tags...)
+	}
+
+	var err error
+	switch {
+	case after == nil:
+		err = add(EventDeleted, struct{}{})
+	case before == nil:
+		err = add(EventCreated, expensePatch{Title: &after.Title, Amount: &after.Amount, Note: &after.Note, Tags: &tags})
+	default:
+		var update expensePatch
+		updated, tagged := false, false
+		for _, f := range changedFields(*before, *after) {
+			switch f {
+			case "title":
+				update.Title, updated = &after.Title, true
+			case "amount":
+				update.Amount, updated = &after.Amount, true
+			case "note":
+				update.Note, updated = &after.Note, true
+			case "tags":
+				tagged = len(before.Tags) > 0 || len(tags) > 0
+			}
+		}
+		if updated {
+			err = add(EventUpdated, update)
+		}
+		if err == nil && tagged {
+			err = add(EventTagged, expensePatch{Tags: &tags})
+		}
+	}
+	return events, err
+}
+
+// historyEvents returns the events replaying into the versions of an
+// expense written by the state store: every version is created, or wholly
+// updated, when it took effect, and a version closed without a successor
+// was deleted when it was closed
+func historyEvents(versions []Version) ([]Event, error) {
+	var events []Event
+	add := func(typ string, data json.RawMessage, v Version, at time.Time) {
+		events = append(events, Event{ExpenseID: v.ID, Version: len(events) + 1, Type: typ, Data: data, Actor: systemActor, CreatedAt: at})
+	}
+
+	exists := false
+	for i, v := range versions {
+		state, err := eventsFor(nil, &v.Expense)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			add(EventUpdated, state[0].Data, v, v.ValidFrom)
+		} else {
+			add(EventCreated, state[0].Data, v, v.ValidFrom)
+		}
+		exists = true
+
+		last := i == len(versions)-1
+		if v.ValidTo != nil && (last || !versions[i+1].ValidFrom.Equal(*v.ValidTo)) {
+			add(EventDeleted, json.RawMessage(`{}`), v, *v.ValidTo)
+			exists = false
+		}
+	}
+	return events, nil
+}
+
+// applyEvent returns the state of exp after ev, nil once deleted
+func applyEvent(exp *Expense, ev Event) (*Expense, error) {
+	switch ev.Type {
+	case EventDeleted:
+		if exp == nil {
+			return nil, fmt.Errorf("event %d deletes missing expense %d", ev.Seq, ev.ExpenseID)
+		}
+		return nil, nil
+	case EventCreated:
+		if exp != nil {
+			return nil, fmt.Errorf("event %d creates existing expense %d", ev.Seq, ev.ExpenseID)
+		}
+		exp = &Expense{ID: ev.ExpenseID}
+	case EventUpdated, EventTagged:
+		if exp == nil {
+			return nil, fmt.Errorf("event %d changes missing expense %d", ev.Seq, ev.ExpenseID)
+		}
+	default:
+		return nil, fmt.Errorf("event %d has unknown type %q", ev.Seq, ev.Type)
+	}
+
+	var p expensePatch
+	if err := json.Unmarshal(ev.Data, &p); err != nil {
+		return nil, fmt.Errorf("can't decode event %d: %s", ev.Seq, err.Error())
+	}
+	next := *exp
+	if p.Title != nil {
+		next.Title = *p.Title
+	}
+	if p.Amount != nil {
+		next.Amount = *p.Amount
+	}
+	if p.Note != nil {
+		next.Note = *p.Note
+	}
+	if p.Tags != nil {
+		next.Tags = *p.Tags
+	}
+	return &next, nil
+}
@@ -0,0 +1,404 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	nextExpenseIDQuery    = "SELECT nextval(pg_get_serial_sequence('expenses', 'id'))"
+	lastEventVersionQuery = "SELECT COALESCE(max(version), 0) FROM expense_events WHERE expense_id = $1"
+	insertEventQuery      = `
+	INSERT INTO expense_events ( expense_id, version, type, data, actor, request_id, source_ip, created_at )
+	VALUES ( $1, $2, $3, $4, $5, $6, $7, $8 )
+	`
+	// unsourcedVersionsQuery reads the versions of expenses written by the
+	// state store, the ones without events, limited to expense $1 unless it is 0
+	unsourcedVersionsQuery = `
+	SELECT version, ` + versionColumns + `, valid_from, valid_to FROM expense_versions v
+	WHERE ( $1 = 0 OR v.expense_id = $1 )
+	AND NOT EXISTS ( SELECT 1 FROM expense_events ev WHERE ev.expense_id = v.expense_id )
+	ORDER BY v.expense_id, v.version
+	`
+	deleteProjectionQuery = "DELETE FROM expenses WHERE id = $1"
+	eventColumns          = "seq, expense_id, version, type, data, actor, request_id, source_ip, created_at"
+	allEventsQuery        = "SELECT " + eventColumns + " FROM expense_events ORDER BY seq"
+	// adoptExpensesQuery gives expenses written by the state store that
+	// have neither events nor versions a created event of their current state
+	adoptExpensesQuery = `
+	INSERT INTO expense_events ( expense_id, version, type, data, actor, created_at )
+	SELECT e.id, 1, 'created',
+		jsonb_build_object('title', e.title, 'amount', e.amount, 'note', e.note, 'tags', to_jsonb(COALESCE(e.tags, '{}'))),
+		'system', COALESCE(( SELECT max(v.valid_from) FROM expense_versions v WHERE v.expense_id = e.id AND v.valid_to IS NULL ), now())
+	FROM expenses e
+	WHERE NOT EXISTS ( SELECT 1 FROM expense_events ev WHERE ev.expense_id = e.id )
+	`
+	insertVersionQuery = `
+	INSERT INTO expense_versions ( expense_id, version, title, amount, note, tags, valid_from, valid_to )
+	VALUES ( $1, $2, $3, $4, $5, $6, $7, $8 )
+	`
+)
+
+// EventStore is the event sourced storer. Changes are appended to the
+// expense_events log and applied in the same transaction to the expenses
+// and expense_versions projections, so reads are the ExpenseStore reads of
+// those tables and stay as fast.
+type EventStore struct {
+	*ExpenseStore
+}
+
+// NewEventStore sources expenses from events, reading and auditing through s
+func NewEventStore(s *ExpenseStore) *EventStore {
+	return &EventStore{ExpenseStore: s}
+}
+
+func (s *EventStore) CreateExpense(ctx context.Context, exp Expense) (_ int, err error) {
+	ctx, span := s.span(ctx, "CreateExpense", insertEventQuery)
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, s.writeTimeout)
+	defer cancel()
+
+	auditStmt, err := s.auditStmt(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		if err := tx.QueryRowContext(ctx, nextExpenseIDQuery).Scan(&exp.ID); err != nil {
+			return err
+		}
+		return s.change(ctx, tx, auditStmt, exp.ID, AuditCreate, nil, &exp)
+	})
+	return exp.ID, ctxErr(ctx, err)
+}
+
+func (s *EventStore) UpdateExpense(ctx context.Context, exp Expense) (err error) {
+	ctx, span := s.span(ctx, "UpdateExpense", insertEventQuery)
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, s.writeTimeout)
+	defer cancel()
+
+	lock, auditStmt, err := s.writeStmts(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := scanExpense(tx.StmtContext(ctx, lock).QueryRowContext(ctx, exp.ID))
+		if err != nil {
+			return err
+		}
+		return s.change(ctx, tx, auditStmt, exp.ID, AuditUpdate, before, &exp)
+	})
+	return ctxErr(ctx, err)
+}
+
+func (s *EventStore) DeleteExpense(ctx context.Context, id int) (err error) {
+	ctx, span := s.span(ctx, "DeleteExpense", insertEventQuery)
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, s.writeTimeout)
+	defer cancel()
+
+	lock, auditStmt, err := s.writeStmts(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := scanExpense(tx.StmtContext(ctx, lock).QueryRowContext(ctx, id))
+		if err != nil {
+			return err
+		}
+		return s.change(ctx, tx, auditStmt, id, AuditDelete, before, nil)
+	})
+	return ctxErr(ctx, err)
+}
+
+func (s *EventStore) RevertExpense(ctx context.Context, id, version int) (_ *Expense, err error) {
+	ctx, span := s.span(ctx, "RevertExpense", expenseVersionQuery)
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, s.writeTimeout)
+	defer cancel()
+
+	get, err := s.stmt(ctx, expenseVersionQuery)
+	if err != nil {
+		return nil, fmt.Errorf("can't prepare query expense version statement: %s", err.Error())
+	}
+	lock, auditStmt, err := s.writeStmts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var exp *Expense
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := scanExpense(tx.StmtContext(ctx, lock).QueryRowContext(ctx, id))
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		exp, err = scanExpense(tx.StmtContext(ctx, get).QueryRowContext(ctx, id, version))
+		if err != nil {
+			return err
+		}
+		return s.change(ctx, tx, auditStmt, id, AuditRevert, before, exp)
+	})
+	if err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	return exp, nil
+}
+
+// writeStmts prepares the statements changing an existing expense needs,
+// before its transaction begins
+func (s *EventStore) writeStmts(ctx context.Context) (lock, auditStmt *sql.Stmt, err error) {
+	lock, err = s.stmt(ctx, lockExpenseQuery)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't prepare lock expense statement: %s", err.Error())
+	}
+	auditStmt, err = s.auditStmt(ctx)
+	return lock, auditStmt, err
+}
+
+// change appends the events turning before into after, then applies them to
+// the projections, the audit log and the outbox. The caller holds the row
+// lock of an existing expense, so event versions of one expense are never
+// raced.
+func (s *EventStore) change(ctx context.Context, tx *sql.Tx, auditStmt *sql.Stmt, id int, action string, before, after *Expense) error {
+	events, err := eventsFor(before, after)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		return nil
+	}
+
+	at := versionTime()
+	var version int
+	if err := tx.QueryRowContext(ctx, lastEventVersionQuery, id).Scan(&version); err != nil {
+		return fmt.Errorf("can't read event version: %s", err.Error())
+	}
+	if version == 0 && before != nil {
+		// written by the state store, its versions become the first events
+		if version, err = adoptVersions(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+	if version == 0 && before != nil {
+		// without versions either, its current state becomes the first event
+		baseline, err := eventsFor(nil, before)
+		if err != nil {
+			return err
+		}
+		if err := appendEvent(ctx, tx, id, 1, baseline[0], Actor{Subject: systemActor}, at); err != nil {
+			return err
+		}
+		version = 1
+	}
+	for i, ev := range events {
+		if err := appendEvent(ctx, tx, id, version+i+1, ev, ActorFrom(ctx), at); err != nil {
+			return err
+		}
+	}
+
+	if err := project(ctx, tx, id, before, after); err != nil {
+		return err
+	}
+	if err := recordVersion(ctx, tx, id, after, at); err != nil {
+		return err
+	}
+	return recordChange(ctx, tx, auditStmt, id, action, before, after)
+}
+
+func appendEvent(ctx context.Context, tx *sql.Tx, id, version int, ev Event, actor Actor, at time.Time) error {
+	_, err := tx.ExecContext(ctx, insertEventQuery,
+		id, version, ev.Type, []byte(ev.Data), actor.Subject, actor.RequestID, actor.SourceIP, at)
+	if err != nil {
+		return fmt.Errorf("can't append %s event: %s", ev.Type, err.Error())
+	}
+	return nil
+}
+
+// adoptVersions appends the events of historyEvents for the versions of
+// expenses written by the state store, of expense id or of all of them
+// when id is 0, so the versions survive replays of the event log. It
+// returns the number of events appended.
+func adoptVersions(ctx context.Context, tx *sql.Tx, id int) (int, error) {
+	rows, err := tx.QueryContext(ctx, unsourcedVersionsQuery, id)
+	if err != nil {
+		return 0, fmt.Errorf("can't read unsourced versions: %s", err.Error())
+	}
+	versions, err := scanVersions(rows)
+	if err != nil {
+		return 0, fmt.Errorf("can't read unsourced versions: %s", err.Error())
+	}
+
+	n := 0
+	for len(versions) > 0 {
+		end := 1
+		for end < len(versions) && versions[end].ID == versions[0].ID {
+			end++
+		}
+		events, err := historyEvents(versions[:end])
+		if err != nil {
+			return n, err
+		}
+		for _, ev := range events {
+			if err := appendEvent(ctx, tx, ev.ExpenseID, ev.Version, ev, Actor{Subject: ev.Actor}, ev.CreatedAt); err != nil {
+				return n, err
+			}
+		}
+		n += len(events)
+		versions = versions[end:]
+	}
+	return n, nil
+}
+
+// project applies a change to the expenses read model
+func project(ctx context.Context, tx *sql.Tx, id int, before, after *Expense) error {
+	var err error
+	switch {
+	case after == nil:
+		_, err = tx.ExecContext(ctx, deleteProjectionQuery, id)
+	case before == nil:
+		_, err = tx.ExecContext(ctx, restoreExpenseQuery, id, after.Title, after.Amount, after.Note, pq.Array(after.Tags))
+	default:
+		_, err = tx.ExecContext(ctx, updateExpenseQuery, id, after.Title, after.Amount, after.Note, pq.Array(after.Tags))
+	}
+	if err != nil {
+		return fmt.Errorf("can't project expense: %s", err.Error())
+	}
+	return nil
+}
+
+// RebuildProjection replays every event into fresh expenses and
+// expense_versions projections, returning the number of events replayed.
+// Expenses written by the state store first get events of their versions.
+// Writers wait on the table locks until the rebuilt projections commit.
+func (s *EventStore) RebuildProjection(ctx context.Context) (n int, err error) {
+	ctx, span := s.span(ctx, "RebuildProjection", allEventsQuery)
+	defer func() { endSpan(span, err) }()
+
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "LOCK TABLE expenses, expense_versions, expense_events IN EXCLUSIVE MODE"); err != nil {
+			return err
+		}
+		// the history of state store expenses is only kept in the versions
+		// about to be deleted
+		if _, err := adoptVersions(ctx, tx, 0); err != nil {
+			return err
+		}
+		for _, q := range []string{
+			adoptExpensesQuery,
+			"DELETE FROM expense_versions",
+			"DELETE FROM expenses",
+		} {
+			if _, err := tx.ExecContext(ctx, q); err != nil {
+				return err
+			}
+		}
+
+		events, err := queryEvents(ctx, tx)
+		if err != nil {
+			return err
+		}
+		expenses, versions, err := replay(events)
+		if err != nil {
+			return err
+		}
+		n = len(events)
+
+		for _, exp := range expenses {
+			_, err := tx.ExecContext(ctx, restoreExpenseQuery, exp.ID, exp.Title, exp.Amount, exp.Note, pq.Array(exp.Tags))
+			if err != nil {
+				return err
+			}
+		}
+		for _, v := range versions {
+			_, err := tx.ExecContext(ctx, insertVersionQuery,
+				v.ID, v.Version, v.Title, v.Amount, v.Note, pq.Array(v.Tags), v.ValidFrom, v.ValidTo)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return n, ctxErr(ctx, err)
+}
+
+// queryEvents reads the whole log, the rows are closed before the
+// projections are written on the same connection
+func queryEvents(ctx context.Context, tx *sql.Tx) ([]Event, error) {
+	rows, err := tx.QueryContext(ctx, allEventsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var ev Event
+		var data []byte
+		err := rows.Scan(&ev.Seq, &ev.ExpenseID, &ev.Version, &ev.Type, &data, &ev.Actor, &ev.RequestID, &ev.SourceIP, &ev.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		ev.Data = data
+		events = append(events, ev)
+	}
+	return events, rows.Err()
+}
+
+// replay folds events in log order into the current expenses and every
+// version they had. Events of one change share their time and make a
+// single version, like the writes that appended them.
+func replay(events []Event) ([]*Expense, []Version, error) {
+	state := map[int]*Expense{}
+	history := map[int][]Version{}
+	var ids []int
+
+	for _, ev := range events {
+		id := ev.ExpenseID
+		if _, seen := history[id]; !seen {
+			history[id] = []Version{}
+			ids = append(ids, id)
+		}
+
+		next, err := applyEvent(state[id], ev)
+		if err != nil {
+			return nil, nil, err
+		}
+		state[id] = next
+
+		vs := history[id]
+		last := len(vs) - 1
+		if last >= 0 && vs[last].ValidTo == nil && vs[last].ValidFrom.Equal(ev.CreatedAt) && next != nil {
+			vs[last].Expense = *next
+			continue
+		}
+		if last >= 0 && vs[last].ValidTo == nil {
+			at := ev.CreatedAt
+			vs[last].ValidTo = &at
+		}
+		if next != nil {
+			vs = append(vs, Version{Version: len(vs) + 1, Expense: *next, ValidFrom: ev.CreatedAt})
+		}
+		history[id] = vs
+	}
+
+	var expenses []*Expense
+	var versions []Version
+	for _, id := range ids {
+		if state[id] != nil {
+			expenses = append(expenses, state[id])
+		}
+		versions = append(versions, history[id]...)
+	}
+	return expenses, versions, nil
+}
@@ -0,0 +1,259 @@
+//go:build unit
+// +build unit
+
+package expense_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bazsup/assessment/expense"
+	"github.com/bazsup/assessment/webhook"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+// historyRows are the columns of the versions of a state store expense
+var historyRows = []string{"version", "expense_id", "title", "amount", "note", "tags", "valid_from", "valid_to"}
+
+func TestDBEventStoreCreate(t *testing.T) {
+	t.Run("Create appends a created event and projects it", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		store := expense.NewEventStore(expense.NewExpenseStore(db))
+		exp := expense.Expense{Title: "coffee", Amount: 60, Tags: []string{"food"}}
+
+		// Arrange
+		audit := mock.ExpectPrepare("INSERT INTO expense_audit")
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT nextval`).WillReturnRows(sqlmock.NewRows([]string{"nextval"}).AddRow(7))
+		mock.ExpectQuery(`SELECT COALESCE\(max\(version\), 0\) FROM expense_events`).WithArgs(7).
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(0))
+		mock.ExpectExec(`INSERT INTO expense_events`).
+			WithArgs(7, 1, expense.EventCreated, []byte(`{"title":"coffee","amount":60,"note":"","tags":["food"]}`),
+				"system", "", "", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`INSERT INTO expenses \( id,`).WithArgs(7, "coffee", 60.0, "", pq.Array(exp.Tags)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectVersion(mock, 7, true)
+		expectChainHead(mock, "")
+		audit.ExpectExec().WillReturnResult(sqlmock.NewResult(1, 1))
+		expectOutbox(mock, webhook.EventExpenseCreated)
+		mock.ExpectCommit()
+
+		// Act
+		id, err := store.CreateExpense(context.Background(), exp)
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.Equal(t, 7, id)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDBEventStoreUpdate(t *testing.T) {
+	t.Run("Expense written by the state store gets events of its versions first", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		store := expense.NewEventStore(expense.NewExpenseStore(db))
+		created := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+		baseline := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+		// Arrange
+		lock := mock.ExpectPrepare("SELECT .+ FOR UPDATE")
+		audit := mock.ExpectPrepare("INSERT INTO expense_audit")
+		mock.ExpectBegin()
+		lock.ExpectQuery().WithArgs(1).WillReturnRows(sqlmock.NewRows(versionRows).AddRow(1, "coffee", 60, "", "{}"))
+		mock.ExpectQuery(`SELECT COALESCE\(max\(version\), 0\) FROM expense_events`).
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(0))
+		mock.ExpectQuery(`SELECT version, .+ FROM expense_versions v`).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(historyRows).
+				AddRow(1, 1, "tea", 40, "", "{}", created, &baseline).
+				AddRow(2, 1, "coffee", 60, "", "{}", baseline, nil))
+		mock.ExpectExec(`INSERT INTO expense_events`).
+			WithArgs(1, 1, expense.EventCreated, []byte(`{"title":"tea","amount":40,"note":"","tags":[]}`), "system", "", "", created).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`INSERT INTO expense_events`).
+			WithArgs(1, 2, expense.EventUpdated, []byte(`{"title":"coffee","amount":60,"note":"","tags":[]}`), "system", "", "", baseline).
+			WillReturnResult(sqlmock.NewResult(2, 1))
+		mock.ExpectExec(`INSERT INTO expense_events`).
+			WithArgs(1, 3, expense.EventUpdated, []byte(`{"amount":65}`), "system", "", "", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(3, 1))
+		mock.ExpectExec(`INSERT INTO expense_events`).
+			WithArgs(1, 4, expense.EventTagged, []byte(`{"tags":["food"]}`), "system", "", "", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(4, 1))
+		mock.ExpectExec(`UPDATE expenses`).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectVersion(mock, 1, true)
+		expectChainHead(mock, "")
+		audit.ExpectExec().WillReturnResult(sqlmock.NewResult(1, 1))
+		expectOutbox(mock, webhook.EventExpenseUpdated)
+		mock.ExpectCommit()
+
+		// Act
+		err = store.UpdateExpense(context.Background(), expense.Expense{ID: 1, Title: "coffee", Amount: 65, Tags: []string{"food"}})
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Missing expense rolls back", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		store := expense.NewEventStore(expense.NewExpenseStore(db))
+
+		// Arrange
+		lock := mock.ExpectPrepare("SELECT .+ FOR UPDATE")
+		mock.ExpectPrepare("INSERT INTO expense_audit")
+		mock.ExpectBegin()
+		lock.ExpectQuery().WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		// Act
+		err = store.UpdateExpense(context.Background(), expense.Expense{ID: 1})
+
+		// Assertions
+		assert.Equal(t, sql.ErrNoRows, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDBRebuildProjection(t *testing.T) {
+	t.Run("Rebuild replays the event log into both projections", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		store := expense.NewEventStore(expense.NewExpenseStore(db))
+		t1 := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+		t2 := t1.Add(time.Hour)
+		t3 := t2.Add(time.Hour)
+		events := sqlmock.NewRows([]string{"seq", "expense_id", "version", "type", "data", "actor", "request_id", "source_ip", "created_at"}).
+			AddRow(1, 1, 1, expense.EventCreated, []byte(`{"title":"coffee","amount":60,"note":"","tags":[]}`), "alice", "", "", t1).
+			AddRow(2, 2, 1, expense.EventCreated, []byte(`{"title":"taxi","amount":200,"note":"","tags":[]}`), "alice", "", "", t1).
+			AddRow(3, 1, 2, expense.EventUpdated, []byte(`{"amount":65}`), "bob", "", "", t2).
+			AddRow(4, 1, 3, expense.EventTagged, []byte(`{"tags":["food"]}`), "bob", "", "", t2).
+			AddRow(5, 2, 2, expense.EventDeleted, []byte(`{}`), "bob", "", "", t3)
+
+		// Arrange
+		mock.ExpectBegin()
+		mock.ExpectExec(`LOCK TABLE expenses, expense_versions, expense_events`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT version, .+ FROM expense_versions v`).WithArgs(0).WillReturnRows(sqlmock.NewRows(historyRows))
+		mock.ExpectExec(`INSERT INTO expense_events .+ NOT EXISTS`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`DELETE FROM expense_versions`).WillReturnResult(sqlmock.NewResult(0, 3))
+		mock.ExpectExec(`DELETE FROM expenses`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`FROM expense_events ORDER BY seq`).WillReturnRows(events)
+		mock.ExpectExec(`INSERT INTO expenses`).WithArgs(1, "coffee", 65.0, "", pq.Array([]string{"food"})).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO expense_versions`).WithArgs(1, 1, "coffee", 60.0, "", sqlmock.AnyArg(), t1, &t2).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO expense_versions`).WithArgs(1, 2, "coffee", 65.0, "", pq.Array([]string{"food"}), t2, nil).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO expense_versions`).WithArgs(2, 1, "taxi", 200.0, "", sqlmock.AnyArg(), t1, &t3).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		// Act
+		n, err := store.RebuildProjection(context.Background())
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.Equal(t, 5, n)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Rebuild keeps the versions of expenses written by the state store", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		store := expense.NewEventStore(expense.NewExpenseStore(db))
+		t1 := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+		t2 := t1.Add(time.Hour)
+		t3 := t2.Add(time.Hour)
+		t4 := t3.Add(time.Hour)
+		coffee := []byte(`{"title":"coffee","amount":60,"note":"","tags":[]}`)
+		latte := []byte(`{"title":"latte","amount":80,"note":"","tags":[]}`)
+
+		// Arrange
+		mock.ExpectBegin()
+		mock.ExpectExec(`LOCK TABLE`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT version, .+ FROM expense_versions v`).WithArgs(0).
+			WillReturnRows(sqlmock.NewRows(historyRows).
+				AddRow(1, 3, "coffee", 60, "", "{}", t1, &t2).
+				AddRow(2, 3, "latte", 80, "", "{}", t2, &t3).
+				AddRow(3, 3, "coffee", 60, "", "{}", t4, nil))
+		adopted := sqlmock.NewRows([]string{"seq", "expense_id", "version", "type", "data", "actor", "request_id", "source_ip", "created_at"})
+		for i, ev := range []struct {
+			typ  string
+			data []byte
+			at   time.Time
+		}{
+			{expense.EventCreated, coffee, t1},
+			{expense.EventUpdated, latte, t2},
+			{expense.EventDeleted, []byte(`{}`), t3},
+			{expense.EventCreated, coffee, t4},
+		} {
+			mock.ExpectExec(`INSERT INTO expense_events`).WithArgs(3, i+1, ev.typ, ev.data, "system", "", "", ev.at).
+				WillReturnResult(sqlmock.NewResult(int64(i+1), 1))
+			adopted.AddRow(i+1, 3, i+1, ev.typ, ev.data, "system", "", "", ev.at)
+		}
+		mock.ExpectExec(`INSERT INTO expense_events .+ NOT EXISTS`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`DELETE FROM expense_versions`).WillReturnResult(sqlmock.NewResult(0, 3))
+		mock.ExpectExec(`DELETE FROM expenses`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`FROM expense_events ORDER BY seq`).WillReturnRows(adopted)
+		mock.ExpectExec(`INSERT INTO expenses`).WithArgs(3, "coffee", 60.0, "", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO expense_versions`).WithArgs(3, 1, "coffee", 60.0, "", sqlmock.AnyArg(), t1, &t2).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO expense_versions`).WithArgs(3, 2, "latte", 80.0, "", sqlmock.AnyArg(), t2, &t3).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO expense_versions`).WithArgs(3, 3, "coffee", 60.0, "", sqlmock.AnyArg(), t4, nil).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		// Act
+		n, err := store.RebuildProjection(context.Background())
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.Equal(t, 4, n)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Event of a missing expense fails the rebuild", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		store := expense.NewEventStore(expense.NewExpenseStore(db))
+
+		// Arrange
+		mock.ExpectBegin()
+		mock.ExpectExec(`LOCK TABLE`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`FROM expense_versions v`).WillReturnRows(sqlmock.NewRows(historyRows))
+		for i := 0; i < 3; i++ {
+			mock.ExpectExec(`.+`).WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+		mock.ExpectQuery(`FROM expense_events ORDER BY seq`).WillReturnRows(
+			sqlmock.NewRows([]string{"seq", "expense_id", "version", "type", "data", "actor", "request_id", "source_ip", "created_at"}).
+				AddRow(1, 1, 1, expense.EventUpdated, []byte(`{"amount":65}`), "bob", "", "", time.Now()))
+		mock.ExpectRollback()
+
+		// Act
+		_, err = store.RebuildProjection(context.Background())
+
+		// Assertions
+		assert.EqualError(t, err, "event 1 changes missing expense 1")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -1,9 +1,26 @@
 package expense
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/bazsup/assessment/auth"
+	"github.com/bazsup/assessment/authz"
+	"github.com/bazsup/assessment/health"
+	"github.com/bazsup/assessment/idempotency"
+	"github.com/bazsup/assessment/metrics"
+	"github.com/bazsup/assessment/problem"
+	"github.com/bazsup/assessment/ratelimit"
+	"github.com/bazsup/assessment/stream"
+	"github.com/bazsup/assessment/webhook"
 	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Expense struct {
@@ -14,55 +31,357 @@ type Expense struct {
 	Tags   []string `json:"tags"`
 }
 
-type Err struct {
-	Message string `json:"message"`
+// LogValue lets the logger redact the note like any other sensitive attribute
+func (exp Expense) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Int("id", exp.ID),
+		slog.String("title", exp.Title),
+		slog.Float64("amount", exp.Amount),
+		slog.String("note", exp.Note),
+		slog.Any("tags", exp.Tags),
+	)
 }
 
 type storer interface {
-	CreateExpense(exp Expense) (int, error)
-	GetExpenseByID(id int) (*Expense, error)
-	GetAllExpenses() ([]*Expense, error)
-	UpdateExpense(exp Expense) error
+	CreateExpense(ctx context.Context, exp Expense) (int, error)
+	GetExpenseByID(ctx context.Context, id int) (*Expense, error)
+	GetAllExpenses(ctx context.Context) ([]*Expense, error)
+	UpdateExpense(ctx context.Context, exp Expense) error
+	DeleteExpense(ctx context.Context, id int) error
+	GetExpenseAsOf(ctx context.Context, id int, at time.Time) (*Expense, error)
+	GetAllExpensesAsOf(ctx context.Context, at time.Time) ([]*Expense, error)
+	ExpenseVersions(ctx context.Context, id int) ([]Version, error)
+	RevertExpense(ctx context.Context, id, version int) (*Expense, error)
+	ExpenseHistory(ctx context.Context, id int) ([]AuditEntry, error)
+	QueryAudit(ctx context.Context, q AuditQuery) ([]AuditEntry, error)
 }
 
+// bindError is the problem of a request body that can't be bound, such as
+// malformed JSON, without the text of the decoder
+func bindError(err error) error {
+	var he *echo.HTTPError
+	if errors.As(err, &he) && he.Code != http.StatusBadRequest {
+		return problem.From(err)
+	}
+	return problem.WrapLocalized(problem.BadRequest, err, problem.DetailBodyUnparsable)
+}
+
+// identityKey is the echo context key holding the authenticated *auth.Identity
+const identityKey = "identity"
+
+// authErrorKey is the echo context key holding why identify rejected the credentials
+const authErrorKey = "auth_error"
+
+// staticTokenSubject is the identity given to callers using the shared AUTH_TOKEN
+const staticTokenSubject = "static-token"
+
 type CustomMiddleware struct {
 	authToken string
+	verifier  *auth.Verifier
 }
 
-func NewCustomMiddleware(authToken string) *CustomMiddleware {
-	return &CustomMiddleware{authToken: authToken}
+func NewCustomMiddleware(authToken string, verifier *auth.Verifier) *CustomMiddleware {
+	return &CustomMiddleware{authToken: authToken, verifier: verifier}
 }
 
-func (cm *CustomMiddleware) authMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+// authRealm is advertised in the WWW-Authenticate header of 401 responses
+const authRealm = "expenses"
+
+// errNoCredentials is returned by authenticate when no credentials were presented
+var errNoCredentials = errors.New("missing credentials")
+
+// errInvalidCredentials is returned by authenticate when the credentials
+// match neither the static token nor a bearer token
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// authenticate resolves the value of an Authorization header to the caller
+func (cm *CustomMiddleware) authenticate(key string) (*auth.Identity, error) {
+	if key == "" {
+		return nil, errNoCredentials
+	}
+
+	if key == cm.authToken {
+		return &auth.Identity{Subject: staticTokenSubject}, nil
+	}
+
+	if cm.verifier != nil && strings.HasPrefix(key, "Bearer ") {
+		return cm.verifier.Verify(strings.TrimPrefix(key, "Bearer "))
+	}
+
+	return nil, errInvalidCredentials
+}
+
+// identify authenticates the caller without rejecting the request, a
+// failure is kept for authMiddleware so the limiter between the two keys
+// unauthenticated requests by IP
+func (cm *CustomMiddleware) identify(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		key := c.Request().Header.Get("Authorization")
+		id, err := cm.authenticate(c.Request().Header.Get("Authorization"))
+		if err != nil {
+			c.Set(authErrorKey, err)
+			return next(c)
+		}
+		c.Set(identityKey, id)
+		return next(c)
+	}
+}
 
-		if key != cm.authToken {
-			return c.JSON(http.StatusUnauthorized, Err{Message: "Unauthorized"})
+// authMiddleware rejects requests identify couldn't authenticate
+func (cm *CustomMiddleware) authMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err, _ := c.Get(authErrorKey).(error)
+		switch {
+		case IdentityFrom(c) != nil:
+			return next(c)
+		case err == nil:
+			// identify didn't run
+			return cm.identify(cm.authMiddleware(next))(c)
+		case err == errNoCredentials:
+			return unauthorized(c, nil)
+		default:
+			return unauthorized(c, err)
 		}
+	}
+}
 
-		return next(c)
+// unauthorized sets a RFC 6750 challenge on the 401, err is only set when credentials were presented
+func unauthorized(c echo.Context, err error) error {
+	challenge := fmt.Sprintf(`Bearer realm=%q`, authRealm)
+	if err == nil {
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, challenge)
+		return problem.Localized(problem.Unauthorized, problem.DetailMissingCredentials)
 	}
+
+	description, key := rejection(err)
+	c.Response().Header().Set(echo.HeaderWWWAuthenticate,
+		challenge+fmt.Sprintf(`, error="invalid_token", error_description=%q`, description))
+	slog.DebugContext(c.Request().Context(), "credentials rejected", "error", err.Error())
+	return problem.Localized(problem.Unauthorized, key)
 }
 
-func NewApp(e *echo.Echo, s storer, authToken string) {
-	h := NewExpense(s)
+// rejection is the fixed error_description and detail key of credentials
+// rejected with err, the verifier's own text may quote the token
+func rejection(err error) (description, key string) {
+	switch {
+	case errors.Is(err, errInvalidCredentials):
+		return "invalid credentials", problem.DetailInvalidCredentials
+	case errors.Is(err, auth.ErrTokenExpired):
+		return "token expired", problem.DetailTokenExpired
+	case errors.Is(err, auth.ErrTokenNotYetValid):
+		return "token not yet valid", problem.DetailTokenNotYetValid
+	case errors.Is(err, auth.ErrInvalidSignature), errors.Is(err, auth.ErrUnknownKey):
+		return "invalid signature", problem.DetailTokenSignature
+	case errors.Is(err, auth.ErrInvalidAudience), errors.Is(err, auth.ErrInvalidIssuer):
+		return "token not issued for this API", problem.DetailTokenAudience
+	default:
+		return "invalid token", problem.DetailRejectedToken
+	}
+}
+
+// IdentityFrom returns the caller authenticated by the auth middleware, or nil
+func IdentityFrom(c echo.Context) *auth.Identity {
+	id, _ := c.Get(identityKey).(*auth.Identity)
+	return id
+}
+
+// clientKey identifies the caller by authenticated identity, or by IP on public routes
+func clientKey(c echo.Context) string {
+	if id := IdentityFrom(c); id != nil {
+		return "id:" + id.Subject
+	}
+	return "ip:" + c.RealIP()
+}
+
+type appOptions struct {
+	verifier *auth.Verifier
+	policy   *authz.Policy
+	limiter  *ratelimit.Limiter
+	replayer echo.MiddlewareFunc
+	health   *health.Checker
+	metrics  *prometheus.Registry
+	// metricTags are the tags expenses_created_total is labelled with
+	metricTags []string
+	tracer     trace.TracerProvider
+	webhooks   webhookAdmin
+	hub        *stream.Hub
+	graphql    *GraphQLLimits
+}
+
+// Option customises the app built by NewApp
+type Option func(*appOptions)
+
+// WithJWTVerifier accepts "Authorization: Bearer <jwt>" alongside the static auth token
+func WithJWTVerifier(v *auth.Verifier) Option {
+	return func(o *appOptions) {
+		o.verifier = v
+	}
+}
+
+// WithPolicy authorizes every expense route against an RBAC/ABAC policy
+func WithPolicy(p *authz.Policy) Option {
+	return func(o *appOptions) {
+		o.policy = p
+	}
+}
+
+// WithRateLimit limits every route and gRPC call per client, keyed by the
+// authenticated identity or the client IP on public routes
+func WithRateLimit(cfg ratelimit.Config) Option {
+	return func(o *appOptions) {
+		cfg.KeyFunc = clientKey
+		o.limiter = ratelimit.NewLimiter(cfg)
+	}
+}
 
-	cm := NewCustomMiddleware(authToken)
-	e.Use(cm.authMiddleware)
+// WithIdempotency replays responses of create and update requests retried
+// with the same Idempotency-Key by the same caller
+func WithIdempotency(cfg idempotency.Config) Option {
+	return func(o *appOptions) {
+		cfg.ScopeFunc = clientKey
+		o.replayer = idempotency.Middleware(cfg)
+	}
+}
+
+// WithHealth reports the checker's dependency checks on /readyz and /health,
+// without it readiness only reflects the process itself
+func WithHealth(c *health.Checker) Option {
+	return func(o *appOptions) {
+		o.health = c
+	}
+}
+
+// WithMetrics instruments every route and the store, and serves reg on /metrics
+func WithMetrics(reg *prometheus.Registry) Option {
+	return func(o *appOptions) {
+		o.metrics = reg
+	}
+}
+
+// WithMetricTags counts created expenses under each of tags, every other
+// tag is counted as "other"
+func WithMetricTags(tags ...string) Option {
+	return func(o *appOptions) {
+		o.metricTags = tags
+	}
+}
+
+// WithTracer starts a server span per request, continuing incoming W3C trace context
+func WithTracer(tp trace.TracerProvider) Option {
+	return func(o *appOptions) {
+		o.tracer = tp
+	}
+}
+
+// WithWebhooks serves the webhook subscription and delivery log routes from w
+func WithWebhooks(w *webhook.PostgresStore) Option {
+	return func(o *appOptions) {
+		o.webhooks = w
+	}
+}
+
+// WithStream serves the expense change streams from hub
+func WithStream(hub *stream.Hub) Option {
+	return func(o *appOptions) {
+		o.hub = hub
+	}
+}
+
+// WithGraphQLLimits replaces DefaultGraphQLLimits, a zero limit is unbounded
+func WithGraphQLLimits(l GraphQLLimits) Option {
+	return func(o *appOptions) {
+		o.graphql = &l
+	}
+}
+
+func NewApp(e *echo.Echo, s storer, authToken string, opts ...Option) {
+	var o appOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if e.IPExtractor == nil {
+		// echo believes forwarding headers by default, clients would pick
+		// the address they are limited and audited by
+		e.IPExtractor = echo.ExtractIPDirect()
+	}
+
+	var httpMetrics *metrics.HTTP
+	if o.metrics != nil {
+		s = instrumentStore(s, o.metrics, o.metricTags)
+		httpMetrics = metrics.NewHTTP(o.metrics)
+	}
+
+	h := NewExpense(s)
+	h.metrics = o.metrics
+	h.policy = o.policy
+	h.health = o.health
+	h.webhooks = o.webhooks
+	h.hub = o.hub
+	h.graphLimits = DefaultGraphQLLimits
+	if o.graphql != nil {
+		h.graphLimits = *o.graphql
+	}
+	if h.health == nil {
+		h.health = health.NewChecker(0)
+	}
+	cm := NewCustomMiddleware(authToken, o.verifier)
+	doc := apiDocument()
 
-	e.POST("/expenses", h.CreateExpense)
-	e.GET("/expenses", h.GetAllExpenses)
-	e.GET("/expenses/:id", h.GetExpense)
-	e.PUT("/expenses/:id", h.UpdateExpense)
+	// auth is attached per route rather than with e.Use so unknown paths
+	// stay 404 and public routes never see the auth middleware
+	for _, r := range Routes() {
+		var m []echo.MiddlewareFunc
+		if o.tracer != nil {
+			m = append(m, traceRequests(o.tracer.Tracer(instrumentation)))
+		}
+		if httpMetrics != nil {
+			// measured ahead of auth so rejected requests are counted too
+			m = append(m, httpMetrics.Middleware)
+		}
+		if r.Access == AccessAuthenticated {
+			m = append(m, cm.identify)
+		}
+		if o.limiter != nil {
+			// ahead of the 401 so floods of bad credentials are limited by IP
+			m = append(m, o.limiter.Middleware)
+		}
+		if r.Access == AccessAuthenticated {
+			m = append(m, cm.authMiddleware)
+		}
+		if op := doc.Operation(r.Method, r.Path); op != nil && (op.RequestBody != nil || len(op.Parameters) > 0) {
+			// validated ahead of the policy so it only sees well formed expenses
+			m = append(m, validateRequest(doc, op))
+		}
+		if h.policy != nil && r.Action != "" {
+			m = append(m, h.authorize(r.Action))
+		}
+		if o.replayer != nil {
+			m = append(m, o.replayer)
+		}
+		e.Add(r.Method, r.Path, h.bind(r.handle), m...)
+	}
 }
 
 type handler struct {
-	store storer
+	store       storer
+	policy      *authz.Policy
+	health      *health.Checker
+	metrics     *prometheus.Registry
+	webhooks    webhookAdmin
+	hub         *stream.Hub
+	graphLimits GraphQLLimits
 }
 
 func NewExpense(store storer) *handler {
-	return &handler{store}
+	return &handler{store: store}
+}
+
+// bind adapts a route handler, tagging the request context with the actor
+// the store records changes under
+func (h *handler) bind(fn func(*handler, echo.Context) error) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.SetRequest(c.Request().WithContext(WithActor(c.Request().Context(), actorOf(c))))
+		return fn(h, c)
+	}
 }
 
 func (h *handler) CreateExpense(c echo.Context) error {
@@ -74,9 +393,17 @@ func (h *handler) GetExpense(c echo.Context) error {
 }
 
 func (h *handler) GetAllExpenses(c echo.Context) error {
-	return GetAllExpensesHandler(c, h.store)
+	keep, err := readFilter(h.policy, IdentityFrom(c))
+	if err != nil {
+		return err
+	}
+	return getAllExpenses(c, h.store, keep)
 }
 
 func (h *handler) UpdateExpense(c echo.Context) error {
 	return UpdateExpense(c, h.store)
 }
+
+func (h *handler) DeleteExpense(c echo.Context) error {
+	return DeleteExpenseHandler(c, h.store)
+}
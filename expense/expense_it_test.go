@@ -4,21 +4,31 @@
 package expense_test
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/bazsup/assessment/audit"
 	"github.com/bazsup/assessment/config"
 	"github.com/bazsup/assessment/expense"
+	"github.com/bazsup/assessment/migration"
+	"github.com/bazsup/assessment/problem"
+	"github.com/bazsup/assessment/retry"
+	"github.com/bazsup/assessment/stream"
+	"github.com/bazsup/assessment/webhook"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 )
@@ -29,12 +39,29 @@ type teardownFunc = func(t *testing.T)
 
 func setup() teardownFunc {
 	eh := echo.New()
+	eh.HTTPErrorHandler = problem.Handler(slog.Default())
+	listening, stopListening := context.WithCancel(context.Background())
 	go func(e *echo.Echo) {
 		config := config.NewConfig()
 		db := expense.InitDB(config.DatabaseUrl)
+		if err := expense.WaitForDB(context.Background(), db, retry.Policy{Initial: 200 * time.Millisecond, Max: 2 * time.Second, MaxWait: 30 * time.Second}); err != nil {
+			log.Fatal("database is unreachable: ", err)
+		}
+		if _, err := migration.New(db).Up(context.Background()); err != nil {
+			log.Fatal("can't migrate database: ", err)
+		}
 		store := expense.NewExpenseStore(db)
+		hub := stream.NewHub(stream.NewPostgresSource(db))
+		if err := hub.Start(context.Background()); err != nil {
+			log.Fatal("can't start stream: ", err)
+		}
+		go stream.Listen(listening, config.DatabaseUrl, hub, time.Minute)
+		go func() {
+			<-listening.Done()
+			hub.Close()
+		}()
 
-		expense.NewApp(e, store, config.AuthToken)
+		expense.NewApp(e, store, config.AuthToken, expense.WithStream(hub))
 
 		e.Start(fmt.Sprintf(":%d", serverPort))
 	}(eh)
@@ -50,6 +77,7 @@ func setup() teardownFunc {
 	}
 
 	return func(t *testing.T) {
+		stopListening()
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 		err := eh.Shutdown(ctx)
@@ -165,6 +193,260 @@ func TestITUpdateExpense(t *testing.T) {
 	}
 }
 
+func TestITDeleteExpenseHistory(t *testing.T) {
+	// Setup server
+	teardown := setup()
+	defer teardown(t)
+
+	// Arrange
+	exp := seedExpense(t)
+	request(http.MethodPut, uri("expenses", strconv.Itoa(exp.ID)), strings.NewReader(`{
+		"title": "test-title",
+		"amount": 40000,
+		"note": "test-note",
+		"tags": ["test-tag1", "test-tag2"]
+	}`)).Body.Close()
+
+	// Act
+	del := request(http.MethodDelete, uri("expenses", strconv.Itoa(exp.ID)), nil)
+	del.Body.Close()
+	get := request(http.MethodGet, uri("expenses", strconv.Itoa(exp.ID)), nil)
+	get.Body.Close()
+
+	var history []expense.AuditEntry
+	err := request(http.MethodGet, uri("expenses", strconv.Itoa(exp.ID), "history"), nil).Decode(&history)
+
+	var report audit.Report
+	verifyErr := request(http.MethodGet, uri("admin", "audit", "verify"), nil).Decode(&report)
+
+	// Assertions
+	assert.Equal(t, http.StatusNoContent, del.StatusCode)
+	assert.Equal(t, http.StatusNotFound, get.StatusCode)
+	if assert.NoError(t, err) && assert.Len(t, history, 3) {
+		assert.Equal(t, expense.AuditCreate, history[0].Action)
+		assert.Equal(t, expense.AuditUpdate, history[1].Action)
+		assert.Equal(t, expense.FieldChange{Before: 39000.0, After: 40000.0}, history[1].Changes["amount"])
+		assert.Equal(t, expense.AuditDelete, history[2].Action)
+		assert.Equal(t, "static-token", history[2].Actor)
+	}
+	if assert.NoError(t, verifyErr) {
+		assert.True(t, report.Valid, "audit chain broken: %+v", report.Broken)
+		assert.GreaterOrEqual(t, report.Entries, 3)
+	}
+}
+
+func TestITRevertExpense(t *testing.T) {
+	// Setup server
+	teardown := setup()
+	defer teardown(t)
+
+	// Arrange
+	exp := seedExpense(t)
+	id := strconv.Itoa(exp.ID)
+	beforeUpdate := time.Now().UTC().Format(time.RFC3339Nano)
+	request(http.MethodPut, uri("expenses", id), strings.NewReader(`{
+		"title": "updated-title",
+		"amount": 40000,
+		"note": "test-note",
+		"tags": ["test-tag1"]
+	}`)).Body.Close()
+	request(http.MethodDelete, uri("expenses", id), nil).Body.Close()
+
+	// Act
+	var asOf expense.Expense
+	asOfErr := request(http.MethodGet, uri("expenses", id+"?as_of="+url.QueryEscape(beforeUpdate)), nil).Decode(&asOf)
+
+	var reverted expense.Expense
+	revertErr := request(http.MethodPost, uri("expenses", id, "revert?version=1"), nil).Decode(&reverted)
+
+	var versions []expense.Version
+	versionsErr := request(http.MethodGet, uri("expenses", id, "versions"), nil).Decode(&versions)
+
+	// Assertions
+	if assert.NoError(t, asOfErr) {
+		assert.Equal(t, exp, asOf)
+	}
+	if assert.NoError(t, revertErr) {
+		assert.Equal(t, exp, reverted)
+	}
+	if assert.NoError(t, versionsErr) && assert.Len(t, versions, 3) {
+		assert.NotNil(t, versions[1].ValidTo, "deleting closes the updated version")
+		assert.Nil(t, versions[2].ValidTo)
+		assert.Equal(t, exp, versions[2].Expense)
+	}
+}
+
+func TestITWebhookDelivery(t *testing.T) {
+	// Setup server
+	teardown := setup()
+	defer teardown(t)
+
+	db := expense.InitDB(conf.DatabaseUrl)
+	defer db.Close()
+	hooks := webhook.NewPostgresStore(db)
+
+	received := make(chan webhook.Body, 100)
+	var secret string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if webhook.Verify(secret, r.Header.Get(webhook.HeaderSignature), body, time.Now(), time.Minute) != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var b webhook.Body
+		json.Unmarshal(body, &b)
+		received <- b
+	}))
+	defer receiver.Close()
+
+	// Arrange
+	sub, err := hooks.CreateSubscription(context.Background(), webhook.Subscription{URL: receiver.URL, Events: []string{webhook.EventExpenseCreated}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hooks.DeleteSubscription(context.Background(), sub.ID)
+	secret = sub.Secret
+	exp := seedExpense(t)
+
+	// Act
+	d := webhook.NewDispatcher(hooks)
+	// the receiver listens on loopback
+	d.Client = receiver.Client()
+	_, err = d.RunOnce(context.Background())
+	close(received)
+
+	// Assertions
+	assert.NoError(t, err)
+	var delivered *webhook.Body
+	for b := range received {
+		if b.ExpenseID == exp.ID {
+			b := b
+			delivered = &b
+		}
+	}
+	if assert.NotNil(t, delivered, "created event is delivered") {
+		assert.Equal(t, webhook.EventExpenseCreated, delivered.Type)
+		assert.Contains(t, string(delivered.Data), `"title":"`+exp.Title+`"`)
+	}
+}
+
+func TestITStreamExpenses(t *testing.T) {
+	// Setup server
+	teardown := setup()
+	defer teardown(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, uri("expenses", "stream"), nil)
+	req.Header.Add("Authorization", conf.AuthToken)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	// Arrange
+	exp := seedExpense(t)
+
+	// Act
+	var created stream.Event
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			json.Unmarshal([]byte(data), &created)
+			if created.ExpenseID == exp.ID {
+				break
+			}
+		}
+	}
+
+	// Assertions
+	assert.Equal(t, exp.ID, created.ExpenseID)
+	assert.Equal(t, webhook.EventExpenseCreated, created.Type)
+}
+
+func TestITGraphQL(t *testing.T) {
+	// Setup server
+	teardown := setup()
+	defer teardown(t)
+
+	// Arrange
+	exp := seedExpense(t)
+
+	// Act
+	query := fmt.Sprintf(`{"query": "{ expense(id: %d) { title versions { version } } tags { tag } summary { count } }"}`, exp.ID)
+	res := request(http.MethodPost, uri("graphql"), strings.NewReader(query))
+
+	var body struct {
+		Data struct {
+			Expense struct {
+				Title    string
+				Versions []struct{ Version int }
+			}
+			Tags    []struct{ Tag string }
+			Summary struct{ Count int }
+		}
+		Errors []interface{}
+	}
+	err := res.Decode(&body)
+
+	// Assertions
+	if assert.NoError(t, err) {
+		assert.EqualValues(t, http.StatusOK, res.StatusCode)
+		assert.Empty(t, body.Errors)
+		assert.Equal(t, exp.Title, body.Data.Expense.Title)
+		assert.Len(t, body.Data.Expense.Versions, 1)
+		assert.Contains(t, body.Data.Tags, struct{ Tag string }{"test-tag1"})
+		assert.Greater(t, body.Data.Summary.Count, 0)
+	}
+}
+
+func TestITGraphQLMonthlyTotals(t *testing.T) {
+	// Setup server
+	teardown := setup()
+	defer teardown(t)
+
+	summary := func() (count int, monthly map[string]int) {
+		var body struct {
+			Data struct {
+				Summary struct {
+					Count   int
+					Monthly []struct {
+						Month string
+						Count int
+					}
+				}
+			}
+		}
+		err := request(http.MethodPost, uri("graphql"), strings.NewReader(`{"query": "{ summary { count monthly { month count } } }"}`)).Decode(&body)
+		if err != nil {
+			t.Fatal("can't query summary:", err)
+		}
+		monthly = map[string]int{}
+		for _, m := range body.Data.Summary.Monthly {
+			monthly[m.Month] = m.Count
+		}
+		return body.Data.Summary.Count, monthly
+	}
+
+	// Arrange
+	exp := seedExpense(t)
+	count, monthly := summary()
+
+	// Act
+	body := strings.NewReader(`{"title": "test-title", "amount": 100, "note": "updated", "tags": ["test-tag1"]}`)
+	res := request(http.MethodPut, uri("expenses", strconv.Itoa(exp.ID)), body)
+	res.Body.Close()
+	updatedCount, updatedMonthly := summary()
+
+	// Assertions
+	assert.EqualValues(t, http.StatusOK, res.StatusCode)
+	assert.Greater(t, monthly[time.Now().UTC().Format("2006-01")], 0)
+	// an update is a new version, the expense stays in the month of its first
+	assert.Equal(t, count, updatedCount)
+	assert.Equal(t, monthly, updatedMonthly)
+}
+
 func TestITAuthTokenRequired(t *testing.T) {
 	// Setup server
 	teardown := setup()
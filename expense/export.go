@@ -0,0 +1,82 @@
+package expense
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bazsup/assessment/locale"
+	"github.com/bazsup/assessment/problem"
+	"github.com/labstack/echo/v4"
+)
+
+// exportContentType is the media type of expense exports
+const exportContentType = "text/csv; charset=UTF-8"
+
+// exportHeader names the export columns in every language
+var exportHeader = map[locale.Tag][]string{
+	locale.English: {"version", "valid from", "valid to", "title", "amount", "note", "tags"},
+	locale.Thai:    {"เวอร์ชัน", "มีผลตั้งแต่", "มีผลถึง", "ชื่อรายการ", "จำนวนเงิน", "หมายเหตุ", "แท็ก"},
+}
+
+// ExportExpense answers the versions of the expense as CSV for
+// spreadsheets, amounts and UTC dates worded in the language of the
+// request. The buddhist_era query param counts years from the Buddhist Era.
+func (h *handler) ExportExpense(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return problem.New(problem.ExpenseNotFound, "")
+	}
+	buddhistEra := false
+	if v := c.QueryParam("buddhist_era"); v != "" {
+		if buddhistEra, err = strconv.ParseBool(v); err != nil {
+			return badQuery(queryParamError("buddhist_era"))
+		}
+	}
+
+	versions, err := h.store.ExpenseVersions(c.Request().Context(), id)
+	if err != nil {
+		return problem.From(err)
+	}
+	if len(versions) == 0 {
+		return problem.New(problem.ExpenseNotFound, "")
+	}
+
+	tag := problem.Language(c.Request())
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(exportHeader[tag])
+	for _, v := range versions {
+		validTo := ""
+		if v.ValidTo != nil {
+			validTo = locale.FormatDate(tag, v.ValidTo.UTC(), buddhistEra)
+		}
+		w.Write([]string{
+			strconv.Itoa(v.Version),
+			locale.FormatDate(tag, v.ValidFrom.UTC(), buddhistEra),
+			validTo,
+			cell(v.Title),
+			locale.FormatAmount(tag, v.Amount),
+			cell(v.Note),
+			cell(strings.Join(v.Tags, " ")),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return problem.From(err)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="expense-%d.csv"`, id))
+	return c.Blob(http.StatusOK, exportContentType, buf.Bytes())
+}
+
+// cell quotes text a spreadsheet would otherwise run as a formula
+func cell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
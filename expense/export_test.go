@@ -0,0 +1,85 @@
+//go:build unit
+// +build unit
+
+package expense_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bazsup/assessment/expense"
+	"github.com/bazsup/assessment/locale"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportExpense(t *testing.T) {
+	validFrom := time.Date(2026, 10, 1, 9, 30, 0, 0, time.UTC)
+	validTo := time.Date(2026, 10, 19, 13, 0, 0, 0, time.UTC)
+	versions := []expense.Version{
+		{Version: 1, Expense: expense.Expense{ID: 1, Title: "strawberry smoothie", Amount: 1234.5, Tags: []string{"food", "beverage"}}, ValidFrom: validFrom, ValidTo: &validTo},
+		{Version: 2, Expense: expense.Expense{ID: 1, Title: "=SUM(A1)", Amount: 79, Note: "night market"}, ValidFrom: validTo},
+	}
+
+	t.Run("Export words amounts and dates in English", func(t *testing.T) {
+		e, store := setupApp(t)
+
+		// Arrange
+		store.ExpenseVersionsWillReturn(versions, nil)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/expenses/1/export", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "text/csv; charset=UTF-8", rec.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, `attachment; filename="expense-1.csv"`, rec.Header().Get(echo.HeaderContentDisposition))
+		assert.Equal(t, "version,valid from,valid to,title,amount,note,tags\n"+
+			"1,1 Oct 2026,19 Oct 2026,strawberry smoothie,\"THB 1,234.50\",,food beverage\n"+
+			"2,19 Oct 2026,,'=SUM(A1),THB 79.00,night market,\n", rec.Body.String())
+	})
+
+	t.Run("Export words Buddhist Era dates in Thai for Thai readers", func(t *testing.T) {
+		e, store := setupApp(t)
+		req := httptest.NewRequest(http.MethodGet, "/expenses/1/export?buddhist_era=true", nil)
+		req.Header.Set(echo.HeaderAuthorization, testAuthToken)
+		req.Header.Set(locale.HeaderAcceptLanguage, "th")
+		rec := httptest.NewRecorder()
+
+		// Arrange
+		store.ExpenseVersionsWillReturn(versions[:1], nil)
+
+		// Act
+		e.ServeHTTP(rec, req)
+
+		// Assertions
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "เวอร์ชัน,มีผลตั้งแต่,มีผลถึง,ชื่อรายการ,จำนวนเงิน,หมายเหตุ,แท็ก\n"+
+			"1,1 ต.ค. 2569,19 ต.ค. 2569,strawberry smoothie,\"1,234.50 บาท\",,food beverage\n", rec.Body.String())
+	})
+
+	t.Run("Export of an unknown expense should returns status not found", func(t *testing.T) {
+		e, store := setupApp(t)
+
+		// Arrange
+		store.ExpenseVersionsWillReturn(nil, nil)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/expenses/9/export", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("Malformed buddhist_era should returns status bad request", func(t *testing.T) {
+		e, _ := setupApp(t)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/expenses/1/export?buddhist_era=maybe", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
@@ -5,32 +5,58 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/bazsup/assessment/problem"
 	"github.com/bazsup/assessment/router"
 )
 
 func GetOneByIDHandler(c router.RouterCtx, store storer) error {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		return c.JSON(http.StatusNotFound, Err{Message: "expense not found"})
+		return problem.New(problem.ExpenseNotFound, "")
 	}
 
-	exp, err := store.GetExpenseByID(id)
+	at, asOf, err := parseAsOf(c)
+	if err != nil {
+		return badQuery(err)
+	}
+
+	var exp *Expense
+	if asOf {
+		exp, err = store.GetExpenseAsOf(c.Request().Context(), id, at)
+	} else {
+		exp, err = store.GetExpenseByID(c.Request().Context(), id)
+	}
 
 	switch err {
 	case sql.ErrNoRows:
-		return c.JSON(http.StatusNotFound, Err{Message: "expense not found"})
+		return problem.New(problem.ExpenseNotFound, "")
 	case nil:
 		return c.JSON(http.StatusOK, exp)
 	default:
-		return c.JSON(http.StatusInternalServerError, Err{Message: "can't scan expense:" + err.Error()})
+		return problem.From(err)
 	}
 }
 
 func GetAllExpensesHandler(c router.RouterCtx, storer storer) error {
-	expenses, err := storer.GetAllExpenses()
+	return getAllExpenses(c, storer, nil)
+}
+
+// getAllExpenses answers the expenses keep allows, all of them when keep is nil
+func getAllExpenses(c router.RouterCtx, storer storer, keep func(*Expense) bool) error {
+	at, asOf, err := parseAsOf(c)
+	if err != nil {
+		return badQuery(err)
+	}
+
+	var expenses []*Expense
+	if asOf {
+		expenses, err = storer.GetAllExpensesAsOf(c.Request().Context(), at)
+	} else {
+		expenses, err = storer.GetAllExpenses(c.Request().Context())
+	}
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+		return problem.From(err)
 	}
 
-	return c.JSON(http.StatusOK, expenses)
+	return c.JSON(http.StatusOK, readable(expenses, keep))
 }
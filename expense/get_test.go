@@ -4,12 +4,16 @@
 package expense_test
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"testing"
 
 	"github.com/bazsup/assessment/expense"
+	"github.com/bazsup/assessment/problem"
+	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -58,14 +62,8 @@ func TestGetExpenseByID(t *testing.T) {
 		// Act
 		err := expense.GetOneByIDHandler(ctx, store)
 
-		var errRes expense.Err
-		ctx.DecodeResponse(&errRes)
-
 		// Assertions
-		if assert.NoError(t, err) {
-			assert.Equal(t, http.StatusInternalServerError, ctx.status)
-			assert.NotEmpty(t, errRes.Message)
-		}
+		assertProblem(t, err, problem.Internal)
 	})
 
 	t.Run("Get Expense Error Not found should returns status not found", func(t *testing.T) {
@@ -79,14 +77,8 @@ func TestGetExpenseByID(t *testing.T) {
 		// Act
 		err := expense.GetOneByIDHandler(ctx, store)
 
-		var errRes expense.Err
-		ctx.DecodeResponse(&errRes)
-
 		// Assertions
-		if assert.NoError(t, err) {
-			assert.Equal(t, http.StatusNotFound, ctx.status)
-			assert.Equal(t, "expense not found", errRes.Message)
-		}
+		assertProblem(t, err, problem.ExpenseNotFound)
 	})
 
 	t.Run("Get Expense Invalid ID Param should returns status not found", func(t *testing.T) {
@@ -98,13 +90,39 @@ func TestGetExpenseByID(t *testing.T) {
 		// Act
 		err := expense.GetOneByIDHandler(ctx, store)
 
-		var errRes expense.Err
-		ctx.DecodeResponse(&errRes)
+		// Assertions
+		assertProblem(t, err, problem.ExpenseNotFound)
+	})
+
+	t.Run("Query timeout should returns status service unavailable", func(t *testing.T) {
+		ctx, store := setupExpense(t)
+
+		// Arrange
+		ctx.SetParam("1")
+
+		store.GetExpenseByIDWillReturn(nil, context.DeadlineExceeded)
+
+		// Act
+		err := expense.GetOneByIDHandler(ctx, store)
 
 		// Assertions
-		if assert.NoError(t, err) {
-			assert.Equal(t, http.StatusNotFound, ctx.status)
-			assert.Equal(t, "expense not found", errRes.Message)
+		assertProblem(t, err, problem.DatabaseTimeout)
+	})
+
+	t.Run("Client disconnect should returns status client closed request", func(t *testing.T) {
+		ctx, store := setupExpense(t)
+
+		// Arrange
+		ctx.SetParam("1")
+
+		store.GetExpenseByIDWillReturn(nil, fmt.Errorf("scan: %w", context.Canceled))
+
+		// Act
+		err := expense.GetOneByIDHandler(ctx, store)
+
+		// Assertions
+		if assertProblem(t, err, problem.RequestCanceled) {
+			assert.Equal(t, problem.StatusClientClosedRequest, problem.StatusOf(err))
 		}
 	})
 }
@@ -147,14 +165,49 @@ func TestGetAllExpenses(t *testing.T) {
 		// Act
 		err := expense.GetAllExpensesHandler(ctx, store)
 
-		var errRes expense.Err
-		ctx.DecodeResponse(&errRes)
+		// Assertions
+		assertProblem(t, err, problem.Internal)
+	})
+}
+
+func TestGetExpenseProblem(t *testing.T) {
+	t.Run("Store error should returns problem without the error text", func(t *testing.T) {
+		e, store := setupApp(t)
+
+		// Arrange
+		store.GetExpenseByIDWillReturn(nil, fmt.Errorf("can't scan expense: pq: column \"note\" does not exist"))
+
+		// Act
+		rec := serve(e, http.MethodGet, "/expenses/1", testAuthToken)
+
+		var body problem.Problem
+		json.Unmarshal(rec.Body.Bytes(), &body)
 
 		// Assertions
-		if assert.NoError(t, err) {
-			assert.Equal(t, http.StatusInternalServerError, ctx.status)
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Equal(t, problem.ContentType, rec.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, problem.Internal, body.Code)
+		assert.Equal(t, problem.Internal.Type(), body.Type)
+		assert.NotContains(t, rec.Body.String(), "scan")
+	})
 
-			assert.NotEmpty(t, errRes.Message)
-		}
+	t.Run("Missing expense should returns expense not found problem", func(t *testing.T) {
+		e, store := setupApp(t)
+
+		// Arrange
+		store.GetExpenseByIDWillReturn(nil, sql.ErrNoRows)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/expenses/1", testAuthToken)
+
+		var body problem.Problem
+		json.Unmarshal(rec.Body.Bytes(), &body)
+
+		// Assertions
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Equal(t, problem.ExpenseNotFound, body.Code)
+		assert.Equal(t, "Expense not found", body.Title)
+		assert.Equal(t, http.StatusNotFound, body.Status)
+		assert.Equal(t, "/expenses/1", body.Instance)
 	})
 }
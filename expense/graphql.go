@@ -0,0 +1,229 @@
+package expense
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bazsup/assessment/problem"
+	gql "github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/labstack/echo/v4"
+)
+
+// graphStore reads what the GraphQL schema serves beyond the storer
+type graphStore interface {
+	QueryExpenses(ctx context.Context, f ExpenseFilter, after, limit int) ([]*Expense, error)
+	TagSummaries(ctx context.Context) ([]TagSummary, error)
+	MonthlyTotals(ctx context.Context) ([]MonthlyTotal, error)
+	VersionsOf(ctx context.Context, ids []int) (map[int][]Version, error)
+}
+
+// GraphQLLimits bound the cost of a GraphQL query before it is executed
+type GraphQLLimits struct {
+	// MaxDepth is the deepest nesting of fields
+	MaxDepth int
+	// MaxComplexity is the most fields a query may resolve, a list field
+	// counts its selection once per item it may return
+	MaxComplexity int
+}
+
+// DefaultGraphQLLimits fit the deepest query the schema needs with room to spare
+var DefaultGraphQLLimits = GraphQLLimits{MaxDepth: 8, MaxComplexity: 2000}
+
+// graphQLRequest is the body of a GraphQL request over HTTP
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// graphQLError answers a query rejected before execution
+func graphQLError(c echo.Context, status int, errs ...error) error {
+	return c.JSON(status, gql.Result{Errors: gqlerrors.FormatErrors(errs...)})
+}
+
+// GraphQL executes a query over expenses, tags and summaries, sent as JSON
+// with POST or as query params with GET. Mutations need a POST.
+func (h *handler) GraphQL(c echo.Context) error {
+	reads, ok := baseStore(h.store).(graphStore)
+	if !ok {
+		return problem.Localized(problem.NotConfigured, problem.DetailGraphQLOff)
+	}
+
+	var req graphQLRequest
+	if c.Request().Method == http.MethodGet {
+		req.Query = c.QueryParam("query")
+		req.OperationName = c.QueryParam("operationName")
+		if v := c.QueryParam("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				return graphQLError(c, http.StatusBadRequest, queryParamError("variables"))
+			}
+		}
+	} else if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return graphQLError(c, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		return graphQLError(c, http.StatusBadRequest, fmt.Errorf("query is required"))
+	}
+
+	doc, err := parser.Parse(parser.ParseParams{Source: req.Query})
+	if err != nil {
+		return graphQLError(c, http.StatusBadRequest, err)
+	}
+	if v := gql.ValidateDocument(&graphSchema, doc, nil); !v.IsValid {
+		return c.JSON(http.StatusBadRequest, gql.Result{Errors: v.Errors})
+	}
+	op := operation(doc, req.OperationName)
+	if op == nil {
+		return graphQLError(c, http.StatusBadRequest, fmt.Errorf("unknown operation %q", req.OperationName))
+	}
+	if op.Operation == ast.OperationTypeMutation && c.Request().Method == http.MethodGet {
+		c.Response().Header().Set(echo.HeaderAllow, http.MethodPost)
+		return graphQLError(c, http.StatusMethodNotAllowed, fmt.Errorf("mutations must be sent with POST"))
+	}
+	if err := h.graphLimits.check(doc, op, req.Variables); err != nil {
+		return graphQLError(c, http.StatusBadRequest, err)
+	}
+
+	ctx := c.Request().Context()
+	ctx = context.WithValue(ctx, graphRequestKey{}, &graphRequest{
+		store:    h.store,
+		reads:    reads,
+		policy:   h.policy,
+		identity: IdentityFrom(c),
+		versions: newVersionLoader(ctx, reads.VersionsOf),
+	})
+	result := gql.Execute(gql.ExecuteParams{
+		Schema:        graphSchema,
+		AST:           doc,
+		OperationName: req.OperationName,
+		Args:          req.Variables,
+		Context:       ctx,
+	})
+	return c.JSON(http.StatusOK, result)
+}
+
+// operation finds the operation a request executes, the only one of the
+// document when name is empty
+func operation(doc *ast.Document, name string) *ast.OperationDefinition {
+	var found *ast.OperationDefinition
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if name == "" {
+			if found != nil {
+				return nil
+			}
+			found = op
+		} else if op.Name != nil && op.Name.Value == name {
+			return op
+		}
+	}
+	return found
+}
+
+// limitWalk measures a query, fragments are expanded where they are spread
+// as validation already rejected cycles
+type limitWalk struct {
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+}
+
+func (l GraphQLLimits) check(doc *ast.Document, op *ast.OperationDefinition, variables map[string]interface{}) error {
+	w := limitWalk{fragments: map[string]*ast.FragmentDefinition{}, variables: variables}
+	for _, def := range doc.Definitions {
+		if f, ok := def.(*ast.FragmentDefinition); ok {
+			w.fragments[f.Name.Value] = f
+		}
+	}
+
+	depth, complexity := w.measure(op.SelectionSet)
+	if l.MaxDepth > 0 && depth > l.MaxDepth {
+		return fmt.Errorf("query depth %d exceeds the limit of %d", depth, l.MaxDepth)
+	}
+	if l.MaxComplexity > 0 && complexity > l.MaxComplexity {
+		return fmt.Errorf("query complexity %d exceeds the limit of %d", complexity, l.MaxComplexity)
+	}
+	return nil
+}
+
+// measure returns the depth and complexity of a selection set, a field
+// costs one plus its selection times the items it may return
+func (w limitWalk) measure(set *ast.SelectionSet) (depth, complexity int) {
+	if set == nil {
+		return 0, 0
+	}
+	for _, s := range set.Selections {
+		var d, c int
+		switch s := s.(type) {
+		case *ast.Field:
+			// introspection is bounded by the schema
+			if strings.HasPrefix(s.Name.Value, "__") {
+				continue
+			}
+			d, c = w.measure(s.SelectionSet)
+			d, c = d+1, 1+c*w.multiplier(s)
+		case *ast.InlineFragment:
+			d, c = w.measure(s.SelectionSet)
+		case *ast.FragmentSpread:
+			if f, ok := w.fragments[s.Name.Value]; ok {
+				d, c = w.measure(f.SelectionSet)
+			}
+		}
+		depth = max(depth, d)
+		complexity += c
+	}
+	return depth, complexity
+}
+
+// listSizes estimates the items of list fields that are not paged, as
+// nothing bounds them they count for a typical large result
+var listSizes = map[string]int{
+	// versions of one expense
+	"versions": 20,
+	// tags in use
+	"tags": 50,
+	// months with expenses, two years of them
+	"monthly": 24,
+}
+
+// multiplier is the most items a field may return
+func (w limitWalk) multiplier(f *ast.Field) int {
+	if f.Name.Value != "expenses" {
+		if n, ok := listSizes[f.Name.Value]; ok {
+			return n
+		}
+		return 1
+	}
+	for _, arg := range f.Arguments {
+		if arg.Name.Value != "first" {
+			continue
+		}
+		switch v := arg.Value.(type) {
+		case *ast.IntValue:
+			if n, err := strconv.Atoi(v.Value); err == nil && n > 0 {
+				return min(n, maxPageSize)
+			}
+		case *ast.Variable:
+			switch n := w.variables[v.Name.Value].(type) {
+			case float64:
+				if n > 0 {
+					return min(int(n), maxPageSize)
+				}
+			case int:
+				if n > 0 {
+					return min(n, maxPageSize)
+				}
+			}
+		}
+	}
+	return defaultPageSize
+}
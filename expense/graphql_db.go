@@ -0,0 +1,196 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/bazsup/assessment/retry"
+	"github.com/lib/pq"
+)
+
+const (
+	filteredExpensesQuery = `
+	SELECT id, title, amount, note, tags FROM expenses
+	WHERE id > $1 AND ( $2::text[] IS NULL OR tags @> $2 )
+	AND ( $3::float8 IS NULL OR amount >= $3 ) AND ( $4::float8 IS NULL OR amount <= $4 )
+	AND strpos(lower(title), lower($5)) > 0
+	ORDER BY id LIMIT $6
+	`
+	tagSummariesQuery = "SELECT tag, count(*), sum(amount) FROM expenses, unnest(tags) AS tag GROUP BY tag ORDER BY tag"
+	// an expense was created when its first version became valid. Expenses
+	// predating versioning got their first version at their last audited
+	// change, or when versioning was migrated, so they count in that month
+	monthlyTotalsQuery = `
+	SELECT to_char(v.valid_from AT TIME ZONE 'UTC', 'YYYY-MM'), count(*), sum(e.amount)
+	FROM expenses e JOIN expense_versions v ON v.expense_id = e.id AND v.version = 1
+	GROUP BY 1 ORDER BY 1
+	`
+	versionsOfExpensesQuery = "SELECT version, " + versionColumns + ", valid_from, valid_to FROM expense_versions WHERE expense_id = ANY($1) ORDER BY expense_id, version"
+)
+
+// ExpenseFilter narrows a query of expenses, zero values do not filter
+type ExpenseFilter struct {
+	// Tags keeps the expenses having every one of them
+	Tags          []string
+	MinAmount     *float64
+	MaxAmount     *float64
+	TitleContains string
+}
+
+// TagSummary counts and totals the expenses having a tag
+type TagSummary struct {
+	Tag   string  `json:"tag"`
+	Count int     `json:"count"`
+	Total float64 `json:"total"`
+}
+
+// MonthlyTotal counts and totals the expenses created in a month, that is
+// the month their first version became valid. Updates keep an expense in its
+// month, expenses predating versioning are in the month of their last change
+// before it.
+type MonthlyTotal struct {
+	// Month is formatted as "2006-01" in UTC
+	Month string  `json:"month"`
+	Count int     `json:"count"`
+	Total float64 `json:"total"`
+}
+
+// QueryExpenses returns up to limit expenses matching f with an id above
+// after, ordered by id
+func (e *ExpenseStore) QueryExpenses(ctx context.Context, f ExpenseFilter, after, limit int) (_ []*Expense, err error) {
+	ctx, span := e.span(ctx, "QueryExpenses", filteredExpensesQuery)
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, e.readTimeout)
+	defer cancel()
+
+	stmt, err := e.stmt(ctx, filteredExpensesQuery)
+	if err != nil {
+		return nil, fmt.Errorf("can't prepare query filtered expenses statement: %s", err.Error())
+	}
+
+	var tags interface{}
+	if len(f.Tags) > 0 {
+		tags = pq.Array(f.Tags)
+	}
+
+	var expenses []*Expense
+	err = retry.Do(ctx, e.readPolicy(ctx), IsTransient, func(int) error {
+		expenses, err = queryExpenses(ctx, stmt, after, tags, f.MinAmount, f.MaxAmount, f.TitleContains, limit)
+		return err
+	})
+	if err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	return expenses, nil
+}
+
+func (e *ExpenseStore) TagSummaries(ctx context.Context) (_ []TagSummary, err error) {
+	ctx, span := e.span(ctx, "TagSummaries", tagSummariesQuery)
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, e.readTimeout)
+	defer cancel()
+
+	stmt, err := e.stmt(ctx, tagSummariesQuery)
+	if err != nil {
+		return nil, fmt.Errorf("can't prepare query tag summaries statement: %s", err.Error())
+	}
+
+	var tags []TagSummary
+	err = retry.Do(ctx, e.readPolicy(ctx), IsTransient, func(int) error {
+		tags = []TagSummary{}
+		return queryRows(ctx, stmt, func(rows *sql.Rows) error {
+			var t TagSummary
+			if err := rows.Scan(&t.Tag, &t.Count, &t.Total); err != nil {
+				return err
+			}
+			tags = append(tags, t)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	return tags, nil
+}
+
+func (e *ExpenseStore) MonthlyTotals(ctx context.Context) (_ []MonthlyTotal, err error) {
+	ctx, span := e.span(ctx, "MonthlyTotals", monthlyTotalsQuery)
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, e.readTimeout)
+	defer cancel()
+
+	stmt, err := e.stmt(ctx, monthlyTotalsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("can't prepare query monthly totals statement: %s", err.Error())
+	}
+
+	var months []MonthlyTotal
+	err = retry.Do(ctx, e.readPolicy(ctx), IsTransient, func(int) error {
+		months = []MonthlyTotal{}
+		return queryRows(ctx, stmt, func(rows *sql.Rows) error {
+			var m MonthlyTotal
+			if err := rows.Scan(&m.Month, &m.Count, &m.Total); err != nil {
+				return err
+			}
+			months = append(months, m)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	return months, nil
+}
+
+// VersionsOf reads the versions of many expenses in one query, keyed by
+// expense id, expenses without versions are missing from the map
+func (e *ExpenseStore) VersionsOf(ctx context.Context, ids []int) (_ map[int][]Version, err error) {
+	ctx, span := e.span(ctx, "VersionsOf", versionsOfExpensesQuery)
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, e.readTimeout)
+	defer cancel()
+
+	stmt, err := e.stmt(ctx, versionsOfExpensesQuery)
+	if err != nil {
+		return nil, fmt.Errorf("can't prepare query versions of expenses statement: %s", err.Error())
+	}
+
+	var versions map[int][]Version
+	err = retry.Do(ctx, e.readPolicy(ctx), IsTransient, func(int) error {
+		versions = map[int][]Version{}
+		return queryRows(ctx, stmt, func(rows *sql.Rows) error {
+			var v Version
+			err := rows.Scan(&v.Version, &v.ID, &v.Title, &v.Amount, &v.Note, pq.Array(&v.Tags), &v.ValidFrom, &v.ValidTo)
+			if err != nil {
+				return err
+			}
+			versions[v.ID] = append(versions[v.ID], v)
+			return nil
+		}, pq.Array(ids))
+	})
+	if err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	return versions, nil
+}
+
+// queryRows calls scan for every row stmt returns
+func queryRows(ctx context.Context, stmt *sql.Stmt, scan func(*sql.Rows) error, args ...interface{}) error {
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
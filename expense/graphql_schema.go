@@ -0,0 +1,470 @@
+package expense
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/bazsup/assessment/auth"
+	"github.com/bazsup/assessment/authz"
+	"github.com/bazsup/assessment/problem"
+	gql "github.com/graphql-go/graphql"
+)
+
+// Page sizes of the expenses query
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+type graphRequestKey struct{}
+
+// graphRequest is what resolvers of one GraphQL request share
+type graphRequest struct {
+	store    storer
+	reads    graphStore
+	policy   *authz.Policy
+	identity *auth.Identity
+	versions *versionLoader
+}
+
+func requestOf(p gql.ResolveParams) *graphRequest {
+	return p.Context.Value(graphRequestKey{}).(*graphRequest)
+}
+
+// authorize evaluates the policy as the REST authorize middleware does
+func (r *graphRequest) authorize(action string, stored, proposed *Expense) error {
+	if r.policy == nil {
+		return nil
+	}
+	if d := accessDecision(r.policy, r.identity, action, stored, proposed); !d.Allowed {
+		return graphError(forbidden(d))
+	}
+	return nil
+}
+
+// graphProblem is the error of a resolver, coded as the problem documents
+// of the REST API with the code and field errors in its extensions
+type graphProblem struct {
+	err *problem.Error
+}
+
+func (e graphProblem) Error() string {
+	switch {
+	case len(e.err.Errors) > 0:
+		return validationFailed + ": " + fieldErrors(e.err.Errors)
+	case e.err.Detail != "":
+		return e.err.Code.Title() + ": " + e.err.Detail
+	default:
+		return e.err.Code.Title()
+	}
+}
+
+func (e graphProblem) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{"code": e.err.Code}
+	if len(e.err.Errors) > 0 {
+		ext["errors"] = e.err.Errors
+	}
+	return ext
+}
+
+// graphError words an error for the errors of a GraphQL response, a
+// missing row is a missing expense and internal errors are only logged
+func graphError(err error) error {
+	var pe *problem.Error
+	switch {
+	case errors.As(err, &pe):
+	case errors.Is(err, sql.ErrNoRows):
+		pe = problem.New(problem.ExpenseNotFound, "")
+	default:
+		pe = problem.From(err)
+	}
+	if pe.Code == problem.Internal {
+		slog.Error("graphql resolver failed", "error", err.Error())
+	}
+	return graphProblem{pe}
+}
+
+// cursor is the opaque position of an expense in the expenses query
+func cursor(id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("expense:" + strconv.Itoa(id)))
+}
+
+func parseCursor(c string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(c)
+	if err == nil {
+		if id, ok := strings.CutPrefix(string(raw), "expense:"); ok {
+			return strconv.Atoi(id)
+		}
+	}
+	return 0, errors.New("invalid cursor")
+}
+
+// expensePage is the source of an ExpenseConnection
+type expensePage struct {
+	nodes     []*Expense
+	hasNext   bool
+	endCursor string
+}
+
+func expenseField(t gql.Output, get func(*Expense) interface{}) *gql.Field {
+	return &gql.Field{Type: t, Resolve: func(p gql.ResolveParams) (interface{}, error) {
+		return get(p.Source.(*Expense)), nil
+	}}
+}
+
+func versionField(t gql.Output, get func(Version) interface{}) *gql.Field {
+	return &gql.Field{Type: t, Resolve: func(p gql.ResolveParams) (interface{}, error) {
+		return get(p.Source.(Version)), nil
+	}}
+}
+
+var stringList = gql.NewNonNull(gql.NewList(gql.NewNonNull(gql.String)))
+
+var versionType = gql.NewObject(gql.ObjectConfig{
+	Name: "Version",
+	Fields: gql.Fields{
+		"version":   versionField(gql.NewNonNull(gql.Int), func(v Version) interface{} { return v.Version }),
+		"title":     versionField(gql.NewNonNull(gql.String), func(v Version) interface{} { return v.Title }),
+		"amount":    versionField(gql.NewNonNull(gql.Float), func(v Version) interface{} { return v.Amount }),
+		"note":      versionField(gql.NewNonNull(gql.String), func(v Version) interface{} { return v.Note }),
+		"tags":      versionField(stringList, func(v Version) interface{} { return v.Tags }),
+		"validFrom": versionField(gql.NewNonNull(gql.DateTime), func(v Version) interface{} { return v.ValidFrom }),
+		"validTo": versionField(gql.DateTime, func(v Version) interface{} {
+			if v.ValidTo == nil {
+				return nil
+			}
+			return *v.ValidTo
+		}),
+	},
+})
+
+// versionsThunk resolves through the request's loader so the versions of
+// every expense of a page are read in one query
+func versionsThunk(p gql.ResolveParams, then func([]Version) interface{}) (interface{}, error) {
+	load := requestOf(p).versions.Load(p.Source.(*Expense).ID)
+	return func() (interface{}, error) {
+		versions, err := load()
+		if err != nil {
+			return nil, graphError(err)
+		}
+		return then(versions), nil
+	}, nil
+}
+
+var expenseType = gql.NewObject(gql.ObjectConfig{
+	Name: "Expense",
+	Fields: gql.Fields{
+		"id":     expenseField(gql.NewNonNull(gql.Int), func(e *Expense) interface{} { return e.ID }),
+		"title":  expenseField(gql.NewNonNull(gql.String), func(e *Expense) interface{} { return e.Title }),
+		"amount": expenseField(gql.NewNonNull(gql.Float), func(e *Expense) interface{} { return e.Amount }),
+		"note":   expenseField(gql.NewNonNull(gql.String), func(e *Expense) interface{} { return e.Note }),
+		"tags": expenseField(stringList, func(e *Expense) interface{} {
+			if e.Tags == nil {
+				return []string{}
+			}
+			return e.Tags
+		}),
+		"createdAt": &gql.Field{
+			Type:        gql.DateTime,
+			Description: "When the first version of the expense became valid",
+			Resolve: func(p gql.ResolveParams) (interface{}, error) {
+				return versionsThunk(p, func(versions []Version) interface{} {
+					if len(versions) == 0 {
+						return nil
+					}
+					return versions[0].ValidFrom
+				})
+			},
+		},
+		"versions": &gql.Field{
+			Type: gql.NewNonNull(gql.NewList(gql.NewNonNull(versionType))),
+			Resolve: func(p gql.ResolveParams) (interface{}, error) {
+				return versionsThunk(p, func(versions []Version) interface{} { return versions })
+			},
+		},
+	},
+})
+
+var pageInfoType = gql.NewObject(gql.ObjectConfig{
+	Name: "PageInfo",
+	Fields: gql.Fields{
+		"hasNextPage": &gql.Field{Type: gql.NewNonNull(gql.Boolean), Resolve: func(p gql.ResolveParams) (interface{}, error) {
+			return p.Source.(*expensePage).hasNext, nil
+		}},
+		"endCursor": &gql.Field{Type: gql.String, Resolve: func(p gql.ResolveParams) (interface{}, error) {
+			if c := p.Source.(*expensePage).endCursor; c != "" {
+				return c, nil
+			}
+			return nil, nil
+		}},
+	},
+})
+
+var expenseConnectionType = gql.NewObject(gql.ObjectConfig{
+	Name: "ExpenseConnection",
+	Fields: gql.Fields{
+		"nodes": &gql.Field{Type: gql.NewNonNull(gql.NewList(gql.NewNonNull(expenseType))), Resolve: func(p gql.ResolveParams) (interface{}, error) {
+			return p.Source.(*expensePage).nodes, nil
+		}},
+		"pageInfo": &gql.Field{Type: gql.NewNonNull(pageInfoType), Resolve: func(p gql.ResolveParams) (interface{}, error) {
+			return p.Source, nil
+		}},
+	},
+})
+
+var tagSummaryType = gql.NewObject(gql.ObjectConfig{
+	Name: "TagSummary",
+	Fields: gql.Fields{
+		"tag":   &gql.Field{Type: gql.NewNonNull(gql.String)},
+		"count": &gql.Field{Type: gql.NewNonNull(gql.Int)},
+		"total": &gql.Field{Type: gql.NewNonNull(gql.Float)},
+	},
+})
+
+var monthlyTotalType = gql.NewObject(gql.ObjectConfig{
+	Name: "MonthlyTotal",
+	Fields: gql.Fields{
+		"month": &gql.Field{Type: gql.NewNonNull(gql.String), Description: "The month in UTC, as 2006-01"},
+		"count": &gql.Field{Type: gql.NewNonNull(gql.Int)},
+		"total": &gql.Field{Type: gql.NewNonNull(gql.Float)},
+	},
+})
+
+// summary is the source of a Summary
+type summary struct {
+	Count   int            `json:"count"`
+	Total   float64        `json:"total"`
+	Monthly []MonthlyTotal `json:"monthly"`
+}
+
+var summaryType = gql.NewObject(gql.ObjectConfig{
+	Name: "Summary",
+	Fields: gql.Fields{
+		"count": &gql.Field{Type: gql.NewNonNull(gql.Int)},
+		"total": &gql.Field{Type: gql.NewNonNull(gql.Float)},
+		"monthly": &gql.Field{
+			Type:        gql.NewNonNull(gql.NewList(gql.NewNonNull(monthlyTotalType))),
+			Description: "Expenses by the month their first version became valid, the month of their last change before versioning for older ones",
+		},
+	},
+})
+
+var expenseFilterType = gql.NewInputObject(gql.InputObjectConfig{
+	Name: "ExpenseFilter",
+	Fields: gql.InputObjectConfigFieldMap{
+		"tags":          &gql.InputObjectFieldConfig{Type: gql.NewList(gql.NewNonNull(gql.String)), Description: "Expenses having every tag"},
+		"minAmount":     &gql.InputObjectFieldConfig{Type: gql.Float},
+		"maxAmount":     &gql.InputObjectFieldConfig{Type: gql.Float},
+		"titleContains": &gql.InputObjectFieldConfig{Type: gql.String, Description: "Case insensitive"},
+	},
+})
+
+var expenseInputType = gql.NewInputObject(gql.InputObjectConfig{
+	Name: "ExpenseInput",
+	Fields: gql.InputObjectConfigFieldMap{
+		"title":  &gql.InputObjectFieldConfig{Type: gql.NewNonNull(gql.String)},
+		"amount": &gql.InputObjectFieldConfig{Type: gql.NewNonNull(gql.Float)},
+		"note":   &gql.InputObjectFieldConfig{Type: gql.String, DefaultValue: ""},
+		"tags":   &gql.InputObjectFieldConfig{Type: gql.NewList(gql.NewNonNull(gql.String)), DefaultValue: []interface{}{}},
+	},
+})
+
+func stringsOf(v interface{}) []string {
+	list, _ := v.([]interface{})
+	out := make([]string, 0, len(list))
+	for _, s := range list {
+		out = append(out, s.(string))
+	}
+	return out
+}
+
+func filterOf(args map[string]interface{}) ExpenseFilter {
+	var f ExpenseFilter
+	in, _ := args["filter"].(map[string]interface{})
+	if tags, ok := in["tags"]; ok {
+		f.Tags = stringsOf(tags)
+	}
+	if v, ok := in["minAmount"].(float64); ok {
+		f.MinAmount = &v
+	}
+	if v, ok := in["maxAmount"].(float64); ok {
+		f.MaxAmount = &v
+	}
+	f.TitleContains, _ = in["titleContains"].(string)
+	return f
+}
+
+func expenseOf(in map[string]interface{}) Expense {
+	exp := Expense{Tags: stringsOf(in["tags"])}
+	exp.Title, _ = in["title"].(string)
+	exp.Amount, _ = in["amount"].(float64)
+	exp.Note, _ = in["note"].(string)
+	return exp
+}
+
+var queryType = gql.NewObject(gql.ObjectConfig{
+	Name: "Query",
+	Fields: gql.Fields{
+		"expense": &gql.Field{
+			Type: expenseType,
+			Args: gql.FieldConfigArgument{"id": &gql.ArgumentConfig{Type: gql.NewNonNull(gql.Int)}},
+			Resolve: func(p gql.ResolveParams) (interface{}, error) {
+				r := requestOf(p)
+				exp, err := r.store.GetExpenseByID(p.Context, p.Args["id"].(int))
+				if errors.Is(err, sql.ErrNoRows) {
+					return nil, nil
+				}
+				if err != nil {
+					return nil, graphError(err)
+				}
+				if err := r.authorize(ActionRead, exp, nil); err != nil {
+					return nil, err
+				}
+				return exp, nil
+			},
+		},
+		"expenses": &gql.Field{
+			Type: gql.NewNonNull(expenseConnectionType),
+			Args: gql.FieldConfigArgument{
+				"filter": &gql.ArgumentConfig{Type: expenseFilterType},
+				"first":  &gql.ArgumentConfig{Type: gql.Int, DefaultValue: defaultPageSize},
+				"after":  &gql.ArgumentConfig{Type: gql.String},
+			},
+			Resolve: func(p gql.ResolveParams) (interface{}, error) {
+				r := requestOf(p)
+				keep, err := readFilter(r.policy, r.identity)
+				if err != nil {
+					return nil, graphError(err)
+				}
+
+				first := p.Args["first"].(int)
+				if first < 1 || first > maxPageSize {
+					return nil, errors.New("first must be between 1 and " + strconv.Itoa(maxPageSize))
+				}
+				after := 0
+				if c, ok := p.Args["after"].(string); ok {
+					var err error
+					if after, err = parseCursor(c); err != nil {
+						return nil, err
+					}
+				}
+
+				// one more than asked tells whether there is a next page
+				expenses, err := r.reads.QueryExpenses(p.Context, filterOf(p.Args), after, first+1)
+				if err != nil {
+					return nil, graphError(err)
+				}
+				page := &expensePage{nodes: expenses}
+				if len(expenses) > first {
+					page.nodes, page.hasNext = expenses[:first], true
+				}
+				if len(page.nodes) > 0 {
+					page.endCursor = cursor(page.nodes[len(page.nodes)-1].ID)
+				}
+				// the cursor stays past unreadable expenses, a page may be short
+				page.nodes = readable(page.nodes, keep)
+				return page, nil
+			},
+		},
+		"tags": &gql.Field{
+			Type:        gql.NewNonNull(gql.NewList(gql.NewNonNull(tagSummaryType))),
+			Description: "Every tag in use, ordered by tag",
+			Resolve: func(p gql.ResolveParams) (interface{}, error) {
+				r := requestOf(p)
+				if err := r.authorize(ActionRead, nil, nil); err != nil {
+					return nil, err
+				}
+				tags, err := r.reads.TagSummaries(p.Context)
+				if err != nil {
+					return nil, graphError(err)
+				}
+				return tags, nil
+			},
+		},
+		"summary": &gql.Field{
+			Type: gql.NewNonNull(summaryType),
+			Resolve: func(p gql.ResolveParams) (interface{}, error) {
+				r := requestOf(p)
+				if err := r.authorize(ActionRead, nil, nil); err != nil {
+					return nil, err
+				}
+				months, err := r.reads.MonthlyTotals(p.Context)
+				if err != nil {
+					return nil, graphError(err)
+				}
+				s := summary{Monthly: months}
+				for _, m := range months {
+					s.Count += m.Count
+					s.Total += m.Total
+				}
+				return s, nil
+			},
+		},
+	},
+})
+
+var mutationType = gql.NewObject(gql.ObjectConfig{
+	Name: "Mutation",
+	Fields: gql.Fields{
+		"createExpense": &gql.Field{
+			Type: gql.NewNonNull(expenseType),
+			Args: gql.FieldConfigArgument{"input": &gql.ArgumentConfig{Type: gql.NewNonNull(expenseInputType)}},
+			Resolve: func(p gql.ResolveParams) (interface{}, error) {
+				r := requestOf(p)
+				exp := expenseOf(p.Args["input"].(map[string]interface{}))
+				if errs := validateExpense(exp); len(errs) > 0 {
+					return nil, graphError(problem.Invalid(errs))
+				}
+				if err := r.authorize(ActionCreate, nil, &exp); err != nil {
+					return nil, err
+				}
+				id, err := r.store.CreateExpense(p.Context, exp)
+				if err != nil {
+					return nil, graphError(err)
+				}
+				exp.ID = id
+				return &exp, nil
+			},
+		},
+		"updateExpense": &gql.Field{
+			Type:        gql.NewNonNull(expenseType),
+			Description: "Replaces the expense with the input",
+			Args: gql.FieldConfigArgument{
+				"id":    &gql.ArgumentConfig{Type: gql.NewNonNull(gql.Int)},
+				"input": &gql.ArgumentConfig{Type: gql.NewNonNull(expenseInputType)},
+			},
+			Resolve: func(p gql.ResolveParams) (interface{}, error) {
+				r := requestOf(p)
+				exp := expenseOf(p.Args["input"].(map[string]interface{}))
+				exp.ID = p.Args["id"].(int)
+				if errs := validateExpense(exp); len(errs) > 0 {
+					return nil, graphError(problem.Invalid(errs))
+				}
+
+				stored, err := r.store.GetExpenseByID(p.Context, exp.ID)
+				if err != nil {
+					return nil, graphError(err)
+				}
+				if err := r.authorize(ActionUpdate, stored, &exp); err != nil {
+					return nil, err
+				}
+				if err := r.store.UpdateExpense(p.Context, exp); err != nil {
+					return nil, graphError(err)
+				}
+				return &exp, nil
+			},
+		},
+	},
+})
+
+var graphSchema = func() gql.Schema {
+	s, err := gql.NewSchema(gql.SchemaConfig{Query: queryType, Mutation: mutationType})
+	if err != nil {
+		panic(err)
+	}
+	return s
+}()
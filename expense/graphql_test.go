@@ -0,0 +1,355 @@
+//go:build unit
+// +build unit
+
+package expense_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bazsup/assessment/authz"
+	"github.com/bazsup/assessment/expense"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	filteredRows  = []string{"id", "title", "amount", "note", "tags"}
+	versionOfRows = []string{"version", "expense_id", "title", "amount", "note", "tags", "valid_from", "valid_to"}
+)
+
+func setupGraphQL(t *testing.T, opts ...expense.Option) (*echo.Echo, sqlmock.Sqlmock) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := newEcho()
+	expense.NewApp(e, expense.NewExpenseStore(db), testAuthToken, opts...)
+	return e, mock
+}
+
+func postGraphQL(e *echo.Echo, query string, variables map[string]interface{}) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(string(body)))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, testAuthToken)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+type graphQLResponse struct {
+	Data   map[string]json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func decodeGraphQL(t *testing.T, rec *httptest.ResponseRecorder) graphQLResponse {
+	var res graphQLResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("can't decode %q: %v", rec.Body.String(), err)
+	}
+	return res
+}
+
+func TestGraphQL(t *testing.T) {
+	created := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+
+	t.Run("Versions of a page of expenses are read in one query", func(t *testing.T) {
+		e, mock := setupGraphQL(t)
+
+		// Arrange
+		mock.ExpectPrepare("SELECT id, title, amount, note, tags FROM expenses WHERE id > ").
+			ExpectQuery().WithArgs(0, pq.Array([]string{"food"}), nil, nil, "", 3).
+			WillReturnRows(sqlmock.NewRows(filteredRows).
+				AddRow(1, "coffee", 60, "", "{food}").
+				AddRow(2, "lunch", 120, "", "{food}").
+				AddRow(3, "dinner", 300, "", "{food}"))
+		mock.ExpectPrepare("FROM expense_versions WHERE expense_id = ANY").
+			ExpectQuery().WithArgs(pq.Array([]int{1, 2})).
+			WillReturnRows(sqlmock.NewRows(versionOfRows).
+				AddRow(1, 1, "coffee", 60, "", "{food}", created, nil).
+				AddRow(1, 2, "lunch", 100, "", "{food}", created, created.Add(time.Hour)).
+				AddRow(2, 2, "lunch", 120, "", "{food}", created.Add(time.Hour), nil))
+
+		// Act
+		rec := postGraphQL(e, `query($tags: [String!]) {
+			expenses(filter: {tags: $tags}, first: 2) {
+				nodes { id title createdAt versions { version amount } }
+				pageInfo { hasNextPage endCursor }
+			}
+		}`, map[string]interface{}{"tags": []string{"food"}})
+
+		res := decodeGraphQL(t, rec)
+
+		// Assertions
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, res.Errors)
+		assert.JSONEq(t, `{
+			"nodes": [
+				{"id": 1, "title": "coffee", "createdAt": "2026-10-01T09:00:00Z", "versions": [{"version": 1, "amount": 60}]},
+				{"id": 2, "title": "lunch", "createdAt": "2026-10-01T09:00:00Z", "versions": [{"version": 1, "amount": 100}, {"version": 2, "amount": 120}]}
+			],
+			"pageInfo": {"hasNextPage": true, "endCursor": "ZXhwZW5zZToy"}
+		}`, string(res.Data["expenses"]))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Tags and summary are served in one request", func(t *testing.T) {
+		e, mock := setupGraphQL(t)
+
+		// Arrange
+		// root fields of a query resolve in no particular order
+		mock.MatchExpectationsInOrder(false)
+		mock.ExpectPrepare("SELECT tag, count").ExpectQuery().
+			WillReturnRows(sqlmock.NewRows([]string{"tag", "count", "sum"}).AddRow("food", 2, 180).AddRow("travel", 1, 900))
+		mock.ExpectPrepare("SELECT to_char").ExpectQuery().
+			WillReturnRows(sqlmock.NewRows([]string{"month", "count", "sum"}).AddRow("2026-09", 1, 900).AddRow("2026-10", 2, 180))
+
+		// Act
+		rec := postGraphQL(e, `{ tags { tag count total } summary { count total monthly { month total } } }`, nil)
+
+		res := decodeGraphQL(t, rec)
+
+		// Assertions
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, res.Errors)
+		assert.JSONEq(t, `[{"tag": "food", "count": 2, "total": 180}, {"tag": "travel", "count": 1, "total": 900}]`, string(res.Data["tags"]))
+		assert.JSONEq(t, `{"count": 3, "total": 1080, "monthly": [{"month": "2026-09", "total": 900}, {"month": "2026-10", "total": 180}]}`, string(res.Data["summary"]))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Expense never stored resolves to null", func(t *testing.T) {
+		e, mock := setupGraphQL(t)
+
+		// Arrange
+		mock.ExpectPrepare("SELECT id, title, amount, note, tags FROM expenses WHERE id = ").
+			ExpectQuery().WithArgs(7).WillReturnRows(sqlmock.NewRows(filteredRows))
+
+		// Act
+		rec := postGraphQL(e, `{ expense(id: 7) { title } }`, nil)
+
+		res := decodeGraphQL(t, rec)
+
+		// Assertions
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, res.Errors)
+		assert.Equal(t, "null", string(res.Data["expense"]))
+	})
+
+	t.Run("Store without GraphQL support should returns status not found", func(t *testing.T) {
+		e, _ := setupApp(t)
+
+		// Act
+		rec := postGraphQL(e, `{ tags { tag } }`, nil)
+
+		// Assertions
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("Invalid query should returns status bad request", func(t *testing.T) {
+		e, _ := setupGraphQL(t)
+
+		for _, query := range []string{`{ expenses {`, `{ expenses { unknown } }`, ``} {
+			// Act
+			rec := postGraphQL(e, query, nil)
+
+			res := decodeGraphQL(t, rec)
+
+			// Assertions
+			assert.Equal(t, http.StatusBadRequest, rec.Code, query)
+			assert.NotEmpty(t, res.Errors, query)
+		}
+	})
+
+	t.Run("Mutation sent with GET should returns status method not allowed", func(t *testing.T) {
+		e, _ := setupGraphQL(t)
+
+		// Act
+		query := url.QueryEscape(`mutation { createExpense(input: {title: "coffee", amount: 60}) { id } }`)
+		rec := serve(e, http.MethodGet, "/graphql?query="+query, testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+		assert.Equal(t, http.MethodPost, rec.Header().Get(echo.HeaderAllow))
+	})
+
+	t.Run("Query sent with GET is executed", func(t *testing.T) {
+		e, mock := setupGraphQL(t)
+
+		// Arrange
+		mock.ExpectPrepare("SELECT tag, count").ExpectQuery().
+			WillReturnRows(sqlmock.NewRows([]string{"tag", "count", "sum"}).AddRow("food", 2, 180))
+
+		// Act
+		rec := serve(e, http.MethodGet, "/graphql?query="+url.QueryEscape(`{ tags { tag } }`), testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"data": {"tags": [{"tag": "food"}]}}`, rec.Body.String())
+	})
+
+	t.Run("Unauthenticated request should returns status unauthorized", func(t *testing.T) {
+		e, _ := setupGraphQL(t)
+
+		// Act
+		rec := serve(e, http.MethodPost, "/graphql", "")
+
+		// Assertions
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
+
+func TestGraphQLLimits(t *testing.T) {
+	t.Run("Query deeper than the limit should returns status bad request", func(t *testing.T) {
+		e, _ := setupGraphQL(t, expense.WithGraphQLLimits(expense.GraphQLLimits{MaxDepth: 3}))
+
+		// Act
+		rec := postGraphQL(e, `{ expenses { nodes { versions { version } } } }`, nil)
+
+		res := decodeGraphQL(t, rec)
+
+		// Assertions
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		if assert.Len(t, res.Errors, 1) {
+			assert.Equal(t, "query depth 4 exceeds the limit of 3", res.Errors[0].Message)
+		}
+	})
+
+	t.Run("Fragments count toward the depth", func(t *testing.T) {
+		e, _ := setupGraphQL(t, expense.WithGraphQLLimits(expense.GraphQLLimits{MaxDepth: 3}))
+
+		// Act
+		rec := postGraphQL(e, `{ expenses { ...page } } fragment page on ExpenseConnection { nodes { ... on Expense { versions { version } } } }`, nil)
+
+		// Assertions
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Query more complex than the limit should returns status bad request", func(t *testing.T) {
+		e, _ := setupGraphQL(t, expense.WithGraphQLLimits(expense.GraphQLLimits{MaxComplexity: 100}))
+
+		// Act
+		rec := postGraphQL(e, `query($first: Int) { expenses(first: $first) { nodes { id title } } }`,
+			map[string]interface{}{"first": 50})
+
+		res := decodeGraphQL(t, rec)
+
+		// Assertions
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		if assert.Len(t, res.Errors, 1) {
+			assert.Equal(t, "query complexity 151 exceeds the limit of 100", res.Errors[0].Message)
+		}
+	})
+
+	t.Run("Every list field counts for its items", func(t *testing.T) {
+		e, _ := setupGraphQL(t, expense.WithGraphQLLimits(expense.GraphQLLimits{MaxComplexity: 40}))
+
+		for query, message := range map[string]string{
+			`{ expense(id: 1) { versions { version title } } }`: "query complexity 42 exceeds the limit of 40",
+			`{ tags { tag count total } }`:                      "query complexity 151 exceeds the limit of 40",
+			`{ summary { monthly { month count total } } }`:     "query complexity 74 exceeds the limit of 40",
+		} {
+			// Act
+			rec := postGraphQL(e, query, nil)
+
+			res := decodeGraphQL(t, rec)
+
+			// Assertions
+			assert.Equal(t, http.StatusBadRequest, rec.Code, query)
+			if assert.Len(t, res.Errors, 1, query) {
+				assert.Equal(t, message, res.Errors[0].Message)
+			}
+		}
+	})
+
+	t.Run("Introspection is not limited", func(t *testing.T) {
+		e, _ := setupGraphQL(t, expense.WithGraphQLLimits(expense.GraphQLLimits{MaxDepth: 1, MaxComplexity: 1}))
+
+		// Act
+		rec := postGraphQL(e, `{ __schema { types { name fields { name type { name } } } } }`, nil)
+
+		// Assertions
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+}
+
+func TestGraphQLAuthorize(t *testing.T) {
+	t.Run("Update of a field the policy protects is refused", func(t *testing.T) {
+		policy, err := authz.ParsePolicy([]byte(`{"roles": {"editor": {"permissions": [{"action": "expenses:read"}, {"action": "expenses:update", "fields": ["note"]}]}},
+			"assignments": {"static-token": ["editor"]}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, mock := setupGraphQL(t, expense.WithPolicy(policy))
+
+		// Arrange
+		mock.ExpectPrepare("SELECT id, title, amount, note, tags FROM expenses WHERE id = ").
+			ExpectQuery().WithArgs(1).WillReturnRows(sqlmock.NewRows(filteredRows).AddRow(1, "coffee", 60, "", "{food}"))
+
+		// Act
+		rec := postGraphQL(e, `mutation { updateExpense(id: 1, input: {title: "coffee", amount: 600, tags: ["food"]}) { id } }`, nil)
+
+		res := decodeGraphQL(t, rec)
+
+		// Assertions
+		assert.Equal(t, http.StatusOK, rec.Code)
+		if assert.Len(t, res.Errors, 1) {
+			assert.Contains(t, res.Errors[0].Message, "Forbidden")
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDBQueryExpenses(t *testing.T) {
+	t.Run("Filter is passed as query arguments", func(t *testing.T) {
+		expStore, mock := setupDB(t)
+
+		// Arrange
+		minAmount := 100.0
+		mock.ExpectPrepare("SELECT id, title, amount, note, tags FROM expenses WHERE id > ").
+			ExpectQuery().WithArgs(5, nil, minAmount, nil, "Lunch", 11).
+			WillReturnRows(sqlmock.NewRows(filteredRows).AddRow(6, "lunch", 120, "", "{}"))
+
+		// Act
+		exps, err := expStore.QueryExpenses(context.Background(), expense.ExpenseFilter{MinAmount: &minAmount, TitleContains: "Lunch"}, 5, 11)
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.Len(t, exps, 1)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Versions of expenses are grouped by expense", func(t *testing.T) {
+		expStore, mock := setupDB(t)
+
+		// Arrange
+		at := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+		mock.ExpectPrepare("FROM expense_versions WHERE expense_id = ANY").
+			ExpectQuery().WithArgs(pq.Array([]int{1, 2})).
+			WillReturnRows(sqlmock.NewRows(versionOfRows).
+				AddRow(1, 1, "a", 10, "", "{}", at, at).
+				AddRow(2, 1, "b", 10, "", "{}", at, nil).
+				AddRow(1, 2, "c", 20, "", "{}", at, nil))
+
+		// Act
+		versions, err := expStore.VersionsOf(context.Background(), []int{1, 2})
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.Len(t, versions[1], 2)
+		assert.Len(t, versions[2], 1)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
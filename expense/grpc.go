@@ -0,0 +1,477 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bazsup/assessment/auth"
+	"github.com/bazsup/assessment/authz"
+	"github.com/bazsup/assessment/expensepb"
+	"github.com/bazsup/assessment/health"
+	"github.com/bazsup/assessment/metrics"
+	"github.com/bazsup/assessment/openapi"
+	"github.com/bazsup/assessment/problem"
+	"github.com/bazsup/assessment/ratelimit"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// publicMethods are the gRPC services served without credentials, like
+// the REST health routes
+var publicMethods = []string{"/grpc.health.v1.Health/", "/grpc.reflection."}
+
+// grpcWrites are the methods counted as writes by the rate limits and the
+// daily write quota, like the REST methods changing expenses
+var grpcWrites = map[string]bool{
+	expensepb.ExpenseService_CreateExpense_FullMethodName: true,
+	expensepb.ExpenseService_UpdateExpense_FullMethodName: true,
+	expensepb.ExpenseService_DeleteExpense_FullMethodName: true,
+}
+
+// NewGRPCServer serves the ExpenseService on top of s, calls are
+// authenticated, limited, measured and traced like NewApp routes. Of the
+// options WithJWTVerifier, WithPolicy, WithRateLimit, WithMetrics,
+// WithTracer and WithHealth apply, the latter driving the gRPC health
+// service.
+func NewGRPCServer(s storer, authToken string, opts ...Option) *grpc.Server {
+	var o appOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var serverOpts []grpc.ServerOption
+	var unary []grpc.UnaryServerInterceptor
+	var stream []grpc.StreamServerInterceptor
+	if o.tracer != nil {
+		serverOpts = append(serverOpts, grpc.StatsHandler(otelgrpc.NewServerHandler(
+			otelgrpc.WithTracerProvider(o.tracer),
+			otelgrpc.WithPropagators(propagator),
+		)))
+	}
+	if o.metrics != nil {
+		s = instrumentStore(s, o.metrics, o.metricTags)
+		m := metrics.NewGRPC(o.metrics)
+		unary = append(unary, m.UnaryInterceptor)
+		stream = append(stream, m.StreamInterceptor)
+	}
+	cm := NewCustomMiddleware(authToken, o.verifier)
+	unary = append(unary, cm.unaryInterceptor)
+	stream = append(stream, cm.streamInterceptor)
+	if o.limiter != nil {
+		// ahead of the rejection so floods of bad credentials are limited by IP
+		l := grpcLimiter{o.limiter}
+		unary = append(unary, l.unaryInterceptor)
+		stream = append(stream, l.streamInterceptor)
+	}
+	unary = append(unary, requireIdentityUnary)
+	stream = append(stream, requireIdentityStream)
+
+	srv := grpc.NewServer(append(serverOpts,
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+	)...)
+	expensepb.RegisterExpenseServiceServer(srv, &grpcServer{store: s, policy: o.policy})
+
+	checker := o.health
+	if checker == nil {
+		checker = health.NewChecker(0)
+	}
+	healthpb.RegisterHealthServer(srv, &grpcHealth{checker: checker, interval: grpcHealthInterval})
+	return srv
+}
+
+type identityCtxKey struct{}
+
+// authErrorCtxKey holds the status answering a call that failed authentication
+type authErrorCtxKey struct{}
+
+// grpcIdentity returns the caller authenticated by the interceptors, or nil
+func grpcIdentity(ctx context.Context) *auth.Identity {
+	id, _ := ctx.Value(identityCtxKey{}).(*auth.Identity)
+	return id
+}
+
+// authContext authenticates the "authorization" metadata of a call,
+// tagging ctx with the caller and the actor the store records changes under
+func (cm *CustomMiddleware) authContext(ctx context.Context, method string) (context.Context, error) {
+	for _, prefix := range publicMethods {
+		if strings.HasPrefix(method, prefix) {
+			return ctx, nil
+		}
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if v := md.Get(key); len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	id, err := cm.authenticate(first("authorization"))
+	switch err {
+	case nil:
+	case errNoCredentials:
+		return nil, status.Error(codes.Unauthenticated, "Unauthorized")
+	default:
+		return nil, status.Error(codes.Unauthenticated, "Unauthorized: credentials are invalid")
+	}
+
+	actor := Actor{Subject: id.Subject, RequestID: first("x-request-id"), SourceIP: peerIP(ctx)}
+	return WithActor(context.WithValue(ctx, identityCtxKey{}, id), actor), nil
+}
+
+// peerIP returns the address of the caller without its port
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	addr := p.Addr.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// identifyCall authenticates a call without rejecting it, a failure is kept
+// in ctx for requireIdentity once the limiter has counted the call
+func (cm *CustomMiddleware) identifyCall(ctx context.Context, method string) context.Context {
+	authCtx, err := cm.authContext(ctx, method)
+	if err != nil {
+		return context.WithValue(ctx, authErrorCtxKey{}, err)
+	}
+	return authCtx
+}
+
+func (cm *CustomMiddleware) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(cm.identifyCall(ctx, info.FullMethod), req)
+}
+
+func (cm *CustomMiddleware) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, contextStream{ss, cm.identifyCall(ss.Context(), info.FullMethod)})
+}
+
+// requireIdentityUnary rejects calls that failed authentication
+func requireIdentityUnary(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err, ok := ctx.Value(authErrorCtxKey{}).(error); ok {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// requireIdentityStream rejects streams that failed authentication
+func requireIdentityStream(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err, ok := ss.Context().Value(authErrorCtxKey{}).(error); ok {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// grpcLimiter enforces the REST rate limits and daily write quota on calls,
+// keyed like clientKey so both transports share the caller's buckets
+type grpcLimiter struct {
+	*ratelimit.Limiter
+}
+
+func (l grpcLimiter) allow(ctx context.Context, method string) error {
+	class := ratelimit.ClassRead
+	if grpcWrites[method] {
+		class = ratelimit.ClassWrite
+	}
+	key := "ip:" + peerIP(ctx)
+	if id := grpcIdentity(ctx); id != nil {
+		key = "id:" + id.Subject
+	}
+
+	res, err := l.Allow(ctx, class, key)
+	if err != nil {
+		return grpcError(err, &errdetails.RetryInfo{RetryDelay: durationpb.New(res.RetryAfter)})
+	}
+	return nil
+}
+
+func (l grpcLimiter) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := l.allow(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (l grpcLimiter) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := l.allow(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// contextStream replaces the context of a server stream
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// grpcHealthInterval is how often Watch streams run the health checks
+const grpcHealthInterval = 5 * time.Second
+
+// grpcHealth answers the gRPC health service from the readiness checks, so
+// the server and its ExpenseService are NOT_SERVING while a dependency is
+// down or the process drains
+type grpcHealth struct {
+	healthpb.UnimplementedHealthServer
+	checker  *health.Checker
+	interval time.Duration
+}
+
+func (h *grpcHealth) status(ctx context.Context, service string) healthpb.HealthCheckResponse_ServingStatus {
+	if service != "" && service != expensepb.ExpenseService_ServiceDesc.ServiceName {
+		return healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+	}
+	if h.checker.ShuttingDown() || h.checker.Run(ctx).Status != health.StatusUp {
+		return healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	return healthpb.HealthCheckResponse_SERVING
+}
+
+func (h *grpcHealth) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	st := h.status(ctx, req.Service)
+	if st == healthpb.HealthCheckResponse_SERVICE_UNKNOWN {
+		return nil, status.Error(codes.NotFound, "unknown service")
+	}
+	return &healthpb.HealthCheckResponse{Status: st}, nil
+}
+
+// Watch sends the status right away, then whenever a check run changes it
+func (h *grpcHealth) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	ctx := stream.Context()
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	last := healthpb.HealthCheckResponse_ServingStatus(-1)
+	for {
+		if st := h.status(ctx, req.Service); st != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: st}); err != nil {
+				return err
+			}
+			last = st
+		}
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+type grpcServer struct {
+	expensepb.UnimplementedExpenseServiceServer
+	store  storer
+	policy *authz.Policy
+}
+
+// grpcCodes are the status codes of the problem codes answered over gRPC
+var grpcCodes = map[problem.Code]codes.Code{
+	problem.ExpenseNotFound:    codes.NotFound,
+	problem.Forbidden:          codes.PermissionDenied,
+	problem.NotFound:           codes.NotFound,
+	problem.Conflict:           codes.Aborted,
+	problem.DatabaseTimeout:    codes.DeadlineExceeded,
+	problem.RequestCanceled:    codes.Canceled,
+	problem.ServiceUnavailable: codes.Unavailable,
+	problem.RateLimited:        codes.ResourceExhausted,
+	problem.QuotaExceeded:      codes.ResourceExhausted,
+}
+
+// grpcError maps a store error to a status with the problem code REST
+// answers in an ErrorInfo detail followed by details, internal errors are
+// only logged
+func grpcError(err error, details ...protoadapt.MessageV1) error {
+	pe := problem.From(err)
+	if errors.Is(err, sql.ErrNoRows) {
+		pe = problem.New(problem.ExpenseNotFound, "")
+	}
+	code, ok := grpcCodes[pe.Code]
+	if !ok {
+		code = codes.Internal
+		slog.Error("grpc request failed", "error", err.Error())
+	}
+
+	msg := pe.Code.Title()
+	if pe.Detail != "" {
+		msg += ": " + pe.Detail
+	}
+	details = append([]protoadapt.MessageV1{&errdetails.ErrorInfo{Reason: string(pe.Code), Domain: authRealm}}, details...)
+	st, detailErr := status.New(code, msg).WithDetails(details...)
+	if detailErr != nil {
+		return status.Error(code, msg)
+	}
+	return st.Err()
+}
+
+// invalidArgument answers an expense failing validation, the field errors
+// are attached as BadRequest details
+func invalidArgument(errs []openapi.FieldError) error {
+	br := &errdetails.BadRequest{}
+	for _, e := range errs {
+		br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{Field: e.Field, Description: e.Message})
+	}
+	st, err := status.New(codes.InvalidArgument, validationFailed+": "+fieldErrors(errs)).WithDetails(br)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, validationFailed+": "+fieldErrors(errs))
+	}
+	return st.Err()
+}
+
+// authorize evaluates the policy as the REST authorize middleware does
+func (s *grpcServer) authorize(ctx context.Context, action string, stored, proposed *Expense) error {
+	if s.policy == nil {
+		return nil
+	}
+	if d := accessDecision(s.policy, grpcIdentity(ctx), action, stored, proposed); !d.Allowed {
+		return grpcError(forbidden(d))
+	}
+	return nil
+}
+
+func toProto(exp *Expense) *expensepb.Expense {
+	return &expensepb.Expense{
+		Id:     int64(exp.ID),
+		Title:  exp.Title,
+		Amount: exp.Amount,
+		Note:   exp.Note,
+		Tags:   exp.Tags,
+	}
+}
+
+func (s *grpcServer) CreateExpense(ctx context.Context, req *expensepb.CreateExpenseRequest) (*expensepb.Expense, error) {
+	exp := Expense{Title: req.Title, Amount: req.Amount, Note: req.Note, Tags: req.Tags}
+	if errs := validateExpense(exp); len(errs) > 0 {
+		return nil, invalidArgument(errs)
+	}
+	if err := s.authorize(ctx, ActionCreate, nil, &exp); err != nil {
+		return nil, err
+	}
+
+	id, err := s.store.CreateExpense(ctx, exp)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	exp.ID = id
+	return toProto(&exp), nil
+}
+
+func (s *grpcServer) GetExpense(ctx context.Context, req *expensepb.GetExpenseRequest) (*expensepb.Expense, error) {
+	exp, err := s.store.GetExpenseByID(ctx, int(req.Id))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if err := s.authorize(ctx, ActionRead, exp, nil); err != nil {
+		return nil, err
+	}
+	return toProto(exp), nil
+}
+
+func (s *grpcServer) ListExpenses(req *expensepb.ListExpensesRequest, stream expensepb.ExpenseService_ListExpensesServer) error {
+	ctx := stream.Context()
+	keep, err := readFilter(s.policy, grpcIdentity(ctx))
+	if err != nil {
+		return grpcError(err)
+	}
+
+	expenses, err := s.store.GetAllExpenses(ctx)
+	if err != nil {
+		return grpcError(err)
+	}
+	for _, exp := range readable(expenses, keep) {
+		if err := stream.Send(toProto(exp)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *grpcServer) UpdateExpense(ctx context.Context, req *expensepb.UpdateExpenseRequest) (*expensepb.Expense, error) {
+	if req.Expense == nil {
+		return nil, status.Error(codes.InvalidArgument, "expense is required")
+	}
+	exp := Expense{ID: int(req.Expense.Id), Title: req.Expense.Title, Amount: req.Expense.Amount, Note: req.Expense.Note, Tags: req.Expense.Tags}
+	if errs := validateExpense(exp); len(errs) > 0 {
+		return nil, invalidArgument(errs)
+	}
+
+	stored, err := s.store.GetExpenseByID(ctx, exp.ID)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if err := s.authorize(ctx, ActionUpdate, stored, &exp); err != nil {
+		return nil, err
+	}
+
+	if err := s.store.UpdateExpense(ctx, exp); err != nil {
+		return nil, grpcError(err)
+	}
+	return toProto(&exp), nil
+}
+
+func (s *grpcServer) DeleteExpense(ctx context.Context, req *expensepb.DeleteExpenseRequest) (*expensepb.DeleteExpenseResponse, error) {
+	stored, err := s.store.GetExpenseByID(ctx, int(req.Id))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if err := s.authorize(ctx, ActionDelete, stored, nil); err != nil {
+		return nil, err
+	}
+
+	if err := s.store.DeleteExpense(ctx, stored.ID); err != nil {
+		return nil, grpcError(err)
+	}
+	return &expensepb.DeleteExpenseResponse{}, nil
+}
+
+func (s *grpcServer) SummarizeExpenses(ctx context.Context, req *expensepb.SummarizeExpensesRequest) (*expensepb.ExpenseSummary, error) {
+	if err := s.authorize(ctx, ActionRead, nil, nil); err != nil {
+		return nil, err
+	}
+
+	expenses, err := s.store.GetAllExpenses(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	summary := &expensepb.ExpenseSummary{}
+	tags := map[string]*expensepb.TagSummary{}
+	for _, exp := range expenses {
+		summary.Count++
+		summary.Total += exp.Amount
+		for _, tag := range exp.Tags {
+			t, ok := tags[tag]
+			if !ok {
+				t = &expensepb.TagSummary{Tag: tag}
+				tags[tag] = t
+				summary.Tags = append(summary.Tags, t)
+			}
+			t.Count++
+			t.Total += exp.Amount
+		}
+	}
+	sort.Slice(summary.Tags, func(i, j int) bool { return summary.Tags[i].Tag < summary.Tags[j].Tag })
+	return summary, nil
+}
@@ -0,0 +1,438 @@
+//go:build unit
+// +build unit
+
+package expense_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bazsup/assessment/authz"
+	"github.com/bazsup/assessment/expense"
+	"github.com/bazsup/assessment/expensepb"
+	"github.com/bazsup/assessment/health"
+	"github.com/bazsup/assessment/problem"
+	"github.com/bazsup/assessment/ratelimit"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func setupGRPC(t *testing.T, opts ...expense.Option) (expensepb.ExpenseServiceClient, *TestStore) {
+	conn, store := dialGRPC(t, opts...)
+	return expensepb.NewExpenseServiceClient(conn), store
+}
+
+func dialGRPC(t *testing.T, opts ...expense.Option) (*grpc.ClientConn, *TestStore) {
+	t.Parallel()
+
+	store := NewTestStore()
+	srv := expense.NewGRPCServer(store, testAuthToken, opts...)
+	lis := bufconn.Listen(1 << 20)
+	go srv.Serve(lis)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		srv.Stop()
+	})
+	return conn, store
+}
+
+func authorized() context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", testAuthToken)
+}
+
+func TestGRPCAuthentication(t *testing.T) {
+	t.Run("Call without credentials should returns unauthenticated", func(t *testing.T) {
+		client, _ := setupGRPC(t)
+
+		// Act
+		_, err := client.GetExpense(context.Background(), &expensepb.GetExpenseRequest{Id: 1})
+
+		// Assertions
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("Call with a wrong token should returns unauthenticated", func(t *testing.T) {
+		client, _ := setupGRPC(t)
+
+		// Act
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "wrong")
+		_, err := client.GetExpense(ctx, &expensepb.GetExpenseRequest{Id: 1})
+
+		// Assertions
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		assert.Equal(t, "Unauthorized: credentials are invalid", status.Convert(err).Message())
+	})
+
+	t.Run("Streaming call without credentials should returns unauthenticated", func(t *testing.T) {
+		client, _ := setupGRPC(t)
+
+		// Act
+		stream, err := client.ListExpenses(context.Background(), &expensepb.ListExpensesRequest{})
+		if err == nil {
+			_, err = stream.Recv()
+		}
+
+		// Assertions
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+}
+
+func TestGRPCExpenseService(t *testing.T) {
+	t.Run("Create returns the expense with its id", func(t *testing.T) {
+		client, store := setupGRPC(t)
+
+		// Arrange
+		store.CreateExpenseWillReturn(1, nil)
+
+		// Act
+		exp, err := client.CreateExpense(authorized(), &expensepb.CreateExpenseRequest{Title: "test-title", Amount: 39000, Tags: []string{"tag1"}})
+
+		// Assertions
+		if assert.NoError(t, err) {
+			assert.Equal(t, int64(1), exp.Id)
+			assert.Equal(t, "test-title", exp.Title)
+			assert.Equal(t, []string{"tag1"}, exp.Tags)
+		}
+	})
+
+	t.Run("Get unknown expense should returns not found", func(t *testing.T) {
+		client, store := setupGRPC(t)
+
+		// Arrange
+		store.GetExpenseByIDWillReturn(nil, sql.ErrNoRows)
+
+		// Act
+		_, err := client.GetExpense(authorized(), &expensepb.GetExpenseRequest{Id: 9})
+
+		// Assertions
+		st := status.Convert(err)
+		assert.Equal(t, codes.NotFound, st.Code())
+		if assert.Len(t, st.Details(), 1) {
+			assert.Equal(t, string(problem.ExpenseNotFound), st.Details()[0].(*errdetails.ErrorInfo).Reason)
+		}
+	})
+
+	t.Run("Store error should returns internal without the error text", func(t *testing.T) {
+		client, store := setupGRPC(t)
+
+		// Arrange
+		store.GetExpenseByIDWillReturn(nil, errors.New("can't scan expense: pq: column missing"))
+
+		// Act
+		_, err := client.GetExpense(authorized(), &expensepb.GetExpenseRequest{Id: 9})
+
+		// Assertions
+		assert.Equal(t, codes.Internal, status.Code(err))
+		assert.NotContains(t, status.Convert(err).Message(), "scan")
+	})
+
+	t.Run("List streams every expense", func(t *testing.T) {
+		client, store := setupGRPC(t)
+
+		// Arrange
+		store.GetAllExpensesWillReturn([]*expense.Expense{{ID: 1, Title: "a"}, {ID: 2, Title: "b"}}, nil)
+
+		// Act
+		stream, err := client.ListExpenses(authorized(), &expensepb.ListExpensesRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []int64
+		for {
+			exp, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, exp.Id)
+		}
+
+		// Assertions
+		assert.Equal(t, []int64{1, 2}, ids)
+	})
+
+	t.Run("Delete removes the stored expense", func(t *testing.T) {
+		client, store := setupGRPC(t)
+
+		// Arrange
+		store.GetExpenseByIDWillReturn(&expense.Expense{ID: 1}, nil)
+		store.DeleteExpenseWillReturn(nil)
+
+		// Act
+		_, err := client.DeleteExpense(authorized(), &expensepb.DeleteExpenseRequest{Id: 1})
+
+		// Assertions
+		assert.NoError(t, err)
+	})
+
+	t.Run("Summarize totals overall and per tag", func(t *testing.T) {
+		client, store := setupGRPC(t)
+
+		// Arrange
+		store.GetAllExpensesWillReturn([]*expense.Expense{
+			{ID: 1, Amount: 100, Tags: []string{"food", "beverage"}},
+			{ID: 2, Amount: 50, Tags: []string{"food"}},
+			{ID: 3, Amount: 10},
+		}, nil)
+
+		// Act
+		summary, err := client.SummarizeExpenses(authorized(), &expensepb.SummarizeExpensesRequest{})
+
+		// Assertions
+		if assert.NoError(t, err) {
+			assert.Equal(t, int64(3), summary.Count)
+			assert.Equal(t, 160.0, summary.Total)
+			if assert.Len(t, summary.Tags, 2) {
+				assert.Equal(t, "beverage", summary.Tags[0].Tag)
+				assert.Equal(t, 100.0, summary.Tags[0].Total)
+				assert.Equal(t, "food", summary.Tags[1].Tag)
+				assert.Equal(t, int64(2), summary.Tags[1].Count)
+				assert.Equal(t, 150.0, summary.Tags[1].Total)
+			}
+		}
+	})
+}
+
+func TestGRPCAuthorize(t *testing.T) {
+	t.Run("Update of a field the policy protects should returns permission denied", func(t *testing.T) {
+		policy, err := authz.ParsePolicy([]byte(`{"roles": {"editor": {"permissions": [{"action": "expenses:update", "fields": ["note"]}]}},
+			"assignments": {"static-token": ["editor"]}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, store := setupGRPC(t, expense.WithPolicy(policy))
+
+		// Arrange
+		store.GetExpenseByIDWillReturn(&expense.Expense{ID: 1, Title: "test-title", Amount: 100}, nil)
+
+		// Act
+		_, err = client.UpdateExpense(authorized(), &expensepb.UpdateExpenseRequest{
+			Expense: &expensepb.Expense{Id: 1, Title: "test-title", Amount: 1000},
+		})
+
+		// Assertions
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		assert.Equal(t, "Forbidden: amount may not be modified", status.Convert(err).Message())
+	})
+
+	t.Run("List streams only the expenses a conditional reader may read", func(t *testing.T) {
+		policy, err := authz.ParsePolicy([]byte(`{"roles": {"small": {"permissions": [
+			{"action": "expenses:read", "conditions": [{"attribute": "amount", "operator": "lt", "value": 1000}]}]}},
+			"assignments": {"static-token": ["small"]}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, store := setupGRPC(t, expense.WithPolicy(policy))
+
+		// Arrange
+		store.GetAllExpensesWillReturn([]*expense.Expense{{ID: 1, Amount: 10}, {ID: 2, Amount: 39000}}, nil)
+
+		// Act
+		stream, err := client.ListExpenses(authorized(), &expensepb.ListExpensesRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []int64
+		for {
+			exp, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, exp.Id)
+		}
+
+		// Assertions
+		assert.Equal(t, []int64{1}, ids)
+	})
+}
+
+func TestGRPCRateLimit(t *testing.T) {
+	limits := func(quota int) expense.Option {
+		return expense.WithRateLimit(ratelimit.Config{
+			Store: ratelimit.NewMemoryStore(),
+			Limits: map[ratelimit.Class]ratelimit.Limit{
+				ratelimit.ClassRead:  {Rate: 1, Burst: 1},
+				ratelimit.ClassWrite: {Rate: 100, Burst: 100},
+			},
+			DailyWriteQuota: quota,
+		})
+	}
+
+	t.Run("Reads past the burst should returns resource exhausted", func(t *testing.T) {
+		client, store := setupGRPC(t, limits(0))
+
+		// Arrange
+		store.GetExpenseByIDWillReturn(&expense.Expense{ID: 1}, nil)
+
+		// Act
+		_, first := client.GetExpense(authorized(), &expensepb.GetExpenseRequest{Id: 1})
+		_, err := client.GetExpense(authorized(), &expensepb.GetExpenseRequest{Id: 1})
+
+		// Assertions
+		assert.NoError(t, first)
+		st := status.Convert(err)
+		assert.Equal(t, codes.ResourceExhausted, st.Code())
+		if assert.Len(t, st.Details(), 2) {
+			assert.Equal(t, string(problem.RateLimited), st.Details()[0].(*errdetails.ErrorInfo).Reason)
+			assert.Positive(t, st.Details()[1].(*errdetails.RetryInfo).RetryDelay.AsDuration())
+		}
+	})
+
+	t.Run("Calls with wrong credentials are limited by peer address", func(t *testing.T) {
+		client, _ := setupGRPC(t, limits(0))
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "wrong")
+
+		// Act
+		_, first := client.GetExpense(ctx, &expensepb.GetExpenseRequest{Id: 1})
+		_, err := client.GetExpense(ctx, &expensepb.GetExpenseRequest{Id: 1})
+
+		// Assertions
+		assert.Equal(t, codes.Unauthenticated, status.Code(first))
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	})
+
+	t.Run("Writes past the daily quota should returns resource exhausted", func(t *testing.T) {
+		client, store := setupGRPC(t, limits(1))
+
+		// Arrange
+		store.CreateExpenseWillReturn(1, nil)
+		req := &expensepb.CreateExpenseRequest{Title: "test-title", Amount: 1}
+
+		// Act
+		_, first := client.CreateExpense(authorized(), req)
+		_, err := client.CreateExpense(authorized(), req)
+
+		// Assertions
+		assert.NoError(t, first)
+		st := status.Convert(err)
+		assert.Equal(t, codes.ResourceExhausted, st.Code())
+		if assert.NotEmpty(t, st.Details()) {
+			assert.Equal(t, string(problem.QuotaExceeded), st.Details()[0].(*errdetails.ErrorInfo).Reason)
+		}
+	})
+}
+
+func TestGRPCObservability(t *testing.T) {
+	t.Run("Calls and store queries are measured on the registry shared with the app", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		expense.NewApp(newEcho(), NewTestStore(), testAuthToken, expense.WithMetrics(reg))
+		client, store := setupGRPC(t, expense.WithMetrics(reg))
+
+		// Arrange
+		store.GetExpenseByIDWillReturn(nil, sql.ErrNoRows)
+
+		// Act
+		client.GetExpense(authorized(), &expensepb.GetExpenseRequest{Id: 9})
+
+		// Assertions
+		err := testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP grpc_server_handled_total gRPC calls by method and status code.
+# TYPE grpc_server_handled_total counter
+grpc_server_handled_total{code="NotFound",method="/expense.v1.ExpenseService/GetExpense"} 1
+`), "grpc_server_handled_total")
+		assert.NoError(t, err)
+		queries, err := testutil.GatherAndCount(reg, "expense_store_query_duration_seconds")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, queries)
+	})
+
+	t.Run("Calls continue the trace of the caller", func(t *testing.T) {
+		tp, rec := setupTracer()
+		client, store := setupGRPC(t, expense.WithTracer(tp))
+
+		// Arrange
+		store.GetExpenseByIDWillReturn(&expense.Expense{ID: 1}, nil)
+		ctx := metadata.AppendToOutgoingContext(authorized(), "traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+		// Act
+		_, err := client.GetExpense(ctx, &expensepb.GetExpenseRequest{Id: 1})
+
+		// Assertions
+		assert.NoError(t, err)
+		if spans := rec.Ended(); assert.Len(t, spans, 1) {
+			s := spans[0]
+			assert.Equal(t, "expense.v1.ExpenseService/GetExpense", s.Name())
+			assert.Equal(t, trace.SpanKindServer, s.SpanKind())
+			assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", s.SpanContext().TraceID().String())
+			assert.Equal(t, "00f067aa0ba902b7", s.Parent().SpanID().String())
+		}
+	})
+}
+
+func TestGRPCHealth(t *testing.T) {
+	check := func(t *testing.T, checker *health.Checker, service string) (healthpb.HealthCheckResponse_ServingStatus, error) {
+		conn, _ := dialGRPC(t, expense.WithHealth(checker))
+		res, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		return res.GetStatus(), err
+	}
+
+	t.Run("Serving while every check passes", func(t *testing.T) {
+		checker := health.NewChecker(time.Second).Add("database", func(context.Context) error { return nil })
+
+		// Act
+		st, err := check(t, checker, "expense.v1.ExpenseService")
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, st)
+	})
+
+	t.Run("Not serving while a dependency is down", func(t *testing.T) {
+		checker := health.NewChecker(time.Second).Add("database", func(context.Context) error { return errors.New("connection refused") })
+
+		// Act
+		st, err := check(t, checker, "")
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, st)
+	})
+
+	t.Run("Not serving once shutting down", func(t *testing.T) {
+		checker := health.NewChecker(time.Second)
+		checker.Shutdown()
+
+		// Act
+		st, err := check(t, checker, "")
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, st)
+	})
+
+	t.Run("Unknown service should returns not found", func(t *testing.T) {
+		// Act
+		_, err := check(t, health.NewChecker(time.Second), "unknown.Service")
+
+		// Assertions
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+}
@@ -0,0 +1,45 @@
+package expense
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/bazsup/assessment/health"
+	"github.com/labstack/echo/v4"
+)
+
+// Liveness answers as long as the process serves requests
+func (h *handler) Liveness(c echo.Context) error {
+	return c.JSON(http.StatusOK, health.Report{Status: health.StatusUp, Checks: []health.Result{}})
+}
+
+// Readiness fails while shutting down or when a dependency check fails
+func (h *handler) Readiness(c echo.Context) error {
+	if h.health.ShuttingDown() {
+		return c.JSON(http.StatusServiceUnavailable, health.Report{Status: health.StatusDown, ShuttingDown: true, Checks: []health.Result{}})
+	}
+
+	report := h.health.Run(c.Request().Context())
+	return c.JSON(reportStatusCode(report), health.Report{Status: report.Status, Checks: []health.Result{}})
+}
+
+// Health reports every dependency check with its latency. The endpoint is
+// public, so why a check failed is logged rather than answered
+func (h *handler) Health(c echo.Context) error {
+	ctx := c.Request().Context()
+	report := h.health.Run(ctx)
+	for i, r := range report.Checks {
+		if r.Error != "" {
+			slog.WarnContext(ctx, "health check failed", "check", r.Name, "error", r.Error)
+			report.Checks[i].Error = ""
+		}
+	}
+	return c.JSON(reportStatusCode(report), report)
+}
+
+func reportStatusCode(r health.Report) int {
+	if r.Status != health.StatusUp {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusOK
+}
@@ -0,0 +1,88 @@
+//go:build unit
+// +build unit
+
+package expense_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bazsup/assessment/expense"
+	"github.com/bazsup/assessment/health"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupHealthApp(t *testing.T, checker *health.Checker) *echo.Echo {
+	t.Parallel()
+
+	e := newEcho()
+	expense.NewApp(e, NewTestStore(), testAuthToken, expense.WithHealth(checker))
+	return e
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	dbDown := func(context.Context) error { return errors.New("connection refused") }
+
+	t.Run("Probes are served without credentials", func(t *testing.T) {
+		e := setupHealthApp(t, health.NewChecker(time.Second))
+
+		for _, path := range []string{"/healthz", "/readyz", "/health"} {
+			// Act
+			rec := serve(e, http.MethodGet, path, "")
+
+			// Assertions
+			assert.Equal(t, http.StatusOK, rec.Code, path)
+		}
+	})
+
+	t.Run("Failing dependency fails readiness but not liveness", func(t *testing.T) {
+		e := setupHealthApp(t, health.NewChecker(time.Second).Add("database", dbDown))
+
+		// Act
+		live := serve(e, http.MethodGet, "/healthz", "")
+		ready := serve(e, http.MethodGet, "/readyz", "")
+
+		// Assertions
+		assert.Equal(t, http.StatusOK, live.Code)
+		assert.Equal(t, http.StatusServiceUnavailable, ready.Code)
+	})
+
+	t.Run("Detailed report lists every check without the failure detail", func(t *testing.T) {
+		e := setupHealthApp(t, health.NewChecker(time.Second).Add("database", dbDown))
+
+		// Act
+		rec := serve(e, http.MethodGet, "/health", "")
+
+		var report health.Report
+		json.Unmarshal(rec.Body.Bytes(), &report)
+
+		// Assertions
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.NotContains(t, rec.Body.String(), "connection refused")
+		if assert.Len(t, report.Checks, 1) {
+			assert.Equal(t, "database", report.Checks[0].Name)
+			assert.Equal(t, health.StatusDown, report.Checks[0].Status)
+			assert.Empty(t, report.Checks[0].Error)
+		}
+	})
+
+	t.Run("Readiness fails once shutdown begins", func(t *testing.T) {
+		checker := health.NewChecker(time.Second)
+		e := setupHealthApp(t, checker)
+
+		// Act
+		checker.Shutdown()
+		ready := serve(e, http.MethodGet, "/readyz", "")
+		live := serve(e, http.MethodGet, "/healthz", "")
+
+		// Assertions
+		assert.Equal(t, http.StatusServiceUnavailable, ready.Code)
+		assert.Contains(t, ready.Body.String(), `"shutting_down":true`)
+		assert.Equal(t, http.StatusOK, live.Code)
+	})
+}
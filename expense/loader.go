@@ -0,0 +1,67 @@
+package expense
+
+import (
+	"context"
+	"sync"
+)
+
+// versionLoader batches the version reads of one GraphQL request. Load
+// only queues an id, the first thunk called reads every queued id in one
+// query, so resolving n expenses costs one query rather than n.
+type versionLoader struct {
+	ctx  context.Context
+	load func(ctx context.Context, ids []int) (map[int][]Version, error)
+
+	mu      sync.Mutex
+	queued  []int
+	loaded  map[int][]Version
+	errs    map[int]error
+	pending map[int]bool
+}
+
+func newVersionLoader(ctx context.Context, load func(context.Context, []int) (map[int][]Version, error)) *versionLoader {
+	return &versionLoader{
+		ctx:     ctx,
+		load:    load,
+		loaded:  map[int][]Version{},
+		errs:    map[int]error{},
+		pending: map[int]bool{},
+	}
+}
+
+// Load returns a thunk of the versions of the expense
+func (l *versionLoader) Load(id int) func() ([]Version, error) {
+	l.mu.Lock()
+	if _, done := l.loaded[id]; !done && !l.pending[id] {
+		l.pending[id] = true
+		l.queued = append(l.queued, id)
+	}
+	l.mu.Unlock()
+
+	return func() ([]Version, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if len(l.queued) > 0 {
+			l.dispatch()
+		}
+		return l.loaded[id], l.errs[id]
+	}
+}
+
+// dispatch reads the queued ids, l.mu must be held
+func (l *versionLoader) dispatch() {
+	ids := l.queued
+	l.queued = nil
+	versions, err := l.load(l.ctx, ids)
+	for _, id := range ids {
+		delete(l.pending, id)
+		if err != nil {
+			l.errs[id] = err
+			continue
+		}
+		l.loaded[id] = versions[id]
+		if l.loaded[id] == nil {
+			l.loaded[id] = []Version{}
+		}
+	}
+}
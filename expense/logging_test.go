@@ -0,0 +1,42 @@
+//go:build unit
+// +build unit
+
+package expense_test
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bazsup/assessment/logging"
+	"github.com/bazsup/assessment/problem"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorRequestID(t *testing.T) {
+	e, store := setupApp(t)
+	e.Use(logging.Middleware(slog.New(slog.NewTextHandler(io.Discard, nil))))
+
+	// Arrange
+	store.GetExpenseByIDWillReturn(nil, sql.ErrNoRows)
+	req := httptest.NewRequest(http.MethodGet, "/expenses/1", nil)
+	req.Header.Set("Authorization", testAuthToken)
+	req.Header.Set(logging.HeaderRequestID, "req-42")
+	rec := httptest.NewRecorder()
+
+	// Act
+	e.ServeHTTP(rec, req)
+
+	var body problem.Problem
+	json.Unmarshal(rec.Body.Bytes(), &body)
+
+	// Assertions
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, problem.ExpenseNotFound, body.Code)
+	assert.Equal(t, "req-42", body.RequestID)
+	assert.Equal(t, "/expenses/1", body.Instance)
+}
@@ -0,0 +1,178 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/bazsup/assessment/problem"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// otherTag labels the expenses created with tags outside the allowlist, tags
+// are chosen by clients and would make series without bound
+const otherTag = "other"
+
+// instrumentedStore decorates a storer with query latency and business metrics
+type instrumentedStore struct {
+	storer
+	queries *prometheus.HistogramVec
+	created *prometheus.CounterVec
+	// tags are counted under their own label, the others under otherTag
+	tags map[string]bool
+}
+
+func instrumentStore(s storer, reg prometheus.Registerer, tags []string) *instrumentedStore {
+	i := &instrumentedStore{
+		storer: s,
+		tags:   map[string]bool{},
+		queries: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "expense_store_query_duration_seconds",
+			Help:    "Expense store latency by method and outcome.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "outcome"}),
+		created: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "expenses_created_total",
+			Help: "Expenses created by tag, untagged expenses are counted once under an empty tag and tags outside the allowlist under \"other\".",
+		}, []string{"tag"}),
+	}
+	// NewApp and NewGRPCServer instrument their stores on the same registry
+	i.queries = register(reg, i.queries)
+	i.created = register(reg, i.created)
+	for _, tag := range tags {
+		i.tags[tag] = true
+	}
+	return i
+}
+
+// register registers c, or returns the collector already registered
+// under its descriptor
+func register[C prometheus.Collector](reg prometheus.Registerer, c C) C {
+	err := reg.Register(c)
+	var are prometheus.AlreadyRegisteredError
+	switch {
+	case err == nil:
+		return c
+	case errors.As(err, &are):
+		return are.ExistingCollector.(C)
+	default:
+		panic(err)
+	}
+}
+
+// unwrap gives access to the decorated store, see DBStats
+func (s *instrumentedStore) unwrap() storer {
+	return s.storer
+}
+
+func (s *instrumentedStore) observe(method string, start time.Time, err error) {
+	outcome := "ok"
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		outcome = "not_found"
+	case err != nil:
+		outcome = "error"
+	}
+	s.queries.WithLabelValues(method, outcome).Observe(time.Since(start).Seconds())
+}
+
+func (s *instrumentedStore) CreateExpense(ctx context.Context, exp Expense) (int, error) {
+	start := time.Now()
+	id, err := s.storer.CreateExpense(ctx, exp)
+	s.observe("CreateExpense", start, err)
+
+	if err == nil {
+		if len(exp.Tags) == 0 {
+			s.created.WithLabelValues("").Inc()
+		}
+		for _, tag := range exp.Tags {
+			if !s.tags[tag] {
+				tag = otherTag
+			}
+			s.created.WithLabelValues(tag).Inc()
+		}
+	}
+	return id, err
+}
+
+func (s *instrumentedStore) GetExpenseByID(ctx context.Context, id int) (*Expense, error) {
+	start := time.Now()
+	exp, err := s.storer.GetExpenseByID(ctx, id)
+	s.observe("GetExpenseByID", start, err)
+	return exp, err
+}
+
+func (s *instrumentedStore) GetAllExpenses(ctx context.Context) ([]*Expense, error) {
+	start := time.Now()
+	exps, err := s.storer.GetAllExpenses(ctx)
+	s.observe("GetAllExpenses", start, err)
+	return exps, err
+}
+
+func (s *instrumentedStore) UpdateExpense(ctx context.Context, exp Expense) error {
+	start := time.Now()
+	err := s.storer.UpdateExpense(ctx, exp)
+	s.observe("UpdateExpense", start, err)
+	return err
+}
+
+func (s *instrumentedStore) DeleteExpense(ctx context.Context, id int) error {
+	start := time.Now()
+	err := s.storer.DeleteExpense(ctx, id)
+	s.observe("DeleteExpense", start, err)
+	return err
+}
+
+func (s *instrumentedStore) GetExpenseAsOf(ctx context.Context, id int, at time.Time) (*Expense, error) {
+	start := time.Now()
+	exp, err := s.storer.GetExpenseAsOf(ctx, id, at)
+	s.observe("GetExpenseAsOf", start, err)
+	return exp, err
+}
+
+func (s *instrumentedStore) GetAllExpensesAsOf(ctx context.Context, at time.Time) ([]*Expense, error) {
+	start := time.Now()
+	exps, err := s.storer.GetAllExpensesAsOf(ctx, at)
+	s.observe("GetAllExpensesAsOf", start, err)
+	return exps, err
+}
+
+func (s *instrumentedStore) ExpenseVersions(ctx context.Context, id int) ([]Version, error) {
+	start := time.Now()
+	versions, err := s.storer.ExpenseVersions(ctx, id)
+	s.observe("ExpenseVersions", start, err)
+	return versions, err
+}
+
+func (s *instrumentedStore) RevertExpense(ctx context.Context, id, version int) (*Expense, error) {
+	start := time.Now()
+	exp, err := s.storer.RevertExpense(ctx, id, version)
+	s.observe("RevertExpense", start, err)
+	return exp, err
+}
+
+func (s *instrumentedStore) ExpenseHistory(ctx context.Context, id int) ([]AuditEntry, error) {
+	start := time.Now()
+	entries, err := s.storer.ExpenseHistory(ctx, id)
+	s.observe("ExpenseHistory", start, err)
+	return entries, err
+}
+
+func (s *instrumentedStore) QueryAudit(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	start := time.Now()
+	entries, err := s.storer.QueryAudit(ctx, q)
+	s.observe("QueryAudit", start, err)
+	return entries, err
+}
+
+// Metrics serves the registry given to WithMetrics in the Prometheus text format
+func (h *handler) Metrics(c echo.Context) error {
+	if h.metrics == nil {
+		return problem.Localized(problem.NotConfigured, problem.DetailMetricsOff)
+	}
+	promhttp.HandlerFor(h.metrics, promhttp.HandlerOpts{}).ServeHTTP(c.Response(), c.Request())
+	return nil
+}
@@ -0,0 +1,82 @@
+//go:build unit
+// +build unit
+
+package expense_test
+
+import (
+	"bytes"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bazsup/assessment/expense"
+	"github.com/bazsup/assessment/metrics"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Run("Requests, queries and allowed created tags are exposed", func(t *testing.T) {
+		t.Parallel()
+		store := NewTestStore()
+		reg := prometheus.NewRegistry()
+		e := newEcho()
+		expense.NewApp(e, store, testAuthToken, expense.WithMetrics(reg), expense.WithMetricTags("food"))
+
+		// Arrange
+		store.CreateExpenseWillReturn(1, nil)
+		store.GetExpenseByIDWillReturn(nil, sql.ErrNoRows)
+
+		// Act
+		req := httptest.NewRequest(http.MethodPost, "/expenses", bytes.NewBufferString(`{"title":"t","amount":1,"tags":["food","beverage"]}`))
+		req.Header.Set(echo.HeaderAuthorization, testAuthToken)
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		e.ServeHTTP(httptest.NewRecorder(), req)
+		serve(e, http.MethodGet, "/expenses/7", testAuthToken)
+		serve(e, http.MethodGet, "/expenses", "")
+		rec := serve(e, http.MethodGet, "/metrics", "")
+
+		// Assertions
+		out := rec.Body.String()
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.True(t, strings.HasPrefix(rec.Header().Get(echo.HeaderContentType), metrics.ContentType))
+		assert.Contains(t, out, `http_requests_total{method="POST",route="/expenses",status="201"} 1`)
+		assert.Contains(t, out, `http_requests_total{method="GET",route="/expenses/:id",status="404"} 1`)
+		assert.Contains(t, out, `http_requests_total{method="GET",route="/expenses",status="401"} 1`)
+		assert.Contains(t, out, `expense_store_query_duration_seconds_count{method="GetExpenseByID",outcome="not_found"} 1`)
+		assert.Contains(t, out, `expenses_created_total{tag="food"} 1`)
+		assert.Contains(t, out, `expenses_created_total{tag="other"} 1`)
+		assert.NotContains(t, out, `tag="beverage"`)
+	})
+
+	t.Run("Metrics are not served without a registry", func(t *testing.T) {
+		e, _ := setupApp(t)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/metrics", "")
+
+		// Assertions
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("Pool stats are still served through the instrumented store", func(t *testing.T) {
+		t.Parallel()
+		db, _, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		e := newEcho()
+		expense.NewApp(e, expense.NewExpenseStore(db), testAuthToken, expense.WithMetrics(prometheus.NewRegistry()))
+
+		// Act
+		rec := serve(e, http.MethodGet, "/admin/db/stats", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+}
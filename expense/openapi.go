@@ -0,0 +1,378 @@
+package expense
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/bazsup/assessment/audit"
+	"github.com/bazsup/assessment/authz"
+	"github.com/bazsup/assessment/health"
+	"github.com/bazsup/assessment/idempotency"
+	"github.com/bazsup/assessment/metrics"
+	"github.com/bazsup/assessment/openapi"
+	"github.com/bazsup/assessment/problem"
+	"github.com/bazsup/assessment/webhook"
+	"github.com/labstack/echo/v4"
+	"github.com/swaggest/swgui/v5emb"
+)
+
+// routeDoc describes a route of the route table in the OpenAPI document.
+// The auth, policy, rate limit and idempotency responses are added from
+// the route itself.
+type routeDoc struct {
+	tag     string
+	summary string
+	// params are the query and header params, path params are derived
+	params []openapi.Parameter
+	// body and result are Go values or *openapi.Schema, a nil result has no content
+	body    interface{}
+	status  int
+	result  interface{}
+	content string
+	errors  []int
+}
+
+func query(name, description string, schema *openapi.Schema) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+var (
+	integerSchema  = &openapi.Schema{Type: "integer"}
+	idSchema       = &openapi.Schema{Type: "integer", Minimum: floatp(0)}
+	stringSchema   = &openapi.Schema{Type: "string"}
+	dateTimeSchema = &openapi.Schema{Type: "string", Format: "date-time"}
+)
+
+var asOfParam = query("as_of", "Reads the expenses as they were at this RFC 3339 time", dateTimeSchema)
+
+// Limits of the expenses accepted by create and update requests
+const (
+	MaxTitleLength = 200
+	MaxNoteLength  = 1000
+	MaxTags        = 10
+	MaxTagLength   = 30
+)
+
+func intp(n int) *int { return &n }
+
+func floatp(f float64) *float64 { return &f }
+
+// tagPattern accepts words of letters and digits joined by hyphens or
+// underscores, as in "food" or "ค่า-เดินทาง"
+const tagPattern = `^[\p{L}\p{M}\p{N}]+([-_][\p{L}\p{M}\p{N}]+)*$`
+
+// expenseInput is the body of create and update requests
+var expenseInput = &openapi.Schema{
+	Type:     "object",
+	Required: []string{"title", "amount"},
+	Properties: map[string]*openapi.Schema{
+		"id":     {Type: "integer", ReadOnly: true, Description: "Ignored, the id is assigned on create and taken from the path on update"},
+		"title":  {Type: "string", MinLength: intp(1), MaxLength: intp(MaxTitleLength), Example: "strawberry smoothie"},
+		"amount": {Type: "number", ExclusiveMinimum: floatp(0), Example: 79},
+		"note":   {Type: "string", MaxLength: intp(MaxNoteLength), Example: "night market promotion discount 10 bath"},
+		"tags": {
+			Type:     "array",
+			MaxItems: intp(MaxTags),
+			Items:    &openapi.Schema{Type: "string", MinLength: intp(1), MaxLength: intp(MaxTagLength), Pattern: tagPattern},
+			Example:  []string{"food", "beverage"},
+		},
+	},
+	AdditionalProperties: false,
+}
+
+var graphQLInput = &openapi.Schema{
+	Type:     "object",
+	Required: []string{"query"},
+	Properties: map[string]*openapi.Schema{
+		"query": stringSchema,
+		// clients commonly send null for what they don't use
+		"variables":     {Type: []string{"object", "null"}},
+		"operationName": {Type: []string{"string", "null"}},
+	},
+}
+
+var graphQLResult = &openapi.Schema{
+	Type: "object",
+	Properties: map[string]*openapi.Schema{
+		"data":   {Type: []string{"object", "null"}},
+		"errors": {Type: "array", Items: &openapi.Schema{Type: "object", Properties: map[string]*openapi.Schema{"message": stringSchema}}},
+	},
+}
+
+var graphQLParams = []openapi.Parameter{
+	query("query", "The GraphQL query, mutations must be sent with POST", stringSchema),
+	query("variables", "The variables of the query as a JSON object", stringSchema),
+	query("operationName", "The operation to execute when the query holds several", stringSchema),
+}
+
+// webhookInput is the body of a subscription request
+var webhookInput = &openapi.Schema{
+	Type:     "object",
+	Required: []string{"url"},
+	Properties: map[string]*openapi.Schema{
+		"url":    {Type: "string", Format: "uri", MinLength: intp(1)},
+		"events": {Type: "array", Items: stringSchema, Description: `Event types delivered, every type when empty. A trailing "*" matches by prefix.`},
+	},
+	AdditionalProperties: false,
+}
+
+func limitSchema(max int) *openapi.Schema {
+	return &openapi.Schema{Type: "integer", Minimum: floatp(1), Maximum: floatp(float64(max))}
+}
+
+var auditPage = []openapi.Parameter{
+	query("after", "Lists the entries after this id", idSchema),
+	query("limit", fmt.Sprintf("Page size, %d by default", DefaultAuditLimit), limitSchema(MaxAuditLimit)),
+}
+
+var deliveryPage = []openapi.Parameter{
+	query("after", "Lists the deliveries after this id", idSchema),
+	query("limit", fmt.Sprintf("Page size, %d by default", webhook.DefaultDeliveryLimit), limitSchema(webhook.MaxDeliveryLimit)),
+}
+
+var streamParams = []openapi.Parameter{
+	{Name: "Last-Event-ID", In: "header", Description: "Resumes after this event id", Schema: idSchema},
+	query("last_event_id", "Resumes after this event id, for clients that can't set headers", idSchema),
+}
+
+var sseSchema = &openapi.Schema{Type: "string", Description: "Server-sent events, each data line holds a change event as JSON"}
+
+// routeDocs documents every route of Routes, keyed by method and path
+var routeDocs = map[string]routeDoc{
+	"POST /expenses": {tag: "expenses", summary: "Create an expense",
+		body: expenseInput, status: http.StatusCreated, result: Expense{}, errors: []int{400, 500, 503}},
+	"GET /expenses": {tag: "expenses", summary: "List every expense the caller may read", params: []openapi.Parameter{asOfParam},
+		status: http.StatusOK, result: []Expense{}, errors: []int{400, 403, 500, 503}},
+	"GET /expenses/:id": {tag: "expenses", summary: "Get an expense", params: []openapi.Parameter{asOfParam},
+		status: http.StatusOK, result: Expense{}, errors: []int{400, 404, 500, 503}},
+	"PUT /expenses/:id": {tag: "expenses", summary: "Replace an expense",
+		body: expenseInput, status: http.StatusOK, result: Expense{}, errors: []int{400, 404, 500, 503}},
+	"DELETE /expenses/:id": {tag: "expenses", summary: "Delete an expense",
+		status: http.StatusNoContent, errors: []int{404, 500, 503}},
+	"GET /expenses/:id/history": {tag: "versions", summary: "List the audited changes of an expense, oldest first",
+		status: http.StatusOK, result: []AuditEntry{}, errors: []int{404, 500, 503}},
+	"GET /expenses/:id/versions": {tag: "versions", summary: "List the versions of an expense",
+		status: http.StatusOK, result: []Version{}, errors: []int{404, 500, 503}},
+	"GET /expenses/:id/export": {tag: "versions", summary: "Export the versions of an expense as CSV, amounts and UTC dates worded in the request language",
+		params: []openapi.Parameter{query("buddhist_era", "Counts years from the Buddhist Era, as 2569 for 2026", &openapi.Schema{Type: "boolean"})},
+		status: http.StatusOK, result: stringSchema, content: "text/csv", errors: []int{400, 404, 500, 503}},
+	"POST /expenses/:id/revert": {tag: "versions", summary: "Restore a version of an expense as a new change",
+		params: []openapi.Parameter{{Name: "version", In: "query", Required: true, Description: "The version restored", Schema: &openapi.Schema{Type: "integer", Minimum: floatp(1)}}},
+		status: http.StatusOK, result: Expense{}, errors: []int{400, 404, 500, 503}},
+	"GET /expenses/stream": {tag: "streams", summary: "Stream expense changes as server-sent events", params: streamParams,
+		status: http.StatusOK, result: sseSchema, content: "text/event-stream", errors: []int{400, 404, 410, 503}},
+	"GET /expenses/stream/ws": {tag: "streams", summary: "Stream expense changes over a WebSocket, one JSON event per message", params: streamParams,
+		status: http.StatusSwitchingProtocols, errors: []int{400, 404, 410, 503}},
+	"GET /graphql": {tag: "graphql", summary: "Execute a GraphQL query", params: graphQLParams,
+		status: http.StatusOK, result: graphQLResult, errors: []int{400, 404, 405}},
+	"POST /graphql": {tag: "graphql", summary: "Execute a GraphQL query or mutation",
+		body: graphQLInput, status: http.StatusOK, result: graphQLResult, errors: []int{400, 404}},
+	"GET /authz/explain": {tag: "authz", summary: "Explain how the policy decides a request of the caller",
+		params: []openapi.Parameter{
+			query("method", "Method of the request, GET by default", stringSchema),
+			query("path", "Path of the request", stringSchema),
+		},
+		status: http.StatusOK, result: authz.Decision{}, errors: []int{400, 404, 500}},
+	"GET /admin/db/stats": {tag: "admin", summary: "Report the database connection pool",
+		status: http.StatusOK, result: PoolStats{}, errors: []int{404}},
+	"GET /admin/audit": {tag: "admin", summary: "Query the audit log of every expense",
+		params: append([]openapi.Parameter{
+			query("expense_id", "", idSchema),
+			query("actor", "", stringSchema),
+			query("action", "", &openapi.Schema{Type: "string", Enum: []interface{}{AuditCreate, AuditUpdate, AuditDelete, AuditRevert}}),
+			query("since", "RFC 3339 time", dateTimeSchema),
+			query("until", "RFC 3339 time", dateTimeSchema),
+		}, auditPage...),
+		status: http.StatusOK, result: []AuditEntry{}, errors: []int{400, 500, 503}},
+	"GET /admin/audit/verify": {tag: "admin", summary: "Verify the audit chain is intact",
+		status: http.StatusOK, result: audit.Report{}, errors: []int{404, 500, 503}},
+	"POST /admin/webhooks": {tag: "webhooks", summary: "Subscribe a URL to expense events, the answer is the only time the signing secret is shown",
+		body: webhookInput, status: http.StatusCreated, result: webhook.Subscription{}, errors: []int{400, 404, 500, 503}},
+	"GET /admin/webhooks": {tag: "webhooks", summary: "List the webhook subscriptions",
+		status: http.StatusOK, result: []webhook.Subscription{}, errors: []int{404, 500, 503}},
+	"DELETE /admin/webhooks/:sid": {tag: "webhooks", summary: "Delete a webhook subscription, its delivery log is kept",
+		status: http.StatusNoContent, errors: []int{404, 500, 503}},
+	"GET /admin/webhooks/deliveries": {tag: "webhooks", summary: "Query the webhook delivery log, status=dead lists the dead letters",
+		params: append([]openapi.Parameter{
+			query("subscription_id", "", idSchema),
+			query("status", "", &openapi.Schema{Type: "string", Enum: []interface{}{webhook.StatusPending, webhook.StatusDelivered, webhook.StatusDead}}),
+		}, deliveryPage...),
+		status: http.StatusOK, result: []webhook.Delivery{}, errors: []int{400, 404, 500, 503}},
+	"POST /admin/webhooks/deliveries/:did/redeliver": {tag: "webhooks", summary: "Queue a dead letter for a fresh round of attempts",
+		status: http.StatusAccepted, errors: []int{404, 500, 503}},
+	"GET /healthz": {tag: "health", summary: "Liveness probe",
+		status: http.StatusOK, result: health.Report{}},
+	"GET /readyz": {tag: "health", summary: "Readiness probe, failing while shutting down or when a dependency is down",
+		status: http.StatusOK, result: health.Report{}, errors: []int{503}},
+	"GET /health": {tag: "health", summary: "Report every dependency check with its latency",
+		status: http.StatusOK, result: health.Report{}, errors: []int{503}},
+	"GET /metrics": {tag: "health", summary: "Prometheus metrics",
+		status: http.StatusOK, result: stringSchema, content: metrics.ContentType, errors: []int{404}},
+	"GET /openapi.json": {tag: "docs", summary: "This document",
+		status: http.StatusOK, result: &openapi.Schema{Type: "object"}},
+	"GET /docs": {tag: "docs", summary: "Interactive API docs",
+		status: http.StatusOK, result: stringSchema, content: echo.MIMETextHTML},
+	"GET /docs/*": {tag: "docs", summary: "Assets of the interactive API docs",
+		status: http.StatusOK, result: &openapi.Schema{Type: "string", Format: "binary"}, content: "application/octet-stream", errors: []int{404}},
+}
+
+var statusDescriptions = map[int]string{
+	400: "The request is malformed or fails validation, errors lists every field error",
+	401: "Credentials are missing or invalid",
+	403: "The authz policy denies the request",
+	404: "The resource does not exist or the feature is not configured",
+	405: "The method is not allowed",
+	409: "The change conflicts with the stored data or a request with the same Idempotency-Key is still in progress",
+	410: "The resumed event is no longer kept",
+	422: "The Idempotency-Key was used with a different request",
+	429: "The client is rate limited",
+	500: "The server failed",
+	503: "A dependency is unavailable or timed out",
+}
+
+// pathParams describes the path params of the route table
+var pathParams = map[string]string{
+	"id":   "Expense id",
+	"sid":  "Webhook subscription id",
+	"did":  "Webhook delivery id",
+	"path": "Asset path",
+}
+
+// OpenAPI builds the OpenAPI document of the routes of Routes, a route
+// without a routeDoc is left out
+func OpenAPI() *openapi.Document {
+	d := openapi.New(openapi.Info{
+		Title:       "Expenses API",
+		Version:     "1.0.0",
+		Description: "Tracks the expenses of bank customers.",
+	})
+	d.Components.SecuritySchemes["token"] = openapi.SecurityScheme{
+		Type: "apiKey", In: "header", Name: echo.HeaderAuthorization,
+		Description: "The AUTH_TOKEN of the server as is",
+	}
+	d.Components.SecuritySchemes["bearer"] = openapi.SecurityScheme{
+		Type: "http", Scheme: "bearer", BearerFormat: "JWT",
+		Description: "A JWT, accepted when the server is configured with JWT keys",
+	}
+	errSchema := d.SchemaOf(problem.Problem{})
+	codes := problem.Codes()
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+	code := d.Components.Schemas["Problem"].Properties["code"]
+	for _, c := range codes {
+		code.Enum = append(code.Enum, string(c))
+	}
+
+	tags := map[string]bool{}
+	for _, r := range Routes() {
+		doc, ok := routeDocs[r.Method+" "+r.Path]
+		if !ok {
+			continue
+		}
+		tags[doc.tag] = true
+
+		_, names := openapi.Path(r.Path)
+		op := &openapi.Operation{
+			Summary:   doc.summary,
+			Tags:      []string{doc.tag},
+			Responses: map[string]*openapi.Response{},
+			Security:  []openapi.SecurityRequirement{},
+		}
+		for _, name := range names {
+			schema := integerSchema
+			if name == "path" {
+				schema = stringSchema
+			}
+			op.Parameters = append(op.Parameters, openapi.Parameter{Name: name, In: "path", Required: true, Description: pathParams[name], Schema: schema})
+		}
+		op.Parameters = append(op.Parameters, doc.params...)
+
+		content := doc.content
+		if content == "" {
+			content = echo.MIMEApplicationJSON
+		}
+		if doc.body != nil {
+			op.RequestBody = &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{
+				echo.MIMEApplicationJSON: {Schema: d.SchemaOf(doc.body)},
+			}}
+		}
+		res := &openapi.Response{Description: http.StatusText(doc.status)}
+		if doc.result != nil {
+			res.Content = map[string]openapi.MediaType{content: {Schema: d.SchemaOf(doc.result)}}
+		}
+		op.Responses[strconv.Itoa(doc.status)] = res
+
+		errors := append([]int{http.StatusTooManyRequests}, doc.errors...)
+		if r.Access == AccessAuthenticated {
+			op.Security = []openapi.SecurityRequirement{{"token": {}}, {"bearer": {}}}
+			errors = append(errors, http.StatusUnauthorized)
+		}
+		if r.Action != "" {
+			errors = append(errors, http.StatusForbidden)
+		}
+		if r.Method == http.MethodPost || r.Method == http.MethodPut || r.Method == http.MethodPatch {
+			op.Parameters = append(op.Parameters, openapi.Parameter{
+				Name: idempotency.HeaderKey, In: "header", Schema: stringSchema,
+				Description: "Replays the response of a request retried with the same key",
+			})
+			errors = append(errors, http.StatusConflict, http.StatusUnprocessableEntity)
+		}
+		for _, status := range errors {
+			res := &openapi.Response{
+				Description: statusDescriptions[status],
+				Content:     map[string]openapi.MediaType{problem.ContentType: {Schema: errSchema}},
+			}
+			if status == http.StatusUnauthorized {
+				res.Headers = map[string]openapi.Header{echo.HeaderWWWAuthenticate: {Description: "A RFC 6750 challenge", Schema: stringSchema}}
+			}
+			op.Responses[strconv.Itoa(status)] = res
+		}
+
+		d.Add(r.Method, r.Path, op)
+	}
+
+	for tag := range tags {
+		d.Tags = append(d.Tags, openapi.Tag{Name: tag})
+	}
+	sort.Slice(d.Tags, func(i, j int) bool { return d.Tags[i].Name < d.Tags[j].Name })
+	return d
+}
+
+// api caches the OpenAPI document, it only depends on the route table
+var api struct {
+	once sync.Once
+	doc  *openapi.Document
+	json []byte
+	err  error
+}
+
+// apiDocument returns the OpenAPI document requests are validated against
+func apiDocument() *openapi.Document {
+	api.once.Do(func() {
+		api.doc = OpenAPI()
+		api.json, api.err = json.Marshal(api.doc)
+	})
+	return api.doc
+}
+
+// OpenAPISpec serves the OpenAPI document of the routes
+func (h *handler) OpenAPISpec(c echo.Context) error {
+	apiDocument()
+	spec, err := api.json, api.err
+	if err != nil {
+		return problem.Wrap(problem.Internal, err, "")
+	}
+	return c.Blob(http.StatusOK, echo.MIMEApplicationJSON, spec)
+}
+
+// docsUI is Swagger UI with its assets embedded in the binary
+var docsUI = v5emb.New("Expenses API", "/openapi.json", "/docs/")
+
+// Docs serves the interactive API docs of the OpenAPI document
+func (h *handler) Docs(c echo.Context) error {
+	docsUI.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
@@ -0,0 +1,96 @@
+//go:build unit
+// +build unit
+
+package expense_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/bazsup/assessment/expense"
+	"github.com/bazsup/assessment/openapi"
+	"github.com/bazsup/assessment/problem"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenAPI(t *testing.T) {
+	t.Run("Every registered route is in the document", func(t *testing.T) {
+		e, _ := setupApp(t)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/openapi.json", "")
+
+		var doc openapi.Document
+		err := json.Unmarshal(rec.Body.Bytes(), &doc)
+
+		// Assertions
+		assert.Equal(t, http.StatusOK, rec.Code)
+		if assert.NoError(t, err) {
+			assert.Equal(t, openapi.Version, doc.OpenAPI)
+			for _, r := range e.Routes() {
+				path, _ := openapi.Path(r.Path)
+				assert.NotNil(t, doc.Paths[path][strings.ToLower(r.Method)], "%s %s is not documented", r.Method, r.Path)
+			}
+		}
+	})
+
+	t.Run("Expense and Problem are described by schemas", func(t *testing.T) {
+		// Act
+		doc := expense.OpenAPI()
+
+		// Assertions
+		if assert.Contains(t, doc.Components.Schemas, "Expense") {
+			assert.ElementsMatch(t, []string{"id", "title", "amount", "note", "tags"}, doc.Components.Schemas["Expense"].Required)
+		}
+		if assert.Contains(t, doc.Components.Schemas, "Problem") {
+			p := doc.Components.Schemas["Problem"]
+			assert.ElementsMatch(t, []string{"type", "title", "status", "code"}, p.Required)
+			assert.Contains(t, p.Properties["code"].Enum, "EXPENSE_NOT_FOUND")
+			assert.Empty(t, doc.Components.Schemas["Expense"].Properties["title"].Enum)
+		}
+
+		get := doc.Operation(http.MethodGet, "/expenses/:id")
+		if assert.NotNil(t, get) {
+			assert.Equal(t, "#/components/schemas/Expense", get.Responses["200"].Content["application/json"].Schema.Ref)
+			assert.Equal(t, "#/components/schemas/Problem", get.Responses["404"].Content[problem.ContentType].Schema.Ref)
+		}
+	})
+
+	t.Run("Authenticated routes require credentials and answer 401", func(t *testing.T) {
+		// Act
+		doc := expense.OpenAPI()
+
+		// Assertions
+		for _, r := range expense.Routes() {
+			op := doc.Operation(r.Method, r.Path)
+			if !assert.NotNil(t, op, "%s %s", r.Method, r.Path) {
+				continue
+			}
+			if r.Access == expense.AccessAuthenticated {
+				assert.NotEmpty(t, op.Security, "%s %s", r.Method, r.Path)
+				assert.Contains(t, op.Responses, "401", "%s %s", r.Method, r.Path)
+			} else {
+				assert.Empty(t, op.Security, "%s %s", r.Method, r.Path)
+				assert.NotContains(t, op.Responses, "401", "%s %s", r.Method, r.Path)
+			}
+			if r.Action != "" {
+				assert.Contains(t, op.Responses, "403", "%s %s", r.Method, r.Path)
+			}
+		}
+	})
+
+	t.Run("Docs UI is served with its assets", func(t *testing.T) {
+		e, _ := setupApp(t)
+
+		// Act
+		page := serve(e, http.MethodGet, "/docs", "")
+		asset := serve(e, http.MethodGet, "/docs/swagger-ui-bundle.js", "")
+
+		// Assertions
+		assert.Equal(t, http.StatusOK, page.Code)
+		assert.Contains(t, page.Body.String(), "/openapi.json")
+		assert.Equal(t, http.StatusOK, asset.Code)
+	})
+}
@@ -0,0 +1,42 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/bazsup/assessment/webhook"
+)
+
+// webhookEvents are the webhook event types of audit actions
+var webhookEvents = map[string]string{
+	AuditCreate: webhook.EventExpenseCreated,
+	AuditUpdate: webhook.EventExpenseUpdated,
+	AuditDelete: webhook.EventExpenseDeleted,
+	AuditRevert: webhook.EventExpenseReverted,
+}
+
+// ChangeData is the data of expense webhook events
+type ChangeData struct {
+	// Expense is nil once deleted, Previous is nil for a new expense
+	Expense   *Expense `json:"expense"`
+	Previous  *Expense `json:"previous"`
+	Actor     string   `json:"actor"`
+	RequestID string   `json:"request_id,omitempty"`
+}
+
+// recordChange audits a change and enqueues its webhook event in tx, the
+// transaction making the change, so subscribers only hear of committed changes
+func recordChange(ctx context.Context, tx *sql.Tx, auditStmt *sql.Stmt, id int, action string, before, after *Expense) error {
+	if err := recordAudit(ctx, tx, auditStmt, id, action, before, after); err != nil {
+		return err
+	}
+
+	actor := ActorFrom(ctx)
+	return webhook.Enqueue(ctx, tx, webhook.Event{
+		Type:       webhookEvents[action],
+		ExpenseID:  id,
+		OccurredAt: time.Now(),
+		Data:       ChangeData{Expense: after, Previous: before, Actor: actor.Subject, RequestID: actor.RequestID},
+	})
+}
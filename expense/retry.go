@@ -0,0 +1,77 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/bazsup/assessment/retry"
+	"github.com/lib/pq"
+)
+
+// DefaultReadRetry retries idempotent reads a couple of times on transient errors
+var DefaultReadRetry = retry.Policy{
+	Attempts: 3,
+	Initial:  50 * time.Millisecond,
+	Max:      500 * time.Millisecond,
+	Jitter:   0.5,
+}
+
+// IsTransient reports whether err is likely to go away on retry, such as
+// a dropped connection, a restarting server or a serialization failure
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "08", // connection exception
+			"40", // transaction rollback: serialization failure, deadlock
+			"53": // insufficient resources: too many connections
+			return true
+		}
+		switch pqErr.Code {
+		case "57P01", "57P02", "57P03": // admin/crash shutdown, cannot connect now
+			return true
+		}
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// WaitForDB pings the database until it answers, backing off between attempts
+// so the app survives starting before Postgres (as in docker-compose). Errors
+// waiting won't fix, such as a wrong password, are returned at once.
+func WaitForDB(ctx context.Context, db *sql.DB, p retry.Policy) error {
+	if p.OnRetry == nil {
+		p.OnRetry = func(attempt int, err error, delay time.Duration) {
+			slog.WarnContext(ctx, "database not ready, retrying",
+				"attempt", attempt, "error", err.Error(), "delay", delay.Round(time.Millisecond).String())
+		}
+	}
+
+	return retry.Do(ctx, p, IsTransient, func(attempt int) error {
+		if err := db.PingContext(ctx); err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "database ready", "attempts", attempt)
+		return nil
+	})
+}
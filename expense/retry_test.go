@@ -0,0 +1,124 @@
+//go:build unit
+// +build unit
+
+package expense_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bazsup/assessment/expense"
+	"github.com/bazsup/assessment/retry"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"no rows", sql.ErrNoRows, false},
+		{"unexpected EOF", fmt.Errorf("read: %w", io.ErrUnexpectedEOF), true},
+		{"connection failure", &pq.Error{Code: "08006"}, true},
+		{"serialization failure", &pq.Error{Code: "40001"}, true},
+		{"too many connections", &pq.Error{Code: "53300"}, true},
+		{"server shutting down", &pq.Error{Code: "57P01"}, true},
+		{"syntax error", &pq.Error{Code: "42601"}, false},
+		{"unique violation", &pq.Error{Code: "23505"}, false},
+	}
+
+	for _, tt := range tests {
+		tt := tt // rebind tt into this lexical scope
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, expense.IsTransient(tt.err))
+		})
+	}
+}
+
+func TestDBReadRetry(t *testing.T) {
+	t.Run("Transient error on read is retried", func(t *testing.T) {
+		expStore, mock := setupDB(t)
+		expStore.WithReadRetry(retry.Policy{Attempts: 3, Initial: time.Millisecond})
+
+		// Arrange
+		get := mock.ExpectPrepare("SELECT .+ FROM expenses WHERE id = .+")
+		get.ExpectQuery().WithArgs(1).WillReturnError(&pq.Error{Code: "40001"})
+		get.ExpectQuery().WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}).
+			AddRow(1, "t", 1, "n", "{}"))
+
+		// Act
+		exp, err := expStore.GetExpenseByID(context.Background(), 1)
+
+		// Assertions
+		if assert.NoError(t, err) {
+			assert.Equal(t, 1, exp.ID)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Not found is not retried", func(t *testing.T) {
+		expStore, mock := setupDB(t)
+		expStore.WithReadRetry(retry.Policy{Attempts: 3, Initial: time.Millisecond})
+
+		// Arrange
+		get := mock.ExpectPrepare("SELECT .+ FROM expenses WHERE id = .+")
+		get.ExpectQuery().WithArgs(1).WillReturnError(sql.ErrNoRows)
+
+		// Act
+		_, err := expStore.GetExpenseByID(context.Background(), 1)
+
+		// Assertions
+		assert.Equal(t, sql.ErrNoRows, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestWaitForDB(t *testing.T) {
+	t.Run("Refused connection is retried until the database answers", func(t *testing.T) {
+		t.Parallel()
+		db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Arrange
+		mock.ExpectPing().WillReturnError(&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED})
+		mock.ExpectPing()
+
+		// Act
+		err = expense.WaitForDB(context.Background(), db, retry.Policy{Initial: time.Millisecond, MaxWait: time.Second})
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Rejected password fails without retrying", func(t *testing.T) {
+		t.Parallel()
+		db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Arrange
+		authErr := &pq.Error{Code: "28P01"}
+		mock.ExpectPing().WillReturnError(authErr)
+
+		// Act
+		err = expense.WaitForDB(context.Background(), db, retry.Policy{Initial: time.Millisecond, MaxWait: time.Second})
+
+		// Assertions
+		assert.Equal(t, authErr, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,148 @@
+package expense
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Access is the authentication policy applied to a route
+type Access string
+
+const (
+	// AccessPublic routes are served without credentials
+	AccessPublic Access = "public"
+	// AccessAuthenticated routes require the auth token or a valid bearer JWT
+	AccessAuthenticated Access = "authenticated"
+)
+
+// Route declares an endpoint and the policy guarding it
+type Route struct {
+	Method string
+	Path   string
+	Access Access
+	// Action is the permission checked by the authz policy, empty routes are not authorized
+	Action string
+	handle func(*handler, echo.Context) error
+}
+
+// Actions checked against the authz policy
+const (
+	ActionCreate = "expenses:create"
+	ActionRead   = "expenses:read"
+	ActionUpdate = "expenses:update"
+	ActionDelete = "expenses:delete"
+	ActionAdmin  = "admin:read"
+	// ActionWebhooks manages webhook subscriptions and their delivery log
+	ActionWebhooks = "webhooks:manage"
+)
+
+// Routes returns the route table registered by NewApp, the single place
+// where endpoints and their auth policy are declared
+func Routes() []Route {
+	return []Route{
+		{http.MethodPost, "/expenses", AccessAuthenticated, ActionCreate, (*handler).CreateExpense},
+		// the list keeps the expenses the caller may read
+		{http.MethodGet, "/expenses", AccessAuthenticated, "", (*handler).GetAllExpenses},
+		{http.MethodGet, "/expenses/:id", AccessAuthenticated, ActionRead, (*handler).GetExpense},
+		{http.MethodPut, "/expenses/:id", AccessAuthenticated, ActionUpdate, (*handler).UpdateExpense},
+		{http.MethodDelete, "/expenses/:id", AccessAuthenticated, ActionDelete, (*handler).DeleteExpense},
+		{http.MethodGet, "/expenses/:id/history", AccessAuthenticated, ActionRead, (*handler).ExpenseHistory},
+		{http.MethodGet, "/expenses/:id/versions", AccessAuthenticated, ActionRead, (*handler).ExpenseVersions},
+		{http.MethodGet, "/expenses/:id/export", AccessAuthenticated, ActionRead, (*handler).ExportExpense},
+		{http.MethodPost, "/expenses/:id/revert", AccessAuthenticated, ActionUpdate, (*handler).RevertExpense},
+		// streams check the read permission of each expense they push
+		{http.MethodGet, "/expenses/stream", AccessAuthenticated, "", (*handler).StreamExpenses},
+		{http.MethodGet, "/expenses/stream/ws", AccessAuthenticated, "", (*handler).StreamExpensesWS},
+		// resolvers check the permission of each field they resolve
+		{http.MethodGet, "/graphql", AccessAuthenticated, "", (*handler).GraphQL},
+		{http.MethodPost, "/graphql", AccessAuthenticated, "", (*handler).GraphQL},
+		{http.MethodGet, "/authz/explain", AccessAuthenticated, "", (*handler).ExplainAuthz},
+		{http.MethodGet, "/admin/db/stats", AccessAuthenticated, ActionAdmin, (*handler).DBStats},
+		{http.MethodGet, "/admin/audit", AccessAuthenticated, ActionAdmin, (*handler).QueryAudit},
+		{http.MethodGet, "/admin/audit/verify", AccessAuthenticated, ActionAdmin, (*handler).VerifyAudit},
+		{http.MethodPost, "/admin/webhooks", AccessAuthenticated, ActionWebhooks, (*handler).CreateWebhook},
+		{http.MethodGet, "/admin/webhooks", AccessAuthenticated, ActionWebhooks, (*handler).ListWebhooks},
+		{http.MethodDelete, "/admin/webhooks/:sid", AccessAuthenticated, ActionWebhooks, (*handler).DeleteWebhook},
+		{http.MethodGet, "/admin/webhooks/deliveries", AccessAuthenticated, ActionWebhooks, (*handler).WebhookDeliveries},
+		{http.MethodPost, "/admin/webhooks/deliveries/:did/redeliver", AccessAuthenticated, ActionWebhooks, (*handler).RedeliverWebhook},
+		{http.MethodGet, "/healthz", AccessPublic, "", (*handler).Liveness},
+		{http.MethodGet, "/readyz", AccessPublic, "", (*handler).Readiness},
+		{http.MethodGet, "/health", AccessPublic, "", (*handler).Health},
+		{http.MethodGet, "/metrics", AccessPublic, "", (*handler).Metrics},
+		{http.MethodGet, "/openapi.json", AccessPublic, "", (*handler).OpenAPISpec},
+		{http.MethodGet, "/docs", AccessPublic, "", (*handler).Docs},
+		{http.MethodGet, "/docs/*", AccessPublic, "", (*handler).Docs},
+	}
+}
+
+// matchRoute finds the declared route serving method and path along with
+// its path params. Like echo's router, static segments win over params and
+// params over a trailing * matching the rest of the path, kept as the "*"
+// param, so /expenses/stream is not served by /expenses/:id.
+func matchRoute(method, path string) (Route, map[string]string, bool) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	var best Route
+	var bestPattern []string
+	var bestParams map[string]string
+	for _, r := range Routes() {
+		if r.Method != method {
+			continue
+		}
+		pattern := strings.Split(strings.Trim(r.Path, "/"), "/")
+		params, ok := matchPattern(pattern, segments)
+		if ok && (bestPattern == nil || moreStatic(pattern, bestPattern)) {
+			best, bestPattern, bestParams = r, pattern, params
+		}
+	}
+	if bestPattern == nil {
+		return Route{}, nil, false
+	}
+	return best, bestParams, true
+}
+
+// matchPattern returns the params of the path segments matching pattern
+func matchPattern(pattern, segments []string) (map[string]string, bool) {
+	params := map[string]string{}
+	for i, p := range pattern {
+		if p == "*" && i == len(pattern)-1 {
+			params["*"] = strings.Join(segments[i:], "/")
+			return params, true
+		}
+		if i >= len(segments) {
+			return nil, false
+		}
+		switch {
+		case strings.HasPrefix(p, ":"):
+			params[p[1:]] = segments[i]
+		case p != segments[i]:
+			return nil, false
+		}
+	}
+	return params, len(pattern) == len(segments)
+}
+
+// segmentKind ranks a pattern segment, static ones first
+func segmentKind(p string) int {
+	switch {
+	case p == "*":
+		return 2
+	case strings.HasPrefix(p, ":"):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// moreStatic reports whether pattern a has a more static segment where it
+// first differs in kind from b, or matches without b's trailing *
+func moreStatic(a, b []string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if ka, kb := segmentKind(a[i]), segmentKind(b[i]); ka != kb {
+			return ka < kb
+		}
+	}
+	return len(a) < len(b)
+}
@@ -0,0 +1,256 @@
+//go:build unit
+// +build unit
+
+package expense_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bazsup/assessment/auth"
+	"github.com/bazsup/assessment/expense"
+	"github.com/bazsup/assessment/locale"
+	"github.com/bazsup/assessment/problem"
+	"github.com/bazsup/assessment/ratelimit"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+const testAuthToken = "November 10, 2009"
+
+// newEcho returns an echo answering errors with problem documents, as the server does
+func newEcho() *echo.Echo {
+	e := echo.New()
+	e.HTTPErrorHandler = problem.Handler(slog.Default())
+	return e
+}
+
+func setupApp(t *testing.T) (*echo.Echo, *TestStore) {
+	t.Parallel()
+
+	e := newEcho()
+	store := NewTestStore()
+	expense.NewApp(e, store, testAuthToken)
+	return e, store
+}
+
+func serve(e *echo.Echo, method, path, authorization string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if authorization != "" {
+		req.Header.Set(echo.HeaderAuthorization, authorization)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRouteTable(t *testing.T) {
+	t.Run("Every expense route requires authentication", func(t *testing.T) {
+		for _, r := range expense.Routes() {
+			if r.Path == "/expenses" || r.Path == "/expenses/:id" {
+				assert.Equal(t, expense.AccessAuthenticated, r.Access, "%s %s", r.Method, r.Path)
+			}
+		}
+	})
+
+	t.Run("Every declared route is registered", func(t *testing.T) {
+		e, _ := setupApp(t)
+
+		registered := map[string]bool{}
+		for _, r := range e.Routes() {
+			registered[r.Method+" "+r.Path] = true
+		}
+
+		for _, r := range expense.Routes() {
+			assert.True(t, registered[r.Method+" "+r.Path], "%s %s is not registered", r.Method, r.Path)
+		}
+	})
+}
+
+func TestRouteAuthentication(t *testing.T) {
+	t.Run("Unknown route should returns status not found without credentials", func(t *testing.T) {
+		e, _ := setupApp(t)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/unknown", "")
+
+		// Assertions
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("Missing credentials should returns challenge", func(t *testing.T) {
+		e, _ := setupApp(t)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/expenses", "")
+
+		// Assertions
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, `Bearer realm="expenses"`, rec.Header().Get(echo.HeaderWWWAuthenticate))
+	})
+
+	t.Run("Missing credentials should returns Thai problem for Thai readers", func(t *testing.T) {
+		e, _ := setupApp(t)
+		req := httptest.NewRequest(http.MethodGet, "/expenses", nil)
+		req.Header.Set(locale.HeaderAcceptLanguage, "th")
+		rec := httptest.NewRecorder()
+
+		// Act
+		e.ServeHTTP(rec, req)
+		var p problem.Problem
+		json.Unmarshal(rec.Body.Bytes(), &p)
+
+		// Assertions
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, problem.Unauthorized, p.Code)
+		assert.Equal(t, "ไม่ได้ยืนยันตัวตน", p.Title)
+		assert.Equal(t, "ไม่พบข้อมูลยืนยันตัวตน", p.Detail)
+	})
+
+	t.Run("Wrong credentials should returns invalid_token challenge", func(t *testing.T) {
+		e, _ := setupApp(t)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/expenses", "wrong-token")
+
+		// Assertions
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Contains(t, rec.Header().Get(echo.HeaderWWWAuthenticate), `error="invalid_token"`)
+		assert.Contains(t, rec.Body.String(), "credentials are invalid")
+	})
+
+	t.Run("Rejected bearer token is described without its own text", func(t *testing.T) {
+		t.Parallel()
+		pub, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := newEcho()
+		expense.NewApp(e, NewTestStore(), testAuthToken,
+			expense.WithJWTVerifier(auth.NewVerifier(testKeys{"k1": pub}, auth.VerifierConfig{})))
+
+		tests := []struct {
+			name        string
+			token       string
+			description string
+			detail      string
+		}{
+			{
+				name:        "unsupported alg",
+				token:       signToken(t, `x", error="insufficient_scope`, key, map[string]interface{}{"sub": "u1"}),
+				description: `error_description="invalid signature"`,
+				detail:      "the bearer token signature is invalid",
+			},
+			{
+				name:        "expired",
+				token:       signToken(t, "EdDSA", key, map[string]interface{}{"sub": "u1", "exp": time.Now().Add(-time.Hour).Unix()}),
+				description: `error_description="token expired"`,
+				detail:      "the bearer token has expired",
+			},
+		}
+
+		for _, tt := range tests {
+			// Act
+			rec := serve(e, http.MethodGet, "/expenses", "Bearer "+tt.token)
+
+			var p problem.Problem
+			json.Unmarshal(rec.Body.Bytes(), &p)
+
+			// Assertions
+			assert.Equal(t, http.StatusUnauthorized, rec.Code, tt.name)
+			assert.Equal(t, `Bearer realm="expenses", error="invalid_token", `+tt.description, rec.Header().Get(echo.HeaderWWWAuthenticate), tt.name)
+			assert.Equal(t, tt.detail, p.Detail, tt.name)
+		}
+	})
+
+	t.Run("Flood of wrong credentials should returns status too many requests", func(t *testing.T) {
+		t.Parallel()
+		e := newEcho()
+		store := NewTestStore()
+		expense.NewApp(e, store, testAuthToken, expense.WithRateLimit(ratelimit.Config{
+			Store:  ratelimit.NewMemoryStore(),
+			Limits: map[ratelimit.Class]ratelimit.Limit{ratelimit.ClassRead: {Rate: 1, Burst: 2}},
+		}))
+		store.GetAllExpensesWillReturn([]*expense.Expense{}, nil)
+
+		// Act
+		first := serve(e, http.MethodGet, "/expenses", "wrong-token")
+		serve(e, http.MethodGet, "/expenses", "")
+		flood := serve(e, http.MethodGet, "/expenses", "wrong-token")
+		authenticated := serve(e, http.MethodGet, "/expenses", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusUnauthorized, first.Code)
+		assert.Equal(t, "1", first.Header().Get(ratelimit.HeaderRemaining))
+		assert.Equal(t, http.StatusTooManyRequests, flood.Code)
+		assert.Equal(t, http.StatusOK, authenticated.Code, "callers are limited by identity, not by the IP")
+	})
+
+	t.Run("Spoofed X-Forwarded-For does not reset the bucket of an IP", func(t *testing.T) {
+		t.Parallel()
+		limited := func(trustedProxies []string) *httptest.ResponseRecorder {
+			e := newEcho()
+			extractor, err := expense.IPExtractor(trustedProxies)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.IPExtractor = extractor
+			expense.NewApp(e, NewTestStore(), testAuthToken, expense.WithRateLimit(ratelimit.Config{
+				Store:  ratelimit.NewMemoryStore(),
+				Limits: map[ratelimit.Class]ratelimit.Limit{ratelimit.ClassRead: {Rate: 1, Burst: 2}},
+			}))
+
+			var rec *httptest.ResponseRecorder
+			for _, forged := range []string{"203.0.113.1", "203.0.113.2", "203.0.113.3"} {
+				req := httptest.NewRequest(http.MethodGet, "/expenses", nil)
+				req.Header.Set(echo.HeaderXForwardedFor, forged)
+				req.Header.Set(echo.HeaderXRealIP, forged)
+				rec = httptest.NewRecorder()
+				e.ServeHTTP(rec, req)
+			}
+			return rec
+		}
+
+		// Act
+		direct := limited(nil)
+		proxied := limited([]string{"192.0.2.1"})
+
+		// Assertions
+		assert.Equal(t, http.StatusTooManyRequests, direct.Code)
+		assert.Equal(t, http.StatusUnauthorized, proxied.Code, "a trusted proxy forwards distinct clients")
+	})
+
+	t.Run("Auth token should reach the handler", func(t *testing.T) {
+		e, store := setupApp(t)
+		store.GetAllExpensesWillReturn([]*expense.Expense{}, nil)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/expenses", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+}
+
+type testKeys auth.KeySet
+
+func (k testKeys) Keys() auth.KeySet {
+	return auth.KeySet(k)
+}
+
+// signToken returns a JWT of claims signed by key under kid k1
+func signToken(t *testing.T, alg string, key ed25519.PrivateKey, claims map[string]interface{}) string {
+	t.Helper()
+
+	h, _ := json.Marshal(map[string]string{"alg": alg, "kid": "k1", "typ": "JWT"})
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(ed25519.Sign(key, []byte(signed)))
+}
@@ -0,0 +1,226 @@
+package expense
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bazsup/assessment/authz"
+	"github.com/bazsup/assessment/problem"
+	"github.com/bazsup/assessment/stream"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/websocket"
+)
+
+// streamWriteTimeout drops a client that stopped reading its stream
+const streamWriteTimeout = 10 * time.Second
+
+// subscribe returns the error answered when a stream can't be opened,
+// along with a nil subscription. Events are filtered by the returned func.
+func (h *handler) subscribe(c echo.Context) (*stream.Subscription, func(stream.Event) bool, error) {
+	if h.hub == nil {
+		return nil, nil, problem.Localized(problem.NotConfigured, problem.DetailStreamingOff)
+	}
+
+	req := authz.Request{Action: ActionRead}
+	if id := IdentityFrom(c); id != nil {
+		req.Subject = id.Subject
+		req.Roles = id.Roles
+	}
+	if h.policy != nil && !h.policy.Grants(req.Subject, req.Roles, ActionRead) {
+		return nil, nil, problem.Localized(problem.Forbidden, problem.DetailForbidden, ActionRead)
+	}
+
+	lastID, err := lastEventID(c)
+	if err != nil {
+		return nil, nil, badQuery(err)
+	}
+	sub, err := h.hub.Subscribe(c.Request().Context(), lastID)
+	switch err {
+	case nil:
+	case stream.ErrTooFarBehind:
+		return nil, nil, problem.WrapLocalized(problem.EventsExpired, err, problem.DetailEventsExpired)
+	case stream.ErrClosed:
+		return nil, nil, problem.WrapLocalized(problem.ServiceUnavailable, err, problem.DetailStreamClosed)
+	default:
+		return nil, nil, problem.From(err)
+	}
+	return sub, h.readable(req), nil
+}
+
+// lastEventID is the id a client resumes after, from the Last-Event-ID
+// header browsers send on reconnect or the last_event_id query param, -1
+// when the client starts with new events
+func lastEventID(c echo.Context) (int64, error) {
+	v := c.Request().Header.Get("Last-Event-ID")
+	if q := c.QueryParam("last_event_id"); q != "" {
+		v = q
+	}
+	if v == "" {
+		return -1, nil
+	}
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || id < 0 {
+		return 0, queryParamError("last_event_id")
+	}
+	return id, nil
+}
+
+// readable filters events down to the expenses req may read, a deleted
+// expense is checked as it was before deletion
+func (h *handler) readable(req authz.Request) func(stream.Event) bool {
+	if h.policy == nil {
+		return func(stream.Event) bool { return true }
+	}
+	return func(ev stream.Event) bool {
+		var data ChangeData
+		if err := json.Unmarshal(ev.Data, &data); err != nil {
+			return false
+		}
+		exp := data.Expense
+		if exp == nil {
+			exp = data.Previous
+		}
+		if exp == nil {
+			return false
+		}
+		req.Resource = exp.attributes()
+		return h.policy.Evaluate(req).Allowed
+	}
+}
+
+// StreamExpenses pushes expense changes as server-sent events. A client
+// too slow to keep up is sent an overflow event and disconnected, it
+// resumes from the database when reconnecting with Last-Event-ID.
+func (h *handler) StreamExpenses(c echo.Context) error {
+	sub, allowed, err := h.subscribe(c)
+	if sub == nil {
+		return err
+	}
+	defer sub.Close()
+
+	res := c.Response()
+	rc := http.NewResponseController(res.Writer)
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	// keeps nginx from buffering the stream
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+
+	send := func(write func() error) bool {
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if err := write(); err != nil {
+			return false
+		}
+		res.Flush()
+		return true
+	}
+	event := func(ev stream.Event) func() error {
+		return func() error {
+			if !allowed(ev) {
+				return nil
+			}
+			return stream.WriteSSE(res, ev)
+		}
+	}
+
+	if !send(func() error { _, err := fmt.Fprint(res, "retry: 3000\n\n"); return err }) {
+		return nil
+	}
+	for _, ev := range sub.Backlog {
+		if !send(event(ev)) {
+			return nil
+		}
+	}
+
+	heartbeat := time.NewTicker(h.hub.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-heartbeat.C:
+			if !send(func() error { return stream.WriteHeartbeat(res) }) {
+				return nil
+			}
+		case ev, ok := <-sub.C:
+			if !ok {
+				if sub.Lagged() {
+					send(func() error { _, err := fmt.Fprint(res, "event: overflow\ndata: {}\n\n"); return err })
+				}
+				return nil
+			}
+			if !send(event(ev)) {
+				return nil
+			}
+		}
+	}
+}
+
+// streamMessage is a WebSocket message that is not an event
+type streamMessage struct {
+	Type string `json:"type"`
+}
+
+// StreamExpensesWS pushes expense changes as JSON WebSocket messages,
+// heartbeat and overflow messages mirror the SSE stream. Messages sent by
+// the client are ignored. Browsers can't send the Authorization header
+// with a WebSocket so it is meant for other clients.
+func (h *handler) StreamExpensesWS(c echo.Context) error {
+	sub, allowed, err := h.subscribe(c)
+	if sub == nil {
+		return err
+	}
+	defer sub.Close()
+
+	// the origin is not checked as the stream is authorized by header,
+	// which a cross site page can't set
+	websocket.Server{Handler: func(ws *websocket.Conn) {
+		defer ws.Close()
+		ctx, cancel := context.WithCancel(c.Request().Context())
+		defer cancel()
+		go func() {
+			defer cancel()
+			var msg []byte
+			for websocket.Message.Receive(ws, &msg) == nil {
+			}
+		}()
+
+		send := func(v interface{}) bool {
+			ws.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			return websocket.JSON.Send(ws, v) == nil
+		}
+		for _, ev := range sub.Backlog {
+			if allowed(ev) && !send(ev) {
+				return
+			}
+		}
+
+		heartbeat := time.NewTicker(h.hub.Heartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-heartbeat.C:
+				if !send(streamMessage{Type: "heartbeat"}) {
+					return
+				}
+			case ev, ok := <-sub.C:
+				if !ok {
+					if sub.Lagged() {
+						send(streamMessage{Type: "overflow"})
+					}
+					return
+				}
+				if allowed(ev) && !send(ev) {
+					return
+				}
+			}
+		}
+	}}.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
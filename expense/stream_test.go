@@ -0,0 +1,199 @@
+//go:build unit
+// +build unit
+
+package expense_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bazsup/assessment/authz"
+	"github.com/bazsup/assessment/expense"
+	"github.com/bazsup/assessment/stream"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
+)
+
+type streamSource []stream.Event
+
+func (s streamSource) After(ctx context.Context, after int64, limit int) ([]stream.Event, error) {
+	var events []stream.Event
+	for _, ev := range s {
+		if ev.ID > after && len(events) < limit {
+			events = append(events, ev)
+		}
+	}
+	return events, nil
+}
+
+func (s streamSource) Latest(ctx context.Context) (int64, error) {
+	return int64(len(s)), nil
+}
+
+func streamEvent(id int64, typ string, data expense.ChangeData) stream.Event {
+	raw, _ := json.Marshal(data)
+	exp := data.Expense
+	if exp == nil {
+		exp = data.Previous
+	}
+	return stream.Event{ID: id, Type: typ, ExpenseID: exp.ID, Data: raw}
+}
+
+// setupStream serves an app streaming the cheap, the expensive and then
+// the deleted cheap expense, with the policy doc when it is not empty
+func setupStream(t *testing.T, doc string) *httptest.Server {
+	t.Parallel()
+
+	cheap := &expense.Expense{ID: 1, Title: "cheap", Amount: 100}
+	expensive := &expense.Expense{ID: 2, Title: "expensive", Amount: 5000}
+	hub := stream.NewHub(streamSource{
+		streamEvent(1, "expense.created", expense.ChangeData{Expense: cheap}),
+		streamEvent(2, "expense.created", expense.ChangeData{Expense: expensive}),
+		streamEvent(3, "expense.deleted", expense.ChangeData{Previous: cheap}),
+	})
+	if err := hub.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := []expense.Option{expense.WithStream(hub)}
+	if doc != "" {
+		policy, err := authz.ParsePolicy([]byte(doc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts = append(opts, expense.WithPolicy(policy))
+	}
+	e := newEcho()
+	expense.NewApp(e, NewTestStore(), testAuthToken, opts...)
+	srv := httptest.NewServer(e)
+	t.Cleanup(func() {
+		hub.Close()
+		srv.Close()
+	})
+	return srv
+}
+
+const cheapReader = `{"roles": {"reader": {"permissions": [{"action": "expenses:read",
+	"conditions": [{"attribute": "amount", "operator": "lte", "value": 1000}]}]}},
+	"assignments": {"static-token": ["reader"]}}`
+
+// readSSE returns the ids of the first n events of the stream
+func readSSE(t *testing.T, url string, header http.Header, n int) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	req.Header = header
+	req.Header.Set(echo.HeaderAuthorization, testAuthToken)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	assert.Equal(t, "text/event-stream", res.Header.Get(echo.HeaderContentType))
+
+	var ids []string
+	scanner := bufio.NewScanner(res.Body)
+	for len(ids) < n && scanner.Scan() {
+		if id, ok := strings.CutPrefix(scanner.Text(), "id: "); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func TestStreamExpenses(t *testing.T) {
+	t.Run("Resumed stream replays the missed events", func(t *testing.T) {
+		srv := setupStream(t, "")
+
+		// Act
+		ids := readSSE(t, srv.URL+"/expenses/stream", http.Header{"Last-Event-Id": {"1"}}, 2)
+
+		// Assertions
+		assert.Equal(t, []string{"2", "3"}, ids)
+	})
+
+	t.Run("Stream only pushes the expenses the caller may read", func(t *testing.T) {
+		srv := setupStream(t, cheapReader)
+
+		// Act
+		ids := readSSE(t, srv.URL+"/expenses/stream?last_event_id=0", http.Header{}, 2)
+
+		// Assertions
+		assert.Equal(t, []string{"1", "3"}, ids)
+	})
+
+	t.Run("Caller without read permission should returns status forbidden", func(t *testing.T) {
+		srv := setupStream(t, `{"roles": {}}`)
+
+		// Act
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/expenses/stream", nil)
+		req.Header.Set(echo.HeaderAuthorization, testAuthToken)
+		res, err := http.DefaultClient.Do(req)
+
+		// Assertions
+		if assert.NoError(t, err) {
+			res.Body.Close()
+			assert.Equal(t, http.StatusForbidden, res.StatusCode)
+		}
+	})
+
+	t.Run("Invalid last event id should returns status bad request", func(t *testing.T) {
+		srv := setupStream(t, "")
+
+		// Act
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/expenses/stream?last_event_id=x", nil)
+		req.Header.Set(echo.HeaderAuthorization, testAuthToken)
+		res, err := http.DefaultClient.Do(req)
+
+		// Assertions
+		if assert.NoError(t, err) {
+			res.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+		}
+	})
+
+	t.Run("App without a stream should returns status not found", func(t *testing.T) {
+		e, _ := setupApp(t)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/expenses/stream", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestStreamExpensesWS(t *testing.T) {
+	t.Run("WebSocket stream sends events as JSON messages", func(t *testing.T) {
+		srv := setupStream(t, cheapReader)
+
+		// Arrange
+		cfg, _ := websocket.NewConfig("ws"+strings.TrimPrefix(srv.URL, "http")+"/expenses/stream/ws?last_event_id=0", srv.URL)
+		cfg.Header.Set(echo.HeaderAuthorization, testAuthToken)
+		ws, err := websocket.DialConfig(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ws.Close()
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		// Act
+		var first, second stream.Event
+		firstErr := websocket.JSON.Receive(ws, &first)
+		secondErr := websocket.JSON.Receive(ws, &second)
+
+		// Assertions
+		assert.NoError(t, firstErr)
+		assert.NoError(t, secondErr)
+		assert.Equal(t, int64(1), first.ID)
+		assert.Equal(t, int64(3), second.ID)
+		assert.Equal(t, "expense.deleted", second.Type)
+	})
+}
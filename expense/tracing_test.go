@@ -0,0 +1,131 @@
+//go:build unit
+// +build unit
+
+package expense_test
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bazsup/assessment/expense"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// setupTracer returns a provider recording every span it ends
+func setupTracer() (*sdktrace.TracerProvider, *tracetest.SpanRecorder) {
+	rec := tracetest.NewSpanRecorder()
+	return sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)), rec
+}
+
+func TestStoreTracing(t *testing.T) {
+	t.Run("Query span carries the SQL statement", func(t *testing.T) {
+		expStore, mock := setupDB(t)
+		tp, rec := setupTracer()
+		expStore.WithTracer(tp)
+
+		// Arrange
+		mock.ExpectPrepare("SELECT .+ FROM expenses WHERE id = .+").
+			ExpectQuery().WithArgs(1).WillReturnError(sql.ErrNoRows)
+		ctx, parent := tp.Tracer("test").Start(context.Background(), "GET /expenses/:id", trace.WithSpanKind(trace.SpanKindServer))
+
+		// Act
+		expStore.GetExpenseByID(ctx, 1)
+
+		// Assertions
+		if spans := rec.Ended(); assert.Len(t, spans, 1) {
+			s := spans[0]
+			assert.Equal(t, "ExpenseStore.GetExpenseByID", s.Name())
+			assert.Equal(t, parent.SpanContext().SpanID(), s.Parent().SpanID())
+			assert.Equal(t, trace.SpanKindClient, s.SpanKind())
+			assert.Contains(t, s.Attributes(), attribute.String("db.query.text", "SELECT id, title, amount, note, tags FROM expenses WHERE id = $1"))
+			assert.Equal(t, codes.Unset, s.Status().Code, "not found is not a failed query")
+		}
+	})
+
+	t.Run("Failed query marks the span as error", func(t *testing.T) {
+		expStore, mock := setupDB(t)
+		tp, rec := setupTracer()
+		expStore.WithTracer(tp)
+
+		// Arrange
+		mock.ExpectPrepare("UPDATE expenses").WillReturnError(sql.ErrConnDone)
+
+		// Act
+		expStore.UpdateExpense(context.Background(), expense.Expense{ID: 1})
+
+		// Assertions
+		if spans := rec.Ended(); assert.Len(t, spans, 1) {
+			assert.Equal(t, codes.Error, spans[0].Status().Code)
+		}
+	})
+}
+
+func TestAppTracing(t *testing.T) {
+	t.Run("Server span is the parent of the query spans", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tp, rec := setupTracer()
+
+		e := newEcho()
+		expense.NewApp(e, expense.NewExpenseStore(db).WithTracer(tp), testAuthToken, expense.WithTracer(tp))
+
+		// Arrange
+		mock.ExpectPrepare("SELECT .+ FROM expenses").
+			ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}))
+
+		// Act
+		res := serve(e, http.MethodGet, "/expenses", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusOK, res.Code)
+		if spans := rec.Ended(); assert.Len(t, spans, 2) {
+			query, server := spans[0], spans[1]
+			assert.Equal(t, "GET /expenses", server.Name())
+			assert.Equal(t, trace.SpanKindServer, server.SpanKind())
+			assert.Equal(t, "ExpenseStore.GetAllExpenses", query.Name())
+			assert.Equal(t, server.SpanContext().TraceID(), query.SpanContext().TraceID())
+			assert.Equal(t, server.SpanContext().SpanID(), query.Parent().SpanID())
+		}
+	})
+
+	t.Run("Incoming traceparent is continued", func(t *testing.T) {
+		tp, rec := setupTracer()
+		store := NewTestStore()
+		e := newEcho()
+		expense.NewApp(e, store, testAuthToken, expense.WithTracer(tp))
+
+		// Arrange
+		store.GetExpenseByIDWillReturn(nil, sql.ErrConnDone)
+		req := httptest.NewRequest(http.MethodGet, "/expenses/1", nil)
+		req.Header.Set(echo.HeaderAuthorization, testAuthToken)
+		req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		res := httptest.NewRecorder()
+
+		// Act
+		e.ServeHTTP(res, req)
+
+		// Assertions
+		assert.Equal(t, http.StatusInternalServerError, res.Code)
+		if spans := rec.Ended(); assert.Len(t, spans, 1) {
+			s := spans[0]
+			assert.Equal(t, "GET /expenses/:id", s.Name())
+			assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", s.SpanContext().TraceID().String())
+			assert.Equal(t, "00f067aa0ba902b7", s.Parent().SpanID().String())
+			assert.True(t, s.Parent().IsRemote())
+			assert.Equal(t, codes.Error, s.Status().Code)
+			assert.Contains(t, s.Attributes(), attribute.Int("http.response.status_code", http.StatusInternalServerError))
+		}
+	})
+}
@@ -1,25 +1,33 @@
 package expense
 
 import (
+	"database/sql"
+	"log/slog"
 	"net/http"
 	"strconv"
 
+	"github.com/bazsup/assessment/problem"
 	"github.com/bazsup/assessment/router"
 )
 
 func UpdateExpense(c router.RouterCtx, store storer) error {
 	var exp Expense
 	if err := c.Bind(&exp); err != nil {
-		return c.JSON(http.StatusBadRequest, Err{Message: err.Error()})
+		return bindError(err)
 	}
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		return c.JSON(http.StatusNotFound, Err{Message: "expense not found"})
+		return problem.New(problem.ExpenseNotFound, "")
 	}
 	exp.ID = id
 
-	if err = store.UpdateExpense(exp); err != nil {
-		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+	err = store.UpdateExpense(c.Request().Context(), exp)
+	if err == sql.ErrNoRows {
+		return problem.New(problem.ExpenseNotFound, "")
 	}
+	if err != nil {
+		return problem.From(err)
+	}
+	slog.DebugContext(c.Request().Context(), "expense updated", "expense", exp)
 	return c.JSON(http.StatusOK, exp)
 }
@@ -5,11 +5,13 @@ package expense_test
 
 import (
 	"bytes"
+	"database/sql"
 	"fmt"
 	"net/http"
 	"testing"
 
 	"github.com/bazsup/assessment/expense"
+	"github.com/bazsup/assessment/problem"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -66,15 +68,8 @@ func TestUpdateExpense(t *testing.T) {
 		ctx.SetReqBody(reqBody)
 		err := expense.UpdateExpense(ctx, store)
 
-		var errRes expense.Err
-		ctx.DecodeResponse(&errRes)
-
 		// Assertions
-		if assert.NoError(t, err) {
-			assert.Equal(t, http.StatusBadRequest, ctx.status)
-
-			assert.NotEmpty(t, errRes.Message)
-		}
+		assertProblem(t, err, problem.BadRequest)
 	})
 
 	t.Run("Invalid expense id param should returns status not found", func(t *testing.T) {
@@ -93,15 +88,8 @@ func TestUpdateExpense(t *testing.T) {
 		ctx.SetReqBody(reqBody)
 		err := expense.UpdateExpense(ctx, store)
 
-		var errRes expense.Err
-		ctx.DecodeResponse(&errRes)
-
 		// Assertions
-		if assert.NoError(t, err) {
-			assert.Equal(t, http.StatusNotFound, ctx.status)
-
-			assert.Equal(t, "expense not found", errRes.Message)
-		}
+		assertProblem(t, err, problem.ExpenseNotFound)
 	})
 
 	t.Run("Update Expense Fail should returns status internal server error", func(t *testing.T) {
@@ -122,15 +110,23 @@ func TestUpdateExpense(t *testing.T) {
 		ctx.SetReqBody(reqBody)
 		err := expense.UpdateExpense(ctx, store)
 
-		var errRes expense.Err
-		ctx.DecodeResponse(&errRes)
-
 		// Assertions
-		if assert.NoError(t, err) {
-			assert.Equal(t, http.StatusInternalServerError, ctx.status)
+		assertProblem(t, err, problem.Internal)
+	})
 
-			assert.NotEmpty(t, errRes.Message)
-		}
+	t.Run("Missing expense should returns status not found", func(t *testing.T) {
+		ctx, store := setupExpense(t)
+
+		// Arrange
+		store.UpdateExpenseWillReturn(sql.ErrNoRows)
+
+		// Act
+		ctx.SetParam("1")
+		ctx.SetReqBody(bytes.NewBufferString(`{"title": "updated-title"}`))
+		err := expense.UpdateExpense(ctx, store)
+
+		// Assertions
+		assertProblem(t, err, problem.ExpenseNotFound)
 	})
 
 }
@@ -0,0 +1,66 @@
+package expense
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/bazsup/assessment/openapi"
+	"github.com/bazsup/assessment/problem"
+	"github.com/labstack/echo/v4"
+)
+
+// validationFailed is the message of a request failing validation
+const validationFailed = "request validation failed"
+
+// validateRequest rejects requests whose params or body don't match op of
+// the OpenAPI document, answering every field error at once
+func validateRequest(doc *openapi.Document, op *openapi.Operation) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			var body []byte
+			if op.RequestBody != nil && req.Body != nil {
+				var err error
+				if body, err = io.ReadAll(req.Body); err != nil {
+					return problem.BodyError(err)
+				}
+				req.Body = io.NopCloser(bytes.NewReader(body))
+			}
+
+			if errs := doc.ValidateRequest(op, req, body); len(errs) > 0 {
+				return problem.Invalid(errs)
+			}
+			return next(c)
+		}
+	}
+}
+
+// validateExpense checks an expense created or updated through another API
+// than REST against the body schema of the REST requests
+func validateExpense(exp Expense) []openapi.FieldError {
+	if exp.Tags == nil {
+		exp.Tags = []string{}
+	}
+	b, err := json.Marshal(exp)
+	if err != nil {
+		return []openapi.FieldError{{In: openapi.InBody, Rule: "json", Message: err.Error()}}
+	}
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return []openapi.FieldError{{In: openapi.InBody, Rule: "json", Message: err.Error()}}
+	}
+	return openapi.Validate(expenseInput, v, openapi.InBody, "")
+}
+
+// fieldErrors words field errors on one line, as in "title: is required"
+func fieldErrors(errs []openapi.FieldError) string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Field + ": " + e.Message
+	}
+	return strings.Join(msgs, "; ")
+}
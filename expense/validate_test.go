@@ -0,0 +1,236 @@
+//go:build unit
+// +build unit
+
+package expense_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bazsup/assessment/expense"
+	"github.com/bazsup/assessment/expensepb"
+	"github.com/bazsup/assessment/openapi"
+	"github.com/bazsup/assessment/problem"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func serveJSON(e *echo.Echo, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, testAuthToken)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+// fieldRules maps the fields of a validation error to their failed rule
+func fieldRules(t *testing.T, rec *httptest.ResponseRecorder) map[string]string {
+	var body problem.Problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("can't decode %q: %v", rec.Body.String(), err)
+	}
+	rules := map[string]string{}
+	for _, e := range body.Errors {
+		rules[e.In+" "+e.Field] = e.Rule
+	}
+	return rules
+}
+
+func TestValidateRequest(t *testing.T) {
+	t.Run("Empty expense should returns status bad request with every field error", func(t *testing.T) {
+		e, _ := setupApp(t)
+
+		// Act
+		rec := serveJSON(e, http.MethodPost, "/expenses", `{}`)
+
+		// Assertions
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, map[string]string{"body title": "required", "body amount": "required"}, fieldRules(t, rec))
+	})
+
+	t.Run("Invalid fields are reported together", func(t *testing.T) {
+		e, _ := setupApp(t)
+
+		// Act
+		rec := serveJSON(e, http.MethodPut, "/expenses/1", `{
+			"title": "",
+			"amount": -10,
+			"note": "`+strings.Repeat("n", expense.MaxNoteLength+1)+`",
+			"tags": ["food", "bad tag", ""],
+			"currency": "THB"
+		}`)
+
+		// Assertions
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, map[string]string{
+			"body title":    "minLength",
+			"body amount":   "exclusiveMinimum",
+			"body note":     "maxLength",
+			"body tags[1]":  "pattern",
+			"body tags[2]":  "minLength",
+			"body currency": "additionalProperties",
+		}, fieldRules(t, rec))
+	})
+
+	t.Run("Too many tags should returns status bad request", func(t *testing.T) {
+		e, _ := setupApp(t)
+
+		// Arrange
+		tags := make([]string, expense.MaxTags+1)
+		for i := range tags {
+			tags[i] = "tag"
+		}
+		body, _ := json.Marshal(map[string]interface{}{"title": "coffee", "amount": 60, "tags": tags})
+
+		// Act
+		rec := serveJSON(e, http.MethodPost, "/expenses", string(body))
+
+		// Assertions
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, map[string]string{"body tags": "maxItems"}, fieldRules(t, rec))
+	})
+
+	t.Run("Malformed JSON should returns status bad request", func(t *testing.T) {
+		e, _ := setupApp(t)
+
+		// Act
+		rec := serveJSON(e, http.MethodPost, "/expenses", `{"title":`)
+
+		// Assertions
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, map[string]string{"body ": "json"}, fieldRules(t, rec))
+	})
+
+	t.Run("Body past the limit should returns status request entity too large", func(t *testing.T) {
+		e, _ := setupApp(t)
+
+		// Arrange
+		e.Use(middleware.BodyLimit("1K"))
+
+		// Act
+		rec := serveJSON(e, http.MethodPost, "/expenses", `{"title": "food", "amount": 50, "note": "`+strings.Repeat("n", 2048)+`"}`)
+
+		// Assertions
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+		assert.Contains(t, rec.Body.String(), string(problem.PayloadTooLarge))
+	})
+
+	t.Run("Valid expense with Thai tags is created", func(t *testing.T) {
+		e, store := setupApp(t)
+
+		// Arrange
+		store.CreateExpenseWillReturn(1, nil)
+
+		// Act
+		rec := serveJSON(e, http.MethodPost, "/expenses", `{"title": "ข้าวมันไก่", "amount": 50, "tags": ["อาหาร", "food_court", "ค่า-เดินทาง"]}`)
+
+		// Assertions
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	})
+
+	t.Run("Invalid query params should returns status bad request", func(t *testing.T) {
+		e, _ := setupApp(t)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/admin/audit?limit=0&since=yesterday&action=rename&expense_id=x", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, map[string]string{
+			"query limit":      "minimum",
+			"query since":      "format",
+			"query action":     "enum",
+			"query expense_id": "type",
+		}, fieldRules(t, rec))
+	})
+
+	t.Run("Missing required query param should returns status bad request", func(t *testing.T) {
+		e, _ := setupApp(t)
+
+		// Act
+		rec := serve(e, http.MethodPost, "/expenses/1/revert", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, map[string]string{"query version": "required"}, fieldRules(t, rec))
+	})
+
+	t.Run("Credentials are checked before validation", func(t *testing.T) {
+		e, _ := setupApp(t)
+
+		// Act
+		rec := serve(e, http.MethodPost, "/expenses", "")
+
+		// Assertions
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
+
+func TestValidateGRPC(t *testing.T) {
+	t.Run("Invalid expense should returns invalid argument with field violations", func(t *testing.T) {
+		client, _ := setupGRPC(t)
+
+		// Act
+		_, err := client.CreateExpense(authorized(), &expensepb.CreateExpenseRequest{Amount: -1})
+
+		// Assertions
+		st := status.Convert(err)
+		assert.Equal(t, codes.InvalidArgument, st.Code())
+		if assert.Len(t, st.Details(), 1) {
+			br := st.Details()[0].(*errdetails.BadRequest)
+			var fields []string
+			for _, v := range br.FieldViolations {
+				fields = append(fields, v.Field)
+			}
+			assert.ElementsMatch(t, []string{"title", "amount"}, fields)
+		}
+	})
+
+	t.Run("Update with an invalid expense should returns invalid argument", func(t *testing.T) {
+		client, _ := setupGRPC(t)
+
+		// Act
+		_, err := client.UpdateExpense(authorized(), &expensepb.UpdateExpenseRequest{
+			Expense: &expensepb.Expense{Id: 1, Title: "coffee", Amount: 60, Tags: []string{"not a tag"}},
+		})
+
+		// Assertions
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
+func TestValidateGraphQL(t *testing.T) {
+	t.Run("Invalid input lists the field errors in the extensions", func(t *testing.T) {
+		e, _ := setupGraphQL(t)
+
+		// Act
+		rec := postGraphQL(e, `mutation { createExpense(input: {title: "", amount: 0}) { id } }`, nil)
+
+		var res struct {
+			Errors []struct {
+				Message    string
+				Extensions struct {
+					Code   problem.Code
+					Errors []openapi.FieldError
+				}
+			}
+		}
+		json.Unmarshal(rec.Body.Bytes(), &res)
+
+		// Assertions
+		assert.Equal(t, http.StatusOK, rec.Code)
+		if assert.Len(t, res.Errors, 1) {
+			assert.Contains(t, res.Errors[0].Message, "title: must not be empty")
+			assert.Equal(t, problem.ValidationFailed, res.Errors[0].Extensions.Code)
+			assert.Len(t, res.Errors[0].Extensions.Errors, 2)
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package expense
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bazsup/assessment/problem"
+	"github.com/bazsup/assessment/router"
+	"github.com/labstack/echo/v4"
+)
+
+// Version is the expense as it was from ValidFrom until ValidTo, ValidTo is
+// nil for the current version
+type Version struct {
+	Version int `json:"version"`
+	Expense
+	ValidFrom time.Time  `json:"valid_from"`
+	ValidTo   *time.Time `json:"valid_to"`
+}
+
+// parseAsOf reads the as_of query param, ok is false when it is absent
+func parseAsOf(c router.RouterCtx) (at time.Time, ok bool, err error) {
+	v := c.QueryParam("as_of")
+	if v == "" {
+		return time.Time{}, false, nil
+	}
+	at, err = time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return time.Time{}, false, queryParamError("as_of")
+	}
+	return at, true, nil
+}
+
+// ExpenseVersions lists every version of the expense, the ones a revert can restore
+func (h *handler) ExpenseVersions(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return problem.New(problem.ExpenseNotFound, "")
+	}
+
+	versions, err := h.store.ExpenseVersions(c.Request().Context(), id)
+	if err != nil {
+		return problem.From(err)
+	}
+	if len(versions) == 0 {
+		return problem.New(problem.ExpenseNotFound, "")
+	}
+	return c.JSON(http.StatusOK, versions)
+}
+
+func (h *handler) RevertExpense(c echo.Context) error {
+	return RevertExpenseHandler(c, h.store)
+}
+
+// RevertExpenseHandler restores the version query param of the expense as
+// a new change, deleted expenses included
+func RevertExpenseHandler(c router.RouterCtx, store storer) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return problem.New(problem.ExpenseNotFound, "")
+	}
+	version, err := strconv.Atoi(c.QueryParam("version"))
+	if err != nil || version < 1 {
+		return badQuery(queryParamError("version"))
+	}
+
+	exp, err := store.RevertExpense(c.Request().Context(), id, version)
+	switch err {
+	case nil:
+		return c.JSON(http.StatusOK, exp)
+	case sql.ErrNoRows:
+		return problem.New(problem.VersionNotFound, "")
+	default:
+		return problem.From(err)
+	}
+}
@@ -0,0 +1,209 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/bazsup/assessment/audit"
+	"github.com/bazsup/assessment/retry"
+	"github.com/lib/pq"
+)
+
+const (
+	closeVersionQuery = "UPDATE expense_versions SET valid_to = $2 WHERE expense_id = $1 AND valid_to IS NULL"
+	openVersionQuery  = `
+	INSERT INTO expense_versions ( expense_id, version, title, amount, note, tags, valid_from )
+	SELECT $1, COALESCE(max(version), 0) + 1, $2, $3, $4, $5, $6 FROM expense_versions WHERE expense_id = $1
+	`
+	versionColumns       = "expense_id, title, amount, note, tags"
+	expenseAsOfQuery     = "SELECT " + versionColumns + " FROM expense_versions WHERE expense_id = $1 AND valid_from <= $2 AND ( valid_to IS NULL OR valid_to > $2 )"
+	allExpensesAsOfQuery = "SELECT " + versionColumns + " FROM expense_versions WHERE valid_from <= $1 AND ( valid_to IS NULL OR valid_to > $1 ) ORDER BY expense_id"
+	expenseVersionsQuery = "SELECT version, " + versionColumns + ", valid_from, valid_to FROM expense_versions WHERE expense_id = $1 ORDER BY version"
+	expenseVersionQuery  = "SELECT " + versionColumns + " FROM expense_versions WHERE expense_id = $1 AND version = $2"
+	restoreExpenseQuery  = "INSERT INTO expenses ( id, title, amount, note, tags ) VALUES ( $1, $2, $3, $4, $5 )"
+)
+
+// recordVersion closes the current version of the expense at at and opens
+// the next one holding exp, a nil exp only closes it as the expense is gone.
+// Writers of an existing expense hold its row lock, so versions of one
+// expense are never opened concurrently.
+func recordVersion(ctx context.Context, tx *sql.Tx, id int, exp *Expense, at time.Time) error {
+	if _, err := tx.ExecContext(ctx, closeVersionQuery, id, at); err != nil {
+		return fmt.Errorf("can't close expense version: %s", err.Error())
+	}
+	if exp == nil {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, openVersionQuery, id, exp.Title, exp.Amount, exp.Note, pq.Array(exp.Tags), at)
+	if err != nil {
+		return fmt.Errorf("can't open expense version: %s", err.Error())
+	}
+	return nil
+}
+
+// versionTime is when a change made now takes effect, at the precision
+// Postgres keeps so as_of reads match the times they are given
+func versionTime() time.Time {
+	return audit.Timestamp(time.Now())
+}
+
+func (e *ExpenseStore) GetExpenseAsOf(ctx context.Context, id int, at time.Time) (_ *Expense, err error) {
+	ctx, span := e.span(ctx, "GetExpenseAsOf", expenseAsOfQuery)
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, e.readTimeout)
+	defer cancel()
+
+	stmt, err := e.stmt(ctx, expenseAsOfQuery)
+	if err != nil {
+		return nil, fmt.Errorf("can't prepare query expense version statement: %s", err.Error())
+	}
+
+	var exp *Expense
+	err = retry.Do(ctx, e.readPolicy(ctx), IsTransient, func(int) error {
+		exp, err = scanExpense(stmt.QueryRowContext(ctx, id, at))
+		return err
+	})
+	if err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	return exp, nil
+}
+
+func (e *ExpenseStore) GetAllExpensesAsOf(ctx context.Context, at time.Time) (_ []*Expense, err error) {
+	ctx, span := e.span(ctx, "GetAllExpensesAsOf", allExpensesAsOfQuery)
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, e.readTimeout)
+	defer cancel()
+
+	stmt, err := e.stmt(ctx, allExpensesAsOfQuery)
+	if err != nil {
+		return nil, fmt.Errorf("can't prepare query expense versions statement: %s", err.Error())
+	}
+
+	var expenses []*Expense
+	err = retry.Do(ctx, e.readPolicy(ctx), IsTransient, func(int) error {
+		expenses, err = queryExpenses(ctx, stmt, at)
+		return err
+	})
+	if err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	return expenses, nil
+}
+
+func (e *ExpenseStore) ExpenseVersions(ctx context.Context, id int) (_ []Version, err error) {
+	ctx, span := e.span(ctx, "ExpenseVersions", expenseVersionsQuery)
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, e.readTimeout)
+	defer cancel()
+
+	stmt, err := e.stmt(ctx, expenseVersionsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("can't prepare query expense versions statement: %s", err.Error())
+	}
+
+	var versions []Version
+	err = retry.Do(ctx, e.readPolicy(ctx), IsTransient, func(int) error {
+		versions, err = queryVersions(ctx, stmt, id)
+		return err
+	})
+	if err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	return versions, nil
+}
+
+func queryVersions(ctx context.Context, stmt *sql.Stmt, id int) ([]Version, error) {
+	rows, err := stmt.QueryContext(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return scanVersions(rows)
+}
+
+// scanVersions reads and closes rows of versions
+func scanVersions(rows *sql.Rows) ([]Version, error) {
+	defer rows.Close()
+
+	versions := []Version{}
+	for rows.Next() {
+		var v Version
+		err := rows.Scan(&v.Version, &v.ID, &v.Title, &v.Amount, &v.Note, pq.Array(&v.Tags), &v.ValidFrom, &v.ValidTo)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// RevertExpense restores version of the expense as a new version, bringing
+// a deleted expense back under its id. The version is read inside the
+// transaction so the restored content is the one audited.
+func (e *ExpenseStore) RevertExpense(ctx context.Context, id, version int) (_ *Expense, err error) {
+	ctx, span := e.span(ctx, "RevertExpense", expenseVersionQuery)
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, e.writeTimeout)
+	defer cancel()
+
+	get, err := e.stmt(ctx, expenseVersionQuery)
+	if err != nil {
+		return nil, fmt.Errorf("can't prepare query expense version statement: %s", err.Error())
+	}
+	lock, err := e.stmt(ctx, lockExpenseQuery)
+	if err != nil {
+		return nil, fmt.Errorf("can't prepare lock expense statement: %s", err.Error())
+	}
+	update, err := e.stmt(ctx, updateExpenseQuery)
+	if err != nil {
+		return nil, fmt.Errorf("can't prepare update expense statement: %s", err.Error())
+	}
+	restore, err := e.stmt(ctx, restoreExpenseQuery)
+	if err != nil {
+		return nil, fmt.Errorf("can't prepare restore expense statement: %s", err.Error())
+	}
+	auditStmt, err := e.auditStmt(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var exp *Expense
+	err = e.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := scanExpense(tx.StmtContext(ctx, lock).QueryRowContext(ctx, id))
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		exp, err = scanExpense(tx.StmtContext(ctx, get).QueryRowContext(ctx, id, version))
+		if err != nil {
+			return err
+		}
+
+		args := []interface{}{id, exp.Title, exp.Amount, exp.Note, pq.Array(exp.Tags)}
+		if before != nil {
+			_, err = tx.StmtContext(ctx, update).ExecContext(ctx, args...)
+		} else {
+			_, err = tx.StmtContext(ctx, restore).ExecContext(ctx, args...)
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := recordVersion(ctx, tx, id, exp, versionTime()); err != nil {
+			return err
+		}
+		return recordChange(ctx, tx, auditStmt, id, AuditRevert, before, exp)
+	})
+	if err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	return exp, nil
+}
@@ -0,0 +1,320 @@
+//go:build unit
+// +build unit
+
+package expense_test
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bazsup/assessment/expense"
+	"github.com/bazsup/assessment/locale"
+	"github.com/bazsup/assessment/problem"
+	"github.com/bazsup/assessment/webhook"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+var versionRows = []string{"expense_id", "title", "amount", "note", "tags"}
+
+func TestGetExpenseAsOf(t *testing.T) {
+	t.Run("as_of reads the expense as it was then", func(t *testing.T) {
+		ctx, store := setupExpense(t)
+
+		// Arrange
+		ctx.SetParam("1")
+		ctx.SetQuery("as_of=2026-10-01T09:30:00%2B07:00")
+		store.GetExpenseByIDWillReturn(&expense.Expense{ID: 1, Title: "old-title"}, nil)
+
+		// Act
+		err := expense.GetOneByIDHandler(ctx, store)
+
+		var exp expense.Expense
+		ctx.DecodeResponse(&exp)
+
+		// Assertions
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusOK, ctx.status)
+			assert.Equal(t, "old-title", exp.Title)
+			assert.True(t, time.Date(2026, 10, 1, 2, 30, 0, 0, time.UTC).Equal(store.asOf))
+		}
+	})
+
+	t.Run("Expense missing at as_of should returns status not found", func(t *testing.T) {
+		ctx, store := setupExpense(t)
+
+		// Arrange
+		ctx.SetParam("1")
+		ctx.SetQuery("as_of=2020-01-01T00:00:00Z")
+		store.GetExpenseByIDWillReturn(nil, sql.ErrNoRows)
+
+		// Act
+		err := expense.GetOneByIDHandler(ctx, store)
+
+		// Assertions
+		assertProblem(t, err, problem.ExpenseNotFound)
+	})
+
+	t.Run("List as_of is a snapshot of every expense then", func(t *testing.T) {
+		ctx, store := setupExpense(t)
+
+		// Arrange
+		ctx.SetQuery("as_of=2026-10-01T00:00:00Z")
+		store.GetAllExpensesWillReturn([]*expense.Expense{{ID: 1}, {ID: 3}}, nil)
+
+		// Act
+		err := expense.GetAllExpensesHandler(ctx, store)
+
+		var exps []expense.Expense
+		ctx.DecodeResponse(&exps)
+
+		// Assertions
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusOK, ctx.status)
+			assert.Len(t, exps, 2)
+			assert.Equal(t, time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), store.asOf)
+		}
+	})
+
+	t.Run("Malformed as_of should returns status bad request", func(t *testing.T) {
+		e, _ := setupApp(t)
+
+		for _, path := range []string{"/expenses?as_of=yesterday", "/expenses/1?as_of=2026-10-01"} {
+			// Act
+			rec := serve(e, http.MethodGet, path, testAuthToken)
+
+			// Assertions
+			assert.Equal(t, http.StatusBadRequest, rec.Code, path)
+		}
+	})
+
+	t.Run("Malformed as_of should returns Thai problem for Thai readers", func(t *testing.T) {
+		ctx, store := setupExpense(t)
+
+		// Arrange
+		ctx.SetQuery("as_of=yesterday")
+		ctx.Request().Header.Set(locale.HeaderAcceptLanguage, "th")
+
+		// Act
+		err := expense.GetAllExpensesHandler(ctx, store)
+
+		// Assertions
+		if assertProblem(t, err, problem.BadRequest) {
+			p := problem.From(err).Document(ctx.Request())
+			assert.Equal(t, "คำขอไม่ถูกต้อง", p.Title)
+			assert.Equal(t, "พารามิเตอร์ as_of ในคำขอไม่ถูกต้อง", p.Detail)
+		}
+	})
+}
+
+func TestExpenseVersions(t *testing.T) {
+	t.Run("Versions of the expense", func(t *testing.T) {
+		e, store := setupApp(t)
+
+		// Arrange
+		validTo := time.Date(2026, 10, 2, 0, 0, 0, 0, time.UTC)
+		store.ExpenseVersionsWillReturn([]expense.Version{
+			{Version: 1, Expense: expense.Expense{ID: 1, Title: "old-title"}, ValidTo: &validTo},
+			{Version: 2, Expense: expense.Expense{ID: 1, Title: "new-title"}},
+		}, nil)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/expenses/1/versions", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"version":1,"id":1,"title":"old-title"`)
+		assert.Contains(t, rec.Body.String(), `"valid_to":null`)
+	})
+
+	t.Run("Expense never stored should returns status not found", func(t *testing.T) {
+		e, store := setupApp(t)
+
+		// Arrange
+		store.ExpenseVersionsWillReturn([]expense.Version{}, nil)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/expenses/1/versions", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestRevertExpense(t *testing.T) {
+	t.Run("Revert answers the restored expense", func(t *testing.T) {
+		ctx, store := setupExpense(t)
+
+		// Arrange
+		ctx.SetParam("1")
+		ctx.SetQuery("version=1")
+		store.RevertExpenseWillReturn(&expense.Expense{ID: 1, Title: "old-title"}, nil)
+
+		// Act
+		err := expense.RevertExpenseHandler(ctx, store)
+
+		var exp expense.Expense
+		ctx.DecodeResponse(&exp)
+
+		// Assertions
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusOK, ctx.status)
+			assert.Equal(t, "old-title", exp.Title)
+		}
+	})
+
+	tests := []struct {
+		name  string
+		query string
+		err   error
+		code  problem.Code
+	}{
+		{"Missing version should returns status bad request", "", nil, problem.BadRequest},
+		{"Version zero should returns status bad request", "version=0", nil, problem.BadRequest},
+		{"Unknown version should returns status not found", "version=9", sql.ErrNoRows, problem.VersionNotFound},
+		{"Timed out revert should returns status service unavailable", "version=1", context.DeadlineExceeded, problem.DatabaseTimeout},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, store := setupExpense(t)
+
+			// Arrange
+			ctx.SetParam("1")
+			ctx.SetQuery(tt.query)
+			store.RevertExpenseWillReturn(nil, tt.err)
+
+			// Act
+			err := expense.RevertExpenseHandler(ctx, store)
+
+			// Assertions
+			assertProblem(t, err, tt.code)
+		})
+	}
+}
+
+func TestDBExpenseAsOf(t *testing.T) {
+	at := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("Expense as of reads the version valid then", func(t *testing.T) {
+		expStore, mock := setupDB(t)
+
+		// Arrange
+		mock.ExpectPrepare("SELECT .+ FROM expense_versions WHERE expense_id = .+ AND valid_from <= .+ AND .+ valid_to > ").
+			ExpectQuery().WithArgs(1, at).
+			WillReturnRows(sqlmock.NewRows(versionRows).AddRow(1, "old-title", 10, "", "{}"))
+
+		// Act
+		exp, err := expStore.GetExpenseAsOf(context.Background(), 1, at)
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.Equal(t, "old-title", exp.Title)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Expenses as of read every version valid then", func(t *testing.T) {
+		expStore, mock := setupDB(t)
+
+		// Arrange
+		mock.ExpectPrepare("SELECT .+ FROM expense_versions WHERE valid_from <= .+ ORDER BY expense_id").
+			ExpectQuery().WithArgs(at).
+			WillReturnRows(sqlmock.NewRows(versionRows).AddRow(1, "a", 10, "", "{}").AddRow(2, "b", 20, "", "{}"))
+
+		// Act
+		exps, err := expStore.GetAllExpensesAsOf(context.Background(), at)
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.Len(t, exps, 2)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDBRevertExpense(t *testing.T) {
+	tags := []string{"food"}
+
+	t.Run("Revert updates the expense to the version as a new version", func(t *testing.T) {
+		expStore, mock := setupDB(t)
+
+		// Arrange
+		get := mock.ExpectPrepare("SELECT .+ FROM expense_versions WHERE expense_id = .+ AND version = ")
+		lock := mock.ExpectPrepare("SELECT .+ FOR UPDATE")
+		update := mock.ExpectPrepare("UPDATE expenses")
+		mock.ExpectPrepare("INSERT INTO expenses")
+		audit := mock.ExpectPrepare("INSERT INTO expense_audit")
+		mock.ExpectBegin()
+		lock.ExpectQuery().WithArgs(1).WillReturnRows(sqlmock.NewRows(versionRows).AddRow(1, "new-title", 20, "", pq.Array(tags)))
+		get.ExpectQuery().WithArgs(1, 1).WillReturnRows(sqlmock.NewRows(versionRows).AddRow(1, "old-title", 20, "", pq.Array(tags)))
+		update.ExpectExec().WithArgs(1, "old-title", 20.0, "", pq.Array(tags)).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectVersion(mock, 1, true)
+		expectChainHead(mock, "")
+		audit.ExpectExec().
+			WithArgs(1, expense.AuditRevert, "system", "", "", []byte(`{"title":{"before":"new-title","after":"old-title"}}`),
+				sqlmock.AnyArg(), "", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		expectOutbox(mock, webhook.EventExpenseReverted)
+		mock.ExpectCommit()
+
+		// Act
+		exp, err := expStore.RevertExpense(context.Background(), 1, 1)
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.Equal(t, &expense.Expense{ID: 1, Title: "old-title", Amount: 20, Note: "", Tags: tags}, exp)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Revert of a deleted expense restores it under its id", func(t *testing.T) {
+		expStore, mock := setupDB(t)
+
+		// Arrange
+		get := mock.ExpectPrepare("SELECT .+ FROM expense_versions WHERE expense_id = .+ AND version = ")
+		lock := mock.ExpectPrepare("SELECT .+ FOR UPDATE")
+		mock.ExpectPrepare("UPDATE expenses")
+		restore := mock.ExpectPrepare("INSERT INTO expenses")
+		audit := mock.ExpectPrepare("INSERT INTO expense_audit")
+		mock.ExpectBegin()
+		lock.ExpectQuery().WithArgs(1).WillReturnError(sql.ErrNoRows)
+		get.ExpectQuery().WithArgs(1, 2).WillReturnRows(sqlmock.NewRows(versionRows).AddRow(1, "title", 20, "", "{}"))
+		restore.ExpectExec().WithArgs(1, "title", 20.0, "", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectVersion(mock, 1, true)
+		expectChainHead(mock, "")
+		audit.ExpectExec().WillReturnResult(sqlmock.NewResult(1, 1))
+		expectOutbox(mock, webhook.EventExpenseReverted)
+		mock.ExpectCommit()
+
+		// Act
+		_, err := expStore.RevertExpense(context.Background(), 1, 2)
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Unknown version rolls back", func(t *testing.T) {
+		expStore, mock := setupDB(t)
+
+		// Arrange
+		get := mock.ExpectPrepare("SELECT .+ FROM expense_versions")
+		lock := mock.ExpectPrepare("SELECT .+ FOR UPDATE")
+		mock.ExpectPrepare("UPDATE expenses")
+		mock.ExpectPrepare("INSERT INTO expenses")
+		mock.ExpectPrepare("INSERT INTO expense_audit")
+		mock.ExpectBegin()
+		lock.ExpectQuery().WillReturnError(sql.ErrNoRows)
+		get.ExpectQuery().WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		// Act
+		_, err := expStore.RevertExpense(context.Background(), 1, 9)
+
+		// Assertions
+		assert.Equal(t, sql.ErrNoRows, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,153 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bazsup/assessment/problem"
+	"github.com/bazsup/assessment/webhook"
+	"github.com/labstack/echo/v4"
+)
+
+// webhookAdmin manages webhook subscriptions and reads their delivery log
+type webhookAdmin interface {
+	CreateSubscription(ctx context.Context, sub webhook.Subscription) (*webhook.Subscription, error)
+	Subscriptions(ctx context.Context) ([]webhook.Subscription, error)
+	DeleteSubscription(ctx context.Context, id int64) error
+	Deliveries(ctx context.Context, q webhook.DeliveryQuery) ([]webhook.Delivery, error)
+	Redeliver(ctx context.Context, id int64, now time.Time) error
+}
+
+// webhooksOff answers webhook routes of an app built without WithWebhooks
+func webhooksOff() error {
+	return problem.Localized(problem.NotConfigured, problem.DetailWebhooksOff)
+}
+
+// CreateWebhook subscribes a URL to expense events, the answer is the only
+// time the signing secret is shown
+func (h *handler) CreateWebhook(c echo.Context) error {
+	if h.webhooks == nil {
+		return webhooksOff()
+	}
+
+	var sub webhook.Subscription
+	if err := c.Bind(&sub); err != nil {
+		return bindError(err)
+	}
+	if err := sub.Validate(); err != nil {
+		return problem.New(problem.ValidationFailed, err.Error())
+	}
+
+	created, err := h.webhooks.CreateSubscription(c.Request().Context(), sub)
+	if err != nil {
+		return problem.From(err)
+	}
+	return c.JSON(http.StatusCreated, created)
+}
+
+func (h *handler) ListWebhooks(c echo.Context) error {
+	if h.webhooks == nil {
+		return webhooksOff()
+	}
+
+	subs, err := h.webhooks.Subscriptions(c.Request().Context())
+	if err != nil {
+		return problem.From(err)
+	}
+	return c.JSON(http.StatusOK, subs)
+}
+
+// DeleteWebhook stops deliveries to the subscription, its log is kept
+func (h *handler) DeleteWebhook(c echo.Context) error {
+	if h.webhooks == nil {
+		return webhooksOff()
+	}
+
+	id, err := strconv.ParseInt(c.Param("sid"), 10, 64)
+	if err != nil {
+		return problem.New(problem.WebhookNotFound, "")
+	}
+
+	switch err := h.webhooks.DeleteSubscription(c.Request().Context(), id); err {
+	case nil:
+		return c.NoContent(http.StatusNoContent)
+	case sql.ErrNoRows:
+		return problem.New(problem.WebhookNotFound, "")
+	default:
+		return problem.From(err)
+	}
+}
+
+// WebhookDeliveries lists the delivery log filtered by the subscription_id
+// and status query params, paged with after and limit. status=dead lists
+// the dead letters.
+func (h *handler) WebhookDeliveries(c echo.Context) error {
+	if h.webhooks == nil {
+		return webhooksOff()
+	}
+
+	q, err := parseDeliveryQuery(c)
+	if err != nil {
+		return badQuery(err)
+	}
+
+	deliveries, err := h.webhooks.Deliveries(c.Request().Context(), q)
+	if err != nil {
+		return problem.From(err)
+	}
+	return c.JSON(http.StatusOK, deliveries)
+}
+
+// RedeliverWebhook queues a dead letter for a fresh round of attempts
+func (h *handler) RedeliverWebhook(c echo.Context) error {
+	if h.webhooks == nil {
+		return webhooksOff()
+	}
+
+	id, err := strconv.ParseInt(c.Param("did"), 10, 64)
+	if err != nil {
+		return problem.New(problem.DeliveryNotFound, "")
+	}
+
+	switch err := h.webhooks.Redeliver(c.Request().Context(), id, time.Now()); err {
+	case nil:
+		return c.NoContent(http.StatusAccepted)
+	case sql.ErrNoRows:
+		return problem.New(problem.DeliveryNotFound, "")
+	default:
+		return problem.From(err)
+	}
+}
+
+func parseDeliveryQuery(c echo.Context) (webhook.DeliveryQuery, error) {
+	q := webhook.DeliveryQuery{
+		Status: c.QueryParam("status"),
+		Limit:  webhook.DefaultDeliveryLimit,
+	}
+
+	var err error
+	if v := c.QueryParam("subscription_id"); v != "" {
+		if q.SubscriptionID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return q, queryParamError("subscription_id")
+		}
+	}
+	if v := c.QueryParam("after"); v != "" {
+		if q.After, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return q, queryParamError("after")
+		}
+	}
+	if v := c.QueryParam("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 || q.Limit > webhook.MaxDeliveryLimit {
+			return q, queryParamError("limit")
+		}
+	}
+	switch q.Status {
+	case "", webhook.StatusPending, webhook.StatusDelivered, webhook.StatusDead:
+	default:
+		return q, queryParamError("status")
+	}
+	return q, nil
+}
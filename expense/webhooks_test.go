@@ -0,0 +1,158 @@
+//go:build unit
+// +build unit
+
+package expense_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bazsup/assessment/expense"
+	"github.com/bazsup/assessment/webhook"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupWebhooks(t *testing.T) (*echo.Echo, sqlmock.Sqlmock) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := newEcho()
+	expense.NewApp(e, NewTestStore(), testAuthToken, expense.WithWebhooks(webhook.NewPostgresStore(db)))
+	return e, mock
+}
+
+func TestCreateWebhook(t *testing.T) {
+	t.Run("Create webhook returns the subscription with its secret", func(t *testing.T) {
+		e, mock := setupWebhooks(t)
+
+		// Arrange
+		mock.ExpectQuery("INSERT INTO webhook_subscriptions").
+			WithArgs("https://example.com/hook", sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+		req := httptest.NewRequest(http.MethodPost, "/admin/webhooks", strings.NewReader(`{"url":"https://example.com/hook","events":["expense.*"]}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, testAuthToken)
+		rec := httptest.NewRecorder()
+
+		// Act
+		e.ServeHTTP(rec, req)
+
+		var sub webhook.Subscription
+		json.Unmarshal(rec.Body.Bytes(), &sub)
+
+		// Assertions
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, int64(1), sub.ID)
+		assert.Len(t, sub.Secret, 64)
+		assert.True(t, sub.Active)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Unknown event type should returns status bad request", func(t *testing.T) {
+		e, _ := setupWebhooks(t)
+
+		// Arrange
+		req := httptest.NewRequest(http.MethodPost, "/admin/webhooks", strings.NewReader(`{"url":"https://example.com/hook","events":["invoice.paid"]}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, testAuthToken)
+		rec := httptest.NewRecorder()
+
+		// Act
+		e.ServeHTTP(rec, req)
+
+		// Assertions
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("App without webhooks should returns status not found", func(t *testing.T) {
+		e, _ := setupApp(t)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/admin/webhooks", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestDeleteWebhook(t *testing.T) {
+	t.Run("Unknown subscription should returns status not found", func(t *testing.T) {
+		e, mock := setupWebhooks(t)
+
+		// Arrange
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE webhook_subscriptions SET active = false").
+			WithArgs(9).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		// Act
+		rec := serve(e, http.MethodDelete, "/admin/webhooks/9", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	t.Run("Dead letters are listed by status", func(t *testing.T) {
+		e, mock := setupWebhooks(t)
+
+		// Arrange
+		mock.ExpectQuery("FROM webhook_deliveries WHERE subscription_id = \\$1 AND status = \\$2 ORDER BY id LIMIT 100").
+			WithArgs(1, webhook.StatusDead).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "subscription_id", "outbox_id", "event_type", "status", "attempts",
+				"next_attempt_at", "last_status_code", "last_error", "created_at", "delivered_at"}).
+				AddRow(3, 1, 7, webhook.EventExpenseCreated, webhook.StatusDead, 10, time.Now(), 500, "receiver answered 500", time.Now(), nil))
+
+		// Act
+		rec := serve(e, http.MethodGet, "/admin/webhooks/deliveries?subscription_id=1&status=dead", testAuthToken)
+
+		var deliveries []webhook.Delivery
+		json.Unmarshal(rec.Body.Bytes(), &deliveries)
+
+		// Assertions
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Len(t, deliveries, 1)
+		assert.Equal(t, 10, deliveries[0].Attempts)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Unknown status should returns status bad request", func(t *testing.T) {
+		e, _ := setupWebhooks(t)
+
+		// Act
+		rec := serve(e, http.MethodGet, "/admin/webhooks/deliveries?status=lost", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func TestRedeliverWebhook(t *testing.T) {
+	t.Run("Dead letter is queued for redelivery", func(t *testing.T) {
+		e, mock := setupWebhooks(t)
+
+		// Arrange
+		mock.ExpectExec("UPDATE webhook_deliveries SET status = 'pending'").
+			WithArgs(3, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		// Act
+		rec := serve(e, http.MethodPost, "/admin/webhooks/deliveries/3/redeliver", testAuthToken)
+
+		// Assertions
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+)
+
+// serveGRPC serves srv on addr along with the gRPC reflection service. The
+// returned func waits for calls in flight until ctx is done, when the
+// remaining ones are cut off. Health reports not serving as soon as the
+// checker shuts down.
+func serveGRPC(srv *grpc.Server, addr string) (func(ctx context.Context), error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	reflection.Register(srv)
+
+	go func() {
+		slog.Info("grpc server started", "addr", addr)
+		if err := srv.Serve(lis); err != nil {
+			fatal("shutting down the grpc server", err)
+		}
+		slog.Info("grpc server stopped")
+	}()
+
+	return func(ctx context.Context) {
+		stopped := make(chan struct{})
+		go func() {
+			srv.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			srv.Stop()
+		}
+	}, nil
+}
@@ -0,0 +1,105 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Status of a single check or of the whole report
+type Status string
+
+const (
+	StatusUp   Status = "up"
+	StatusDown Status = "down"
+)
+
+// CheckFunc probes a dependency, a nil error means the dependency is healthy
+type CheckFunc func(ctx context.Context) error
+
+type check struct {
+	name string
+	fn   CheckFunc
+}
+
+// Result is the outcome of one check
+type Result struct {
+	Name      string  `json:"name"`
+	Status    Status  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the outcome of every check, Status is down when any check is down
+type Report struct {
+	Status       Status   `json:"status"`
+	ShuttingDown bool     `json:"shutting_down"`
+	Checks       []Result `json:"checks"`
+}
+
+// Checker runs the registered dependency checks and tracks shutdown so
+// readiness fails while the process drains
+type Checker struct {
+	timeout      time.Duration
+	checks       []check
+	shuttingDown int32
+}
+
+// NewChecker returns a checker bounding every check run by timeout
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// Add registers a dependency check, checks must be added before serving
+func (c *Checker) Add(name string, fn CheckFunc) *Checker {
+	c.checks = append(c.checks, check{name: name, fn: fn})
+	return c
+}
+
+// Shutdown marks the process as draining, readiness fails from now on
+func (c *Checker) Shutdown() {
+	atomic.StoreInt32(&c.shuttingDown, 1)
+}
+
+func (c *Checker) ShuttingDown() bool {
+	return atomic.LoadInt32(&c.shuttingDown) == 1
+}
+
+// Run executes every check concurrently and reports them in registration order
+func (c *Checker) Run(ctx context.Context) Report {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	results := make([]Result, len(c.checks))
+	var wg sync.WaitGroup
+	for i, ch := range c.checks {
+		wg.Add(1)
+		go func(i int, ch check) {
+			defer wg.Done()
+			start := time.Now()
+			err := ch.fn(ctx)
+
+			r := Result{Name: ch.name, Status: StatusUp, LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				r.Status, r.Error = StatusDown, err.Error()
+			}
+			results[i] = r
+		}(i, ch)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusUp, ShuttingDown: c.ShuttingDown(), Checks: results}
+	if report.ShuttingDown {
+		report.Status = StatusDown
+	}
+	for _, r := range results {
+		if r.Status == StatusDown {
+			report.Status = StatusDown
+		}
+	}
+	return report
+}
@@ -0,0 +1,73 @@
+//go:build unit
+// +build unit
+
+package health_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bazsup/assessment/health"
+	"github.com/stretchr/testify/assert"
+)
+
+func up(context.Context) error { return nil }
+
+func TestRun(t *testing.T) {
+	t.Run("All checks up", func(t *testing.T) {
+		c := health.NewChecker(time.Second).Add("database", up).Add("cache", up)
+
+		// Act
+		r := c.Run(context.Background())
+
+		// Assertions
+		assert.Equal(t, health.StatusUp, r.Status)
+		if assert.Len(t, r.Checks, 2) {
+			assert.Equal(t, "database", r.Checks[0].Name)
+			assert.Equal(t, "cache", r.Checks[1].Name)
+		}
+	})
+
+	t.Run("Failing check marks the report down", func(t *testing.T) {
+		c := health.NewChecker(time.Second).
+			Add("database", func(context.Context) error { return errors.New("connection refused") }).
+			Add("cache", up)
+
+		// Act
+		r := c.Run(context.Background())
+
+		// Assertions
+		assert.Equal(t, health.StatusDown, r.Status)
+		assert.Equal(t, health.Result{Name: "database", Status: health.StatusDown, LatencyMs: r.Checks[0].LatencyMs, Error: "connection refused"}, r.Checks[0])
+		assert.Equal(t, health.StatusUp, r.Checks[1].Status)
+	})
+
+	t.Run("Slow check is bounded by the timeout", func(t *testing.T) {
+		c := health.NewChecker(10*time.Millisecond).Add("slow", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+
+		// Act
+		r := c.Run(context.Background())
+
+		// Assertions
+		assert.Equal(t, health.StatusDown, r.Status)
+		assert.Equal(t, context.DeadlineExceeded.Error(), r.Checks[0].Error)
+	})
+
+	t.Run("Shutting down marks the report down", func(t *testing.T) {
+		c := health.NewChecker(time.Second).Add("database", up)
+
+		// Act
+		c.Shutdown()
+		r := c.Run(context.Background())
+
+		// Assertions
+		assert.True(t, c.ShuttingDown())
+		assert.True(t, r.ShuttingDown)
+		assert.Equal(t, health.StatusDown, r.Status)
+	})
+}
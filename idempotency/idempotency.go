@@ -0,0 +1,62 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// HeaderKey is the request header carrying the client generated key
+const HeaderKey = "Idempotency-Key"
+
+// HeaderReplayed marks responses served from the store
+const HeaderReplayed = "Idempotent-Replayed"
+
+// ErrNotFound is returned when a key has no record
+var ErrNotFound = errors.New("idempotency key not found")
+
+// Response is the stored outcome of the first request using a key
+type Response struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// Record is the state kept for a key, Response is nil while the first request
+// is in flight. An in flight record expires after the lock timeout so a
+// request that never completes, e.g. because the process crashed, doesn't
+// hold its key for the whole TTL.
+type Record struct {
+	Key         string
+	Fingerprint string
+	ExpiresAt   time.Time
+	Response    *Response
+}
+
+// Store persists idempotency records, implementations must be safe for concurrent use
+type Store interface {
+	// Reserve claims key for a new request unless an unexpired record
+	// exists, in which case that record is returned with reserved false.
+	// The reservation expires after lock unless it is completed first.
+	Reserve(ctx context.Context, key, fingerprint string, now time.Time, lock time.Duration) (rec *Record, reserved bool, err error)
+	// Complete stores the response of the request that reserved key and
+	// keeps it until expiresAt
+	Complete(ctx context.Context, key string, res Response, expiresAt time.Time) error
+	// Release forgets key so the request can be retried, used when it failed
+	Release(ctx context.Context, key string) error
+	// DeleteExpired drops records past their expiry, returning how many were deleted
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+// Fingerprint identifies a request by method, path and body
+func Fingerprint(method, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,70 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps records in process memory, keys are per replica
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]*Record
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: map[string]*Record{}}
+}
+
+// Reserve takes over an expired record of key, other expired records are
+// left to DeleteExpired so a reservation doesn't scan the whole store
+func (s *MemoryStore) Reserve(_ context.Context, key, fingerprint string, now time.Time, lock time.Duration) (*Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rec, ok := s.records[key]; ok && now.Before(rec.ExpiresAt) {
+		cp := *rec
+		return &cp, false, nil
+	}
+
+	rec := &Record{Key: key, Fingerprint: fingerprint, ExpiresAt: now.Add(lock)}
+	s.records[key] = rec
+	cp := *rec
+	return &cp, true, nil
+}
+
+func (s *MemoryStore) Complete(_ context.Context, key string, res Response, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.records[key]
+	if !ok {
+		return ErrNotFound
+	}
+	rec.Response = &res
+	rec.ExpiresAt = expiresAt
+	return nil
+}
+
+func (s *MemoryStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+// DeleteExpired removes records past their expiry, returning how many were deleted
+func (s *MemoryStore) DeleteExpired(_ context.Context, now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for k, rec := range s.records {
+		if !now.Before(rec.ExpiresAt) {
+			delete(s.records, k)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,109 @@
+//go:build unit
+// +build unit
+
+package idempotency_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bazsup/assessment/idempotency"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2022, 12, 24, 10, 0, 0, 0, time.UTC)
+
+	t.Run("Second reserve returns the completed record", func(t *testing.T) {
+		t.Parallel()
+		s := idempotency.NewMemoryStore()
+
+		// Act
+		_, reserved, _ := s.Reserve(ctx, "k", "fp", now, time.Hour)
+		s.Complete(ctx, "k", idempotency.Response{Status: 201, Body: []byte(`{"id":1}`)}, now.Add(time.Hour))
+		rec, again, _ := s.Reserve(ctx, "k", "fp", now.Add(time.Minute), time.Hour)
+
+		// Assertions
+		assert.True(t, reserved)
+		assert.False(t, again)
+		if assert.NotNil(t, rec.Response) {
+			assert.Equal(t, 201, rec.Response.Status)
+		}
+	})
+
+	t.Run("Expired key can be reserved again", func(t *testing.T) {
+		t.Parallel()
+		s := idempotency.NewMemoryStore()
+
+		// Act
+		s.Reserve(ctx, "k", "fp", now, time.Hour)
+		_, reserved, _ := s.Reserve(ctx, "k", "other", now.Add(time.Hour), time.Hour)
+
+		// Assertions
+		assert.True(t, reserved)
+	})
+
+	t.Run("In flight key can be reserved again after the lock timeout", func(t *testing.T) {
+		t.Parallel()
+		s := idempotency.NewMemoryStore()
+
+		// Act
+		s.Reserve(ctx, "k", "fp", now, time.Minute)
+		_, locked, _ := s.Reserve(ctx, "k", "fp", now.Add(59*time.Second), time.Minute)
+		_, reserved, _ := s.Reserve(ctx, "k", "fp", now.Add(time.Minute), time.Minute)
+
+		// Assertions
+		assert.False(t, locked)
+		assert.True(t, reserved)
+	})
+
+	t.Run("Completed key is kept past the lock timeout", func(t *testing.T) {
+		t.Parallel()
+		s := idempotency.NewMemoryStore()
+
+		// Act
+		s.Reserve(ctx, "k", "fp", now, time.Minute)
+		s.Complete(ctx, "k", idempotency.Response{Status: 201}, now.Add(time.Hour))
+		rec, reserved, _ := s.Reserve(ctx, "k", "fp", now.Add(time.Minute), time.Minute)
+
+		// Assertions
+		assert.False(t, reserved)
+		assert.NotNil(t, rec.Response)
+	})
+
+	t.Run("Released key can be reserved again", func(t *testing.T) {
+		t.Parallel()
+		s := idempotency.NewMemoryStore()
+
+		// Act
+		s.Reserve(ctx, "k", "fp", now, time.Hour)
+		s.Release(ctx, "k")
+		_, reserved, _ := s.Reserve(ctx, "k", "fp", now, time.Hour)
+
+		// Assertions
+		assert.True(t, reserved)
+	})
+}
+
+func TestMemoryStoreDeleteExpired(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2022, 12, 24, 10, 0, 0, 0, time.UTC)
+	s := idempotency.NewMemoryStore()
+
+	// Arrange
+	s.Reserve(ctx, "expired", "fp", now, time.Minute)
+	s.Reserve(ctx, "completed", "fp", now, time.Minute)
+	s.Complete(ctx, "completed", idempotency.Response{Status: 201}, now.Add(time.Hour))
+
+	// Act
+	n, err := s.DeleteExpired(ctx, now.Add(time.Minute))
+
+	// Assertions
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(1), n)
+	}
+	_, reserved, _ := s.Reserve(ctx, "completed", "fp", now.Add(time.Minute), time.Minute)
+	assert.False(t, reserved)
+}
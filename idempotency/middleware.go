@@ -0,0 +1,165 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bazsup/assessment/problem"
+	"github.com/labstack/echo/v4"
+)
+
+// maxKeyLength bounds the client supplied key stored in the table
+const maxKeyLength = 255
+
+// outcomeTimeout bounds recording the outcome of a request, which outlives
+// the request context so a disconnected client doesn't leave its key in progress
+const outcomeTimeout = 5 * time.Second
+
+// defaultLockTimeout is how long a key stays in progress when Config.LockTimeout is not set
+const defaultLockTimeout = time.Minute
+
+// Config configures the idempotency middleware
+type Config struct {
+	Store Store
+	// TTL is how long a key and its response are kept
+	TTL time.Duration
+	// LockTimeout is how long a key stays in progress before it can be
+	// reserved again, it must outlast the slowest request
+	LockTimeout time.Duration
+	// ScopeFunc namespaces keys per client so callers can't replay each other's responses
+	ScopeFunc func(c echo.Context) string
+	Now       func() time.Time
+}
+
+type recorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// Middleware replays the stored response of POST, PUT and PATCH requests
+// that repeat an Idempotency-Key. Reusing a key with a different request
+// is rejected with 422, and a key whose first request is still running
+// with 409. Server errors and canceled requests are not stored so the
+// client can retry them.
+func Middleware(cfg Config) echo.MiddlewareFunc {
+	if cfg.ScopeFunc == nil {
+		cfg.ScopeFunc = func(c echo.Context) string { return c.RealIP() }
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	if cfg.LockTimeout <= 0 {
+		cfg.LockTimeout = defaultLockTimeout
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			clientKey := req.Header.Get(HeaderKey)
+			if clientKey == "" || !appliesTo(req.Method) {
+				return next(c)
+			}
+			if len(clientKey) > maxKeyLength {
+				return problem.Localized(problem.BadRequest, problem.DetailKeyTooLong, HeaderKey)
+			}
+
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				return problem.BodyError(err)
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body))
+
+			ctx := req.Context()
+			key := cfg.ScopeFunc(c) + ":" + clientKey
+			fingerprint := Fingerprint(req.Method, req.URL.Path, body)
+
+			rec, reserved, err := cfg.Store.Reserve(ctx, key, fingerprint, cfg.Now(), cfg.LockTimeout)
+			if err != nil {
+				slog.ErrorContext(ctx, "idempotency store failed", "error", err.Error())
+				return next(c)
+			}
+
+			if !reserved {
+				switch {
+				case rec.Fingerprint != fingerprint:
+					return problem.Localized(problem.IdempotencyKeyReused, problem.DetailKeyReused, HeaderKey)
+				case rec.Response == nil:
+					return problem.Localized(problem.RequestInProgress, problem.DetailKeyInProgress, HeaderKey)
+				default:
+					c.Response().Header().Set(HeaderReplayed, strconv.FormatBool(true))
+					return c.Blob(rec.Response.Status, rec.Response.ContentType, rec.Response.Body)
+				}
+			}
+
+			res := c.Response()
+			rw := &recorder{ResponseWriter: res.Writer}
+			res.Writer = rw
+
+			defer func() {
+				if r := recover(); r != nil {
+					res.Writer = rw.ResponseWriter
+					release(ctx, cfg.Store, key)
+					panic(r)
+				}
+			}()
+
+			err = next(c)
+			if err != nil {
+				// answer now so client errors are stored like any other response
+				c.Error(err)
+			}
+			res.Writer = rw.ResponseWriter
+
+			if !storable(res.Status, err) {
+				release(ctx, cfg.Store, key)
+				return nil
+			}
+
+			stored := Response{
+				Status:      res.Status,
+				ContentType: res.Header().Get(echo.HeaderContentType),
+				Body:        rw.body.Bytes(),
+			}
+			outcomeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), outcomeTimeout)
+			defer cancel()
+			if err := cfg.Store.Complete(outcomeCtx, key, stored, cfg.Now().Add(cfg.TTL)); err != nil {
+				slog.ErrorContext(ctx, "idempotency store failed", "error", err.Error())
+			}
+			return nil
+		}
+	}
+}
+
+// storable reports whether the outcome of a request is final. A request
+// abandoned because its context ended says nothing about the request
+// itself, a retry must run it again.
+func storable(status int, err error) bool {
+	if status >= http.StatusInternalServerError || status == problem.StatusClientClosedRequest {
+		return false
+	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// release frees key for a retry, even when the request context is done
+func release(ctx context.Context, store Store, key string) {
+	outcomeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), outcomeTimeout)
+	defer cancel()
+	if err := store.Release(outcomeCtx, key); err != nil {
+		slog.ErrorContext(ctx, "idempotency store failed", "error", err.Error())
+	}
+}
+
+func appliesTo(method string) bool {
+	return method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch
+}
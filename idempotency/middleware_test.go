@@ -0,0 +1,230 @@
+//go:build unit
+// +build unit
+
+package idempotency_test
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bazsup/assessment/idempotency"
+	"github.com/bazsup/assessment/problem"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupEcho(t *testing.T, status int) (*echo.Echo, *int) {
+	t.Parallel()
+
+	calls := 0
+	e := echo.New()
+	e.HTTPErrorHandler = problem.Handler(slog.Default())
+	mw := idempotency.Middleware(idempotency.Config{Store: idempotency.NewMemoryStore(), TTL: time.Hour})
+	e.POST("/expenses", func(c echo.Context) error {
+		calls++
+		if status == http.StatusNotFound {
+			return problem.New(problem.ExpenseNotFound, "")
+		}
+		return c.JSON(status, map[string]int{"id": calls})
+	}, mw)
+	return e, &calls
+}
+
+func post(e *echo.Echo, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/expenses", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if key != "" {
+		req.Header.Set(idempotency.HeaderKey, key)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Run("Retry replays the stored response", func(t *testing.T) {
+		e, calls := setupEcho(t, http.StatusCreated)
+
+		// Act
+		first := post(e, "key-1", `{"title":"a"}`)
+		retry := post(e, "key-1", `{"title":"a"}`)
+
+		// Assertions
+		assert.Equal(t, 1, *calls)
+		assert.Equal(t, http.StatusCreated, retry.Code)
+		assert.Equal(t, first.Body.String(), retry.Body.String())
+		assert.Equal(t, echo.MIMEApplicationJSONCharsetUTF8, retry.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, "true", retry.Header().Get(idempotency.HeaderReplayed))
+	})
+
+	t.Run("Reused key with a different body should returns status unprocessable entity", func(t *testing.T) {
+		e, calls := setupEcho(t, http.StatusCreated)
+
+		// Act
+		post(e, "key-1", `{"title":"a"}`)
+		rec := post(e, "key-1", `{"title":"b"}`)
+
+		// Assertions
+		assert.Equal(t, 1, *calls)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"code":"IDEMPOTENCY_KEY_REUSED"`)
+	})
+
+	t.Run("Retry replays the client error returned by the handler", func(t *testing.T) {
+		e, calls := setupEcho(t, http.StatusNotFound)
+
+		// Act
+		first := post(e, "key-1", `{"title":"a"}`)
+		retry := post(e, "key-1", `{"title":"a"}`)
+
+		// Assertions
+		assert.Equal(t, 1, *calls)
+		assert.Equal(t, http.StatusNotFound, retry.Code)
+		assert.Equal(t, problem.ContentType, retry.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, first.Body.String(), retry.Body.String())
+	})
+
+	t.Run("Requests without a key are not deduplicated", func(t *testing.T) {
+		e, calls := setupEcho(t, http.StatusCreated)
+
+		// Act
+		post(e, "", `{"title":"a"}`)
+		post(e, "", `{"title":"a"}`)
+
+		// Assertions
+		assert.Equal(t, 2, *calls)
+	})
+
+	t.Run("Server errors are not stored", func(t *testing.T) {
+		e, calls := setupEcho(t, http.StatusInternalServerError)
+
+		// Act
+		post(e, "key-1", `{"title":"a"}`)
+		post(e, "key-1", `{"title":"a"}`)
+
+		// Assertions
+		assert.Equal(t, 2, *calls)
+	})
+
+	t.Run("Key left in progress is taken over after the lock timeout", func(t *testing.T) {
+		t.Parallel()
+		calls := 0
+		now := time.Date(2022, 12, 24, 10, 0, 0, 0, time.UTC)
+		store := idempotency.NewMemoryStore()
+		e := echo.New()
+		e.HTTPErrorHandler = problem.Handler(slog.Default())
+		e.POST("/expenses", func(c echo.Context) error {
+			calls++
+			return c.JSON(http.StatusCreated, map[string]int{"id": calls})
+		}, idempotency.Middleware(idempotency.Config{
+			Store:       store,
+			TTL:         time.Hour,
+			LockTimeout: time.Minute,
+			ScopeFunc:   func(c echo.Context) string { return "client" },
+			Now:         func() time.Time { return now },
+		}))
+
+		// Arrange
+		fingerprint := idempotency.Fingerprint(http.MethodPost, "/expenses", []byte(`{"title":"a"}`))
+		store.Reserve(context.Background(), "client:key-1", fingerprint, now, time.Minute)
+
+		// Act
+		locked := post(e, "key-1", `{"title":"a"}`)
+		now = now.Add(time.Minute)
+		retry := post(e, "key-1", `{"title":"a"}`)
+
+		// Assertions
+		assert.Equal(t, http.StatusConflict, locked.Code)
+		assert.Equal(t, http.StatusCreated, retry.Code)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("Response is stored after the client went away", func(t *testing.T) {
+		t.Parallel()
+		calls := 0
+		e := echo.New()
+		e.POST("/expenses", func(c echo.Context) error {
+			calls++
+			return c.JSON(http.StatusCreated, map[string]int{"id": calls})
+		}, idempotency.Middleware(idempotency.Config{Store: contextStore{idempotency.NewMemoryStore()}, TTL: time.Hour}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req := httptest.NewRequest(http.MethodPost, "/expenses", strings.NewReader(`{"title":"a"}`)).WithContext(ctx)
+		req.Header.Set(idempotency.HeaderKey, "key-1")
+
+		// Act
+		e.ServeHTTP(httptest.NewRecorder(), req)
+		retry := post(e, "key-1", `{"title":"a"}`)
+
+		// Assertions
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, http.StatusCreated, retry.Code)
+		assert.Equal(t, "true", retry.Header().Get(idempotency.HeaderReplayed))
+	})
+
+	t.Run("Request canceled by its client is not stored", func(t *testing.T) {
+		t.Parallel()
+		calls := 0
+		e := echo.New()
+		e.HTTPErrorHandler = problem.Handler(slog.Default())
+		e.POST("/expenses", func(c echo.Context) error {
+			calls++
+			if calls == 1 {
+				return context.Canceled
+			}
+			return c.JSON(http.StatusCreated, map[string]int{"id": calls})
+		}, idempotency.Middleware(idempotency.Config{Store: idempotency.NewMemoryStore(), TTL: time.Hour}))
+
+		// Act
+		first := post(e, "key-1", `{"title":"a"}`)
+		retry := post(e, "key-1", `{"title":"a"}`)
+
+		// Assertions
+		assert.Equal(t, problem.StatusClientClosedRequest, first.Code)
+		assert.Equal(t, 2, calls)
+		assert.Equal(t, http.StatusCreated, retry.Code)
+		assert.Empty(t, retry.Header().Get(idempotency.HeaderReplayed))
+	})
+
+	t.Run("Panicking request releases its key", func(t *testing.T) {
+		t.Parallel()
+		calls := 0
+		e := echo.New()
+		e.Use(middleware.Recover())
+		e.POST("/expenses", func(c echo.Context) error {
+			calls++
+			if calls == 1 {
+				panic("boom")
+			}
+			return c.JSON(http.StatusCreated, map[string]int{"id": calls})
+		}, idempotency.Middleware(idempotency.Config{Store: idempotency.NewMemoryStore(), TTL: time.Hour}))
+
+		// Act
+		first := post(e, "key-1", `{"title":"a"}`)
+		retry := post(e, "key-1", `{"title":"a"}`)
+
+		// Assertions
+		assert.Equal(t, http.StatusInternalServerError, first.Code)
+		assert.Equal(t, 2, calls)
+		assert.Equal(t, http.StatusCreated, retry.Code)
+	})
+}
+
+// contextStore records outcomes like the Postgres store, failing once the context is done
+type contextStore struct {
+	idempotency.Store
+}
+
+func (s contextStore) Complete(ctx context.Context, key string, res idempotency.Response, expiresAt time.Time) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return s.Store.Complete(ctx, key, res, expiresAt)
+}
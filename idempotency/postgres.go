@@ -0,0 +1,81 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// PostgresStore keeps records in Postgres so retries can land on any replica
+type PostgresStore struct {
+	*sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db}
+}
+
+// Reserve inserts the key, taking over an expired record in the same statement
+func (s *PostgresStore) Reserve(ctx context.Context, key, fingerprint string, now time.Time, lock time.Duration) (*Record, bool, error) {
+	expiresAt := now.Add(lock)
+
+	var claimed string
+	err := s.DB.QueryRowContext(ctx, `
+	INSERT INTO idempotency_keys ( key, fingerprint, expires_at ) VALUES ( $1, $2, $3 )
+	ON CONFLICT ( key ) DO UPDATE
+	SET fingerprint = EXCLUDED.fingerprint, expires_at = EXCLUDED.expires_at, status = NULL, content_type = NULL, body = NULL
+	WHERE idempotency_keys.expires_at <= $4
+	RETURNING key
+	`, key, fingerprint, expiresAt, now).Scan(&claimed)
+	switch err {
+	case nil:
+		return &Record{Key: key, Fingerprint: fingerprint, ExpiresAt: expiresAt}, true, nil
+	case sql.ErrNoRows:
+	default:
+		return nil, false, fmt.Errorf("can't reserve idempotency key: %s", err.Error())
+	}
+
+	rec := &Record{Key: key}
+	var status sql.NullInt64
+	var contentType sql.NullString
+	var body []byte
+	err = s.DB.QueryRowContext(ctx,
+		"SELECT fingerprint, expires_at, status, content_type, body FROM idempotency_keys WHERE key = $1", key).
+		Scan(&rec.Fingerprint, &rec.ExpiresAt, &status, &contentType, &body)
+	if err != nil {
+		return nil, false, fmt.Errorf("can't query idempotency key: %s", err.Error())
+	}
+	if status.Valid {
+		rec.Response = &Response{Status: int(status.Int64), ContentType: contentType.String, Body: body}
+	}
+
+	return rec, false, nil
+}
+
+func (s *PostgresStore) Complete(ctx context.Context, key string, res Response, expiresAt time.Time) error {
+	result, err := s.DB.ExecContext(ctx,
+		"UPDATE idempotency_keys SET status = $2, content_type = $3, body = $4, expires_at = $5 WHERE key = $1",
+		key, res.Status, res.ContentType, res.Body, expiresAt)
+	if err != nil {
+		return fmt.Errorf("can't store idempotent response: %s", err.Error())
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) Release(ctx context.Context, key string) error {
+	_, err := s.DB.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE key = $1", key)
+	return err
+}
+
+// DeleteExpired removes records past their expiry, returning how many were deleted
+func (s *PostgresStore) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	result, err := s.DB.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= $1", now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
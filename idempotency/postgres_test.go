@@ -0,0 +1,122 @@
+//go:build unit
+// +build unit
+
+package idempotency_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bazsup/assessment/idempotency"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupDB(t *testing.T) (*idempotency.PostgresStore, sqlmock.Sqlmock) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	return idempotency.NewPostgresStore(db), mock
+}
+
+func TestPostgresStoreReserve(t *testing.T) {
+	now := time.Date(2022, 12, 24, 10, 0, 0, 0, time.UTC)
+
+	t.Run("New key is reserved until the lock timeout", func(t *testing.T) {
+		s, mock := setupDB(t)
+
+		// Arrange
+		mock.ExpectQuery("INSERT INTO idempotency_keys (.+) ON CONFLICT (.+) RETURNING key").
+			WithArgs("k", "fp", now.Add(time.Minute), now).
+			WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow("k"))
+
+		// Act
+		rec, reserved, err := s.Reserve(context.Background(), "k", "fp", now, time.Minute)
+
+		// Assertions
+		if assert.NoError(t, err) {
+			assert.True(t, reserved)
+			assert.Nil(t, rec.Response)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Existing key returns the stored response", func(t *testing.T) {
+		s, mock := setupDB(t)
+
+		// Arrange
+		mock.ExpectQuery("INSERT INTO idempotency_keys").WillReturnError(sql.ErrNoRows)
+		mock.ExpectQuery("SELECT fingerprint, expires_at, status, content_type, body FROM idempotency_keys WHERE key = .+").
+			WithArgs("k").
+			WillReturnRows(sqlmock.NewRows([]string{"fingerprint", "expires_at", "status", "content_type", "body"}).
+				AddRow("fp", now.Add(time.Hour), 201, "application/json", []byte(`{"id":1}`)))
+
+		// Act
+		rec, reserved, err := s.Reserve(context.Background(), "k", "fp", now, time.Hour)
+
+		// Assertions
+		if assert.NoError(t, err) {
+			assert.False(t, reserved)
+			assert.Equal(t, "fp", rec.Fingerprint)
+			assert.Equal(t, &idempotency.Response{Status: 201, ContentType: "application/json", Body: []byte(`{"id":1}`)}, rec.Response)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("In flight key has no response", func(t *testing.T) {
+		s, mock := setupDB(t)
+
+		// Arrange
+		mock.ExpectQuery("INSERT INTO idempotency_keys").WillReturnError(sql.ErrNoRows)
+		mock.ExpectQuery("SELECT fingerprint").
+			WillReturnRows(sqlmock.NewRows([]string{"fingerprint", "expires_at", "status", "content_type", "body"}).
+				AddRow("fp", now.Add(time.Hour), nil, nil, nil))
+
+		// Act
+		rec, reserved, err := s.Reserve(context.Background(), "k", "fp", now, time.Hour)
+
+		// Assertions
+		if assert.NoError(t, err) {
+			assert.False(t, reserved)
+			assert.Nil(t, rec.Response)
+		}
+	})
+}
+
+func TestPostgresStoreComplete(t *testing.T) {
+	now := time.Date(2022, 12, 24, 10, 0, 0, 0, time.UTC)
+
+	t.Run("Response is kept until the given expiry", func(t *testing.T) {
+		s, mock := setupDB(t)
+
+		// Arrange
+		mock.ExpectExec("UPDATE idempotency_keys SET status = .+, content_type = .+, body = .+, expires_at = .+ WHERE key = .+").
+			WithArgs("k", 201, "application/json", []byte(`{"id":1}`), now.Add(24*time.Hour)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		// Act
+		err := s.Complete(context.Background(), "k", idempotency.Response{Status: 201, ContentType: "application/json", Body: []byte(`{"id":1}`)}, now.Add(24*time.Hour))
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Unknown key returns ErrNotFound", func(t *testing.T) {
+		s, mock := setupDB(t)
+
+		// Arrange
+		mock.ExpectExec("UPDATE idempotency_keys SET status").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		// Act
+		err := s.Complete(context.Background(), "k", idempotency.Response{Status: 201}, now.Add(time.Hour))
+
+		// Assertions
+		assert.Equal(t, idempotency.ErrNotFound, err)
+	})
+}
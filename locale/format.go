@@ -0,0 +1,62 @@
+package locale
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BuddhistEraOffset is added to a Gregorian year to give the Thai solar year
+const BuddhistEraOffset = 543
+
+var thaiMonths = [...]string{"ม.ค.", "ก.พ.", "มี.ค.", "เม.ย.", "พ.ค.", "มิ.ย.", "ก.ค.", "ส.ค.", "ก.ย.", "ต.ค.", "พ.ย.", "ธ.ค."}
+
+// FormatAmount formats an amount of baht with thousand separators and
+// satang, as "THB 1,234.50" in English and "1,234.50 บาท" in Thai
+func FormatAmount(tag Tag, amount float64) string {
+	s := separate(amount)
+	if tag == Thai {
+		return s + " บาท"
+	}
+	return "THB " + s
+}
+
+// separate formats amount with two decimals, grouping its integer part by thousands
+func separate(amount float64) string {
+	s := strconv.FormatFloat(math.Abs(amount), 'f', 2, 64)
+	whole, frac := s[:len(s)-3], s[len(s)-3:]
+
+	var b strings.Builder
+	if amount < 0 && s != "0.00" {
+		b.WriteByte('-')
+	}
+	for i, d := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(d)
+	}
+	b.WriteString(frac)
+	return b.String()
+}
+
+// FormatDate formats the day of t, as "19 Oct 2026" in English and
+// "19 ต.ค. 2026" in Thai. buddhistEra counts years from the Buddhist Era
+// instead, as "19 Oct 2569 BE" and "19 ต.ค. 2569".
+func FormatDate(tag Tag, t time.Time, buddhistEra bool) string {
+	year := t.Year()
+	if buddhistEra {
+		year += BuddhistEraOffset
+	}
+	day := strconv.Itoa(t.Day()) + " "
+
+	if tag == Thai {
+		return day + thaiMonths[t.Month()-1] + " " + strconv.Itoa(year)
+	}
+	s := day + t.Format("Jan") + " " + strconv.Itoa(year)
+	if buddhistEra {
+		s += " BE"
+	}
+	return s
+}
@@ -0,0 +1,119 @@
+// Package locale picks the language of API messages from the
+// Accept-Language header and formats amounts and dates for its readers.
+package locale
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	HeaderAcceptLanguage  = "Accept-Language"
+	HeaderContentLanguage = "Content-Language"
+)
+
+// Tag is a supported language
+type Tag string
+
+const (
+	English Tag = "en"
+	Thai    Tag = "th"
+)
+
+// Parse returns the supported language of a language tag such as "th-TH"
+func Parse(s string) (Tag, bool) {
+	base := strings.ToLower(strings.TrimSpace(s))
+	if i := strings.IndexAny(base, "-_"); i >= 0 {
+		base = base[:i]
+	}
+	switch t := Tag(base); t {
+	case English, Thai:
+		return t, true
+	}
+	return "", false
+}
+
+// Negotiate picks the supported language a client prefers in an
+// Accept-Language header, or fallback when it accepts none of them
+func Negotiate(header string, fallback Tag) Tag {
+	best, bestQ := fallback, 0.0
+	for _, part := range strings.Split(header, ",") {
+		lang, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = f
+		}
+
+		tag, ok := Parse(lang)
+		if strings.TrimSpace(lang) == "*" {
+			tag, ok = fallback, true
+		}
+		if ok && q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+	return best
+}
+
+type tagKey struct{}
+
+// WithTag returns ctx carrying the language of the request
+func WithTag(ctx context.Context, tag Tag) context.Context {
+	return context.WithValue(ctx, tagKey{}, tag)
+}
+
+// FromContext returns the language of the request ctx belongs to, ok is
+// false when it was not negotiated
+func FromContext(ctx context.Context) (tag Tag, ok bool) {
+	tag, ok = ctx.Value(tagKey{}).(Tag)
+	return tag, ok
+}
+
+// Middleware negotiates the language of every request, fallback answers
+// clients without a supported Accept-Language
+func Middleware(fallback Tag) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			tag := Negotiate(req.Header.Get(HeaderAcceptLanguage), fallback)
+			c.SetRequest(req.WithContext(WithTag(req.Context(), tag)))
+
+			h := c.Response().Header()
+			h.Set(HeaderContentLanguage, string(tag))
+			h.Add(echo.HeaderVary, HeaderAcceptLanguage)
+			return next(c)
+		}
+	}
+}
+
+// Catalog holds the messages of every language by key, in fmt format
+type Catalog map[Tag]map[string]string
+
+// Has reports whether key has a message in tag
+func (c Catalog) Has(tag Tag, key string) bool {
+	_, ok := c[tag][key]
+	return ok
+}
+
+// Sprintf formats the message of key in tag, falling back to English and
+// then to the key itself
+func (c Catalog) Sprintf(tag Tag, key string, args ...interface{}) string {
+	format, ok := c[tag][key]
+	if !ok {
+		if format, ok = c[English][key]; !ok {
+			return key
+		}
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
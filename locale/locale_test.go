@@ -0,0 +1,115 @@
+//go:build unit
+// +build unit
+
+package locale_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bazsup/assessment/locale"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in  string
+		tag locale.Tag
+		ok  bool
+	}{
+		{"en", locale.English, true},
+		{"th-TH", locale.Thai, true},
+		{" TH ", locale.Thai, true},
+		{"en_US", locale.English, true},
+		{"fr", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		tag, ok := locale.Parse(tt.in)
+
+		// Assertions
+		assert.Equal(t, tt.tag, tag, tt.in)
+		assert.Equal(t, tt.ok, ok, tt.in)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   locale.Tag
+	}{
+		{"empty header", "", locale.Thai},
+		{"single language", "en-US", locale.English},
+		{"first preferred", "th-TH,th;q=0.9,en;q=0.8", locale.Thai},
+		{"higher quality wins", "en;q=0.5, th;q=0.8", locale.Thai},
+		{"unsupported languages skipped", "fr-FR, de;q=0.9, en;q=0.1", locale.English},
+		{"nothing supported", "fr, de", locale.Thai},
+		{"wildcard", "fr, *;q=0.5", locale.Thai},
+		{"invalid quality skipped", "en;q=x, th;q=0.2", locale.Thai},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			got := locale.Negotiate(tt.header, locale.Thai)
+
+			// Assertions
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	e := echo.New()
+	e.Use(locale.Middleware(locale.English))
+	e.GET("/", func(c echo.Context) error {
+		tag, _ := locale.FromContext(c.Request().Context())
+		return c.String(http.StatusOK, string(tag))
+	})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(locale.HeaderAcceptLanguage, "th")
+	rec := httptest.NewRecorder()
+
+	// Act
+	e.ServeHTTP(rec, req)
+
+	// Assertions
+	assert.Equal(t, "th", rec.Body.String())
+	assert.Equal(t, "th", rec.Header().Get(locale.HeaderContentLanguage))
+	assert.Equal(t, locale.HeaderAcceptLanguage, rec.Header().Get(echo.HeaderVary))
+}
+
+func TestCatalog(t *testing.T) {
+	c := locale.Catalog{
+		locale.English: {"hello": "hello %s", "bye": "bye"},
+		locale.Thai:    {"hello": "สวัสดี %s"},
+	}
+
+	// Assertions
+	assert.Equal(t, "สวัสดี bob", c.Sprintf(locale.Thai, "hello", "bob"))
+	assert.Equal(t, "bye", c.Sprintf(locale.Thai, "bye"))
+	assert.Equal(t, "unknown", c.Sprintf(locale.Thai, "unknown"))
+	assert.True(t, c.Has(locale.English, "bye"))
+	assert.False(t, c.Has(locale.Thai, "bye"))
+}
+
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		tag    locale.Tag
+		amount float64
+		want   string
+	}{
+		{locale.English, 1234.5, "THB 1,234.50"},
+		{locale.Thai, 1234.5, "1,234.50 บาท"},
+		{locale.English, 0, "THB 0.00"},
+		{locale.English, 999, "THB 999.00"},
+		{locale.English, 1234567.891, "THB 1,234,567.89"},
+		{locale.Thai, -1000, "-1,000.00 บาท"},
+	}
+	for _, tt := range tests {
+		// Assertions
+		assert.Equal(t, tt.want, locale.FormatAmount(tt.tag, tt.amount))
+	}
+}
@@ -0,0 +1,197 @@
+//go:build unit
+// +build unit
+
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bazsup/assessment/logging"
+	"github.com/bazsup/assessment/problem"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+type secret struct{ note string }
+
+func (s secret) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("title", "lunch"), slog.String("note", s.note))
+}
+
+func setupLogger(t *testing.T, cfg logging.Config) (*slog.Logger, *bytes.Buffer) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return logger, &buf
+}
+
+// lines decodes the JSON log lines written to buf
+func lines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+
+	var out []map[string]interface{}
+	for _, l := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(l), &m); err != nil {
+			t.Fatalf("log line is not JSON: %s", l)
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func TestNew(t *testing.T) {
+	t.Run("Invalid level should returns error", func(t *testing.T) {
+		_, err := logging.New(&bytes.Buffer{}, logging.Config{Level: "loud", Format: "json"})
+
+		assert.Error(t, err)
+	})
+
+	t.Run("Invalid format should returns error", func(t *testing.T) {
+		_, err := logging.New(&bytes.Buffer{}, logging.Config{Level: "info", Format: "xml"})
+
+		assert.Error(t, err)
+	})
+
+	t.Run("Level filters records", func(t *testing.T) {
+		logger, buf := setupLogger(t, logging.Config{Level: "warn", Format: "json"})
+
+		// Act
+		logger.Info("hidden")
+		logger.Warn("shown")
+
+		// Assertions
+		assert.NotContains(t, buf.String(), "hidden")
+		assert.Contains(t, buf.String(), "shown")
+	})
+}
+
+func TestRedaction(t *testing.T) {
+	t.Run("Credentials and notes are redacted", func(t *testing.T) {
+		logger, buf := setupLogger(t, logging.Config{Level: "info", Format: "json", Redact: true})
+
+		// Act
+		logger.Info("request", "Authorization", "Bearer abc", "expense", secret{"my salary"})
+
+		// Assertions
+		out := buf.String()
+		assert.NotContains(t, out, "Bearer abc")
+		assert.NotContains(t, out, "my salary")
+		line := lines(t, buf)[0]
+		assert.Equal(t, logging.Redacted, line["Authorization"])
+		assert.Equal(t, map[string]interface{}{"title": "lunch", "note": logging.Redacted}, line["expense"])
+	})
+
+	t.Run("Redaction can be turned off", func(t *testing.T) {
+		logger, buf := setupLogger(t, logging.Config{Level: "info", Format: "json"})
+
+		// Act
+		logger.Info("request", "expense", secret{"my salary"})
+
+		// Assertions
+		assert.Contains(t, buf.String(), "my salary")
+	})
+}
+
+func TestContextAttributes(t *testing.T) {
+	logger, buf := setupLogger(t, logging.Config{Level: "info", Format: "json"})
+	ctx := logging.WithRequestID(context.Background(), "req-1")
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(ctx, "op")
+
+	// Act
+	logger.With("component", "test").InfoContext(ctx, "hello")
+
+	// Assertions
+	line := lines(t, buf)[0]
+	assert.Equal(t, "req-1", line["request_id"])
+	assert.Equal(t, span.SpanContext().TraceID().String(), line["trace_id"])
+	assert.Equal(t, span.SpanContext().SpanID().String(), line["span_id"])
+	assert.Equal(t, "test", line["component"])
+}
+
+func setupApp(t *testing.T, level string) (*echo.Echo, *bytes.Buffer) {
+	logger, buf := setupLogger(t, logging.Config{Level: level, Format: "json", Redact: true})
+
+	e := echo.New()
+	e.HTTPErrorHandler = problem.Handler(logger)
+	e.Use(logging.Middleware(logger))
+	e.GET("/expenses", func(c echo.Context) error {
+		return c.String(http.StatusOK, logging.RequestID(c.Request().Context()))
+	})
+	return e, buf
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Run("Request id is generated and logged", func(t *testing.T) {
+		e, buf := setupApp(t, "info")
+		rec := httptest.NewRecorder()
+
+		// Act
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/expenses", nil))
+
+		// Assertions
+		id := rec.Header().Get(logging.HeaderRequestID)
+		assert.Len(t, id, 32)
+		assert.Equal(t, id, rec.Body.String())
+		line := lines(t, buf)[0]
+		assert.Equal(t, id, line["request_id"])
+		assert.Equal(t, "/expenses", line["route"])
+		assert.Equal(t, float64(http.StatusOK), line["status"])
+		assert.Nil(t, line["headers"])
+	})
+
+	t.Run("Valid incoming request id is kept", func(t *testing.T) {
+		e, _ := setupApp(t, "info")
+		req := httptest.NewRequest(http.MethodGet, "/expenses", nil)
+		req.Header.Set(logging.HeaderRequestID, "3f2b8c1e-upstream")
+		rec := httptest.NewRecorder()
+
+		// Act
+		e.ServeHTTP(rec, req)
+
+		// Assertions
+		assert.Equal(t, "3f2b8c1e-upstream", rec.Header().Get(logging.HeaderRequestID))
+	})
+
+	t.Run("Invalid incoming request id is replaced", func(t *testing.T) {
+		e, _ := setupApp(t, "info")
+		req := httptest.NewRequest(http.MethodGet, "/expenses", nil)
+		req.Header.Set(logging.HeaderRequestID, "evil\" injected")
+		rec := httptest.NewRecorder()
+
+		// Act
+		e.ServeHTTP(rec, req)
+
+		// Assertions
+		assert.NotEqual(t, "evil\" injected", rec.Header().Get(logging.HeaderRequestID))
+		assert.Len(t, rec.Header().Get(logging.HeaderRequestID), 32)
+	})
+
+	t.Run("Debug level logs headers with authorization redacted", func(t *testing.T) {
+		e, buf := setupApp(t, "debug")
+		req := httptest.NewRequest(http.MethodGet, "/expenses", nil)
+		req.Header.Set(echo.HeaderAuthorization, "November 10, 2009")
+		req.Header.Set(echo.HeaderAccept, "application/json")
+
+		// Act
+		e.ServeHTTP(httptest.NewRecorder(), req)
+
+		// Assertions
+		assert.NotContains(t, buf.String(), "November 10, 2009")
+		headers := lines(t, buf)[0]["headers"].(map[string]interface{})
+		assert.Equal(t, logging.Redacted, headers["authorization"])
+		assert.Equal(t, "application/json", headers["accept"])
+	})
+}
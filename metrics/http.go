@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/bazsup/assessment/problem"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ContentType of the Prometheus text exposition format, served to scrapers
+// not negotiating the protobuf one
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// HTTP instruments echo routes by route template, so /expenses/1 and
+// /expenses/2 share the /expenses/:id series
+type HTTP struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inFlight prometheus.Gauge
+}
+
+func NewHTTP(r prometheus.Registerer) *HTTP {
+	m := &HTTP{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests by route and status.",
+		}, []string{"method", "route", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "HTTP request latency by route and status.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "http_requests_in_flight",
+			Help: "HTTP requests currently being served.",
+		}),
+	}
+	r.MustRegister(m.requests, m.duration, m.inFlight)
+	return m
+}
+
+func (m *HTTP) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+
+		start := time.Now()
+		err := next(c)
+
+		// the error handler answers errors nothing was written for
+		status := c.Response().Status
+		if err != nil && !c.Response().Committed {
+			status = problem.StatusOf(err)
+		}
+		method, route := c.Request().Method, c.Path()
+		m.requests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+		m.duration.WithLabelValues(method, route, strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
@@ -0,0 +1,48 @@
+//go:build unit
+// +build unit
+
+package metrics_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bazsup/assessment/metrics"
+	"github.com/bazsup/assessment/problem"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPMiddleware(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := metrics.NewHTTP(reg)
+
+	e := echo.New()
+	e.GET("/expenses/:id", func(c echo.Context) error { return c.NoContent(http.StatusOK) }, m.Middleware)
+	e.GET("/boom", func(c echo.Context) error { return errors.New("boom") }, m.Middleware)
+	e.GET("/missing", func(c echo.Context) error { return problem.New(problem.ExpenseNotFound, "") }, m.Middleware)
+
+	// Act
+	for _, path := range []string{"/expenses/1", "/expenses/2", "/boom", "/missing"} {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	// Assertions
+	err := testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP http_requests_total HTTP requests by route and status.
+# TYPE http_requests_total counter
+http_requests_total{method="GET",route="/boom",status="500"} 1
+http_requests_total{method="GET",route="/expenses/:id",status="200"} 2
+http_requests_total{method="GET",route="/missing",status="404"} 1
+# HELP http_requests_in_flight HTTP requests currently being served.
+# TYPE http_requests_in_flight gauge
+http_requests_in_flight 0
+`), "http_requests_total", "http_requests_in_flight")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, testutil.CollectAndCount(reg, "http_request_duration_seconds"))
+}
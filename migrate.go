@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/bazsup/assessment/health"
+	"github.com/bazsup/assessment/migration"
+)
+
+const migrateUsage = "usage: migrate up | down [steps] | status"
+
+// runMigrate implements the "migrate up|down|status" subcommand
+func runMigrate(db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	ctx := context.Background()
+	m := migration.New(db)
+
+	switch args[0] {
+	case "up":
+		done, err := m.Up(ctx)
+		for _, mg := range done {
+			slog.Info("applied migration", "version", mg.Version, "name", mg.Name)
+		}
+		if err == nil && len(done) == 0 {
+			slog.Info("schema is up to date")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid steps %q: %s", args[1], migrateUsage)
+			}
+			steps = n
+		}
+		done, err := m.Down(ctx, steps)
+		for _, mg := range done {
+			slog.Info("reverted migration", "version", mg.Version, "name", mg.Name)
+		}
+		return err
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05 MST")
+			}
+			fmt.Printf("%04d  %-30s  %s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q: %s", args[0], migrateUsage)
+	}
+}
+
+// migrateOnStartup applies or checks migrations according to the MIGRATIONS_MODE config
+func migrateOnStartup(db *sql.DB, mode string) error {
+	ctx := context.Background()
+	m := migration.New(db)
+
+	switch mode {
+	case "auto":
+		done, err := m.Up(ctx)
+		for _, mg := range done {
+			slog.Info("applied migration", "version", mg.Version, "name", mg.Name)
+		}
+		return err
+	case "check":
+		pending, err := m.Pending(ctx)
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			return fmt.Errorf("database schema is behind by %d migration(s), run \"migrate up\" first", len(pending))
+		}
+		return nil
+	case "off":
+		return nil
+	default:
+		return fmt.Errorf("unknown MIGRATIONS_MODE %q", mode)
+	}
+}
+
+// migrationsCurrent fails while migrations are pending
+func migrationsCurrent(m *migration.Migrator) health.CheckFunc {
+	return func(ctx context.Context) error {
+		pending, err := m.Pending(ctx)
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			return fmt.Errorf("%d migration(s) pending", len(pending))
+		}
+		return nil
+	}
+}
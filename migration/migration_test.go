@@ -0,0 +1,175 @@
+//go:build unit
+// +build unit
+
+package migration_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bazsup/assessment/migration"
+	"github.com/stretchr/testify/assert"
+)
+
+var testMigrations = []migration.Migration{
+	{Version: 1, Name: "create_expenses", Up: "CREATE TABLE expenses ()", Down: "DROP TABLE expenses"},
+	{Version: 2, Name: "add_column", Up: "ALTER TABLE expenses ADD COLUMN x INT", Down: "ALTER TABLE expenses DROP COLUMN x"},
+}
+
+func setupDB(t *testing.T) (*migration.Migrator, sqlmock.Sqlmock) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	return migration.NewWithMigrations(db, testMigrations), mock
+}
+
+func expectLocked(mock sqlmock.Sqlmock, applied *sqlmock.Rows) {
+	mock.ExpectExec("SELECT pg_advisory_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").WillReturnRows(applied)
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("Load sorts migrations by version", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"0002_b.up.sql":   {Data: []byte("B")},
+			"0001_a.up.sql":   {Data: []byte("A")},
+			"0001_a.down.sql": {Data: []byte("-A")},
+			"README.md":       {Data: []byte("ignored")},
+		}
+
+		// Act
+		ms, err := migration.Load(fsys)
+
+		// Assertions
+		if assert.NoError(t, err) && assert.Len(t, ms, 2) {
+			assert.Equal(t, migration.Migration{Version: 1, Name: "a", Up: "A", Down: "-A"}, ms[0])
+			assert.Equal(t, 2, ms[1].Version)
+		}
+	})
+
+	t.Run("Down file without up file should returns error", func(t *testing.T) {
+		// Act
+		_, err := migration.Load(fstest.MapFS{"0001_a.down.sql": {Data: []byte("-A")}})
+
+		// Assertions
+		assert.Error(t, err)
+	})
+
+	t.Run("Embedded migrations start with the expenses table", func(t *testing.T) {
+		// Act
+		ms := migration.Embedded()
+
+		// Assertions
+		if assert.NotEmpty(t, ms) {
+			assert.Equal(t, "create_expenses", ms[0].Name)
+			assert.Contains(t, ms[0].Up, "CREATE TABLE IF NOT EXISTS expenses")
+		}
+	})
+}
+
+func TestUp(t *testing.T) {
+	t.Run("Up applies only pending migrations under the advisory lock", func(t *testing.T) {
+		m, mock := setupDB(t)
+
+		// Arrange
+		expectLocked(mock, sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, time.Now()))
+		mock.ExpectBegin()
+		mock.ExpectExec("ALTER TABLE expenses ADD COLUMN x INT").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO schema_migrations").WithArgs(2, "add_column").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectExec("SELECT pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		// Act
+		done, err := m.Up(context.Background())
+
+		// Assertions
+		if assert.NoError(t, err) && assert.Len(t, done, 1) {
+			assert.Equal(t, 2, done[0].Version)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Failed migration rolls back and stops", func(t *testing.T) {
+		m, mock := setupDB(t)
+
+		// Arrange
+		expectLocked(mock, sqlmock.NewRows([]string{"version", "applied_at"}))
+		mock.ExpectBegin()
+		mock.ExpectExec("CREATE TABLE expenses").WillReturnError(fmt.Errorf("syntax error"))
+		mock.ExpectRollback()
+		mock.ExpectExec("SELECT pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		// Act
+		done, err := m.Up(context.Background())
+
+		// Assertions
+		assert.ErrorContains(t, err, "1_create_expenses up: syntax error")
+		assert.Empty(t, done)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDown(t *testing.T) {
+	m, mock := setupDB(t)
+
+	// Arrange
+	now := time.Now()
+	expectLocked(mock, sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, now).AddRow(2, now))
+	mock.ExpectBegin()
+	mock.ExpectExec("ALTER TABLE expenses DROP COLUMN x").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM schema_migrations WHERE version = .+").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("SELECT pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// Act
+	done, err := m.Down(context.Background(), 1)
+
+	// Assertions
+	if assert.NoError(t, err) && assert.Len(t, done, 1) {
+		assert.Equal(t, 2, done[0].Version)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPending(t *testing.T) {
+	t.Run("Pending reads applied migrations without DDL", func(t *testing.T) {
+		m, mock := setupDB(t)
+
+		// Arrange
+		mock.ExpectQuery("SELECT to_regclass").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").
+			WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, time.Now()))
+
+		// Act
+		pending, err := m.Pending(context.Background())
+
+		// Assertions
+		if assert.NoError(t, err) && assert.Len(t, pending, 1) {
+			assert.Equal(t, "add_column", pending[0].Name)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Every migration is pending before schema_migrations exists", func(t *testing.T) {
+		m, mock := setupDB(t)
+
+		// Arrange
+		mock.ExpectQuery("SELECT to_regclass").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+		// Act
+		pending, err := m.Pending(context.Background())
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.Len(t, pending, 2)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+	key TEXT PRIMARY KEY,
+	tokens FLOAT NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS rate_limit_quotas (
+	key TEXT NOT NULL,
+	day DATE NOT NULL,
+	count INT NOT NULL,
+	PRIMARY KEY (key, day)
+);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	key TEXT PRIMARY KEY,
+	fingerprint TEXT NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	status INT,
+	content_type TEXT,
+	body BYTEA
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS expense_audit;
+DROP FUNCTION IF EXISTS expense_audit_append_only();
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS expense_audit (
+	id BIGSERIAL PRIMARY KEY,
+	expense_id INT NOT NULL,
+	action TEXT NOT NULL,
+	actor TEXT NOT NULL,
+	request_id TEXT NOT NULL DEFAULT '',
+	source_ip TEXT NOT NULL DEFAULT '',
+	changes JSONB NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS expense_audit_expense_id_idx ON expense_audit ( expense_id, id );
+CREATE INDEX IF NOT EXISTS expense_audit_actor_idx ON expense_audit ( actor, id );
+
+-- audit records are append only, corrections are new records
+CREATE OR REPLACE FUNCTION expense_audit_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'expense_audit is append only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER expense_audit_append_only
+	BEFORE UPDATE OR DELETE ON expense_audit
+	FOR EACH ROW EXECUTE FUNCTION expense_audit_append_only();
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS audit_checkpoints;
+ALTER TABLE expense_audit DROP COLUMN IF EXISTS hash, DROP COLUMN IF EXISTS prev_hash;
@@ -0,0 +1,14 @@
+-- entries written before this migration keep empty hashes and are
+-- reported as unchained by audit verification
+ALTER TABLE expense_audit
+	ADD COLUMN IF NOT EXISTS prev_hash TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS hash TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS audit_checkpoints (
+	id BIGSERIAL PRIMARY KEY,
+	audit_id BIGINT NOT NULL,
+	hash TEXT NOT NULL,
+	key_id TEXT NOT NULL,
+	signature TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,22 @@
+-- every version an expense has had, valid from valid_from until valid_to,
+-- the current version has no valid_to and a deleted expense has none open
+CREATE TABLE IF NOT EXISTS expense_versions (
+	expense_id INT NOT NULL,
+	version INT NOT NULL,
+	title TEXT,
+	amount FLOAT,
+	note TEXT,
+	tags TEXT[],
+	valid_from TIMESTAMPTZ NOT NULL,
+	valid_to TIMESTAMPTZ,
+	PRIMARY KEY ( expense_id, version )
+);
+
+CREATE INDEX IF NOT EXISTS expense_versions_valid_idx ON expense_versions ( valid_from, valid_to );
+
+-- expenses predating versioning start at their last audited change, or now
+INSERT INTO expense_versions ( expense_id, version, title, amount, note, tags, valid_from )
+SELECT e.id, 1, e.title, e.amount, e.note, e.tags,
+	COALESCE(( SELECT max(a.created_at) FROM expense_audit a WHERE a.expense_id = e.id ), now())
+FROM expenses e
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS expense_events;
+DROP FUNCTION IF EXISTS expense_events_append_only();
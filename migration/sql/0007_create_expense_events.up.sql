@@ -0,0 +1,24 @@
+-- the event log of the event sourced store, expenses and expense_versions
+-- are projections of it rebuilt by "rebuild-projection"
+CREATE TABLE IF NOT EXISTS expense_events (
+	seq BIGSERIAL PRIMARY KEY,
+	expense_id INT NOT NULL,
+	version INT NOT NULL,
+	type TEXT NOT NULL,
+	data JSONB NOT NULL,
+	actor TEXT NOT NULL,
+	request_id TEXT NOT NULL DEFAULT '',
+	source_ip TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL,
+	UNIQUE ( expense_id, version )
+);
+
+CREATE OR REPLACE FUNCTION expense_events_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'expense_events is append only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER expense_events_append_only
+	BEFORE UPDATE OR DELETE ON expense_events
+	FOR EACH ROW EXECUTE FUNCTION expense_events_append_only();
@@ -0,0 +1,42 @@
+-- expense changes waiting to be fanned out to webhook subscriptions,
+-- written in the transaction making the change
+CREATE TABLE IF NOT EXISTS outbox (
+	id BIGSERIAL PRIMARY KEY,
+	event_type TEXT NOT NULL,
+	expense_id INT NOT NULL,
+	payload JSONB NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	dispatched_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS outbox_undispatched_idx ON outbox ( id ) WHERE dispatched_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+	id BIGSERIAL PRIMARY KEY,
+	url TEXT NOT NULL,
+	secret TEXT NOT NULL,
+	-- event types delivered, empty for every type
+	events TEXT[] NOT NULL DEFAULT '{}',
+	active BOOLEAN NOT NULL DEFAULT true,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- one delivery per subscription and event, kept as the delivery log;
+-- dead deliveries exhausted their attempts and wait for a redelivery
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id BIGSERIAL PRIMARY KEY,
+	subscription_id BIGINT NOT NULL REFERENCES webhook_subscriptions ( id ),
+	outbox_id BIGINT NOT NULL,
+	event_type TEXT NOT NULL,
+	body TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INT NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMPTZ NOT NULL,
+	last_status_code INT NOT NULL DEFAULT 0,
+	last_error TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	delivered_at TIMESTAMPTZ,
+	UNIQUE ( subscription_id, outbox_id )
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries ( next_attempt_at ) WHERE status = 'pending';
@@ -0,0 +1,13 @@
+-- wakes the stream listeners of every replica once a change commits, the
+-- payload is the outbox id though listeners read every event after the
+-- last one they published
+CREATE OR REPLACE FUNCTION outbox_notify() RETURNS trigger AS $$
+BEGIN
+	PERFORM pg_notify('expense_changes', NEW.id::text);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER outbox_notify
+	AFTER INSERT ON outbox
+	FOR EACH ROW EXECUTE FUNCTION outbox_notify();
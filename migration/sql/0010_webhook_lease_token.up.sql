@@ -0,0 +1,3 @@
+-- identifies the claim holding a delivery, so a dispatcher whose lease
+-- lapsed can't overwrite the outcome of the one that claimed it next
+ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS lease_token TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS audit_checkpoints_append_only ON audit_checkpoints;
+DROP FUNCTION IF EXISTS audit_checkpoints_append_only();
+ALTER TABLE audit_checkpoints DROP COLUMN IF EXISTS seq;
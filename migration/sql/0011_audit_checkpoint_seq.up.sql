@@ -0,0 +1,21 @@
+-- checkpoints are numbered without gaps so verification notices a deleted
+-- one, and like audit records they are append only
+ALTER TABLE audit_checkpoints ADD COLUMN IF NOT EXISTS seq BIGINT;
+
+UPDATE audit_checkpoints c SET seq = n.seq
+FROM ( SELECT id, row_number() OVER ( ORDER BY id ) AS seq FROM audit_checkpoints ) n
+WHERE c.id = n.id;
+
+ALTER TABLE audit_checkpoints
+	ALTER COLUMN seq SET NOT NULL,
+	ADD CONSTRAINT audit_checkpoints_seq_key UNIQUE ( seq );
+
+CREATE OR REPLACE FUNCTION audit_checkpoints_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_checkpoints is append only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_checkpoints_append_only
+	BEFORE UPDATE OR DELETE ON audit_checkpoints
+	FOR EACH ROW EXECUTE FUNCTION audit_checkpoints_append_only();
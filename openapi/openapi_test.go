@@ -0,0 +1,99 @@
+//go:build unit
+// +build unit
+
+package openapi_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/bazsup/assessment/openapi"
+	"github.com/stretchr/testify/assert"
+)
+
+type base struct {
+	ID int `json:"id"`
+}
+
+type item struct {
+	base
+	Name     string            `json:"name"`
+	Note     string            `json:"note,omitempty"`
+	Tags     []string          `json:"tags"`
+	Labels   map[string]string `json:"labels"`
+	At       time.Time         `json:"at"`
+	Until    *time.Time        `json:"until"`
+	Parent   *item             `json:"parent"`
+	Internal string            `json:"-"`
+	hidden   string
+}
+
+func TestSchemaOf(t *testing.T) {
+	t.Run("Named struct is referenced from the components", func(t *testing.T) {
+		doc := openapi.New(openapi.Info{Title: "test", Version: "1"})
+
+		// Act
+		ref := doc.SchemaOf([]item{})
+
+		// Assertions
+		assert.Equal(t, "array", ref.Type)
+		assert.Equal(t, "#/components/schemas/item", ref.Items.Ref)
+
+		s := doc.Components.Schemas["item"]
+		if assert.NotNil(t, s) {
+			assert.ElementsMatch(t, []string{"id", "name", "tags", "labels", "at", "until", "parent"}, s.Required)
+			assert.NotContains(t, s.Properties, "Internal")
+			assert.NotContains(t, s.Properties, "hidden")
+			assert.Equal(t, "integer", s.Properties["id"].Type)
+			assert.Equal(t, "date-time", s.Properties["at"].Format)
+			assert.Equal(t, []string{"string", "null"}, s.Properties["until"].Type)
+			assert.Equal(t, "#/components/schemas/item", s.Properties["parent"].OneOf[0].Ref)
+			assert.Equal(t, "string", s.Properties["labels"].AdditionalProperties.(*openapi.Schema).Type)
+		}
+	})
+
+	t.Run("Schema is used as is", func(t *testing.T) {
+		doc := openapi.New(openapi.Info{Title: "test", Version: "1"})
+		s := &openapi.Schema{Type: "string"}
+
+		// Act
+		got := doc.SchemaOf(s)
+
+		// Assertions
+		assert.Same(t, s, got)
+	})
+
+	t.Run("Document encodes as OpenAPI 3.1", func(t *testing.T) {
+		doc := openapi.New(openapi.Info{Title: "test", Version: "1"})
+		doc.Add("GET", "/items/:id", &openapi.Operation{Responses: map[string]*openapi.Response{"200": {Description: "OK"}}, Security: []openapi.SecurityRequirement{}})
+
+		// Act
+		b, err := json.Marshal(doc)
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"openapi": "3.1.0", "info": {"title": "test", "version": "1"},
+			"paths": {"/items/{id}": {"get": {"responses": {"200": {"description": "OK"}}, "security": []}}},
+			"components": {}}`, string(b))
+	})
+}
+
+func TestPath(t *testing.T) {
+	for echoPath, want := range map[string]struct {
+		path   string
+		params []string
+	}{
+		"/expenses":                         {"/expenses", nil},
+		"/expenses/:id/versions":            {"/expenses/{id}/versions", []string{"id"}},
+		"/admin/webhooks/deliveries/:did/x": {"/admin/webhooks/deliveries/{did}/x", []string{"did"}},
+		"/docs/*":                           {"/docs/{path}", []string{"path"}},
+	} {
+		// Act
+		path, params := openapi.Path(echoPath)
+
+		// Assertions
+		assert.Equal(t, want.path, path)
+		assert.Equal(t, want.params, params)
+	}
+}
@@ -0,0 +1,314 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Where a field error was found
+const (
+	InBody   = "body"
+	InQuery  = "query"
+	InHeader = "header"
+)
+
+// FieldError is a request field failing its schema. Rule is the schema
+// keyword that failed and Limit its value, so clients can word the error.
+type FieldError struct {
+	In      string      `json:"in"`
+	Field   string      `json:"field"`
+	Rule    string      `json:"rule"`
+	Limit   interface{} `json:"limit,omitempty"`
+	Message string      `json:"message"`
+}
+
+// ValidateRequest checks the query and header params and the JSON body of
+// req against op, returning every field error. The body is left for the
+// handler to read.
+func (d *Document) ValidateRequest(op *Operation, req *http.Request, body []byte) []FieldError {
+	var errs []FieldError
+	for _, p := range op.Parameters {
+		var values []string
+		switch p.In {
+		case InQuery:
+			values = req.URL.Query()[p.Name]
+		case InHeader:
+			values = req.Header.Values(p.Name)
+		default:
+			continue
+		}
+		if len(values) == 0 || values[0] == "" {
+			if p.Required {
+				errs = append(errs, FieldError{In: p.In, Field: p.Name, Rule: "required", Message: "is required"})
+			}
+			continue
+		}
+		errs = append(errs, d.validateParam(p, values[0])...)
+	}
+
+	if op.RequestBody == nil {
+		return errs
+	}
+	media, ok := op.RequestBody.Content["application/json"]
+	if !ok {
+		return errs
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		if op.RequestBody.Required {
+			errs = append(errs, FieldError{In: InBody, Rule: "required", Message: "is required"})
+		}
+		return errs
+	}
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return append(errs, FieldError{In: InBody, Rule: "json", Message: "must be valid JSON"})
+	}
+	return append(errs, d.Validate(media.Schema, v, InBody, "")...)
+}
+
+// validateParam parses a param value as its schema type before checking it
+func (d *Document) validateParam(p Parameter, value string) []FieldError {
+	s := d.resolve(p.Schema)
+	typ, _ := s.Type.(string)
+	var v interface{} = value
+	switch typ {
+	case "integer", "number":
+		n := json.Number(value)
+		if _, err := n.Float64(); err != nil {
+			return []FieldError{{In: p.In, Field: p.Name, Rule: "type", Limit: typ, Message: typeMessage(typ)}}
+		}
+		v = n
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return []FieldError{{In: p.In, Field: p.Name, Rule: "type", Limit: typ, Message: typeMessage(typ)}}
+		}
+		v = b
+	}
+	return d.Validate(s, v, p.In, p.Name)
+}
+
+// Validate checks v against s, a schema referencing no component
+func Validate(s *Schema, v interface{}, in, field string) []FieldError {
+	return (&Document{}).Validate(s, v, in, field)
+}
+
+// Validate checks v, a JSON value decoded with UseNumber, against s.
+// Fields are named by a dotted path from field, as in tags[1].
+func (d *Document) Validate(s *Schema, v interface{}, in, field string) []FieldError {
+	s = d.resolve(s)
+	fail := func(rule string, limit interface{}, format string, args ...interface{}) []FieldError {
+		return []FieldError{{In: in, Field: field, Rule: rule, Limit: limit, Message: fmt.Sprintf(format, args...)}}
+	}
+
+	if v == nil {
+		if s.allows("null") || s.Type == nil {
+			return nil
+		}
+		return fail("type", s.typeName(), "%s", typeMessage(s.typeName()))
+	}
+	if len(s.OneOf) > 0 {
+		for _, alt := range s.OneOf {
+			if len(d.Validate(alt, v, in, field)) == 0 {
+				return nil
+			}
+		}
+		return fail("oneOf", nil, "does not match any allowed schema")
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if fmt.Sprint(e) == fmt.Sprint(v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fail("enum", s.Enum, "must be one of %s", enumList(s.Enum))
+		}
+	}
+
+	switch v := v.(type) {
+	case string:
+		if !s.allows("string") {
+			return fail("type", s.typeName(), "%s", typeMessage(s.typeName()))
+		}
+		n := utf8.RuneCountInString(v)
+		if s.MinLength != nil && n < *s.MinLength {
+			if *s.MinLength == 1 {
+				return fail("minLength", *s.MinLength, "must not be empty")
+			}
+			return fail("minLength", *s.MinLength, "must be at least %d characters", *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			return fail("maxLength", *s.MaxLength, "must be at most %d characters", *s.MaxLength)
+		}
+		if s.Pattern != "" && !pattern(s.Pattern).MatchString(v) {
+			return fail("pattern", s.Pattern, "has an invalid format")
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, v); err != nil {
+				return fail("format", s.Format, "must be an RFC 3339 time")
+			}
+		}
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil || !(s.allows("number") || s.allows("integer")) {
+			return fail("type", s.typeName(), "%s", typeMessage(s.typeName()))
+		}
+		if !s.allows("number") && f != math.Trunc(f) {
+			return fail("type", "integer", "%s", typeMessage("integer"))
+		}
+		if s.Minimum != nil && f < *s.Minimum {
+			return fail("minimum", *s.Minimum, "must be at least %v", *s.Minimum)
+		}
+		if s.ExclusiveMinimum != nil && f <= *s.ExclusiveMinimum {
+			return fail("exclusiveMinimum", *s.ExclusiveMinimum, "must be greater than %v", *s.ExclusiveMinimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			return fail("maximum", *s.Maximum, "must be at most %v", *s.Maximum)
+		}
+	case bool:
+		if !s.allows("boolean") {
+			return fail("type", s.typeName(), "%s", typeMessage(s.typeName()))
+		}
+	case []interface{}:
+		if !s.allows("array") {
+			return fail("type", s.typeName(), "%s", typeMessage(s.typeName()))
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			return fail("maxItems", *s.MaxItems, "must have at most %d items", *s.MaxItems)
+		}
+		var errs []FieldError
+		if s.Items != nil {
+			for i, item := range v {
+				errs = append(errs, d.Validate(s.Items, item, in, fmt.Sprintf("%s[%d]", field, i))...)
+			}
+		}
+		return errs
+	case map[string]interface{}:
+		if !s.allows("object") {
+			return fail("type", s.typeName(), "%s", typeMessage(s.typeName()))
+		}
+		return d.validateObject(s, v, in, field)
+	}
+	return nil
+}
+
+func (d *Document) validateObject(s *Schema, v map[string]interface{}, in, field string) []FieldError {
+	var errs []FieldError
+	for _, name := range s.Required {
+		if _, ok := v[name]; !ok {
+			errs = append(errs, FieldError{In: in, Field: join(field, name), Rule: "required", Message: "is required"})
+		}
+	}
+
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if p, ok := s.Properties[name]; ok {
+			errs = append(errs, d.Validate(p, v[name], in, join(field, name))...)
+			continue
+		}
+		switch extra := s.AdditionalProperties.(type) {
+		case bool:
+			if !extra {
+				errs = append(errs, FieldError{In: in, Field: join(field, name), Rule: "additionalProperties", Message: "is not allowed"})
+			}
+		case *Schema:
+			errs = append(errs, d.Validate(extra, v[name], in, join(field, name))...)
+		}
+	}
+	return errs
+}
+
+// resolve follows a reference to the component schemas
+func (d *Document) resolve(s *Schema) *Schema {
+	for s != nil && s.Ref != "" {
+		s = d.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	if s == nil {
+		return &Schema{}
+	}
+	return s
+}
+
+// allows reports whether a value of JSON type typ may match s, an integer
+// is also a number
+func (s *Schema) allows(typ string) bool {
+	switch t := s.Type.(type) {
+	case nil:
+		return true
+	case string:
+		return t == typ || (t == "number" && typ == "integer")
+	case []string:
+		for _, t := range t {
+			if t == typ || (t == "number" && typ == "integer") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// typeName is the first type s allows
+func (s *Schema) typeName() string {
+	switch t := s.Type.(type) {
+	case string:
+		return t
+	case []string:
+		return t[0]
+	}
+	return ""
+}
+
+func typeMessage(typ string) string {
+	switch typ {
+	case "array", "object", "integer":
+		return "must be an " + typ
+	default:
+		return "must be a " + typ
+	}
+}
+
+func join(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
+
+func enumList(enum []interface{}) string {
+	values := make([]string, len(enum))
+	for i, e := range enum {
+		values[i] = fmt.Sprint(e)
+	}
+	return strings.Join(values, ", ")
+}
+
+// patterns caches the compiled schema patterns
+var patterns sync.Map
+
+func pattern(expr string) *regexp.Regexp {
+	if re, ok := patterns.Load(expr); ok {
+		return re.(*regexp.Regexp)
+	}
+	re := regexp.MustCompile(expr)
+	patterns.Store(expr, re)
+	return re
+}
@@ -0,0 +1,158 @@
+//go:build unit
+// +build unit
+
+package openapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bazsup/assessment/openapi"
+	"github.com/stretchr/testify/assert"
+)
+
+func decode(t *testing.T, s string) interface{} {
+	var v interface{}
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func rules(errs []openapi.FieldError) map[string]string {
+	m := map[string]string{}
+	for _, e := range errs {
+		m[e.Field] = e.Rule
+	}
+	return m
+}
+
+var itemSchema = &openapi.Schema{
+	Type:     "object",
+	Required: []string{"name", "count"},
+	Properties: map[string]*openapi.Schema{
+		"name":  {Type: "string", MinLength: ptr(1), MaxLength: ptr(5)},
+		"count": {Type: "integer", Minimum: ptr(1.0), Maximum: ptr(10.0)},
+		"price": {Type: "number", ExclusiveMinimum: ptr(0.0)},
+		"kind":  {Type: "string", Enum: []interface{}{"a", "b"}},
+		"code":  {Type: "string", Pattern: `^[A-Z]+$`},
+		"tags":  {Type: "array", MaxItems: ptr(2), Items: &openapi.Schema{Type: "string"}},
+		"note":  {Type: []string{"string", "null"}},
+		"meta":  {Type: "object", AdditionalProperties: &openapi.Schema{Type: "integer"}},
+	},
+	AdditionalProperties: false,
+}
+
+func TestValidate(t *testing.T) {
+	t.Run("Valid value has no error", func(t *testing.T) {
+		// Act
+		errs := openapi.Validate(itemSchema, decode(t, `{"name": "ชา", "count": 2, "price": 0.5, "kind": "a", "code": "AB",
+			"tags": ["x"], "note": null, "meta": {"x": 1}}`), openapi.InBody, "")
+
+		// Assertions
+		assert.Empty(t, errs)
+	})
+
+	t.Run("Every failing field is reported", func(t *testing.T) {
+		// Act
+		errs := openapi.Validate(itemSchema, decode(t, `{"name": "toolong", "count": 1.5, "price": 0, "kind": "c", "code": "ab",
+			"tags": ["x", "y", "z"], "note": 1, "meta": {"x": "1"}, "extra": true}`), openapi.InBody, "")
+
+		// Assertions
+		assert.Equal(t, map[string]string{
+			"name":   "maxLength",
+			"count":  "type",
+			"price":  "exclusiveMinimum",
+			"kind":   "enum",
+			"code":   "pattern",
+			"tags":   "maxItems",
+			"note":   "type",
+			"meta.x": "type",
+			"extra":  "additionalProperties",
+		}, rules(errs))
+	})
+
+	t.Run("Missing fields are required", func(t *testing.T) {
+		// Act
+		errs := openapi.Validate(itemSchema, decode(t, `{}`), openapi.InBody, "")
+
+		// Assertions
+		assert.Equal(t, map[string]string{"name": "required", "count": "required"}, rules(errs))
+	})
+
+	t.Run("Limit and message describe the failed rule", func(t *testing.T) {
+		// Act
+		errs := openapi.Validate(itemSchema, decode(t, `{"name": "n", "count": 11}`), openapi.InBody, "")
+
+		// Assertions
+		assert.Equal(t, []openapi.FieldError{{In: "body", Field: "count", Rule: "maximum", Limit: 10.0, Message: "must be at most 10"}}, errs)
+	})
+
+	t.Run("Array items are named by index", func(t *testing.T) {
+		// Act
+		errs := openapi.Validate(itemSchema, decode(t, `{"name": "n", "count": 1, "tags": ["x", 1]}`), openapi.InBody, "")
+
+		// Assertions
+		assert.Equal(t, map[string]string{"tags[1]": "type"}, rules(errs))
+	})
+}
+
+func TestValidateRequest(t *testing.T) {
+	doc := openapi.New(openapi.Info{Title: "test", Version: "1"})
+	op := &openapi.Operation{
+		Parameters: []openapi.Parameter{
+			{Name: "limit", In: "query", Schema: &openapi.Schema{Type: "integer", Minimum: ptr(1.0)}},
+			{Name: "at", In: "query", Required: true, Schema: &openapi.Schema{Type: "string", Format: "date-time"}},
+			{Name: "Last-Event-ID", In: "header", Schema: &openapi.Schema{Type: "integer"}},
+		},
+		RequestBody: &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{
+			"application/json": {Schema: doc.SchemaOf(struct {
+				Name string `json:"name"`
+			}{})},
+		}},
+	}
+
+	t.Run("Params are parsed as their schema type", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/?limit=2&at=2026-10-01T00:00:00%2B07:00", nil)
+		req.Header.Set("Last-Event-ID", "7")
+
+		// Act
+		errs := doc.ValidateRequest(op, req, []byte(`{"name": "n"}`))
+
+		// Assertions
+		assert.Empty(t, errs)
+	})
+
+	t.Run("Params and body are checked together", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/?limit=zero", nil)
+		req.Header.Set("Last-Event-ID", "-")
+
+		// Act
+		errs := doc.ValidateRequest(op, req, []byte(`{"name": 1}`))
+
+		// Assertions
+		assert.ElementsMatch(t, []openapi.FieldError{
+			{In: "query", Field: "limit", Rule: "type", Limit: "integer", Message: "must be an integer"},
+			{In: "query", Field: "at", Rule: "required", Message: "is required"},
+			{In: "header", Field: "Last-Event-ID", Rule: "type", Limit: "integer", Message: "must be an integer"},
+			{In: "body", Field: "name", Rule: "type", Limit: "string", Message: "must be a string"},
+		}, errs)
+	})
+
+	t.Run("Required body is reported when empty", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/?at=2026-10-01T00:00:00Z", nil)
+
+		// Act
+		errs := doc.ValidateRequest(op, req, nil)
+
+		// Assertions
+		assert.Equal(t, []openapi.FieldError{{In: "body", Rule: "required", Message: "is required"}}, errs)
+	})
+}
@@ -0,0 +1,96 @@
+package problem
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/bazsup/assessment/locale"
+	"github.com/bazsup/assessment/logging"
+	"github.com/bazsup/assessment/openapi"
+	"github.com/labstack/echo/v4"
+)
+
+// Problem is the RFC 7807 document answered for an error, extended with
+// the stable code, the request id and the field errors
+type Problem struct {
+	Type      string               `json:"type"`
+	Title     string               `json:"title"`
+	Status    int                  `json:"status"`
+	Detail    string               `json:"detail,omitempty"`
+	Instance  string               `json:"instance,omitempty"`
+	Code      Code                 `json:"code"`
+	RequestID string               `json:"request_id,omitempty"`
+	Errors    []openapi.FieldError `json:"errors,omitempty"`
+}
+
+// Document returns the problem document of e answered for req, worded in
+// the language of req
+func (e *Error) Document(req *http.Request) Problem {
+	tag := Language(req)
+	p := Problem{
+		Type:      e.Code.Type(),
+		Title:     e.Code.TitleIn(tag),
+		Status:    e.Status(),
+		Detail:    e.Detail,
+		Instance:  req.URL.Path,
+		Code:      e.Code,
+		RequestID: logging.RequestID(req.Context()),
+	}
+	if e.key != "" {
+		p.Detail = details.Sprintf(tag, e.key, e.args...)
+	}
+	if len(e.Errors) > 0 {
+		p.Errors = make([]openapi.FieldError, len(e.Errors))
+		for i, fe := range e.Errors {
+			fe.Message = fieldMessage(tag, fe)
+			p.Errors[i] = fe
+		}
+	}
+	return p
+}
+
+// Language is the language negotiated for req by locale.Middleware, or
+// read from its Accept-Language header when the middleware is not used
+func Language(req *http.Request) locale.Tag {
+	if tag, ok := locale.FromContext(req.Context()); ok {
+		return tag
+	}
+	return locale.Negotiate(req.Header.Get(locale.HeaderAcceptLanguage), locale.English)
+}
+
+// Handler answers errors returned by handlers and middlewares, including
+// echo's own 404 and 405, with a problem document and logs server errors
+// along with their cause
+func Handler(logger *slog.Logger) echo.HTTPErrorHandler {
+	return func(err error, c echo.Context) {
+		if c.Response().Committed {
+			return
+		}
+
+		pe := From(err)
+		ctx := c.Request().Context()
+		if pe.Status() >= http.StatusInternalServerError {
+			logger.ErrorContext(ctx, "request failed", "code", string(pe.Code), "error", err.Error())
+		}
+
+		if c.Request().Method == http.MethodHead {
+			err = c.NoContent(pe.Status())
+		} else {
+			err = Write(c, pe)
+		}
+		if err != nil {
+			logger.ErrorContext(ctx, "can't write error response", "error", err.Error())
+		}
+	}
+}
+
+// Write answers the problem document of e, for the few responses written
+// outside of the error handler
+func Write(c echo.Context, e *Error) error {
+	b, err := json.Marshal(e.Document(c.Request()))
+	if err != nil {
+		return err
+	}
+	return c.Blob(e.Status(), ContentType, b)
+}
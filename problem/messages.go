@@ -0,0 +1,277 @@
+package problem
+
+import (
+	"strings"
+
+	"github.com/bazsup/assessment/locale"
+	"github.com/bazsup/assessment/openapi"
+)
+
+// titles words every code
+var titles = locale.Catalog{
+	locale.English: {
+		string(BadRequest):           "Bad request",
+		string(ValidationFailed):     "Request validation failed",
+		string(Unauthorized):         "Unauthorized",
+		string(Forbidden):            "Forbidden",
+		string(NotFound):             "Resource not found",
+		string(ExpenseNotFound):      "Expense not found",
+		string(VersionNotFound):      "Expense version not found",
+		string(WebhookNotFound):      "Webhook subscription not found",
+		string(DeliveryNotFound):     "Dead webhook delivery not found",
+		string(NotConfigured):        "Feature not configured",
+		string(MethodNotAllowed):     "Method not allowed",
+		string(Conflict):             "Conflict",
+		string(RequestInProgress):    "Request in progress",
+		string(EventsExpired):        "Events no longer available",
+		string(PayloadTooLarge):      "Payload too large",
+		string(UnsupportedMediaType): "Unsupported media type",
+		string(IdempotencyKeyReused): "Idempotency key reused",
+		string(RateLimited):          "Too many requests",
+		string(QuotaExceeded):        "Daily write quota exceeded",
+		string(RequestCanceled):      "Request canceled",
+		string(Internal):             "Internal server error",
+		string(ServiceUnavailable):   "Service unavailable",
+		string(DatabaseTimeout):      "Database query timed out",
+	},
+	locale.Thai: {
+		string(BadRequest):           "คำขอไม่ถูกต้อง",
+		string(ValidationFailed):     "ข้อมูลในคำขอไม่ผ่านการตรวจสอบ",
+		string(Unauthorized):         "ไม่ได้ยืนยันตัวตน",
+		string(Forbidden):            "ไม่มีสิทธิ์เข้าถึง",
+		string(NotFound):             "ไม่พบข้อมูลที่ร้องขอ",
+		string(ExpenseNotFound):      "ไม่พบรายการค่าใช้จ่าย",
+		string(VersionNotFound):      "ไม่พบเวอร์ชันของรายการค่าใช้จ่าย",
+		string(WebhookNotFound):      "ไม่พบการสมัครรับเว็บฮุก",
+		string(DeliveryNotFound):     "ไม่พบการส่งเว็บฮุกที่ล้มเหลว",
+		string(NotConfigured):        "ยังไม่ได้เปิดใช้งานความสามารถนี้",
+		string(MethodNotAllowed):     "ไม่รองรับเมธอดนี้",
+		string(Conflict):             "ข้อมูลขัดแย้งกัน",
+		string(RequestInProgress):    "คำขอกำลังดำเนินการอยู่",
+		string(EventsExpired):        "ไม่มีเหตุการณ์ที่ร้องขอแล้ว",
+		string(PayloadTooLarge):      "ข้อมูลในคำขอมีขนาดใหญ่เกินไป",
+		string(UnsupportedMediaType): "ไม่รองรับชนิดของข้อมูล",
+		string(IdempotencyKeyReused): "Idempotency-Key ถูกใช้ไปแล้ว",
+		string(RateLimited):          "ส่งคำขอบ่อยเกินไป",
+		string(QuotaExceeded):        "เกินโควตาการบันทึกรายวัน",
+		string(RequestCanceled):      "คำขอถูกยกเลิก",
+		string(Internal):             "เกิดข้อผิดพลาดภายในระบบ",
+		string(ServiceUnavailable):   "ระบบไม่พร้อมให้บริการชั่วคราว",
+		string(DatabaseTimeout):      "ฐานข้อมูลตอบสนองช้าเกินกำหนด",
+	},
+}
+
+// Keys of the detail messages given to Localized
+const (
+	DetailInvalidFields      = "invalid_fields"
+	DetailMissingCredentials = "missing_credentials"
+	DetailInvalidCredentials = "invalid_credentials"
+	DetailRejectedToken      = "rejected_token"
+	DetailTokenExpired       = "token_expired"
+	DetailTokenNotYetValid   = "token_not_yet_valid"
+	DetailTokenSignature     = "token_signature"
+	DetailTokenAudience      = "token_audience"
+	DetailForbidden          = "forbidden"
+	DetailForbiddenCondition = "forbidden_condition"
+	DetailForbiddenResource  = "forbidden_resource"
+	DetailForbiddenField     = "forbidden_field"
+	DetailRetryAfter         = "retry_after"
+	DetailQuotaReset         = "quota_reset"
+	DetailKeyReused          = "key_reused"
+	DetailKeyInProgress      = "key_in_progress"
+	DetailKeyTooLong         = "key_too_long"
+	DetailInvalidQueryParam  = "invalid_query_param"
+	DetailNoRoute            = "no_route"
+	DetailRequestUnparsable  = "request_unparsable"
+	DetailBodyUnparsable     = "body_unparsable"
+	DetailBodyUnreadable     = "body_unreadable"
+	DetailDBUnavailable      = "db_unavailable"
+	DetailConflict           = "conflict"
+	DetailConcurrentChange   = "concurrent_change"
+	DetailEventsExpired      = "events_expired"
+	DetailStreamClosed       = "stream_closed"
+	DetailMetricsOff         = "metrics_off"
+	DetailGraphQLOff         = "graphql_off"
+	DetailPoolOff            = "pool_off"
+	DetailWebhooksOff        = "webhooks_off"
+	DetailStreamingOff       = "streaming_off"
+	DetailAuditChainOff      = "audit_chain_off"
+)
+
+var details = locale.Catalog{
+	locale.English: {
+		DetailInvalidFields:      "one or more fields are invalid",
+		DetailMissingCredentials: "credentials are missing",
+		DetailInvalidCredentials: "credentials are invalid",
+		DetailRejectedToken:      "the bearer token was rejected",
+		DetailTokenExpired:       "the bearer token has expired",
+		DetailTokenNotYetValid:   "the bearer token is not valid yet",
+		DetailTokenSignature:     "the bearer token signature is invalid",
+		DetailTokenAudience:      "the bearer token was not issued for this API",
+		DetailForbidden:          "no permission of your roles grants %s",
+		DetailForbiddenCondition: "the expense does not meet the policy condition on %s",
+		DetailForbiddenResource:  "the policy condition on %s needs a single expense",
+		DetailForbiddenField:     "%s may not be modified",
+		DetailRetryAfter:         "retry after %s seconds",
+		DetailQuotaReset:         "the quota resets at midnight UTC",
+		DetailKeyReused:          "%s was already used with a different request",
+		DetailKeyInProgress:      "a request with this %s is still in progress",
+		DetailKeyTooLong:         "%s is too long",
+		DetailInvalidQueryParam:  "invalid query param %s",
+		DetailNoRoute:            "no route matches %s %s",
+		DetailRequestUnparsable:  "the request could not be parsed",
+		DetailBodyUnparsable:     "the request body could not be parsed",
+		DetailBodyUnreadable:     "the request body could not be read",
+		DetailDBUnavailable:      "the database is unavailable, retry later",
+		DetailConflict:           "the change conflicts with the stored data",
+		DetailConcurrentChange:   "the change conflicted with a concurrent one, retry it",
+		DetailEventsExpired:      "last event id is too far behind, reload and subscribe again",
+		DetailStreamClosed:       "the stream is shutting down, reconnect later",
+		DetailMetricsOff:         "metrics are disabled",
+		DetailGraphQLOff:         "graphql is not supported by the store",
+		DetailPoolOff:            "the store has no connection pool",
+		DetailWebhooksOff:        "webhooks are not configured",
+		DetailStreamingOff:       "streaming is not configured",
+		DetailAuditChainOff:      "the store keeps no audit chain",
+	},
+	locale.Thai: {
+		DetailInvalidFields:      "มีข้อมูลบางช่องไม่ถูกต้อง",
+		DetailMissingCredentials: "ไม่พบข้อมูลยืนยันตัวตน",
+		DetailInvalidCredentials: "ข้อมูลยืนยันตัวตนไม่ถูกต้อง",
+		DetailRejectedToken:      "โทเค็นถูกปฏิเสธ",
+		DetailTokenExpired:       "โทเค็นหมดอายุแล้ว",
+		DetailTokenNotYetValid:   "โทเค็นยังไม่ถึงเวลาใช้งาน",
+		DetailTokenSignature:     "ลายเซ็นของโทเค็นไม่ถูกต้อง",
+		DetailTokenAudience:      "โทเค็นนี้ไม่ได้ออกให้ใช้กับ API นี้",
+		DetailForbidden:          "บทบาทของคุณไม่มีสิทธิ์ %s",
+		DetailForbiddenCondition: "รายการค่าใช้จ่ายไม่ตรงตามเงื่อนไขของนโยบายเรื่อง %s",
+		DetailForbiddenResource:  "เงื่อนไขของนโยบายเรื่อง %s ใช้กับรายการค่าใช้จ่ายทีละรายการเท่านั้น",
+		DetailForbiddenField:     "ไม่อนุญาตให้แก้ไข %s",
+		DetailRetryAfter:         "ลองใหม่อีกครั้งใน %s วินาที",
+		DetailQuotaReset:         "โควตาจะเริ่มใหม่เมื่อเที่ยงคืนตามเวลา UTC",
+		DetailKeyReused:          "%s นี้ถูกใช้กับคำขออื่นไปแล้ว",
+		DetailKeyInProgress:      "คำขอที่ใช้ %s นี้กำลังดำเนินการอยู่",
+		DetailKeyTooLong:         "%s ยาวเกินไป",
+		DetailInvalidQueryParam:  "พารามิเตอร์ %s ในคำขอไม่ถูกต้อง",
+		DetailNoRoute:            "ไม่มีเส้นทางที่ตรงกับ %s %s",
+		DetailRequestUnparsable:  "ไม่สามารถแปลความหมายของคำขอได้",
+		DetailBodyUnparsable:     "ไม่สามารถแปลความหมายของข้อมูลในคำขอได้",
+		DetailBodyUnreadable:     "ไม่สามารถอ่านข้อมูลในคำขอได้",
+		DetailDBUnavailable:      "ฐานข้อมูลไม่พร้อมใช้งาน โปรดลองใหม่ภายหลัง",
+		DetailConflict:           "การแก้ไขขัดแย้งกับข้อมูลที่บันทึกไว้",
+		DetailConcurrentChange:   "การแก้ไขขัดแย้งกับการแก้ไขอื่นที่เกิดขึ้นพร้อมกัน โปรดลองใหม่",
+		DetailEventsExpired:      "รหัสเหตุการณ์ล่าสุดเก่าเกินไป โปรดโหลดข้อมูลใหม่แล้วสมัครรับอีกครั้ง",
+		DetailStreamClosed:       "สตรีมกำลังปิดตัว โปรดเชื่อมต่อใหม่ภายหลัง",
+		DetailMetricsOff:         "ไม่ได้เปิดใช้งานเมตริก",
+		DetailGraphQLOff:         "ที่เก็บข้อมูลไม่รองรับ GraphQL",
+		DetailPoolOff:            "ที่เก็บข้อมูลไม่มีพูลการเชื่อมต่อ",
+		DetailWebhooksOff:        "ยังไม่ได้ตั้งค่าเว็บฮุก",
+		DetailStreamingOff:       "ยังไม่ได้ตั้งค่าการสตรีม",
+		DetailAuditChainOff:      "ที่เก็บข้อมูลไม่ได้เก็บสายโซ่การตรวจสอบ",
+	},
+}
+
+// fields words field errors by their rule, type and format rules by
+// their limit as in "type.string"
+var fields = locale.Catalog{
+	locale.English: {
+		"required":             "is required",
+		"json":                 "must be valid JSON",
+		"oneOf":                "does not match any allowed schema",
+		"enum":                 "must be one of %s",
+		"type.string":          "must be a string",
+		"type.number":          "must be a number",
+		"type.integer":         "must be an integer",
+		"type.boolean":         "must be a boolean",
+		"type.array":           "must be an array",
+		"type.object":          "must be an object",
+		"minLength.empty":      "must not be empty",
+		"minLength":            "must be at least %d characters",
+		"maxLength":            "must be at most %d characters",
+		"pattern":              "has an invalid format",
+		"format.date-time":     "must be an RFC 3339 time",
+		"minimum":              "must be at least %v",
+		"exclusiveMinimum":     "must be greater than %v",
+		"maximum":              "must be at most %v",
+		"maxItems":             "must have at most %d items",
+		"additionalProperties": "is not allowed",
+	},
+	locale.Thai: {
+		"required":             "จำเป็นต้องระบุ",
+		"json":                 "ต้องเป็น JSON ที่ถูกต้อง",
+		"oneOf":                "ไม่ตรงกับรูปแบบที่อนุญาต",
+		"enum":                 "ต้องเป็นค่าใดค่าหนึ่งต่อไปนี้: %s",
+		"type.string":          "ต้องเป็นข้อความ",
+		"type.number":          "ต้องเป็นตัวเลข",
+		"type.integer":         "ต้องเป็นจำนวนเต็ม",
+		"type.boolean":         "ต้องเป็นค่าจริงหรือเท็จ",
+		"type.array":           "ต้องเป็นรายการ",
+		"type.object":          "ต้องเป็นออบเจกต์",
+		"minLength.empty":      "ต้องไม่เว้นว่าง",
+		"minLength":            "ต้องมีอย่างน้อย %d ตัวอักษร",
+		"maxLength":            "ต้องมีไม่เกิน %d ตัวอักษร",
+		"pattern":              "มีรูปแบบไม่ถูกต้อง",
+		"format.date-time":     "ต้องเป็นเวลาในรูปแบบ RFC 3339",
+		"minimum":              "ต้องไม่น้อยกว่า %v",
+		"exclusiveMinimum":     "ต้องมากกว่า %v",
+		"maximum":              "ต้องไม่เกิน %v",
+		"maxItems":             "ต้องมีไม่เกิน %d รายการ",
+		"additionalProperties": "ไม่อนุญาตให้ระบุ",
+	},
+}
+
+// amountFields are the fields whose numeric limits are amounts of baht
+var amountFields = map[string]bool{"amount": true}
+
+// fieldMessage words fe in tag from its rule and limit, keeping the
+// message of the validator for rules the catalog doesn't word
+func fieldMessage(tag locale.Tag, fe openapi.FieldError) string {
+	key := fe.Rule
+	var args []interface{}
+	switch fe.Rule {
+	case "required", "json", "oneOf", "pattern", "additionalProperties":
+	case "type", "format":
+		s, ok := fe.Limit.(string)
+		if !ok {
+			return fe.Message
+		}
+		key += "." + s
+	case "enum":
+		values, ok := fe.Limit.([]interface{})
+		if !ok {
+			return fe.Message
+		}
+		words := make([]string, len(values))
+		for i, v := range values {
+			words[i], _ = v.(string)
+		}
+		args = append(args, strings.Join(words, ", "))
+	case "minLength", "maxLength", "maxItems":
+		n, ok := fe.Limit.(int)
+		if !ok {
+			return fe.Message
+		}
+		if fe.Rule == "minLength" && n == 1 {
+			key = "minLength.empty"
+		} else {
+			args = append(args, n)
+		}
+	case "minimum", "exclusiveMinimum", "maximum":
+		f, ok := fe.Limit.(float64)
+		if !ok {
+			return fe.Message
+		}
+		if amountFields[fe.Field] {
+			args = append(args, locale.FormatAmount(tag, f))
+		} else {
+			args = append(args, f)
+		}
+	default:
+		return fe.Message
+	}
+
+	if !fields.Has(locale.English, key) {
+		return fe.Message
+	}
+	return fields.Sprintf(tag, key, args...)
+}
@@ -0,0 +1,264 @@
+// Package problem answers API errors with RFC 7807 problem details. Every
+// error carries a stable code from the catalogue, internal error text is
+// logged but never sent to clients.
+package problem
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/bazsup/assessment/locale"
+	"github.com/bazsup/assessment/openapi"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// ContentType is the media type of problem documents
+const ContentType = "application/problem+json"
+
+// TypePrefix prefixes the code of a problem to form its type URI
+const TypePrefix = "urn:expenses:problem:"
+
+// StatusClientClosedRequest is the nginx convention for a client that went away before the response
+const StatusClientClosedRequest = 499
+
+// Code identifies a kind of error, clients may switch on it
+type Code string
+
+const (
+	BadRequest           Code = "BAD_REQUEST"
+	ValidationFailed     Code = "VALIDATION_FAILED"
+	Unauthorized         Code = "UNAUTHORIZED"
+	Forbidden            Code = "FORBIDDEN"
+	NotFound             Code = "NOT_FOUND"
+	ExpenseNotFound      Code = "EXPENSE_NOT_FOUND"
+	VersionNotFound      Code = "VERSION_NOT_FOUND"
+	WebhookNotFound      Code = "WEBHOOK_NOT_FOUND"
+	DeliveryNotFound     Code = "DELIVERY_NOT_FOUND"
+	NotConfigured        Code = "NOT_CONFIGURED"
+	MethodNotAllowed     Code = "METHOD_NOT_ALLOWED"
+	Conflict             Code = "CONFLICT"
+	RequestInProgress    Code = "REQUEST_IN_PROGRESS"
+	EventsExpired        Code = "EVENTS_EXPIRED"
+	PayloadTooLarge      Code = "PAYLOAD_TOO_LARGE"
+	UnsupportedMediaType Code = "UNSUPPORTED_MEDIA_TYPE"
+	IdempotencyKeyReused Code = "IDEMPOTENCY_KEY_REUSED"
+	RateLimited          Code = "RATE_LIMITED"
+	QuotaExceeded        Code = "QUOTA_EXCEEDED"
+	RequestCanceled      Code = "REQUEST_CANCELED"
+	Internal             Code = "INTERNAL_ERROR"
+	ServiceUnavailable   Code = "SERVICE_UNAVAILABLE"
+	DatabaseTimeout      Code = "DATABASE_TIMEOUT"
+)
+
+// catalogue gives the status of every code, titles are in messages.go
+var catalogue = map[Code]int{
+	BadRequest:           http.StatusBadRequest,
+	ValidationFailed:     http.StatusBadRequest,
+	Unauthorized:         http.StatusUnauthorized,
+	Forbidden:            http.StatusForbidden,
+	NotFound:             http.StatusNotFound,
+	ExpenseNotFound:      http.StatusNotFound,
+	VersionNotFound:      http.StatusNotFound,
+	WebhookNotFound:      http.StatusNotFound,
+	DeliveryNotFound:     http.StatusNotFound,
+	NotConfigured:        http.StatusNotFound,
+	MethodNotAllowed:     http.StatusMethodNotAllowed,
+	Conflict:             http.StatusConflict,
+	RequestInProgress:    http.StatusConflict,
+	EventsExpired:        http.StatusGone,
+	PayloadTooLarge:      http.StatusRequestEntityTooLarge,
+	UnsupportedMediaType: http.StatusUnsupportedMediaType,
+	IdempotencyKeyReused: http.StatusUnprocessableEntity,
+	RateLimited:          http.StatusTooManyRequests,
+	QuotaExceeded:        http.StatusTooManyRequests,
+	RequestCanceled:      StatusClientClosedRequest,
+	Internal:             http.StatusInternalServerError,
+	ServiceUnavailable:   http.StatusServiceUnavailable,
+	DatabaseTimeout:      http.StatusServiceUnavailable,
+}
+
+// Codes lists the catalogue, for documentation
+func Codes() []Code {
+	codes := make([]Code, 0, len(catalogue))
+	for c := range catalogue {
+		codes = append(codes, c)
+	}
+	return codes
+}
+
+// Status is the HTTP status answered for code
+func (c Code) Status() int {
+	if status, ok := catalogue[c]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Title is the short, fixed summary of code in English
+func (c Code) Title() string {
+	return c.TitleIn(locale.English)
+}
+
+// TitleIn is the summary of code in the language tag
+func (c Code) TitleIn(tag locale.Tag) string {
+	if _, ok := catalogue[c]; !ok {
+		c = Internal
+	}
+	return titles.Sprintf(tag, string(c))
+}
+
+// Type is the URI identifying code in problem documents
+func (c Code) Type() string {
+	return TypePrefix + strings.ReplaceAll(strings.ToLower(string(c)), "_", "-")
+}
+
+// Error is an error answered to the client. Detail is safe to send, the
+// cause is only logged.
+type Error struct {
+	Code   Code
+	Detail string
+	// Errors lists every field failing validation
+	Errors []openapi.FieldError
+	cause  error
+	// key and args word Detail in the language of each request
+	key  string
+	args []interface{}
+}
+
+// New returns the error of code explained by detail, which may be empty
+func New(code Code, detail string) *Error {
+	return &Error{Code: code, Detail: detail}
+}
+
+// Wrap returns the error of code caused by err, whose text is not sent
+func Wrap(code Code, err error, detail string) *Error {
+	return &Error{Code: code, Detail: detail, cause: err}
+}
+
+// Localized returns the error of code explained by the detail message of
+// key, worded in the language of each request
+func Localized(code Code, key string, args ...interface{}) *Error {
+	return &Error{Code: code, Detail: details.Sprintf(locale.English, key, args...), key: key, args: args}
+}
+
+// WrapLocalized returns the error of code caused by err, explained by the
+// detail message of key like Localized
+func WrapLocalized(code Code, err error, key string, args ...interface{}) *Error {
+	e := Localized(code, key, args...)
+	e.cause = err
+	return e
+}
+
+// Invalid returns the validation error listing errs
+func Invalid(errs []openapi.FieldError) *Error {
+	e := Localized(ValidationFailed, DetailInvalidFields)
+	e.Errors = errs
+	return e
+}
+
+func (e *Error) Error() string {
+	msg := string(e.Code)
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	if e.cause != nil {
+		msg += ": " + e.cause.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Status is the HTTP status of e
+func (e *Error) Status() int {
+	return e.Code.Status()
+}
+
+// From maps any error returned by a handler to the problem answered for
+// it: echo's own errors by status, store errors by their cause and
+// anything else to an internal error without its text
+func From(err error) *Error {
+	var pe *Error
+	if errors.As(err, &pe) {
+		return pe
+	}
+
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		return fromStatus(he.Code, err)
+	}
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return Wrap(NotFound, err, "")
+	case errors.Is(err, context.DeadlineExceeded):
+		return Wrap(DatabaseTimeout, err, "")
+	case errors.Is(err, context.Canceled):
+		return Wrap(RequestCanceled, err, "")
+	case errors.Is(err, driver.ErrBadConn):
+		return WrapLocalized(ServiceUnavailable, err, DetailDBUnavailable)
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "23": // integrity constraint violation
+			return WrapLocalized(Conflict, err, DetailConflict)
+		case "40": // serialization failure, deadlock
+			return WrapLocalized(Conflict, err, DetailConcurrentChange)
+		case "08", "53", "57": // connection, resources, operator intervention
+			return WrapLocalized(ServiceUnavailable, err, DetailDBUnavailable)
+		}
+	}
+
+	return Wrap(Internal, err, "")
+}
+
+// BodyError answers a request body that could not be read, keeping the 413
+// of a body past the limit of echo's BodyLimit middleware
+func BodyError(err error) *Error {
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		return From(err)
+	}
+	return WrapLocalized(BadRequest, err, DetailBodyUnreadable)
+}
+
+// StatusOf is the HTTP status answered for err
+func StatusOf(err error) int {
+	return From(err).Status()
+}
+
+func fromStatus(status int, err error) *Error {
+	switch status {
+	case http.StatusBadRequest:
+		return WrapLocalized(BadRequest, err, DetailRequestUnparsable)
+	case http.StatusUnauthorized:
+		return Wrap(Unauthorized, err, "")
+	case http.StatusForbidden:
+		return Wrap(Forbidden, err, "")
+	case http.StatusNotFound:
+		return Wrap(NotFound, err, "")
+	case http.StatusMethodNotAllowed:
+		return Wrap(MethodNotAllowed, err, "")
+	case http.StatusRequestEntityTooLarge:
+		return Wrap(PayloadTooLarge, err, "")
+	case http.StatusUnsupportedMediaType:
+		return Wrap(UnsupportedMediaType, err, "")
+	case http.StatusTooManyRequests:
+		return Wrap(RateLimited, err, "")
+	case http.StatusServiceUnavailable:
+		return Wrap(ServiceUnavailable, err, "")
+	}
+	if status < http.StatusInternalServerError {
+		return Wrap(BadRequest, err, "")
+	}
+	return Wrap(Internal, err, "")
+}
@@ -0,0 +1,243 @@
+//go:build unit
+// +build unit
+
+package problem_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bazsup/assessment/locale"
+	"github.com/bazsup/assessment/logging"
+	"github.com/bazsup/assessment/openapi"
+	"github.com/bazsup/assessment/problem"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrom(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		code   problem.Code
+		status int
+	}{
+		{"problem error", problem.New(problem.ExpenseNotFound, ""), problem.ExpenseNotFound, http.StatusNotFound},
+		{"wrapped problem error", fmt.Errorf("get: %w", problem.New(problem.Forbidden, "")), problem.Forbidden, http.StatusForbidden},
+		{"echo not found", echo.ErrNotFound, problem.NotFound, http.StatusNotFound},
+		{"echo method not allowed", echo.ErrMethodNotAllowed, problem.MethodNotAllowed, http.StatusMethodNotAllowed},
+		{"echo bind error", echo.NewHTTPError(http.StatusBadRequest, "Syntax error: offset=3"), problem.BadRequest, http.StatusBadRequest},
+		{"echo unsupported media type", echo.ErrUnsupportedMediaType, problem.UnsupportedMediaType, http.StatusUnsupportedMediaType},
+		{"no rows", fmt.Errorf("scan: %w", sql.ErrNoRows), problem.NotFound, http.StatusNotFound},
+		{"timeout", fmt.Errorf("query: %w", context.DeadlineExceeded), problem.DatabaseTimeout, http.StatusServiceUnavailable},
+		{"canceled", context.Canceled, problem.RequestCanceled, problem.StatusClientClosedRequest},
+		{"unique violation", &pq.Error{Code: "23505"}, problem.Conflict, http.StatusConflict},
+		{"serialization failure", &pq.Error{Code: "40001"}, problem.Conflict, http.StatusConflict},
+		{"connection failure", &pq.Error{Code: "08006"}, problem.ServiceUnavailable, http.StatusServiceUnavailable},
+		{"unknown error", errors.New("can't scan expense: pq: column missing"), problem.Internal, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			pe := problem.From(tt.err)
+
+			// Assertions
+			assert.Equal(t, tt.code, pe.Code)
+			assert.Equal(t, tt.status, pe.Status())
+		})
+	}
+}
+
+func TestCatalogue(t *testing.T) {
+	for _, code := range problem.Codes() {
+		assert.NotEmpty(t, code.Title(), code)
+		assert.NotEqual(t, code.Title(), code.TitleIn(locale.Thai), code)
+		assert.True(t, strings.HasPrefix(code.Type(), problem.TypePrefix), code)
+	}
+	assert.Equal(t, "urn:expenses:problem:expense-not-found", problem.ExpenseNotFound.Type())
+	assert.Equal(t, http.StatusInternalServerError, problem.Code("UNKNOWN").Status())
+}
+
+// setupApp answers errors with problem.Handler behind the logging middleware
+func setupApp(t *testing.T) (*echo.Echo, *bytes.Buffer) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, logging.Config{Level: "info", Format: "json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.HTTPErrorHandler = problem.Handler(logger)
+	e.Use(logging.Middleware(logger))
+	e.GET("/boom", func(c echo.Context) error {
+		return errors.New("pq: relation \"expenses\" does not exist")
+	})
+	e.GET("/expenses/:id", func(c echo.Context) error {
+		return problem.New(problem.ExpenseNotFound, "no expense has id "+c.Param("id"))
+	})
+	e.POST("/expenses", func(c echo.Context) error {
+		return problem.Invalid([]openapi.FieldError{
+			{In: openapi.InBody, Field: "title", Rule: "required", Message: "is required"},
+			{In: openapi.InBody, Field: "amount", Rule: "exclusiveMinimum", Limit: 0.0, Message: "must be greater than 0"},
+		})
+	})
+	return e, &buf
+}
+
+func decode(t *testing.T, rec *httptest.ResponseRecorder) problem.Problem {
+	t.Helper()
+
+	var p problem.Problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+		t.Fatalf("body is not a problem document: %s", rec.Body)
+	}
+	return p
+}
+
+func TestHandler(t *testing.T) {
+	t.Run("Problem error should returns problem document", func(t *testing.T) {
+		e, _ := setupApp(t)
+		rec := httptest.NewRecorder()
+
+		// Act
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/expenses/7", nil))
+		p := decode(t, rec)
+
+		// Assertions
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Equal(t, problem.ContentType, rec.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, problem.ExpenseNotFound.Type(), p.Type)
+		assert.Equal(t, "Expense not found", p.Title)
+		assert.Equal(t, http.StatusNotFound, p.Status)
+		assert.Equal(t, "no expense has id 7", p.Detail)
+		assert.Equal(t, "/expenses/7", p.Instance)
+		assert.Equal(t, problem.ExpenseNotFound, p.Code)
+		assert.Equal(t, rec.Header().Get(logging.HeaderRequestID), p.RequestID)
+	})
+
+	t.Run("Validation error should returns field errors", func(t *testing.T) {
+		e, _ := setupApp(t)
+		rec := httptest.NewRecorder()
+
+		// Act
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/expenses", nil))
+		p := decode(t, rec)
+
+		// Assertions
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, problem.ValidationFailed, p.Code)
+		assert.Equal(t, "one or more fields are invalid", p.Detail)
+		if assert.Len(t, p.Errors, 2) {
+			assert.Equal(t, "title", p.Errors[0].Field)
+			assert.Equal(t, "required", p.Errors[0].Rule)
+			assert.Equal(t, "is required", p.Errors[0].Message)
+			assert.Equal(t, "must be greater than THB 0.00", p.Errors[1].Message)
+		}
+	})
+
+	t.Run("Thai Accept-Language should returns Thai messages", func(t *testing.T) {
+		e, _ := setupApp(t)
+		req := httptest.NewRequest(http.MethodPost, "/expenses", nil)
+		req.Header.Set(locale.HeaderAcceptLanguage, "th-TH,th;q=0.9,en;q=0.8")
+		rec := httptest.NewRecorder()
+
+		// Act
+		e.ServeHTTP(rec, req)
+		p := decode(t, rec)
+
+		// Assertions
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, problem.ValidationFailed, p.Code)
+		assert.Equal(t, "ข้อมูลในคำขอไม่ผ่านการตรวจสอบ", p.Title)
+		assert.Equal(t, "มีข้อมูลบางช่องไม่ถูกต้อง", p.Detail)
+		if assert.Len(t, p.Errors, 2) {
+			assert.Equal(t, "จำเป็นต้องระบุ", p.Errors[0].Message)
+			assert.Equal(t, "ต้องมากกว่า 0.00 บาท", p.Errors[1].Message)
+		}
+	})
+
+	t.Run("Unsupported Accept-Language should returns English messages", func(t *testing.T) {
+		e, _ := setupApp(t)
+		req := httptest.NewRequest(http.MethodGet, "/expenses/7", nil)
+		req.Header.Set(locale.HeaderAcceptLanguage, "fr")
+		rec := httptest.NewRecorder()
+
+		// Act
+		e.ServeHTTP(rec, req)
+		p := decode(t, rec)
+
+		// Assertions
+		assert.Equal(t, "Expense not found", p.Title)
+		assert.Equal(t, "no expense has id 7", p.Detail)
+	})
+
+	t.Run("Unknown route should returns status not found", func(t *testing.T) {
+		e, _ := setupApp(t)
+		rec := httptest.NewRecorder()
+
+		// Act
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/unknown", nil))
+		p := decode(t, rec)
+
+		// Assertions
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Equal(t, problem.NotFound, p.Code)
+		assert.Equal(t, "/unknown", p.Instance)
+	})
+
+	t.Run("Internal error should be logged and not exposed", func(t *testing.T) {
+		e, buf := setupApp(t)
+		rec := httptest.NewRecorder()
+
+		// Act
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/boom", nil))
+		p := decode(t, rec)
+
+		// Assertions
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Equal(t, problem.Internal, p.Code)
+		assert.NotContains(t, rec.Body.String(), "relation")
+
+		var logged []map[string]interface{}
+		for _, l := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var m map[string]interface{}
+			json.Unmarshal([]byte(l), &m)
+			logged = append(logged, m)
+		}
+		if assert.Len(t, logged, 2) {
+			assert.Equal(t, "request failed", logged[0]["msg"])
+			assert.Contains(t, logged[0]["error"], "relation \"expenses\" does not exist")
+			assert.Equal(t, "ERROR", logged[1]["level"])
+			assert.Equal(t, logged[0]["request_id"], logged[1]["request_id"])
+		}
+	})
+
+	t.Run("HEAD request should returns no body", func(t *testing.T) {
+		e, _ := setupApp(t)
+		rec := httptest.NewRecorder()
+
+		// Act
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/unknown", nil))
+
+		// Assertions
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Empty(t, rec.Body.String())
+	})
+}
+
+func TestWrap(t *testing.T) {
+	err := problem.Wrap(problem.Internal, fmt.Errorf("scan: %w", sql.ErrConnDone), "")
+
+	// Assertions
+	assert.Equal(t, "INTERNAL_ERROR: scan: sql: connection is already closed", err.Error())
+	assert.ErrorIs(t, err, sql.ErrConnDone)
+}
@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+type quota struct {
+	day   time.Time
+	count int
+}
+
+// MemoryStore keeps limits in process memory, limits are per replica
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	quotas  map[string]*quota
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: map[string]*bucket{},
+		quotas:  map[string]*quota{},
+	}
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updatedAt: now}
+		s.buckets[key] = b
+	}
+
+	var res Result
+	b.tokens, res = take(refill(b.tokens, now.Sub(b.updatedAt), limit), limit)
+	b.updatedAt = now
+	return res, nil
+}
+
+func (s *MemoryStore) CountQuota(_ context.Context, key string, max int, now time.Time) (QuotaResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	day, reset := quotaWindow(now)
+	q, ok := s.quotas[key]
+	if !ok || !q.day.Equal(day) {
+		q = &quota{day: day}
+		s.quotas[key] = q
+	}
+	q.count++
+
+	return quotaResult(q.count, max, reset), nil
+}
+
+// DeleteExpired drops buckets untouched since before and quota counters of
+// the days ended by then, returning how many were deleted
+func (s *MemoryStore) DeleteExpired(_ context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for key, b := range s.buckets {
+		if !b.updatedAt.After(before) {
+			delete(s.buckets, key)
+			n++
+		}
+	}
+	day, _ := quotaWindow(before)
+	for key, q := range s.quotas {
+		if q.day.Before(day) {
+			delete(s.quotas, key)
+			n++
+		}
+	}
+	return n, nil
+}
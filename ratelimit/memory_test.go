@@ -0,0 +1,113 @@
+//go:build unit
+// +build unit
+
+package ratelimit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bazsup/assessment/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStoreTake(t *testing.T) {
+	limit := ratelimit.Limit{Rate: 1, Burst: 2}
+	now := time.Date(2022, 12, 24, 10, 0, 0, 0, time.UTC)
+
+	t.Run("Burst is consumed then refilled over time", func(t *testing.T) {
+		t.Parallel()
+		s := ratelimit.NewMemoryStore()
+		ctx := context.Background()
+
+		// Act
+		first, _ := s.Take(ctx, "k", limit, now)
+		second, _ := s.Take(ctx, "k", limit, now)
+		third, _ := s.Take(ctx, "k", limit, now)
+		later, _ := s.Take(ctx, "k", limit, now.Add(time.Second))
+
+		// Assertions
+		assert.True(t, first.Allowed)
+		assert.Equal(t, 1, first.Remaining)
+		assert.True(t, second.Allowed)
+		assert.Equal(t, 0, second.Remaining)
+		assert.False(t, third.Allowed)
+		assert.Equal(t, time.Second, third.RetryAfter)
+		assert.Equal(t, 2*time.Second, third.Reset)
+		assert.True(t, later.Allowed)
+	})
+
+	t.Run("Keys have separate buckets", func(t *testing.T) {
+		t.Parallel()
+		s := ratelimit.NewMemoryStore()
+		ctx := context.Background()
+		one := ratelimit.Limit{Rate: 1, Burst: 1}
+
+		// Act
+		a, _ := s.Take(ctx, "a", one, now)
+		b, _ := s.Take(ctx, "b", one, now)
+
+		// Assertions
+		assert.True(t, a.Allowed)
+		assert.True(t, b.Allowed)
+	})
+}
+
+func TestMemoryStoreCountQuota(t *testing.T) {
+	t.Parallel()
+	s := ratelimit.NewMemoryStore()
+	ctx := context.Background()
+	now := time.Date(2022, 12, 24, 18, 0, 0, 0, time.UTC)
+
+	// Act
+	first, _ := s.CountQuota(ctx, "k", 2, now)
+	second, _ := s.CountQuota(ctx, "k", 2, now)
+	third, _ := s.CountQuota(ctx, "k", 2, now)
+	nextDay, _ := s.CountQuota(ctx, "k", 2, now.Add(6*time.Hour))
+
+	// Assertions
+	assert.True(t, first.Allowed)
+	assert.Equal(t, 1, first.Remaining)
+	assert.True(t, second.Allowed)
+	assert.False(t, third.Allowed)
+	assert.Equal(t, 6*time.Hour, third.Reset)
+	assert.True(t, nextDay.Allowed)
+}
+
+func TestMemoryStoreDeleteExpired(t *testing.T) {
+	t.Parallel()
+	s := ratelimit.NewMemoryStore()
+	ctx := context.Background()
+	limit := ratelimit.Limit{Rate: 1, Burst: 1}
+	now := time.Date(2022, 12, 24, 18, 0, 0, 0, time.UTC)
+
+	// Arrange
+	s.Take(ctx, "idle", limit, now.Add(-time.Minute))
+	s.Take(ctx, "busy", limit, now)
+	s.CountQuota(ctx, "yesterday", 1, now.Add(-24*time.Hour))
+	s.CountQuota(ctx, "today", 1, now)
+
+	// Act
+	n, err := s.DeleteExpired(ctx, now.Add(-time.Second))
+	busy, _ := s.Take(ctx, "busy", limit, now)
+	today, _ := s.CountQuota(ctx, "today", 1, now)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	assert.False(t, busy.Allowed, "recent buckets are kept")
+	assert.False(t, today.Allowed, "counters of the current day are kept")
+}
+
+func TestIdleAfter(t *testing.T) {
+	// Act
+	idle := ratelimit.IdleAfter(map[ratelimit.Class]ratelimit.Limit{
+		ratelimit.ClassRead:  {Rate: 10, Burst: 20},
+		ratelimit.ClassWrite: {Rate: 0.5, Burst: 5},
+	})
+
+	// Assertions
+	assert.Equal(t, 10*time.Second, idle)
+	assert.Zero(t, ratelimit.IdleAfter(map[ratelimit.Class]ratelimit.Limit{ratelimit.ClassRead: {}}))
+}
@@ -0,0 +1,120 @@
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bazsup/assessment/problem"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	HeaderLimit     = "RateLimit-Limit"
+	HeaderRemaining = "RateLimit-Remaining"
+	HeaderReset     = "RateLimit-Reset"
+)
+
+// Config configures the rate limit middleware
+type Config struct {
+	Store Store
+	// Limits per route class, a class without a positive rate is not limited
+	Limits map[Class]Limit
+	// DailyWriteQuota caps write requests per key per UTC day, zero disables it
+	DailyWriteQuota int
+	// KeyFunc identifies the client, defaults to the client IP
+	KeyFunc func(c echo.Context) string
+	Now     func() time.Time
+}
+
+// ClassFor maps a request method to its route class
+func ClassFor(method string) Class {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return ClassRead
+	default:
+		return ClassWrite
+	}
+}
+
+// Limiter enforces token bucket limits and daily write quotas. Store
+// failures are logged and let the request through rather than taking the
+// API down with the limiter.
+type Limiter struct {
+	cfg Config
+}
+
+func NewLimiter(cfg Config) *Limiter {
+	if cfg.KeyFunc == nil {
+		cfg.KeyFunc = func(c echo.Context) string { return c.RealIP() }
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	return &Limiter{cfg: cfg}
+}
+
+// Allow takes a token of class for key and counts writes against the daily
+// quota. The bucket state is returned for the rate limit headers, its Limit
+// is zero when class is not limited. A denied request gets the problem to
+// answer with, RetryAfter is then set.
+func (l *Limiter) Allow(ctx context.Context, class Class, key string) (Result, error) {
+	limit, ok := l.cfg.Limits[class]
+	if !ok || limit.Rate <= 0 {
+		return Result{Allowed: true}, nil
+	}
+	now := l.cfg.Now()
+
+	res, err := l.cfg.Store.Take(ctx, string(class)+":"+key, limit, now)
+	if err != nil {
+		slog.ErrorContext(ctx, "rate limit store failed", "error", err.Error())
+		return Result{Allowed: true}, nil
+	}
+	if !res.Allowed {
+		return res, problem.Localized(problem.RateLimited, problem.DetailRetryAfter, seconds(res.RetryAfter))
+	}
+
+	if class == ClassWrite && l.cfg.DailyWriteQuota > 0 {
+		q, err := l.cfg.Store.CountQuota(ctx, key, l.cfg.DailyWriteQuota, now)
+		if err != nil {
+			slog.ErrorContext(ctx, "rate limit store failed", "error", err.Error())
+			return res, nil
+		}
+		if !q.Allowed {
+			res.Allowed, res.RetryAfter = false, q.Reset
+			return res, problem.Localized(problem.QuotaExceeded, problem.DetailQuotaReset)
+		}
+	}
+	return res, nil
+}
+
+// Middleware enforces the limits on echo routes, keyed by KeyFunc
+func Middleware(cfg Config) echo.MiddlewareFunc {
+	return NewLimiter(cfg).Middleware
+}
+
+func (l *Limiter) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		res, err := l.Allow(c.Request().Context(), ClassFor(c.Request().Method), l.cfg.KeyFunc(c))
+
+		h := c.Response().Header()
+		if res.Limit > 0 {
+			h.Set(HeaderLimit, strconv.Itoa(res.Limit))
+			h.Set(HeaderRemaining, strconv.Itoa(res.Remaining))
+			h.Set(HeaderReset, seconds(res.Reset))
+		}
+		if err != nil {
+			h.Set(echo.HeaderRetryAfter, seconds(res.RetryAfter))
+			return err
+		}
+		return next(c)
+	}
+}
+
+// seconds formats d as whole seconds rounded up, as the headers require
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
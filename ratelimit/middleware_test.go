@@ -0,0 +1,99 @@
+//go:build unit
+// +build unit
+
+package ratelimit_test
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bazsup/assessment/problem"
+	"github.com/bazsup/assessment/ratelimit"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupEcho(cfg ratelimit.Config) *echo.Echo {
+	e := echo.New()
+	e.HTTPErrorHandler = problem.Handler(slog.Default())
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	e.GET("/expenses", ok, ratelimit.Middleware(cfg))
+	e.POST("/expenses", ok, ratelimit.Middleware(cfg))
+	return e
+}
+
+func do(e *echo.Echo, method string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/expenses", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMiddleware(t *testing.T) {
+	now := time.Date(2022, 12, 24, 23, 0, 0, 0, time.UTC)
+
+	t.Run("Sets rate limit headers and rejects when the bucket is empty", func(t *testing.T) {
+		t.Parallel()
+		e := setupEcho(ratelimit.Config{
+			Store:  ratelimit.NewMemoryStore(),
+			Limits: map[ratelimit.Class]ratelimit.Limit{ratelimit.ClassRead: {Rate: 0.5, Burst: 1}},
+			Now:    func() time.Time { return now },
+		})
+
+		// Act
+		first := do(e, http.MethodGet)
+		second := do(e, http.MethodGet)
+
+		// Assertions
+		assert.Equal(t, http.StatusOK, first.Code)
+		assert.Equal(t, "1", first.Header().Get(ratelimit.HeaderLimit))
+		assert.Equal(t, "0", first.Header().Get(ratelimit.HeaderRemaining))
+		assert.Equal(t, "2", first.Header().Get(ratelimit.HeaderReset))
+
+		assert.Equal(t, http.StatusTooManyRequests, second.Code)
+		assert.Equal(t, "2", second.Header().Get(echo.HeaderRetryAfter))
+		assert.Contains(t, second.Body.String(), `"code":"RATE_LIMITED"`)
+	})
+
+	t.Run("Classes are limited independently", func(t *testing.T) {
+		t.Parallel()
+		e := setupEcho(ratelimit.Config{
+			Store:  ratelimit.NewMemoryStore(),
+			Limits: map[ratelimit.Class]ratelimit.Limit{ratelimit.ClassWrite: {Rate: 1, Burst: 1}},
+			Now:    func() time.Time { return now },
+		})
+
+		// Act
+		do(e, http.MethodPost)
+		write := do(e, http.MethodPost)
+		read := do(e, http.MethodGet)
+
+		// Assertions
+		assert.Equal(t, http.StatusTooManyRequests, write.Code)
+		assert.Equal(t, http.StatusOK, read.Code)
+		assert.Empty(t, read.Header().Get(ratelimit.HeaderLimit))
+	})
+
+	t.Run("Daily write quota rejects until the next day", func(t *testing.T) {
+		t.Parallel()
+		e := setupEcho(ratelimit.Config{
+			Store:           ratelimit.NewMemoryStore(),
+			Limits:          map[ratelimit.Class]ratelimit.Limit{ratelimit.ClassWrite: {Rate: 100, Burst: 100}},
+			DailyWriteQuota: 1,
+			Now:             func() time.Time { return now },
+		})
+
+		// Act
+		first := do(e, http.MethodPost)
+		second := do(e, http.MethodPost)
+
+		// Assertions
+		assert.Equal(t, http.StatusOK, first.Code)
+		assert.Equal(t, http.StatusTooManyRequests, second.Code)
+		assert.Equal(t, "3600", second.Header().Get(echo.HeaderRetryAfter))
+		assert.Contains(t, second.Body.String(), `"code":"QUOTA_EXCEEDED"`)
+	})
+}
@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// PostgresStore keeps limits in Postgres so they hold across replicas
+type PostgresStore struct {
+	*sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db}
+}
+
+// Take locks the bucket row so concurrent replicas see a consistent token count
+func (s *PostgresStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return Result{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO rate_limit_buckets ( key, tokens, updated_at ) VALUES ( $1, $2, $3 ) ON CONFLICT ( key ) DO NOTHING",
+		key, float64(limit.Burst), now)
+	if err != nil {
+		return Result{}, fmt.Errorf("can't insert rate limit bucket: %s", err.Error())
+	}
+
+	var tokens float64
+	var updatedAt time.Time
+	err = tx.QueryRowContext(ctx,
+		"SELECT tokens, updated_at FROM rate_limit_buckets WHERE key = $1 FOR UPDATE", key).
+		Scan(&tokens, &updatedAt)
+	if err != nil {
+		return Result{}, fmt.Errorf("can't lock rate limit bucket: %s", err.Error())
+	}
+
+	tokens, res := take(refill(tokens, now.Sub(updatedAt), limit), limit)
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE rate_limit_buckets SET tokens = $2, updated_at = $3 WHERE key = $1",
+		key, tokens, now)
+	if err != nil {
+		return Result{}, fmt.Errorf("can't update rate limit bucket: %s", err.Error())
+	}
+
+	return res, tx.Commit()
+}
+
+func (s *PostgresStore) CountQuota(ctx context.Context, key string, max int, now time.Time) (QuotaResult, error) {
+	day, reset := quotaWindow(now)
+
+	var count int
+	err := s.DB.QueryRowContext(ctx, `
+	INSERT INTO rate_limit_quotas ( key, day, count ) VALUES ( $1, $2, 1 )
+	ON CONFLICT ( key, day ) DO UPDATE SET count = rate_limit_quotas.count + 1
+	RETURNING count
+	`, key, day).Scan(&count)
+	if err != nil {
+		return QuotaResult{}, fmt.Errorf("can't count quota: %s", err.Error())
+	}
+
+	return quotaResult(count, max, reset), nil
+}
+
+// DeleteExpired removes buckets untouched since before and quota counters
+// of the days ended by then, returning how many were deleted
+func (s *PostgresStore) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	buckets, err := s.DB.ExecContext(ctx, "DELETE FROM rate_limit_buckets WHERE updated_at <= $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("can't purge rate limit buckets: %s", err.Error())
+	}
+	day, _ := quotaWindow(before)
+	quotas, err := s.DB.ExecContext(ctx, "DELETE FROM rate_limit_quotas WHERE day < $1", day)
+	if err != nil {
+		return 0, fmt.Errorf("can't purge rate limit quotas: %s", err.Error())
+	}
+
+	nb, _ := buckets.RowsAffected()
+	nq, _ := quotas.RowsAffected()
+	return nb + nq, nil
+}
@@ -0,0 +1,116 @@
+//go:build unit
+// +build unit
+
+package ratelimit_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bazsup/assessment/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupDB(t *testing.T) (*ratelimit.PostgresStore, sqlmock.Sqlmock) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	return ratelimit.NewPostgresStore(db), mock
+}
+
+func TestPostgresStoreTake(t *testing.T) {
+	limit := ratelimit.Limit{Rate: 1, Burst: 10}
+	now := time.Date(2022, 12, 24, 10, 0, 0, 0, time.UTC)
+
+	t.Run("Take refills and updates the locked bucket", func(t *testing.T) {
+		s, mock := setupDB(t)
+
+		// Arrange
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO rate_limit_buckets").
+			WithArgs("k", float64(10), now).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT tokens, updated_at FROM rate_limit_buckets WHERE key = .+ FOR UPDATE").
+			WithArgs("k").
+			WillReturnRows(sqlmock.NewRows([]string{"tokens", "updated_at"}).AddRow(0.5, now.Add(-2*time.Second)))
+		mock.ExpectExec("UPDATE rate_limit_buckets SET tokens").
+			WithArgs("k", 1.5, now).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		// Act
+		res, err := s.Take(context.Background(), "k", limit, now)
+
+		// Assertions
+		if assert.NoError(t, err) {
+			assert.True(t, res.Allowed)
+			assert.Equal(t, 1, res.Remaining)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Lock error rolls back", func(t *testing.T) {
+		s, mock := setupDB(t)
+
+		// Arrange
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO rate_limit_buckets").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT tokens").WillReturnError(fmt.Errorf("lock timeout"))
+		mock.ExpectRollback()
+
+		// Act
+		_, err := s.Take(context.Background(), "k", limit, now)
+
+		// Assertions
+		assert.ErrorContains(t, err, "lock timeout")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPostgresStoreCountQuota(t *testing.T) {
+	s, mock := setupDB(t)
+	now := time.Date(2022, 12, 24, 10, 0, 0, 0, time.UTC)
+
+	// Arrange
+	mock.ExpectQuery("INSERT INTO rate_limit_quotas (.+) ON CONFLICT (.+) RETURNING count").
+		WithArgs("k", time.Date(2022, 12, 24, 0, 0, 0, 0, time.UTC)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(4))
+
+	// Act
+	q, err := s.CountQuota(context.Background(), "k", 3, now)
+
+	// Assertions
+	if assert.NoError(t, err) {
+		assert.False(t, q.Allowed)
+		assert.Equal(t, 0, q.Remaining)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStoreDeleteExpired(t *testing.T) {
+	s, mock := setupDB(t)
+	before := time.Date(2022, 12, 24, 10, 0, 0, 0, time.UTC)
+
+	// Arrange
+	mock.ExpectExec("DELETE FROM rate_limit_buckets WHERE updated_at <= .+").
+		WithArgs(before).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("DELETE FROM rate_limit_quotas WHERE day < .+").
+		WithArgs(time.Date(2022, 12, 24, 0, 0, 0, 0, time.UTC)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	// Act
+	n, err := s.DeleteExpired(context.Background(), before)
+
+	// Assertions
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(5), n)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,104 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Class groups routes sharing the same limit
+type Class string
+
+const (
+	ClassRead  Class = "read"
+	ClassWrite Class = "write"
+)
+
+// Limit is a token bucket refilled at Rate tokens per second up to Burst tokens
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Result describes the state of a bucket after a request took a token
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is the time until the next token is available, zero when allowed
+	RetryAfter time.Duration
+}
+
+// QuotaResult describes a daily quota after a request was counted
+type QuotaResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time until the quota window starts over
+	Reset time.Duration
+}
+
+// Store keeps buckets and quota counters, implementations must be safe for concurrent use
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+	CountQuota(ctx context.Context, key string, max int, now time.Time) (QuotaResult, error)
+	// DeleteExpired drops buckets untouched since before and past quota counters
+	DeleteExpired(ctx context.Context, before time.Time) (int64, error)
+}
+
+// IdleAfter returns how long the slowest bucket of limits takes to refill
+// from empty. A bucket untouched that long is full, the same as a missing
+// one, so it can be deleted.
+func IdleAfter(limits map[Class]Limit) time.Duration {
+	var idle time.Duration
+	for _, l := range limits {
+		if l.Rate <= 0 {
+			continue
+		}
+		if d := time.Duration(float64(l.Burst) / l.Rate * float64(time.Second)); d > idle {
+			idle = d
+		}
+	}
+	return idle
+}
+
+// refill returns the tokens available after elapsed time, capped at the burst size
+func refill(tokens float64, elapsed time.Duration, limit Limit) float64 {
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return math.Min(float64(limit.Burst), tokens+elapsed.Seconds()*limit.Rate)
+}
+
+// take removes a token when one is available and reports the resulting bucket state
+func take(tokens float64, limit Limit) (float64, Result) {
+	res := Result{Limit: limit.Burst}
+	if tokens >= 1 {
+		tokens--
+		res.Allowed = true
+	} else if limit.Rate > 0 {
+		res.RetryAfter = time.Duration((1 - tokens) / limit.Rate * float64(time.Second))
+	}
+
+	res.Remaining = int(math.Floor(tokens))
+	if limit.Rate > 0 {
+		res.Reset = time.Duration((float64(limit.Burst) - tokens) / limit.Rate * float64(time.Second))
+	}
+	return tokens, res
+}
+
+// quotaWindow returns the UTC day now falls in and the time left until it ends
+func quotaWindow(now time.Time) (time.Time, time.Duration) {
+	day := now.UTC().Truncate(24 * time.Hour)
+	return day, day.Add(24 * time.Hour).Sub(now)
+}
+
+func quotaResult(count, max int, reset time.Duration) QuotaResult {
+	remaining := max - count
+	if remaining < 0 {
+		remaining = 0
+	}
+	return QuotaResult{Allowed: count <= max, Limit: max, Remaining: remaining, Reset: reset}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/bazsup/assessment/expense"
+)
+
+// runRebuildProjection implements the "rebuild-projection" subcommand,
+// replaying the event log into the expenses and expense_versions tables
+func runRebuildProjection(db *sql.DB) error {
+	start := time.Now()
+	n, err := expense.NewEventStore(expense.NewExpenseStore(db)).RebuildProjection(context.Background())
+	if err != nil {
+		return err
+	}
+	slog.Info("rebuilt projections", "events", n, "took", time.Since(start).Round(time.Millisecond).String())
+	return nil
+}
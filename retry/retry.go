@@ -0,0 +1,77 @@
+package retry
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Policy describes an exponential backoff with jitter
+type Policy struct {
+	// Attempts caps the number of calls, zero means no cap (MaxWait still applies)
+	Attempts int
+	// Initial is the delay before the second attempt
+	Initial time.Duration
+	// Max caps a single delay
+	Max time.Duration
+	// Multiplier grows the delay after each attempt, defaults to 2
+	Multiplier float64
+	// Jitter randomly shortens each delay by up to this fraction (0..1)
+	Jitter float64
+	// MaxWait bounds the total time spent retrying, zero means no bound
+	MaxWait time.Duration
+	// OnRetry is called before sleeping with the failed attempt number, its error and the delay
+	OnRetry func(attempt int, err error, delay time.Duration)
+}
+
+// Delay returns the backoff after the given failed attempt (1 based)
+func (p Policy) Delay(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+
+	d := float64(p.Initial) * math.Pow(multiplier, float64(attempt-1))
+	if p.Max > 0 && d > float64(p.Max) {
+		d = float64(p.Max)
+	}
+	if p.Jitter > 0 {
+		d -= d * p.Jitter * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// Do calls fn until it succeeds, returns an error retryable rejects, the
+// policy is exhausted or ctx is done. The last error of fn is returned,
+// wrapped with the attempt count when the policy gave up.
+func Do(ctx context.Context, p Policy, retryable func(error) bool, fn func(attempt int) error) error {
+	start := time.Now()
+
+	for attempt := 1; ; attempt++ {
+		err := fn(attempt)
+		if err == nil || !retryable(err) {
+			return err
+		}
+		if p.Attempts > 0 && attempt >= p.Attempts {
+			return fmt.Errorf("gave up after %d attempts: %w", attempt, err)
+		}
+
+		delay := p.Delay(attempt)
+		if p.MaxWait > 0 && time.Since(start)+delay > p.MaxWait {
+			return fmt.Errorf("gave up after %d attempts in %s: %w", attempt, time.Since(start).Round(time.Millisecond), err)
+		}
+		if p.OnRetry != nil {
+			p.OnRetry(attempt, err, delay)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,116 @@
+//go:build unit
+// +build unit
+
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bazsup/assessment/retry"
+	"github.com/stretchr/testify/assert"
+)
+
+var errTransient = errors.New("transient")
+
+func always(error) bool { return true }
+
+func TestDelay(t *testing.T) {
+	p := retry.Policy{Initial: 100 * time.Millisecond, Max: time.Second}
+
+	assert.Equal(t, 100*time.Millisecond, p.Delay(1))
+	assert.Equal(t, 200*time.Millisecond, p.Delay(2))
+	assert.Equal(t, 800*time.Millisecond, p.Delay(4))
+	assert.Equal(t, time.Second, p.Delay(10))
+
+	t.Run("Jitter only shortens the delay", func(t *testing.T) {
+		jittered := retry.Policy{Initial: 100 * time.Millisecond, Jitter: 0.5}
+		for i := 0; i < 100; i++ {
+			d := jittered.Delay(1)
+			assert.GreaterOrEqual(t, d, 50*time.Millisecond)
+			assert.LessOrEqual(t, d, 100*time.Millisecond)
+		}
+	})
+}
+
+func TestDo(t *testing.T) {
+	fast := retry.Policy{Attempts: 5, Initial: time.Millisecond}
+
+	t.Run("Retries until success and reports each retry", func(t *testing.T) {
+		var retried []int
+		p := fast
+		p.OnRetry = func(attempt int, err error, delay time.Duration) { retried = append(retried, attempt) }
+
+		// Act
+		err := retry.Do(context.Background(), p, always, func(attempt int) error {
+			if attempt < 3 {
+				return errTransient
+			}
+			return nil
+		})
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.Equal(t, []int{1, 2}, retried)
+	})
+
+	t.Run("Non retryable error stops immediately", func(t *testing.T) {
+		calls := 0
+		permanent := errors.New("permanent")
+
+		// Act
+		err := retry.Do(context.Background(), fast, func(err error) bool { return err == errTransient }, func(int) error {
+			calls++
+			return permanent
+		})
+
+		// Assertions
+		assert.Equal(t, permanent, err)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("Gives up after the attempt cap", func(t *testing.T) {
+		calls := 0
+
+		// Act
+		err := retry.Do(context.Background(), fast, always, func(int) error {
+			calls++
+			return errTransient
+		})
+
+		// Assertions
+		assert.ErrorIs(t, err, errTransient)
+		assert.ErrorContains(t, err, "gave up after 5 attempts")
+		assert.Equal(t, 5, calls)
+	})
+
+	t.Run("Gives up when the next delay exceeds the max wait", func(t *testing.T) {
+		calls := 0
+		p := retry.Policy{Initial: 50 * time.Millisecond, MaxWait: 100 * time.Millisecond}
+
+		// Act
+		err := retry.Do(context.Background(), p, always, func(int) error {
+			calls++
+			return errTransient
+		})
+
+		// Assertions
+		assert.ErrorIs(t, err, errTransient)
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("Stops when the context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		// Act
+		err := retry.Do(ctx, retry.Policy{Initial: time.Hour}, always, func(int) error {
+			return errTransient
+		})
+
+		// Assertions
+		assert.Equal(t, errTransient, err)
+	})
+}
@@ -1,7 +1,12 @@
 package router
 
+import "net/http"
+
 type RouterCtx interface {
+	Request() *http.Request
 	Param(string) string
+	QueryParam(string) string
 	Bind(interface{}) error
 	JSON(int, interface{}) error
+	NoContent(int) error
 }
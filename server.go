@@ -2,44 +2,378 @@ package main
 
 import (
 	"context"
-	"log"
+	"crypto/ed25519"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/bazsup/assessment/audit"
+	"github.com/bazsup/assessment/auth"
+	"github.com/bazsup/assessment/authz"
 	"github.com/bazsup/assessment/config"
 	"github.com/bazsup/assessment/expense"
+	"github.com/bazsup/assessment/health"
+	"github.com/bazsup/assessment/idempotency"
+	"github.com/bazsup/assessment/locale"
+	"github.com/bazsup/assessment/logging"
+	"github.com/bazsup/assessment/migration"
+	"github.com/bazsup/assessment/problem"
+	"github.com/bazsup/assessment/ratelimit"
+	"github.com/bazsup/assessment/retry"
+	"github.com/bazsup/assessment/stream"
+	"github.com/bazsup/assessment/webhook"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"google.golang.org/grpc"
 )
 
+const (
+	// drainTimeout is how long requests in flight may finish on shutdown
+	drainTimeout = 10 * time.Second
+	// unwindTimeout is how long requests canceled after the drain get to return
+	unwindTimeout = 2 * time.Second
+)
+
+// tools are the subcommands run instead of the server
+var tools = map[string]bool{"migrate": true, "verify-audit": true, "rebuild-projection": true}
+
 func main() {
-	config := config.NewConfig()
+	newConfig := config.NewConfig
+	if len(os.Args) > 1 && tools[os.Args[1]] {
+		newConfig = config.NewToolConfig
+	}
+	config := newConfig()
+	logger, err := logging.New(os.Stdout, logging.Config{
+		Level:  config.Log.Level,
+		Format: config.Log.Format,
+		Redact: config.Log.Redact,
+	})
+	if err != nil {
+		panic(err)
+	}
+	slog.SetDefault(logger)
+
 	db := expense.InitDB(config.DatabaseUrl)
+	db.SetMaxOpenConns(config.DBPool.MaxOpenConns)
+	db.SetMaxIdleConns(config.DBPool.MaxIdleConns)
+	db.SetConnMaxLifetime(config.DBPool.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(config.DBPool.ConnMaxIdleTime)
+
+	err = expense.WaitForDB(context.Background(), db, retry.Policy{
+		Initial: 500 * time.Millisecond,
+		Max:     10 * time.Second,
+		Jitter:  0.3,
+		MaxWait: config.DBStartupMaxWait,
+	})
+	if err != nil {
+		fatal("database is unreachable", err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(db, os.Args[2:]); err != nil {
+			fatal("migrate failed", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "rebuild-projection" {
+		if err := runRebuildProjection(db); err != nil {
+			fatal("rebuild-projection failed", err)
+		}
+		return
+	}
+	var signingKey ed25519.PrivateKey
+	if config.Audit.SigningKeyFile != "" {
+		signingKey, err = audit.LoadSigningKey(config.Audit.SigningKeyFile)
+		if err != nil {
+			fatal("can't load audit signing key", err)
+		}
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify-audit" {
+		if err := runVerifyAudit(db, signingKey); err != nil {
+			fatal("verify-audit failed", err)
+		}
+		return
+	}
+	if err := migrateOnStartup(db, config.MigrationsMode); err != nil {
+		fatal("can't migrate database", err)
+	}
+
+	language, ok := locale.Parse(config.DefaultLanguage)
+	if !ok {
+		fatal("can't use default language", fmt.Errorf("unsupported language %q", config.DefaultLanguage))
+	}
 
 	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+	e.HTTPErrorHandler = problem.Handler(logger)
+	// client addresses key rate limits and idempotency and are audited
+	extractor, err := expense.IPExtractor(config.TrustedProxies)
+	if err != nil {
+		fatal("can't use trusted proxies", err)
+	}
+	e.IPExtractor = extractor
+	// requests still running when the drain timeout is over are canceled
+	requests, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	e.Server.BaseContext = func(net.Listener) context.Context { return requests }
 
-	e.Use(middleware.Logger())
+	e.Use(logging.Middleware(logger))
+	e.Use(locale.Middleware(language))
 	e.Use(middleware.Recover())
+	// bodies are read whole for validation, authorization and idempotency
+	e.Use(middleware.BodyLimit(config.MaxBodySize))
+
+	var opts []expense.Option
+	if config.JWT.KeysFile != "" {
+		keys, err := auth.NewKeyFile(config.JWT.KeysFile, 30*time.Second)
+		if err != nil {
+			fatal("can't load jwt keys", err)
+		}
+		verifier := auth.NewVerifier(keys, auth.VerifierConfig{
+			Audience:      config.JWT.Audience,
+			Issuer:        config.JWT.Issuer,
+			IdentityClaim: config.JWT.IdentityClaim,
+			ScopesClaim:   config.JWT.ScopesClaim,
+			RolesClaim:    config.JWT.RolesClaim,
+		})
+		opts = append(opts, expense.WithJWTVerifier(verifier))
+	}
+
+	if config.AuthzPolicyFile != "" {
+		policy, err := authz.LoadPolicy(config.AuthzPolicyFile)
+		if err != nil {
+			fatal("can't load authz policy", err)
+		}
+		opts = append(opts, expense.WithPolicy(policy))
+	}
+
+	var limitStore ratelimit.Store = ratelimit.NewMemoryStore()
+	if config.RateLimit.Store == "postgres" {
+		limitStore = ratelimit.NewPostgresStore(db)
+	}
+	limits := map[ratelimit.Class]ratelimit.Limit{
+		ratelimit.ClassRead:  {Rate: config.RateLimit.ReadRate, Burst: config.RateLimit.ReadBurst},
+		ratelimit.ClassWrite: {Rate: config.RateLimit.WriteRate, Burst: config.RateLimit.WriteBurst},
+	}
+	opts = append(opts, expense.WithRateLimit(ratelimit.Config{
+		Store:           limitStore,
+		Limits:          limits,
+		DailyWriteQuota: config.RateLimit.DailyWriteQuota,
+	}))
+	go purgeRateLimits(limitStore, ratelimit.IdleAfter(limits))
+
+	var replayStore idempotency.Store = idempotency.NewMemoryStore()
+	if config.Idempotency.Store == "postgres" {
+		replayStore = idempotency.NewPostgresStore(db)
+	}
+	go purgeIdempotencyKeys(replayStore, config.Idempotency.LockTimeout)
+	opts = append(opts, expense.WithIdempotency(idempotency.Config{
+		Store:       replayStore,
+		TTL:         config.Idempotency.TTL,
+		LockTimeout: config.Idempotency.LockTimeout,
+	}))
+
+	checker := health.NewChecker(2*time.Second).
+		Add("database", db.PingContext).
+		Add("migrations", migrationsCurrent(migration.New(db)))
+	opts = append(opts, expense.WithHealth(checker))
+	opts = append(opts, expense.WithGraphQLLimits(expense.GraphQLLimits{
+		MaxDepth:      config.GraphQL.MaxDepth,
+		MaxComplexity: config.GraphQL.MaxComplexity,
+	}))
 
-	store := expense.NewExpenseStore(db)
-	expense.NewApp(e, store, config.AuthToken)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collectors.NewDBStatsCollector(db, "expenses"))
+	opts = append(opts, expense.WithMetrics(reg), expense.WithMetricTags(config.MetricTags...))
+
+	tracer, err := newTracerProvider(config.Tracing)
+	if err != nil {
+		fatal("can't set up tracing", err)
+	}
+	if tracer != nil {
+		opts = append(opts, expense.WithTracer(tracer))
+	}
+
+	readRetry := expense.DefaultReadRetry
+	readRetry.Attempts = config.DBReadAttempts
+	store := expense.NewExpenseStore(db).
+		WithTimeouts(config.DBReadTimeout, config.DBWriteTimeout).
+		WithReadRetry(readRetry)
+	if tracer != nil {
+		store.WithTracer(tracer)
+	}
+	if signingKey != nil {
+		store.WithAuditKey(signingKey.Public().(ed25519.PublicKey))
+		go checkpointAudit(audit.NewCheckpointer(db, signingKey), config.Audit.CheckpointInterval)
+	}
+	defer store.Close()
+
+	background, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	if config.Webhooks.Enabled {
+		hooks := webhook.NewPostgresStore(db)
+		opts = append(opts, expense.WithWebhooks(hooks))
+		go newDispatcher(hooks, config.Webhooks).Run(background, config.Webhooks.Interval)
+		go purgeOutbox(hooks, config.Webhooks.OutboxRetention)
+	}
+
+	var hub *stream.Hub
+	if config.Stream.Enabled {
+		hub = stream.NewHub(stream.NewPostgresSource(db))
+		hub.Heartbeat = config.Stream.Heartbeat
+		hub.BufferSize = config.Stream.BufferSize
+		hub.MaxBacklog = config.Stream.MaxBacklog
+		if err := hub.Start(context.Background()); err != nil {
+			fatal("can't start expense stream", err)
+		}
+		opts = append(opts, expense.WithStream(hub))
+		go func() {
+			if err := stream.Listen(background, config.DatabaseUrl, hub, config.Stream.PollInterval); err != nil {
+				fatal("can't listen for expense changes", err)
+			}
+		}()
+	}
+
+	var grpcServer *grpc.Server
+	switch config.StoreBackend {
+	case "state":
+		expense.NewApp(e, store, config.AuthToken, opts...)
+		grpcServer = expense.NewGRPCServer(store, config.AuthToken, opts...)
+	case "events":
+		events := expense.NewEventStore(store)
+		expense.NewApp(e, events, config.AuthToken, opts...)
+		grpcServer = expense.NewGRPCServer(events, config.AuthToken, opts...)
+	default:
+		fatal("can't select store", fmt.Errorf("unknown STORE_BACKEND %q", config.StoreBackend))
+	}
+
+	stopGRPC := func(context.Context) {}
+	if config.GRPCPort != "off" {
+		stopGRPC, err = serveGRPC(grpcServer, config.GRPCPort)
+		if err != nil {
+			fatal("can't serve grpc", err)
+		}
+	}
 
 	go func() {
+		slog.Info("server started", "addr", config.Port)
 		if err := e.Start(config.Port); err != nil && err != http.ErrServerClosed { // Start server
-			e.Logger.Fatal("shutting down the server")
+			fatal("shutting down the server", err)
 		}
-		log.Println("bye bye!")
+		slog.Info("server stopped")
 	}()
 
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 	<-shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	checker.Shutdown()
+	if config.ReadinessGrace > 0 {
+		// keep serving while load balancers notice the failing readiness
+		slog.Info("readiness down, waiting before shutdown", "grace", config.ReadinessGrace)
+		time.Sleep(config.ReadinessGrace)
+	}
+	stopBackground()
+	if hub != nil {
+		// streams never end on their own and would hold up the shutdown
+		hub.Close()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
 	defer cancel()
+	stopCanceling := context.AfterFunc(ctx, cancelRequests)
+	defer stopCanceling()
+	grpcStopped := make(chan struct{})
+	go func() {
+		stopGRPC(ctx)
+		close(grpcStopped)
+	}()
 	if err := e.Shutdown(ctx); err != nil {
-		e.Logger.Fatal(err)
+		// canceled requests roll back and release their idempotency keys
+		unwind, cancelUnwind := context.WithTimeout(context.Background(), unwindTimeout)
+		defer cancelUnwind()
+		if err := e.Shutdown(unwind); err != nil {
+			fatal("can't shut down gracefully", err)
+		}
+	}
+	<-grpcStopped
+	if tracer != nil {
+		if err := tracer.Shutdown(ctx); err != nil {
+			slog.Error("can't flush traces", "error", err.Error())
+		}
+	}
+}
+
+// fatal logs err and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err.Error())
+	os.Exit(1)
+}
+
+// newTracerProvider builds the tracer provider of the configured exporter,
+// nil when tracing is off
+func newTracerProvider(cfg config.TracingConfig) (*sdktrace.TracerProvider, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case "none", "":
+		return nil, nil
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "file":
+		f, ferr := os.OpenFile(cfg.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if ferr != nil {
+			return nil, ferr
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+	case "otlp":
+		exporter, err = otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(cfg.Endpoint))
+	default:
+		return nil, fmt.Errorf("unknown TRACING_EXPORTER %q", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(cfg.ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		// an incoming traceparent keeps its sampling decision
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	), nil
+}
+
+// purgeRateLimits deletes buckets idle long enough to have refilled and
+// past quota counters, so keys of clients gone for good don't pile up
+func purgeRateLimits(store ratelimit.Store, idle time.Duration) {
+	for range time.Tick(max(idle, time.Minute)) {
+		if _, err := store.DeleteExpired(context.Background(), time.Now().Add(-idle)); err != nil {
+			slog.Error("can't purge rate limits", "error", err.Error())
+		}
+	}
+}
+
+// purgeIdempotencyKeys deletes expired keys so the store does not grow forever
+func purgeIdempotencyKeys(store idempotency.Store, every time.Duration) {
+	for range time.Tick(max(every, time.Minute)) {
+		if _, err := store.DeleteExpired(context.Background(), time.Now()); err != nil {
+			slog.Error("can't purge idempotency keys", "error", err.Error())
+		}
 	}
 }
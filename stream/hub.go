@@ -0,0 +1,202 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Event is an expense change pushed to subscribers, its ID orders events
+// and is what a reconnecting client resumes after
+type Event struct {
+	ID         int64           `json:"id"`
+	Type       string          `json:"type"`
+	ExpenseID  int             `json:"expense_id"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// Source is the durable, ordered log of events the hub publishes
+type Source interface {
+	// After returns up to limit events with an id above after, oldest first
+	After(ctx context.Context, after int64, limit int) ([]Event, error)
+	// Latest returns the id of the newest event, zero when there is none
+	Latest(ctx context.Context) (int64, error)
+}
+
+var (
+	// ErrTooFarBehind is returned when resuming would replay more than
+	// MaxBacklog events, the client should reload instead
+	ErrTooFarBehind = errors.New("last event id is too far behind, reload and subscribe again")
+	ErrClosed       = errors.New("stream is closed")
+)
+
+// Hub fans events read from a Source out to subscribers. A subscriber
+// falling BufferSize events behind is dropped rather than slowing down
+// the others, it catches up by resuming from its last event.
+type Hub struct {
+	source Source
+	// BufferSize is how many events a subscriber may fall behind
+	BufferSize int
+	// MaxBacklog caps the events replayed to a resuming subscriber
+	MaxBacklog int
+	BatchSize  int
+	// Heartbeat is how often idle subscribers are sent a heartbeat
+	Heartbeat time.Duration
+
+	polling sync.Mutex
+	mu      sync.Mutex
+	last    int64
+	subs    map[*Subscription]struct{}
+	closed  bool
+}
+
+func NewHub(source Source) *Hub {
+	return &Hub{
+		source:     source,
+		BufferSize: 256,
+		MaxBacklog: 1000,
+		BatchSize:  100,
+		Heartbeat:  15 * time.Second,
+		subs:       map[*Subscription]struct{}{},
+	}
+}
+
+// Start makes the newest event of the source the one live subscribers
+// start after, it must be called before the hub is polled
+func (h *Hub) Start(ctx context.Context) error {
+	id, err := h.source.Latest(ctx)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	h.last = id
+	h.mu.Unlock()
+	return nil
+}
+
+// Poll publishes the events appended to the source since the last poll,
+// returning how many were published
+func (h *Hub) Poll(ctx context.Context) (int, error) {
+	h.polling.Lock()
+	defer h.polling.Unlock()
+
+	n := 0
+	for {
+		h.mu.Lock()
+		after := h.last
+		h.mu.Unlock()
+
+		events, err := h.source.After(ctx, after, h.BatchSize)
+		if err != nil {
+			return n, err
+		}
+		h.publish(events)
+		n += len(events)
+		if len(events) < h.BatchSize {
+			return n, nil
+		}
+	}
+}
+
+func (h *Hub) publish(events []Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, ev := range events {
+		for s := range h.subs {
+			select {
+			case s.c <- ev:
+			default:
+				s.lagged = true
+				h.drop(s)
+			}
+		}
+		h.last = ev.ID
+	}
+}
+
+// drop unregisters s and closes its channel, h.mu must be held
+func (h *Hub) drop(s *Subscription) {
+	delete(h.subs, s)
+	close(s.c)
+}
+
+// Subscribe registers a subscriber of the events after lastID, a negative
+// lastID subscribes to new events only. The events already published
+// after lastID are in the Backlog of the subscription.
+func (h *Hub) Subscribe(ctx context.Context, lastID int64) (*Subscription, error) {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return nil, ErrClosed
+	}
+	s := &Subscription{c: make(chan Event, h.BufferSize), hub: h}
+	s.C = s.c
+	h.subs[s] = struct{}{}
+	// live events are the ones after last, the backlog is read up to it
+	last := h.last
+	h.mu.Unlock()
+
+	for lastID >= 0 && lastID < last {
+		events, err := h.source.After(ctx, lastID, h.BatchSize)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		for _, ev := range events {
+			if ev.ID > last {
+				break
+			}
+			s.Backlog = append(s.Backlog, ev)
+			lastID = ev.ID
+		}
+		if len(s.Backlog) > h.MaxBacklog {
+			s.Close()
+			return nil, ErrTooFarBehind
+		}
+		if len(events) < h.BatchSize {
+			break
+		}
+	}
+	return s, nil
+}
+
+// Close drops every subscriber and refuses new ones, so streams end
+// before the server shuts down
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for s := range h.subs {
+		h.drop(s)
+	}
+}
+
+// Subscription receives the events published after it subscribed
+type Subscription struct {
+	// Backlog holds the events missed since the resumed id, they come before C
+	Backlog []Event
+	// C is closed once the subscriber is dropped or the hub closes
+	C <-chan Event
+
+	c      chan Event
+	hub    *Hub
+	lagged bool
+}
+
+// Lagged reports whether C was closed because the subscriber fell too far
+// behind, it is only meaningful once C is closed
+func (s *Subscription) Lagged() bool {
+	return s.lagged
+}
+
+// Close unsubscribes
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	if _, ok := s.hub.subs[s]; ok {
+		s.hub.drop(s)
+	}
+}
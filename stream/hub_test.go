@@ -0,0 +1,168 @@
+//go:build unit
+// +build unit
+
+package stream_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/bazsup/assessment/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+type memorySource struct {
+	mu     sync.Mutex
+	events []stream.Event
+}
+
+func (s *memorySource) append(types ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, typ := range types {
+		s.events = append(s.events, stream.Event{ID: int64(len(s.events) + 1), Type: typ})
+	}
+}
+
+func (s *memorySource) After(ctx context.Context, after int64, limit int) ([]stream.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []stream.Event
+	for _, ev := range s.events {
+		if ev.ID > after && len(events) < limit {
+			events = append(events, ev)
+		}
+	}
+	return events, nil
+}
+
+func (s *memorySource) Latest(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.events)), nil
+}
+
+func setupHub(t *testing.T, existing ...string) (*stream.Hub, *memorySource) {
+	t.Parallel()
+
+	source := &memorySource{}
+	source.append(existing...)
+	hub := stream.NewHub(source)
+	hub.BatchSize = 2
+	if err := hub.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return hub, source
+}
+
+func ids(events []stream.Event) []int64 {
+	var ids []int64
+	for _, ev := range events {
+		ids = append(ids, ev.ID)
+	}
+	return ids
+}
+
+func drain(c <-chan stream.Event) []stream.Event {
+	var events []stream.Event
+	for {
+		select {
+		case ev, ok := <-c:
+			if !ok {
+				return events
+			}
+			events = append(events, ev)
+		default:
+			return events
+		}
+	}
+}
+
+func TestHub(t *testing.T) {
+	t.Run("New subscriber receives the events published after it subscribed", func(t *testing.T) {
+		hub, source := setupHub(t, "expense.created")
+
+		// Arrange
+		sub, err := hub.Subscribe(context.Background(), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		source.append("expense.updated", "expense.updated", "expense.deleted")
+
+		// Act
+		n, err := hub.Poll(context.Background())
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.Equal(t, 3, n)
+		assert.Empty(t, sub.Backlog)
+		assert.Equal(t, []int64{2, 3, 4}, ids(drain(sub.C)))
+	})
+
+	t.Run("Resuming subscriber gets the missed events as backlog", func(t *testing.T) {
+		hub, source := setupHub(t, "expense.created", "expense.updated", "expense.updated")
+
+		// Arrange
+		sub, err := hub.Subscribe(context.Background(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		source.append("expense.deleted")
+
+		// Act
+		hub.Poll(context.Background())
+
+		// Assertions
+		assert.Equal(t, []int64{2, 3}, ids(sub.Backlog))
+		assert.Equal(t, []int64{4}, ids(drain(sub.C)))
+	})
+
+	t.Run("Resuming too far behind is refused", func(t *testing.T) {
+		hub, _ := setupHub(t, "expense.created", "expense.updated", "expense.updated")
+		hub.MaxBacklog = 1
+
+		// Act
+		_, err := hub.Subscribe(context.Background(), 0)
+
+		// Assertions
+		assert.Equal(t, stream.ErrTooFarBehind, err)
+	})
+
+	t.Run("Slow subscriber is dropped without holding up the others", func(t *testing.T) {
+		hub, source := setupHub(t)
+		hub.BufferSize = 1
+
+		// Arrange
+		slow, _ := hub.Subscribe(context.Background(), -1)
+		source.append("expense.created")
+		hub.Poll(context.Background())
+		fast, _ := hub.Subscribe(context.Background(), -1)
+		source.append("expense.updated")
+
+		// Act
+		hub.Poll(context.Background())
+
+		// Assertions
+		assert.Equal(t, []int64{1}, ids(drain(slow.C)))
+		assert.True(t, slow.Lagged())
+		assert.Equal(t, []int64{2}, ids(drain(fast.C)))
+	})
+
+	t.Run("Closing the hub ends every subscription", func(t *testing.T) {
+		hub, _ := setupHub(t)
+
+		// Arrange
+		sub, _ := hub.Subscribe(context.Background(), -1)
+
+		// Act
+		hub.Close()
+
+		// Assertions
+		_, open := <-sub.C
+		assert.False(t, open)
+		assert.False(t, sub.Lagged())
+		_, err := hub.Subscribe(context.Background(), -1)
+		assert.Equal(t, stream.ErrClosed, err)
+	})
+}
@@ -0,0 +1,82 @@
+package stream
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Channel is notified by the outbox trigger whenever a change commits
+const Channel = "expense_changes"
+
+// PostgresSource reads events from the outbox. Outbox ids commit in order
+// as changes are written under the audit chain lock, so reading after the
+// last published id never skips a change committed later.
+type PostgresSource struct {
+	*sql.DB
+}
+
+func NewPostgresSource(db *sql.DB) *PostgresSource {
+	return &PostgresSource{db}
+}
+
+func (s *PostgresSource) After(ctx context.Context, after int64, limit int) ([]Event, error) {
+	rows, err := s.DB.QueryContext(ctx,
+		"SELECT id, event_type, expense_id, payload, created_at FROM outbox WHERE id > $1 ORDER BY id LIMIT $2", after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var ev Event
+		var data []byte
+		if err := rows.Scan(&ev.ID, &ev.Type, &ev.ExpenseID, &data, &ev.OccurredAt); err != nil {
+			return nil, err
+		}
+		ev.Data = data
+		events = append(events, ev)
+	}
+	return events, rows.Err()
+}
+
+func (s *PostgresSource) Latest(ctx context.Context) (int64, error) {
+	var id int64
+	err := s.DB.QueryRowContext(ctx, "SELECT COALESCE(max(id), 0) FROM outbox").Scan(&id)
+	return id, err
+}
+
+// Listen polls hub whenever a change is notified on Channel, so every
+// replica streams changes made through any other. It also polls every
+// fallback interval as notifications sent while reconnecting are lost.
+// Listen returns when ctx is done.
+func Listen(ctx context.Context, dsn string, hub *Hub, fallback time.Duration) error {
+	l := pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			slog.Error("stream listener connection failed", "error", err.Error())
+		}
+	})
+	defer l.Close()
+	if err := l.Listen(Channel); err != nil {
+		return err
+	}
+
+	t := time.NewTicker(fallback)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		// a nil notification follows a reconnect
+		case <-l.Notify:
+		case <-t.C:
+		}
+		if _, err := hub.Poll(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("can't poll stream events", "error", err.Error())
+		}
+	}
+}
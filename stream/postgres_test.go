@@ -0,0 +1,39 @@
+//go:build unit
+// +build unit
+
+package stream_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bazsup/assessment/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostgresSource(t *testing.T) {
+	t.Run("After reads the outbox events after an id", func(t *testing.T) {
+		t.Parallel()
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		now := time.Date(2022, 12, 24, 10, 0, 0, 0, time.UTC)
+
+		// Arrange
+		mock.ExpectQuery("FROM outbox WHERE id > \\$1 ORDER BY id LIMIT \\$2").
+			WithArgs(4, 100).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "event_type", "expense_id", "payload", "created_at"}).
+				AddRow(5, "expense.created", 1, []byte(`{"actor":"alice"}`), now))
+
+		// Act
+		events, err := stream.NewPostgresSource(db).After(context.Background(), 4, 100)
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.Equal(t, []stream.Event{{ID: 5, Type: "expense.created", ExpenseID: 1, OccurredAt: now, Data: []byte(`{"actor":"alice"}`)}}, events)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,27 @@
+//go:build unit
+// +build unit
+
+package stream_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/bazsup/assessment/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteSSE(t *testing.T) {
+	t.Run("Event is named after its type and carries its id", func(t *testing.T) {
+		var buf bytes.Buffer
+
+		// Act
+		err := stream.WriteSSE(&buf, stream.Event{ID: 7, Type: "expense.created", ExpenseID: 1, Data: json.RawMessage(`{}`)})
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.Equal(t, "id: 7\nevent: expense.created\n"+
+			`data: {"id":7,"type":"expense.created","expense_id":1,"occurred_at":"0001-01-01T00:00:00Z","data":{}}`+"\n\n", buf.String())
+	})
+}
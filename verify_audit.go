@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/bazsup/assessment/audit"
+)
+
+// runVerifyAudit implements the "verify-audit" subcommand, checking
+// checkpoint signatures too when the signing key is configured
+func runVerifyAudit(db *sql.DB, key ed25519.PrivateKey) error {
+	var pub ed25519.PublicKey
+	if key != nil {
+		pub = key.Public().(ed25519.PublicKey)
+	}
+
+	r, err := audit.Verify(context.Background(), db, pub)
+	if err != nil {
+		return err
+	}
+	if !r.Valid {
+		slog.Error("audit chain is broken",
+			"audit_id", r.Broken.AuditID, "reason", r.Broken.Reason, "entries", r.Entries)
+		return errors.New("audit chain is broken")
+	}
+	slog.Info("audit chain is intact",
+		"entries", r.Entries, "legacy", r.Legacy, "checkpoints", r.Checkpoints, "last_hash", r.LastHash)
+	return nil
+}
+
+// checkpointAudit signs the head of the audit chain every interval
+func checkpointAudit(c *audit.Checkpointer, interval time.Duration) {
+	for range time.Tick(interval) {
+		cp, err := c.Checkpoint(context.Background())
+		if err != nil {
+			slog.Error("can't checkpoint audit chain", "error", err.Error())
+			continue
+		}
+		if cp != nil {
+			slog.Info("signed audit checkpoint", "audit_id", cp.AuditID, "key_id", cp.KeyID)
+		}
+	}
+}
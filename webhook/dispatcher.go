@@ -0,0 +1,179 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bazsup/assessment/retry"
+)
+
+// Job is a delivery due for an attempt
+type Job struct {
+	DeliveryID int64
+	EventID    int64
+	EventType  string
+	// Attempts made before this one
+	Attempts int
+	URL      string
+	Secret   string
+	Body     []byte
+	// Lease identifies the claim, only its holder can record the outcome
+	Lease string
+}
+
+// Outcome is the result of one delivery attempt
+type Outcome struct {
+	StatusCode int
+	Error      string
+	// Status is the delivery status after the attempt
+	Status string
+	// NextAttemptAt is when a pending delivery is retried
+	NextAttemptAt time.Time
+	At            time.Time
+}
+
+// Store is what the dispatcher needs of the outbox and delivery log
+type Store interface {
+	// FanOut turns up to limit undispatched outbox events into pending
+	// deliveries of the subscriptions matching them, returning the events fanned out
+	FanOut(ctx context.Context, limit int, now time.Time) (int, error)
+	// Claim leases up to limit deliveries due at now until now+lease, so a
+	// dispatcher dying mid attempt leaves them to be retried
+	Claim(ctx context.Context, limit int, now time.Time, lease time.Duration) ([]Job, error)
+	// Record stores the outcome of attempting the job, ErrLeaseLost is
+	// returned when the delivery was claimed again or is no longer pending
+	Record(ctx context.Context, job Job, o Outcome) error
+}
+
+// ErrLeaseLost is returned by Record for a job whose lease lapsed and
+// which another dispatcher claimed since
+var ErrLeaseLost = errors.New("webhook delivery lease lost")
+
+// Dispatcher delivers outbox events to webhook subscriptions
+type Dispatcher struct {
+	Store  Store
+	Client *http.Client
+	// Backoff spaces retries of a failed delivery, after Backoff.Attempts
+	// attempts the delivery is dead lettered
+	Backoff retry.Policy
+	// BatchSize caps the attempts of one run, each delivery is claimed
+	// right before its attempt
+	BatchSize int
+	// Lease bounds a single attempt, it should exceed the client timeout
+	Lease time.Duration
+	Now   func() time.Time
+}
+
+// DefaultBackoff retries for about a day before dead lettering
+var DefaultBackoff = retry.Policy{
+	Attempts: 10,
+	Initial:  30 * time.Second,
+	Max:      6 * time.Hour,
+	Jitter:   0.2,
+}
+
+func NewDispatcher(store Store) *Dispatcher {
+	return &Dispatcher{
+		Store:     store,
+		Client:    NewClient(10 * time.Second),
+		Backoff:   DefaultBackoff,
+		BatchSize: 100,
+		Lease:     time.Minute,
+		Now:       time.Now,
+	}
+}
+
+// Run dispatches every interval until ctx is done
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if _, err := d.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("can't dispatch webhooks", "error", err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// RunOnce fans out pending outbox events and attempts the deliveries due,
+// returning the number of attempts made
+func (d *Dispatcher) RunOnce(ctx context.Context) (int, error) {
+	if _, err := d.Store.FanOut(ctx, d.BatchSize, d.Now()); err != nil {
+		return 0, fmt.Errorf("can't fan out outbox: %s", err.Error())
+	}
+
+	n := 0
+	var errs []error
+	for n < d.BatchSize {
+		// claimed one at a time so the lease only has to cover one attempt
+		jobs, err := d.Store.Claim(ctx, 1, d.Now(), d.Lease)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("can't claim deliveries: %s", err.Error()))
+			break
+		}
+		if len(jobs) == 0 {
+			break
+		}
+		for _, job := range jobs {
+			o := d.attempt(ctx, job)
+			n++
+			switch err := d.Store.Record(ctx, job, o); {
+			case errors.Is(err, ErrLeaseLost):
+				slog.WarnContext(ctx, "webhook delivery outcome dropped", "delivery_id", job.DeliveryID, "error", err.Error())
+			case err != nil:
+				errs = append(errs, fmt.Errorf("can't record delivery %d: %s", job.DeliveryID, err.Error()))
+			}
+		}
+	}
+	return n, errors.Join(errs...)
+}
+
+// attempt POSTs the job, any 2xx answer delivers it
+func (d *Dispatcher) attempt(ctx context.Context, job Job) Outcome {
+	now := d.Now()
+	o := Outcome{At: now}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.URL, bytes.NewReader(job.Body))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(HeaderEvent, job.EventType)
+		req.Header.Set(HeaderDelivery, strconv.FormatInt(job.DeliveryID, 10))
+		req.Header.Set(HeaderSignature, Sign(job.Secret, now, job.Body))
+
+		var res *http.Response
+		res, err = d.Client.Do(req)
+		if err == nil {
+			io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+			res.Body.Close()
+			o.StatusCode = res.StatusCode
+			if res.StatusCode >= 200 && res.StatusCode < 300 {
+				o.Status = StatusDelivered
+				return o
+			}
+			err = fmt.Errorf("receiver answered %s", res.Status)
+		}
+	}
+	o.Error = err.Error()
+
+	attempts := job.Attempts + 1
+	if d.Backoff.Attempts > 0 && attempts >= d.Backoff.Attempts {
+		o.Status = StatusDead
+		slog.WarnContext(ctx, "webhook delivery dead lettered",
+			"delivery_id", job.DeliveryID, "attempts", attempts, "error", o.Error)
+		return o
+	}
+	o.Status = StatusPending
+	o.NextAttemptAt = now.Add(d.Backoff.Delay(attempts))
+	return o
+}
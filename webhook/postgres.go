@@ -0,0 +1,308 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PostgresStore keeps subscriptions and the delivery log next to the outbox
+type PostgresStore struct {
+	*sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db}
+}
+
+// FanOut locks the batch with SKIP LOCKED so dispatchers on other replicas
+// fan out other events meanwhile
+func (s *PostgresStore) FanOut(ctx context.Context, limit int, now time.Time) (n int, err error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.QueryContext(ctx, `
+	SELECT id, event_type, expense_id, payload, created_at FROM outbox
+	WHERE dispatched_at IS NULL ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED
+	`, limit)
+	if err != nil {
+		return 0, err
+	}
+	var events []Body
+	for rows.Next() {
+		var b Body
+		var data []byte
+		if err := rows.Scan(&b.ID, &b.Type, &b.ExpenseID, &data, &b.OccurredAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		b.Data = data
+		events = append(events, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(events) == 0 {
+		return 0, tx.Commit()
+	}
+
+	subs, err := querySubscriptions(ctx, tx, "WHERE active")
+	if err != nil {
+		return 0, err
+	}
+
+	ids := make([]int64, 0, len(events))
+	for _, ev := range events {
+		ids = append(ids, ev.ID)
+		body, err := json.Marshal(ev)
+		if err != nil {
+			return 0, err
+		}
+		for _, sub := range subs {
+			if !sub.Matches(ev.Type) {
+				continue
+			}
+			_, err := tx.ExecContext(ctx, `
+			INSERT INTO webhook_deliveries ( subscription_id, outbox_id, event_type, body, next_attempt_at )
+			VALUES ( $1, $2, $3, $4, $5 ) ON CONFLICT DO NOTHING
+			`, sub.ID, ev.ID, ev.Type, string(body), now)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE outbox SET dispatched_at = $2 WHERE id = ANY($1)", pq.Array(ids), now)
+	if err != nil {
+		return 0, err
+	}
+	return len(events), tx.Commit()
+}
+
+func (s *PostgresStore) Claim(ctx context.Context, limit int, now time.Time, lease time.Duration) ([]Job, error) {
+	token, err := NewSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `
+	UPDATE webhook_deliveries d SET next_attempt_at = $3, lease_token = $4
+	FROM webhook_subscriptions s
+	WHERE s.id = d.subscription_id AND d.id IN (
+		SELECT id FROM webhook_deliveries
+		WHERE status = 'pending' AND next_attempt_at <= $2
+		ORDER BY next_attempt_at LIMIT $1 FOR UPDATE SKIP LOCKED
+	)
+	RETURNING d.id, d.outbox_id, d.event_type, d.attempts, s.url, s.secret, d.body
+	`, limit, now, now.Add(lease), token)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		j := Job{Lease: token}
+		var body string
+		if err := rows.Scan(&j.DeliveryID, &j.EventID, &j.EventType, &j.Attempts, &j.URL, &j.Secret, &body); err != nil {
+			return nil, err
+		}
+		j.Body = []byte(body)
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+func (s *PostgresStore) Record(ctx context.Context, job Job, o Outcome) error {
+	var deliveredAt *time.Time
+	next := o.At
+	if o.Status == StatusDelivered {
+		deliveredAt = &o.At
+	}
+	if o.Status == StatusPending {
+		next = o.NextAttemptAt
+	}
+	res, err := s.DB.ExecContext(ctx, `
+	UPDATE webhook_deliveries
+	SET status = $2, attempts = attempts + 1, last_status_code = $3, last_error = $4, next_attempt_at = $5, delivered_at = $6
+	WHERE id = $1 AND lease_token = $7 AND status = 'pending'
+	`, job.DeliveryID, o.Status, o.StatusCode, o.Error, next, deliveredAt, job.Lease)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// CreateSubscription stores sub, generating its secret when empty
+func (s *PostgresStore) CreateSubscription(ctx context.Context, sub Subscription) (*Subscription, error) {
+	if sub.Secret == "" {
+		secret, err := NewSecret()
+		if err != nil {
+			return nil, err
+		}
+		sub.Secret = secret
+	}
+	if sub.Events == nil {
+		sub.Events = []string{}
+	}
+	sub.Active = true
+
+	err := s.DB.QueryRowContext(ctx, `
+	INSERT INTO webhook_subscriptions ( url, secret, events ) VALUES ( $1, $2, $3 )
+	RETURNING id, created_at
+	`, sub.URL, sub.Secret, pq.Array(sub.Events)).Scan(&sub.ID, &sub.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("can't create webhook subscription: %s", err.Error())
+	}
+	return &sub, nil
+}
+
+// Subscriptions lists every subscription without its secret
+func (s *PostgresStore) Subscriptions(ctx context.Context) ([]Subscription, error) {
+	subs, err := querySubscriptions(ctx, s.DB, "")
+	for i := range subs {
+		subs[i].Secret = ""
+	}
+	return subs, err
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func querySubscriptions(ctx context.Context, db querier, where string) ([]Subscription, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, url, secret, events, active, created_at FROM webhook_subscriptions "+where+" ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := []Subscription{}
+	for rows.Next() {
+		var sub Subscription
+		if err := rows.Scan(&sub.ID, &sub.URL, &sub.Secret, pq.Array(&sub.Events), &sub.Active, &sub.CreatedAt); err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+// DeleteSubscription deactivates the subscription, keeping its delivery
+// log, and dead letters its pending deliveries. sql.ErrNoRows is returned
+// for an unknown or already deleted subscription.
+func (s *PostgresStore) DeleteSubscription(ctx context.Context, id int64) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "UPDATE webhook_subscriptions SET active = false WHERE id = $1 AND active", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	_, err = tx.ExecContext(ctx,
+		"UPDATE webhook_deliveries SET status = 'dead', last_error = 'subscription deleted' WHERE subscription_id = $1 AND status = 'pending'", id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Deliveries reads the delivery log, oldest first
+func (s *PostgresStore) Deliveries(ctx context.Context, q DeliveryQuery) ([]Delivery, error) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, strings.Replace(cond, "?", "$"+strconv.Itoa(len(args)), 1))
+	}
+	if q.SubscriptionID != 0 {
+		add("subscription_id = ?", q.SubscriptionID)
+	}
+	if q.Status != "" {
+		add("status = ?", q.Status)
+	}
+	if q.After != 0 {
+		add("id > ?", q.After)
+	}
+	limit := q.Limit
+	switch {
+	case limit <= 0:
+		limit = DefaultDeliveryLimit
+	case limit > MaxDeliveryLimit:
+		limit = MaxDeliveryLimit
+	}
+
+	query := `SELECT id, subscription_id, outbox_id, event_type, status, attempts, next_attempt_at,
+	last_status_code, last_error, created_at, delivered_at FROM webhook_deliveries`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id LIMIT " + strconv.Itoa(limit)
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []Delivery{}
+	for rows.Next() {
+		var d Delivery
+		err := rows.Scan(&d.ID, &d.SubscriptionID, &d.EventID, &d.EventType, &d.Status, &d.Attempts, &d.NextAttemptAt,
+			&d.LastStatusCode, &d.LastError, &d.CreatedAt, &d.DeliveredAt)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// Redeliver queues a dead delivery of an active subscription for a fresh
+// round of attempts, sql.ErrNoRows is returned for any other delivery
+func (s *PostgresStore) Redeliver(ctx context.Context, id int64, now time.Time) error {
+	res, err := s.DB.ExecContext(ctx, `
+	UPDATE webhook_deliveries SET status = 'pending', attempts = 0, next_attempt_at = $2
+	WHERE id = $1 AND status = 'dead'
+	AND subscription_id IN ( SELECT id FROM webhook_subscriptions WHERE active )
+	`, id, now)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// PurgeOutbox deletes events fanned out before t, their deliveries keep a copy of the body
+func (s *PostgresStore) PurgeOutbox(ctx context.Context, t time.Time) (int64, error) {
+	res, err := s.DB.ExecContext(ctx, "DELETE FROM outbox WHERE dispatched_at < $1", t)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,207 @@
+//go:build unit
+// +build unit
+
+package webhook_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bazsup/assessment/webhook"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupDB(t *testing.T) (*webhook.PostgresStore, sqlmock.Sqlmock) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	return webhook.NewPostgresStore(db), mock
+}
+
+var subscriptionRows = []string{"id", "url", "secret", "events", "active", "created_at"}
+
+func TestPostgresStoreFanOut(t *testing.T) {
+	t.Run("Event is fanned out to the matching subscriptions", func(t *testing.T) {
+		s, mock := setupDB(t)
+
+		// Arrange
+		mock.ExpectBegin()
+		mock.ExpectQuery("FROM outbox WHERE dispatched_at IS NULL (.+) FOR UPDATE SKIP LOCKED").
+			WithArgs(10).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "event_type", "expense_id", "payload", "created_at"}).
+				AddRow(7, webhook.EventExpenseDeleted, 1, []byte(`{}`), now))
+		mock.ExpectQuery("FROM webhook_subscriptions WHERE active").
+			WillReturnRows(sqlmock.NewRows(subscriptionRows).
+				AddRow(1, "https://a.example", "s", "{expense.created}", true, now).
+				AddRow(2, "https://b.example", "s", "{expense.*}", true, now))
+		mock.ExpectExec("INSERT INTO webhook_deliveries").
+			WithArgs(2, 7, webhook.EventExpenseDeleted, sqlmock.AnyArg(), now).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE outbox SET dispatched_at").
+			WithArgs(pq.Array([]int64{7}), now).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		// Act
+		n, err := s.FanOut(context.Background(), 10, now)
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPostgresStoreDeleteSubscription(t *testing.T) {
+	t.Run("Pending deliveries of a deleted subscription are dead lettered", func(t *testing.T) {
+		s, mock := setupDB(t)
+
+		// Arrange
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE webhook_subscriptions SET active = false").
+			WithArgs(1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE webhook_deliveries SET status = 'dead'").
+			WithArgs(1).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+
+		// Act
+		err := s.DeleteSubscription(context.Background(), 1)
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Unknown subscription should returns no rows", func(t *testing.T) {
+		s, mock := setupDB(t)
+
+		// Arrange
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE webhook_subscriptions SET active = false").
+			WithArgs(9).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		// Act
+		err := s.DeleteSubscription(context.Background(), 9)
+
+		// Assertions
+		assert.Equal(t, sql.ErrNoRows, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPostgresStoreDeliveries(t *testing.T) {
+	t.Run("Query caps the limit at the maximum", func(t *testing.T) {
+		s, mock := setupDB(t)
+
+		// Arrange
+		mock.ExpectQuery(`SELECT .+ FROM webhook_deliveries ORDER BY id LIMIT 1000`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		// Act
+		_, err := s.Deliveries(context.Background(), webhook.DeliveryQuery{Limit: 5000})
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPostgresStoreRedeliver(t *testing.T) {
+	t.Run("Dead delivery is queued again", func(t *testing.T) {
+		s, mock := setupDB(t)
+
+		// Arrange
+		mock.ExpectExec("UPDATE webhook_deliveries SET status = 'pending', attempts = 0").
+			WithArgs(3, now).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		// Act
+		err := s.Redeliver(context.Background(), 3, now)
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Delivery that is not dead should returns no rows", func(t *testing.T) {
+		s, mock := setupDB(t)
+
+		// Arrange
+		mock.ExpectExec("UPDATE webhook_deliveries SET status = 'pending'").
+			WithArgs(3, now).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		// Act
+		err := s.Redeliver(context.Background(), 3, now)
+
+		// Assertions
+		assert.Equal(t, sql.ErrNoRows, err)
+	})
+}
+
+func TestPostgresStoreRecord(t *testing.T) {
+	t.Run("Delivered outcome stamps the delivery time", func(t *testing.T) {
+		s, mock := setupDB(t)
+
+		// Arrange
+		mock.ExpectExec("UPDATE webhook_deliveries SET status = (.+) attempts = attempts \\+ 1").
+			WithArgs(1, webhook.StatusDelivered, 200, "", now, now, "lease-1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		// Act
+		err := s.Record(context.Background(), webhook.Job{DeliveryID: 1, Lease: "lease-1"}, webhook.Outcome{StatusCode: 200, Status: webhook.StatusDelivered, At: now})
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Outcome of a lapsed lease is dropped", func(t *testing.T) {
+		s, mock := setupDB(t)
+
+		// Arrange
+		mock.ExpectExec("UPDATE webhook_deliveries (.+) WHERE id = \\$1 AND lease_token = \\$7").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		// Act
+		err := s.Record(context.Background(), webhook.Job{DeliveryID: 1, Lease: "stale"}, webhook.Outcome{Status: webhook.StatusDelivered, At: now})
+
+		// Assertions
+		assert.Equal(t, webhook.ErrLeaseLost, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPostgresStoreClaim(t *testing.T) {
+	t.Run("Claimed deliveries carry the lease token", func(t *testing.T) {
+		s, mock := setupDB(t)
+
+		// Arrange
+		mock.ExpectQuery("UPDATE webhook_deliveries d SET next_attempt_at = \\$3, lease_token = \\$4").
+			WithArgs(1, now, now.Add(time.Minute), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "outbox_id", "event_type", "attempts", "url", "secret", "body"}).
+				AddRow(1, 7, webhook.EventExpenseCreated, 0, "https://example.com/hook", "s3cret", `{"id":7}`))
+
+		// Act
+		jobs, err := s.Claim(context.Background(), 1, now, time.Minute)
+
+		// Assertions
+		assert.NoError(t, err)
+		if assert.Len(t, jobs, 1) {
+			assert.Len(t, jobs[0].Lease, 64)
+			assert.Equal(t, int64(7), jobs[0].EventID)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,151 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Event types delivered to subscriptions
+const (
+	EventExpenseCreated  = "expense.created"
+	EventExpenseUpdated  = "expense.updated"
+	EventExpenseDeleted  = "expense.deleted"
+	EventExpenseReverted = "expense.reverted"
+)
+
+// EventTypes lists every event type a subscription can filter on
+var EventTypes = []string{EventExpenseCreated, EventExpenseUpdated, EventExpenseDeleted, EventExpenseReverted}
+
+// Event is a change enqueued in the outbox
+type Event struct {
+	Type       string
+	ExpenseID  int
+	OccurredAt time.Time
+	// Data is marshalled into the data field of the delivered body
+	Data interface{}
+}
+
+// Enqueue writes ev to the outbox in tx, it is delivered only once tx commits
+func Enqueue(ctx context.Context, tx *sql.Tx, ev Event) error {
+	data, err := json.Marshal(ev.Data)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO outbox ( event_type, expense_id, payload, created_at ) VALUES ( $1, $2, $3, $4 )",
+		ev.Type, ev.ExpenseID, data, ev.OccurredAt)
+	if err != nil {
+		return fmt.Errorf("can't enqueue %s event: %s", ev.Type, err.Error())
+	}
+	return nil
+}
+
+// Body is the JSON body POSTed to subscribers
+type Body struct {
+	// ID identifies the event, it is the same for every subscription and
+	// redelivery so receivers can drop duplicates
+	ID         int64           `json:"id"`
+	Type       string          `json:"type"`
+	ExpenseID  int             `json:"expense_id"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// Subscription is a URL receiving the events it filters on
+type Subscription struct {
+	ID  int64  `json:"id"`
+	URL string `json:"url"`
+	// Secret signs deliveries, it is only shown when the subscription is created
+	Secret string `json:"secret,omitempty"`
+	// Events are the event types delivered, every type when empty. A
+	// trailing "*" matches by prefix, as in "expense.*".
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Matches reports whether the subscription wants events of typ
+func (s Subscription) Matches(typ string) bool {
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, e := range s.Events {
+		if e == typ || (strings.HasSuffix(e, "*") && strings.HasPrefix(typ, strings.TrimSuffix(e, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the URL is absolute http(s) and not on an internal
+// address, and every filter can match. Names resolving to internal
+// addresses are refused when delivering.
+func (s Subscription) Validate() error {
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); (ip != nil && forbiddenIP(ip)) || strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return errors.New("url must not point to a loopback, private or link-local address")
+	}
+	for _, e := range s.Events {
+		known := false
+		for _, typ := range EventTypes {
+			if (Subscription{Events: []string{e}}).Matches(typ) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown event type %q", e)
+		}
+	}
+	return nil
+}
+
+// Delivery statuses
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	// StatusDead deliveries exhausted their attempts
+	StatusDead = "dead"
+)
+
+// Delivery is the log of delivering one event to one subscription
+type Delivery struct {
+	ID             int64      `json:"id"`
+	SubscriptionID int64      `json:"subscription_id"`
+	EventID        int64      `json:"event_id"`
+	EventType      string     `json:"event_type"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at"`
+	LastStatusCode int        `json:"last_status_code"`
+	LastError      string     `json:"last_error"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+}
+
+// Default and maximum page sizes of the delivery log
+const (
+	DefaultDeliveryLimit = 100
+	MaxDeliveryLimit     = 1000
+)
+
+// DeliveryQuery filters the delivery log, zero values do not filter
+type DeliveryQuery struct {
+	SubscriptionID int64
+	Status         string
+	// After pages through the log by delivery id
+	After int64
+	// Limit is DefaultDeliveryLimit when zero and capped at MaxDeliveryLimit
+	Limit int
+}
@@ -0,0 +1,255 @@
+//go:build unit
+// +build unit
+
+package webhook_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bazsup/assessment/retry"
+	"github.com/bazsup/assessment/webhook"
+	"github.com/stretchr/testify/assert"
+)
+
+var now = time.Date(2022, 12, 24, 10, 0, 0, 0, time.UTC)
+
+type fakeStore struct {
+	jobs     []webhook.Job
+	outcomes map[int64]webhook.Outcome
+	// recordErrs fail recording the outcome of deliveries
+	recordErrs map[int64]error
+}
+
+func (s *fakeStore) FanOut(ctx context.Context, limit int, now time.Time) (int, error) {
+	return 0, nil
+}
+
+func (s *fakeStore) Claim(ctx context.Context, limit int, now time.Time, lease time.Duration) ([]webhook.Job, error) {
+	if limit > len(s.jobs) {
+		limit = len(s.jobs)
+	}
+	jobs := s.jobs[:limit]
+	s.jobs = s.jobs[limit:]
+	return jobs, nil
+}
+
+func (s *fakeStore) Record(ctx context.Context, job webhook.Job, o webhook.Outcome) error {
+	if err := s.recordErrs[job.DeliveryID]; err != nil {
+		return err
+	}
+	s.outcomes[job.DeliveryID] = o
+	return nil
+}
+
+func setupDispatcher(t *testing.T, jobs ...webhook.Job) (*webhook.Dispatcher, *fakeStore) {
+	t.Parallel()
+
+	store := &fakeStore{jobs: jobs, outcomes: map[int64]webhook.Outcome{}}
+	d := webhook.NewDispatcher(store)
+	d.Backoff = retry.Policy{Attempts: 3, Initial: time.Minute, Max: time.Hour}
+	d.Now = func() time.Time { return now }
+	// receivers of the tests listen on loopback
+	d.Client = &http.Client{Timeout: time.Second}
+	return d, store
+}
+
+func TestDispatcher(t *testing.T) {
+	t.Run("Delivery is signed and delivered on a 2xx answer", func(t *testing.T) {
+		var header, event string
+		var body []byte
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header, event = r.Header.Get(webhook.HeaderSignature), r.Header.Get(webhook.HeaderEvent)
+			body, _ = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer receiver.Close()
+
+		// Arrange
+		d, store := setupDispatcher(t, webhook.Job{DeliveryID: 1, EventType: webhook.EventExpenseCreated,
+			URL: receiver.URL, Secret: "s3cret", Body: []byte(`{"id":1}`)})
+
+		// Act
+		n, err := d.RunOnce(context.Background())
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+		assert.Equal(t, webhook.EventExpenseCreated, event)
+		assert.Equal(t, `{"id":1}`, string(body))
+		assert.NoError(t, webhook.Verify("s3cret", header, body, now, time.Minute))
+		assert.Equal(t, webhook.Outcome{StatusCode: http.StatusNoContent, Status: webhook.StatusDelivered, At: now}, store.outcomes[1])
+	})
+
+	t.Run("Failed delivery is retried after the backoff", func(t *testing.T) {
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer receiver.Close()
+
+		// Arrange
+		d, store := setupDispatcher(t, webhook.Job{DeliveryID: 1, Attempts: 1, URL: receiver.URL})
+
+		// Act
+		_, err := d.RunOnce(context.Background())
+
+		// Assertions
+		assert.NoError(t, err)
+		o := store.outcomes[1]
+		assert.Equal(t, webhook.StatusPending, o.Status)
+		assert.Equal(t, http.StatusServiceUnavailable, o.StatusCode)
+		assert.Equal(t, "receiver answered 503 Service Unavailable", o.Error)
+		assert.Equal(t, now.Add(2*time.Minute), o.NextAttemptAt)
+	})
+
+	t.Run("Delivery is dead lettered after its last attempt", func(t *testing.T) {
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer receiver.Close()
+
+		// Arrange
+		d, store := setupDispatcher(t, webhook.Job{DeliveryID: 1, Attempts: 2, URL: receiver.URL})
+
+		// Act
+		_, err := d.RunOnce(context.Background())
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.Equal(t, webhook.StatusDead, store.outcomes[1].Status)
+	})
+
+	t.Run("Unreachable receiver is retried", func(t *testing.T) {
+		receiver := httptest.NewServer(http.NotFoundHandler())
+		receiver.Close()
+
+		// Arrange
+		d, store := setupDispatcher(t, webhook.Job{DeliveryID: 1, URL: receiver.URL})
+
+		// Act
+		_, err := d.RunOnce(context.Background())
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.Equal(t, webhook.StatusPending, store.outcomes[1].Status)
+		assert.NotEmpty(t, store.outcomes[1].Error)
+		assert.Zero(t, store.outcomes[1].StatusCode)
+	})
+
+	t.Run("Failing to record an outcome doesn't abandon the batch", func(t *testing.T) {
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer receiver.Close()
+
+		// Arrange
+		d, store := setupDispatcher(t, webhook.Job{DeliveryID: 1, URL: receiver.URL}, webhook.Job{DeliveryID: 2, URL: receiver.URL},
+			webhook.Job{DeliveryID: 3, URL: receiver.URL})
+		store.recordErrs = map[int64]error{1: errors.New("connection reset"), 2: webhook.ErrLeaseLost}
+
+		// Act
+		n, err := d.RunOnce(context.Background())
+
+		// Assertions
+		assert.EqualError(t, err, "can't record delivery 1: connection reset")
+		assert.Equal(t, 3, n)
+		assert.Equal(t, webhook.StatusDelivered, store.outcomes[3].Status)
+	})
+
+	t.Run("Receiver on an internal address is refused", func(t *testing.T) {
+		var called bool
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		}))
+		defer receiver.Close()
+
+		// Arrange
+		d, store := setupDispatcher(t, webhook.Job{DeliveryID: 1, URL: receiver.URL})
+		d.Client = webhook.NewClient(time.Second)
+
+		// Act
+		_, err := d.RunOnce(context.Background())
+
+		// Assertions
+		assert.NoError(t, err)
+		assert.False(t, called)
+		assert.Equal(t, webhook.StatusPending, store.outcomes[1].Status)
+		assert.Contains(t, store.outcomes[1].Error, webhook.ErrForbiddenAddress.Error())
+	})
+
+	t.Run("Redirects are not followed", func(t *testing.T) {
+		client := webhook.NewClient(time.Second)
+
+		// Assertions
+		assert.Equal(t, http.ErrUseLastResponse, client.CheckRedirect(nil, nil))
+	})
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"id":1}`)
+	header := webhook.Sign("s3cret", now, body)
+
+	t.Run("Signature of the body is valid", func(t *testing.T) {
+		assert.NoError(t, webhook.Verify("s3cret", header, body, now.Add(time.Minute), 5*time.Minute))
+	})
+
+	t.Run("Tampered body is rejected", func(t *testing.T) {
+		assert.Equal(t, webhook.ErrSignature, webhook.Verify("s3cret", header, []byte(`{"id":2}`), now, time.Minute))
+	})
+
+	t.Run("Other secret is rejected", func(t *testing.T) {
+		assert.Equal(t, webhook.ErrSignature, webhook.Verify("other", header, body, now, time.Minute))
+	})
+
+	t.Run("Malformed header is rejected", func(t *testing.T) {
+		assert.Equal(t, webhook.ErrSignature, webhook.Verify("s3cret", "v1=abc", body, now, time.Minute))
+	})
+
+	t.Run("Old signature is rejected", func(t *testing.T) {
+		assert.EqualError(t, webhook.Verify("s3cret", header, body, now.Add(time.Hour), 5*time.Minute), "webhook signature expired")
+	})
+}
+
+func TestSubscription(t *testing.T) {
+	t.Run("Matches filters by type and prefix", func(t *testing.T) {
+		assert.True(t, webhook.Subscription{}.Matches(webhook.EventExpenseDeleted))
+		assert.True(t, webhook.Subscription{Events: []string{"expense.*"}}.Matches(webhook.EventExpenseUpdated))
+		assert.True(t, webhook.Subscription{Events: []string{webhook.EventExpenseCreated}}.Matches(webhook.EventExpenseCreated))
+		assert.False(t, webhook.Subscription{Events: []string{webhook.EventExpenseCreated}}.Matches(webhook.EventExpenseDeleted))
+	})
+
+	t.Run("Validate accepts known filters", func(t *testing.T) {
+		sub := webhook.Subscription{URL: "https://example.com/hook", Events: []string{"expense.*", webhook.EventExpenseCreated}}
+
+		assert.NoError(t, sub.Validate())
+	})
+
+	t.Run("Validate rejects a relative URL", func(t *testing.T) {
+		assert.Error(t, webhook.Subscription{URL: "/hook"}.Validate())
+	})
+
+	t.Run("Validate rejects internal addresses", func(t *testing.T) {
+		for _, u := range []string{
+			"http://169.254.169.254/latest/meta-data",
+			"http://127.0.0.1:8080/hook",
+			"http://localhost/hook",
+			"http://10.0.0.7/hook",
+			"http://192.168.1.1/hook",
+			"http://[::1]/hook",
+			"http://0.0.0.0/hook",
+		} {
+			assert.Error(t, webhook.Subscription{URL: u}.Validate(), u)
+		}
+	})
+
+	t.Run("Validate rejects an unknown event type", func(t *testing.T) {
+		sub := webhook.Subscription{URL: "https://example.com/hook", Events: []string{"invoice.created"}}
+
+		assert.EqualError(t, sub.Validate(), `unknown event type "invoice.created"`)
+	})
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/bazsup/assessment/config"
+	"github.com/bazsup/assessment/webhook"
+)
+
+// newDispatcher builds the outbox dispatcher of the configured retry policy
+func newDispatcher(store *webhook.PostgresStore, cfg config.WebhookConfig) *webhook.Dispatcher {
+	d := webhook.NewDispatcher(store)
+	d.Client = webhook.NewClient(cfg.Timeout)
+	d.Backoff.Attempts = cfg.MaxAttempts
+	d.Backoff.Initial = cfg.InitialBackoff
+	d.Backoff.Max = cfg.MaxBackoff
+	if d.Lease < 2*cfg.Timeout {
+		d.Lease = 2 * cfg.Timeout
+	}
+	return d
+}
+
+// purgeOutbox deletes fanned out events once retention has passed, their
+// deliveries keep a copy of the body for redelivery
+func purgeOutbox(store *webhook.PostgresStore, retention time.Duration) {
+	for range time.Tick(time.Hour) {
+		if _, err := store.PurgeOutbox(context.Background(), time.Now().Add(-retention)); err != nil {
+			slog.Error("can't purge outbox", "error", err.Error())
+		}
+	}
+}